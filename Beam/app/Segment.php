@@ -4,10 +4,12 @@ namespace App;
 
 use App\Model\TableName;
 use Illuminate\Database\Eloquent\Model;
+use Illuminate\Database\Eloquent\SoftDeletes;
 
 class Segment extends Model
 {
     use TableName;
+    use SoftDeletes;
 
     protected $casts = [
         'active' => 'boolean',
@@ -24,11 +26,26 @@ class Segment extends Model
         'segment_group_id'
     ];
 
+    protected static function boot()
+    {
+        parent::boot();
+
+        // version is checked by segments API to detect concurrent modifications
+        static::updating(function (Segment $segment) {
+            $segment->version = $segment->version + 1;
+        });
+    }
+
     public function rules()
     {
         return $this->hasMany(SegmentRule::class);
     }
 
+    public function revisions()
+    {
+        return $this->hasMany(SegmentRevision::class);
+    }
+
     public function users()
     {
         return $this->hasMany(SegmentUser::class);
@@ -27,4 +27,14 @@ class SegmentGroup extends Model
     protected $casts = [
         'sorting' => 'integer',
     ];
+
+    protected static function boot()
+    {
+        parent::boot();
+
+        // version is checked by segments API to detect concurrent modifications
+        static::updating(function (SegmentGroup $segmentGroup) {
+            $segmentGroup->version = $segmentGroup->version + 1;
+        });
+    }
 }
@@ -0,0 +1,27 @@
+<?php
+
+namespace App;
+
+use App\Model\TableName;
+use Illuminate\Database\Eloquent\Model;
+
+class SegmentRevision extends Model
+{
+    use TableName;
+
+    const UPDATED_AT = null;
+
+    protected $casts = [
+        'criteria' => 'json',
+    ];
+
+    public function segment()
+    {
+        return $this->belongsTo(Segment::class);
+    }
+
+    public function segmentGroup()
+    {
+        return $this->belongsTo(SegmentGroup::class);
+    }
+}
@@ -9,7 +9,16 @@ class SegmentRule extends Model
     protected $casts = [
         'fields' => 'json',
         'flags' => 'json',
+        'overridable' => 'json',
         'timespan' => 'integer',
+        'timespan_end' => 'integer',
+        'value' => 'float',
+        'value2' => 'float',
+    ];
+
+    protected $dates = [
+        'time_after',
+        'time_before',
     ];
 
     protected $attributes = [
@@ -20,13 +29,21 @@ class SegmentRule extends Model
     protected $fillable = [
         'id',
         'timespan',
+        'timespan_end',
+        'time_after',
+        'time_before',
         'count',
+        'aggregate',
+        'aggregate_field',
+        'value',
+        'value2',
         'event_category',
         'event_action',
         'segment_id',
         'operator',
         'fields',
         'flags',
+        'overridable',
     ];
 
     public function segment()
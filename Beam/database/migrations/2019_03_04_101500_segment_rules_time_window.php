@@ -0,0 +1,34 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class SegmentRulesTimeWindow extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->integer('timespan_end')->comment("Number of minutes ago the relative window ends")->nullable()->after('timespan');
+            $table->timestamp('time_after')->comment("Absolute start of the window")->nullable()->after('timespan_end');
+            $table->timestamp('time_before')->comment("Absolute end of the window")->nullable()->after('time_after');
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->dropColumn(['timespan_end', 'time_after', 'time_before']);
+        });
+    }
+}
@@ -0,0 +1,35 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class SegmentRulesAggregate extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->string('aggregate')->comment("Aggregate function of value-aggregate rule (sum, avg, distinct)")->default('')->after('count');
+            $table->string('aggregate_field')->comment("Field aggregated by distinct value-aggregate rule")->default('')->after('aggregate');
+            $table->double('value')->comment("Threshold of value-aggregate rule")->default(0)->after('aggregate_field');
+            $table->double('value2')->comment("Second threshold of value-aggregate rule")->nullable()->after('value');
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->dropColumn(['aggregate', 'aggregate_field', 'value', 'value2']);
+        });
+    }
+}
@@ -0,0 +1,41 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class CreateSegmentRevisionsTable extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::create('segment_revisions', function (Blueprint $table) {
+            $table->increments('id');
+            $table->integer('segment_id')->unsigned();
+            $table->integer('revision')->unsigned()->comment("Sequence number of revision within the segment");
+            $table->string('name');
+            $table->integer('segment_group_id')->unsigned();
+            $table->json('criteria')->nullable();
+            $table->string('author')->nullable()->comment("Author of the change as provided by the API client");
+            $table->integer('rollback_of')->unsigned()->nullable()->comment("Revision the segment was rolled back to");
+            $table->timestamp('created_at')->nullable();
+
+            $table->foreign('segment_id')->references('id')->on('segments');
+            $table->unique(['segment_id', 'revision']);
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::dropIfExists('segment_revisions');
+    }
+}
@@ -0,0 +1,32 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class SegmentRulesOverridable extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->json('overridable')->comment("Params of rule which can be overridden by client checking the segment (count, operator, timespan)")->nullable()->after('flags');
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::table("segment_rules", function (Blueprint $table) {
+            $table->dropColumn(['overridable']);
+        });
+    }
+}
@@ -0,0 +1,37 @@
+<?php
+
+use Illuminate\Support\Facades\DB;
+use Illuminate\Database\Migrations\Migration;
+
+class BackfillSegmentRevisions extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * Stores current state of every segment without any revision as its first revision,
+     * so the definition prior to the first edit can be restored.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        DB::statement("
+            INSERT INTO segment_revisions (segment_id, revision, name, segment_group_id, criteria, author, rollback_of, created_at)
+            SELECT segments.id, 1, segments.name, segments.segment_group_id, segments.criteria, NULL, NULL, COALESCE(segments.updated_at, segments.created_at, NOW())
+            FROM segments
+            LEFT JOIN segment_revisions ON segment_revisions.segment_id = segments.id
+            WHERE segment_revisions.id IS NULL
+        ");
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * Backfilled revisions can't be told apart from the first revisions stored via API, they're kept.
+     *
+     * @return void
+     */
+    public function down()
+    {
+    }
+}
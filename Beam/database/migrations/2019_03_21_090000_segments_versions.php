@@ -0,0 +1,45 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class SegmentsVersions extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * Version of segments and segment groups is incremented on every modification and used by segments API
+     * for optimistic concurrency. Codes of segment groups are enforced to be unique the same way codes of segments are.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::table("segments", function (Blueprint $table) {
+            $table->integer('version')->unsigned()->default(1);
+        });
+
+        Schema::table("segment_groups", function (Blueprint $table) {
+            $table->integer('version')->unsigned()->default(1);
+            $table->unique(['code']);
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::table("segment_groups", function (Blueprint $table) {
+            $table->dropUnique(['code']);
+            $table->dropColumn('version');
+        });
+
+        Schema::table("segments", function (Blueprint $table) {
+            $table->dropColumn('version');
+        });
+    }
+}
@@ -0,0 +1,36 @@
+<?php
+
+use Illuminate\Support\Facades\Schema;
+use Illuminate\Database\Schema\Blueprint;
+use Illuminate\Database\Migrations\Migration;
+
+class CreateSegmentImportMembersTable extends Migration
+{
+    /**
+     * Run the migrations.
+     *
+     * Members of explicit segments imported via segments API are staged here before they're moved to the segment.
+     *
+     * @return void
+     */
+    public function up()
+    {
+        Schema::create('segment_import_members', function (Blueprint $table) {
+            $table->increments('id');
+            $table->string('import_id', 36)->comment("ID of the running import");
+            $table->string('member');
+
+            $table->index(['import_id', 'member']);
+        });
+    }
+
+    /**
+     * Reverse the migrations.
+     *
+     * @return void
+     */
+    public function down()
+    {
+        Schema::dropIfExists('segment_import_members');
+    }
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // Config represents config structure for segments cmd.
 type Config struct {
 	SegmentsAddr string `envconfig:"addr" required:"true"`
@@ -15,5 +17,15 @@ type Config struct {
 	ElasticUser   string `envconfig:"elastic_user" required:"false"`
 	ElasticPasswd string `envconfig:"elastic_passwd" required:"false"`
 
+	UniquePrecision int `envconfig:"unique_precision" required:"false" default:"0"`
+
 	URLEdit string `envconfig:"url_edit" required:"true"`
+
+	RFMPeriod time.Duration `envconfig:"rfm_period" required:"false" default:"8760h"`
+
+	RuleCacheSize      int           `envconfig:"rule_cache_size" required:"false" default:"0"`
+	RuleCacheTTL       time.Duration `envconfig:"rule_cache_ttl" required:"false" default:"5m"`
+	RuleCacheIngestLag time.Duration `envconfig:"rule_cache_ingest_lag" required:"false" default:"30s"`
+
+	CacheInvalidateToken string `envconfig:"cache_invalidate_token" required:"false"`
 }
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
 	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
 	"gitlab.com/remp/remp/Beam/go/model"
 )
@@ -43,7 +44,7 @@ func (c *CommerceController) CountStep(ctx *app.CountStepCommerceContext) error
 func processCount(c *CommerceController, ao model.AggregateOptions) (app.CountCollection, error) {
 	crc, ok, err := c.CommerceStorage.Count(ao)
 	if err != nil {
-		return nil, err
+		return nil, aggregateError(err)
 	}
 	if !ok {
 		cr := model.CountRow{
@@ -57,6 +58,46 @@ func processCount(c *CommerceController, ao model.AggregateOptions) (app.CountCo
 	return CountRowCollection(crc).ToMediaType(), nil
 }
 
+// Unique runs the unique action.
+func (c *CommerceController) Unique(ctx *app.UniqueCommerceContext) error {
+	o := aggregateOptionsFromCommerceOptions(ctx.Payload)
+	o.UniqueExact = ctx.Exact
+	if ctx.PrecisionThreshold != nil {
+		o.UniquePrecision = *ctx.PrecisionThreshold
+	}
+
+	crc, ok, err := c.CommerceStorage.Unique(o, ctx.Item)
+	if err != nil {
+		if err == model.ErrTooManyUniques {
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return aggregateError(err)
+	}
+	if !ok {
+		crc = model.CountRowCollection{
+			model.CountRow{
+				Tags:  make(map[string]string),
+				Count: 0,
+			},
+		}
+	}
+
+	return ctx.OK(CountRowCollection(crc).ToMediaType())
+}
+
+// Funnel runs the funnel action.
+func (c *CommerceController) Funnel(ctx *app.FunnelCommerceContext) error {
+	o := aggregateOptionsFromCommerceOptions(ctx.Payload)
+	frc, err := c.CommerceStorage.Funnel(o, ctx.Item)
+	if err != nil {
+		if errors.Cause(err) == model.ErrFunnelOptions {
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return aggregateError(err)
+	}
+	return ctx.OK(FunnelRowCollection(frc).ToMediaType())
+}
+
 // List runs the list action.
 func (c *CommerceController) List(ctx *app.ListCommerceContext) error {
 	aggOptions := aggregateOptionsFromCommerceOptions(ctx.Payload.Conditions)
@@ -64,16 +105,23 @@ func (c *CommerceController) List(ctx *app.ListCommerceContext) error {
 		AggregateOptions: aggOptions,
 		SelectFields:     ctx.Payload.SelectFields,
 	}
+	page := listPageFromPayload(ctx.Payload.Limit, ctx.Payload.Sort, ctx.Payload.Cursor)
 
-	crc, err := c.CommerceStorage.List(o)
-	if err != nil {
-		return err
-	}
-	mt, err := CommerceRowCollection(crc).ToMediaType()
-	if err != nil {
-		return err
-	}
-	return ctx.OK(mt)
+	err := handleList(ctx.ResponseData, page, ctx.Payload.Format, func(page model.ListPage) (interface{}, string, error) {
+		o.ListPage = page
+		crc, next, err := c.CommerceStorage.List(o)
+		if err != nil {
+			return nil, "", err
+		}
+		mt, err := CommerceRowCollection(crc).ToMediaType()
+		return mt, next, err
+	}, func(mt interface{}) error {
+		return ctx.OK(mt.(app.CommercesCollection))
+	})
+	if err == model.ErrInvalidCursor {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+	return err
 }
 
 // SumStep runs the sum action for particular step
@@ -100,7 +148,7 @@ func (c *CommerceController) Sum(ctx *app.SumCommerceContext) error {
 func processSum(c *CommerceController, ao model.AggregateOptions) (app.SumCollection, error) {
 	src, ok, err := c.CommerceStorage.Sum(ao)
 	if err != nil {
-		return nil, err
+		return nil, aggregateError(err)
 	}
 	if !ok {
 		sr := model.SumRow{
@@ -138,13 +186,15 @@ func aggregateOptionsFromCommerceOptions(payload *app.CommerceOptionsPayload) mo
 
 	for _, val := range payload.FilterBy {
 		fb := &model.FilterBy{
-			Tag:    val.Tag,
-			Values: val.Values,
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
 		}
 		o.FilterBy = append(o.FilterBy, fb)
 	}
 
 	o.GroupBy = payload.GroupBy
+	o.GroupTop = groupTopFromPayload(payload.GroupTop)
 	if payload.TimeAfter != nil {
 		o.TimeAfter = *payload.TimeAfter
 	}
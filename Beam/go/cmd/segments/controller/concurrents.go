@@ -26,7 +26,7 @@ func (c *ConcurrentsController) Count(ctx *app.CountConcurrentsContext) error {
 
 	crc, ok, err := c.ConcurrentsStorage.Count(o)
 	if err != nil {
-		return err
+		return aggregateError(err)
 	}
 
 	if !ok {
@@ -48,13 +48,15 @@ func aggregateOptionsFromConcurrentOptions(payload *app.ConcurrentsOptionsPayloa
 
 	for _, val := range payload.FilterBy {
 		fb := &model.FilterBy{
-			Tag:    val.Tag,
-			Values: val.Values,
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
 		}
 		o.FilterBy = append(o.FilterBy, fb)
 	}
 
 	o.GroupBy = payload.GroupBy
+	o.GroupTop = groupTopFromPayload(payload.GroupTop)
 
 	if payload.TimeAfter != nil {
 		o.TimeAfter = *payload.TimeAfter
@@ -44,7 +44,7 @@ func (c *EventController) CountAction(ctx *app.CountActionEventsContext) error {
 func processEventCount(c *EventController, ao model.AggregateOptions) (app.CountCollection, error) {
 	crc, ok, err := c.EventStorage.Count(ao)
 	if err != nil {
-		return nil, err
+		return nil, aggregateError(err)
 	}
 	if !ok {
 		cr := model.CountRow{
@@ -58,6 +58,33 @@ func processEventCount(c *EventController, ao model.AggregateOptions) (app.Count
 	return CountRowCollection(crc).ToMediaType(), nil
 }
 
+// Unique runs the unique action.
+func (c *EventController) Unique(ctx *app.UniqueEventsContext) error {
+	o := aggregateOptionsFromEventsOptions(ctx.Payload)
+	o.UniqueExact = ctx.Exact
+	if ctx.PrecisionThreshold != nil {
+		o.UniquePrecision = *ctx.PrecisionThreshold
+	}
+
+	crc, ok, err := c.EventStorage.Unique(o, ctx.Item)
+	if err != nil {
+		if err == model.ErrTooManyUniques {
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return aggregateError(err)
+	}
+	if !ok {
+		crc = model.CountRowCollection{
+			model.CountRow{
+				Tags:  make(map[string]string),
+				Count: 0,
+			},
+		}
+	}
+
+	return ctx.OK(CountRowCollection(crc).ToMediaType())
+}
+
 // List runs the list action.
 func (c *EventController) List(ctx *app.ListEventsContext) error {
 	aggOptions := aggregateOptionsFromEventsOptions(ctx.Payload.Conditions)
@@ -65,16 +92,23 @@ func (c *EventController) List(ctx *app.ListEventsContext) error {
 		AggregateOptions: aggOptions,
 		SelectFields:     ctx.Payload.SelectFields,
 	}
+	page := listPageFromPayload(ctx.Payload.Limit, ctx.Payload.Sort, ctx.Payload.Cursor)
 
-	erc, err := c.EventStorage.List(o)
-	if err != nil {
-		return err
-	}
-	mt, err := EventRowCollection(erc).ToMediaType()
-	if err != nil {
-		return err
-	}
-	return ctx.OK(mt)
+	err := handleList(ctx.ResponseData, page, ctx.Payload.Format, func(page model.ListPage) (interface{}, string, error) {
+		o.ListPage = page
+		erc, next, err := c.EventStorage.List(o)
+		if err != nil {
+			return nil, "", err
+		}
+		mt, err := EventRowCollection(erc).ToMediaType()
+		return mt, next, err
+	}, func(mt interface{}) error {
+		return ctx.OK(mt.(app.EventsCollection))
+	})
+	if err == model.ErrInvalidCursor {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+	return err
 }
 
 // Categories runs the categories action.
@@ -110,13 +144,15 @@ func aggregateOptionsFromEventsOptions(payload *app.EventOptionsPayload) model.A
 
 	for _, val := range payload.FilterBy {
 		fb := &model.FilterBy{
-			Tag:    val.Tag,
-			Values: val.Values,
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
 		}
 		o.FilterBy = append(o.FilterBy, fb)
 	}
 
 	o.GroupBy = payload.GroupBy
+	o.GroupTop = groupTopFromPayload(payload.GroupTop)
 	if payload.TimeAfter != nil {
 		o.TimeAfter = *payload.TimeAfter
 	}
@@ -1,7 +1,10 @@
 package controller
 
 import (
+	"time"
+
 	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
 	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
 	"gitlab.com/remp/remp/Beam/go/model"
 )
@@ -9,19 +12,25 @@ import (
 // JournalController implements the journal resource.
 type JournalController struct {
 	*goa.Controller
-	EventStorage    model.EventStorage
-	CommerceStorage model.CommerceStorage
-	PageviewStorage model.PageviewStorage
+	EventStorage       model.EventStorage
+	CommerceStorage    model.CommerceStorage
+	PageviewStorage    model.PageviewStorage
+	QueryStorage       model.QueryStorage
+	AttributionStorage model.AttributionStorage
+	RetentionStorage   model.RetentionStorage
 }
 
 // NewJournalController creates an journal controller.
 func NewJournalController(service *goa.Service, es model.EventStorage, cs model.CommerceStorage,
-	ps model.PageviewStorage) *JournalController {
+	ps model.PageviewStorage, qs model.QueryStorage, as model.AttributionStorage, rs model.RetentionStorage) *JournalController {
 	return &JournalController{
-		Controller:      service.NewController("JournalController"),
-		EventStorage:    es,
-		CommerceStorage: cs,
-		PageviewStorage: ps,
+		Controller:         service.NewController("JournalController"),
+		EventStorage:       es,
+		CommerceStorage:    cs,
+		PageviewStorage:    ps,
+		QueryStorage:       qs,
+		AttributionStorage: as,
+		RetentionStorage:   rs,
 	}
 }
 
@@ -33,3 +42,159 @@ func (c *JournalController) Flags(ctx *app.FlagsJournalContext) error {
 		Events:    c.EventStorage.Flags(),
 	})
 }
+
+// Query runs the query action.
+func (c *JournalController) Query(ctx *app.QueryJournalContext) error {
+	q := queryFromPayload(ctx.Payload)
+	if err := q.Validate(); err != nil {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+
+	qrc, err := c.QueryStorage.Query(q)
+	if err != nil {
+		switch errors.Cause(err) {
+		case model.ErrUnknownField, model.ErrInvalidFieldType, model.ErrTooManyUniques, model.ErrGroupOtherMetrics:
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return err
+	}
+	return ctx.OK(QueryRowCollection(qrc).ToMediaType())
+}
+
+// Attribution runs the attribution action.
+func (c *JournalController) Attribution(ctx *app.AttributionJournalContext) error {
+	o := model.AttributionOptions{
+		Tag:      ctx.Payload.Tag,
+		Model:    ctx.Payload.Model,
+		Window:   time.Duration(ctx.Payload.Window) * time.Minute,
+		HalfLife: time.Duration(ctx.Payload.HalfLife) * time.Minute,
+	}
+	for _, val := range ctx.Payload.FilterBy {
+		o.FilterBy = append(o.FilterBy, &model.FilterBy{
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
+		})
+	}
+	o.TimeBefore = time.Now()
+	if ctx.Payload.TimeBefore != nil {
+		o.TimeBefore = *ctx.Payload.TimeBefore
+	}
+	o.TimeAfter = o.TimeBefore.Add(-model.DefaultAttributionRange)
+	if ctx.Payload.TimeAfter != nil {
+		o.TimeAfter = *ctx.Payload.TimeAfter
+	}
+
+	if err := o.Validate(); err != nil {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+
+	arc, err := c.AttributionStorage.Attribute(o)
+	if err != nil {
+		return err
+	}
+	return ctx.OK(AttributionRowCollection(arc).ToMediaType())
+}
+
+// Retention runs the retention action.
+func (c *JournalController) Retention(ctx *app.RetentionJournalContext) error {
+	o := model.RetentionOptions{
+		Item:    ctx.Payload.Item,
+		Cohort:  ctx.Payload.Cohort,
+		Period:  ctx.Payload.Period,
+		Periods: ctx.Payload.Periods,
+	}
+	for _, val := range ctx.Payload.FilterBy {
+		o.FilterBy = append(o.FilterBy, &model.FilterBy{
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
+		})
+	}
+	if ctx.Payload.TimeAfter != nil {
+		o.TimeAfter = *ctx.Payload.TimeAfter
+	}
+	if ctx.Payload.TimeBefore != nil {
+		o.TimeBefore = *ctx.Payload.TimeBefore
+	}
+
+	if err := o.Validate(); err != nil {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+
+	rrc, err := c.RetentionStorage.Retention(o)
+	if err != nil {
+		return err
+	}
+	return ctx.OK(RetentionRowCollection(rrc).ToMediaType())
+}
+
+// aggregateError reports errors caused by the options of aggregation as bad requests.
+func aggregateError(err error) error {
+	if errors.Cause(err) == model.ErrGroupOtherMetrics {
+		return goa.ErrBadRequest(err)
+	}
+	return err
+}
+
+// groupTopFromPayload converts payload data to the limits of grouping.
+func groupTopFromPayload(payload []*app.GroupTop) []model.GroupTop {
+	var tops []model.GroupTop
+	for _, t := range payload {
+		top := model.GroupTop{
+			Tag:    t.Tag,
+			Size:   t.Size,
+			Metric: t.Metric,
+			Asc:    t.Order == "asc",
+			Other:  t.Other,
+		}
+		if t.Field != nil {
+			top.Field = *t.Field
+		}
+		tops = append(tops, top)
+	}
+	return tops
+}
+
+// queryFromPayload converts payload data to Query.
+func queryFromPayload(payload *app.JournalQueryPayload) model.Query {
+	q := model.Query{
+		Source: payload.Source,
+	}
+	for _, m := range payload.Metrics {
+		qm := model.QueryMetric{
+			Type:     m.Type,
+			Percents: m.Percents,
+		}
+		if m.Name != nil {
+			qm.Name = *m.Name
+		}
+		if m.Field != nil {
+			qm.Field = *m.Field
+		}
+		q.Metrics = append(q.Metrics, qm)
+	}
+
+	for _, val := range payload.FilterBy {
+		q.FilterBy = append(q.FilterBy, &model.FilterBy{
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
+		})
+	}
+	q.GroupBy = payload.GroupBy
+	q.GroupTop = groupTopFromPayload(payload.GroupTop)
+	if payload.TimeAfter != nil {
+		q.TimeAfter = *payload.TimeAfter
+	}
+	if payload.TimeBefore != nil {
+		q.TimeBefore = *payload.TimeBefore
+	}
+	if payload.TimeHistogram != nil {
+		q.TimeHistogram = &model.TimeHistogram{
+			Interval: payload.TimeHistogram.Interval,
+			Offset:   payload.TimeHistogram.Offset,
+		}
+	}
+	return q
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+
+	"github.com/goadesign/goa"
+	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// maxUnlimitedListSize is the highest number of records returned within JSON response if client doesn't limit it.
+const maxUnlimitedListSize = 10000
+
+// listPageFromPayload creates model.ListPage from the pagination attributes of list payloads.
+func listPageFromPayload(limit *int, sort []*app.ListSort, cursor *string) model.ListPage {
+	var page model.ListPage
+	if limit != nil {
+		page.Limit = *limit
+	}
+	for _, s := range sort {
+		page.Sort = append(page.Sort, model.ListSort{
+			Field: s.Field,
+			Desc:  s.Order == "desc",
+		})
+	}
+	if cursor != nil {
+		page.Cursor = *cursor
+	}
+	return page
+}
+
+// handleList lists rows using provided list function, which returns media type collection of the page rows
+// and the cursor of the next page.
+//
+// JSON response is passed to the ok function at once; it contains either the single page if the page limit
+// is set, or all the rows if there are no more than maxUnlimitedListSize records (bad request is returned
+// otherwise). NDJSON response is streamed page by page; errors occurring after the response status was written
+// are logged and the stream is ended by {"error": "..."} record.
+func handleList(rd *goa.ResponseData, page model.ListPage, format string,
+	list func(page model.ListPage) (interface{}, string, error), ok func(interface{}) error) error {
+
+	limited := page.Limit > 0
+	if !limited {
+		page.Limit = maxUnlimitedListSize
+		if format == "ndjson" {
+			page.Limit = exportPageSize
+		}
+	}
+
+	// first page is loaded before writing anything so the errors are still reported properly
+	rows, next, err := list(page)
+	if err != nil {
+		return err
+	}
+	if limited && next != "" {
+		rd.Header().Set(nextCursorHeader, next)
+	}
+	if format != "ndjson" {
+		if !limited && next != "" {
+			return goa.ErrBadRequest(fmt.Errorf("more than %d records found, use limit and cursor or ndjson format", maxUnlimitedListSize))
+		}
+		return ok(rows)
+	}
+
+	rd.Header().Set("Content-Type", "application/x-ndjson")
+	rd.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(rd)
+	for {
+		rv := reflect.ValueOf(rows)
+		for i := 0; i < rv.Len(); i++ {
+			if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+				log.Println("Failed to write listed records:", err)
+				return nil
+			}
+		}
+		if f, ok := rd.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		if limited || next == "" {
+			return nil
+		}
+		page.Cursor = next
+		rows, next, err = list(page)
+		if err != nil {
+			log.Println("Failed to list next page of records:", err)
+			if err := enc.Encode(streamError{Error: err.Error()}); err != nil {
+				log.Println("Failed to write listed records:", err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goadesign/goa"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+func TestHandleList_StreamError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rd := &goa.ResponseData{ResponseWriter: rec}
+
+	calls := 0
+	err := handleList(rd, model.ListPage{}, "ndjson", func(page model.ListPage) (interface{}, string, error) {
+		calls++
+		if page.Cursor != "" {
+			return nil, "", errors.New("elastic unavailable")
+		}
+		return []string{"r1", "r2"}, "r2", nil
+	}, func(interface{}) error {
+		t.Error("ndjson rows passed to json response")
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("returned error %v after the response was written", err)
+	}
+	if calls != 2 {
+		t.Errorf("listed %d pages, expected 2", calls)
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("returned status %d, expected %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); body != "\"r1\"\n\"r2\"\n{\"error\":\"elastic unavailable\"}\n" {
+		t.Errorf("streamed body %q", body)
+	}
+}
+
+func TestHandleList_Unlimited(t *testing.T) {
+	var unlimitedTests = []struct {
+		Name       string
+		Next       string
+		BadRequest bool
+	}{
+		{"all records loaded", "", false},
+		{"too many records", "r2", true},
+	}
+
+	for _, ut := range unlimitedTests {
+		rec := httptest.NewRecorder()
+		rd := &goa.ResponseData{ResponseWriter: rec}
+
+		var rows interface{}
+		err := handleList(rd, model.ListPage{}, "json", func(page model.ListPage) (interface{}, string, error) {
+			if page.Limit != maxUnlimitedListSize {
+				t.Errorf("%s: listed page of %d records, expected %d", ut.Name, page.Limit, maxUnlimitedListSize)
+			}
+			return []string{"r1", "r2"}, ut.Next, nil
+		}, func(mt interface{}) error {
+			rows = mt
+			return nil
+		})
+
+		_, badRequest := err.(goa.ServiceError)
+		if badRequest != ut.BadRequest {
+			t.Errorf("%s: returned error %v", ut.Name, err)
+		}
+		if (rows == nil) != ut.BadRequest {
+			t.Errorf("%s: returned rows %v", ut.Name, rows)
+		}
+		if next := rec.Header().Get(nextCursorHeader); next != "" {
+			t.Errorf("%s: returned cursor %s of unlimited response", ut.Name, next)
+		}
+	}
+}
@@ -81,13 +81,68 @@ type AvgRow model.AvgRow
 // AvgRowCollection is the collection of sum rows.
 type AvgRowCollection model.AvgRowCollection
 
+// MaxRow represent row with max result.
+type MaxRow model.MaxRow
+
+// MaxRowCollection is the collection of max rows.
+type MaxRowCollection model.MaxRowCollection
+
+// CompletionRow represent row with completion result.
+type CompletionRow model.CompletionRow
+
+// CompletionRowCollection is the collection of completion rows.
+type CompletionRowCollection model.CompletionRowCollection
+
+// PercentilesRow represent row with percentiles result.
+type PercentilesRow model.PercentilesRow
+
+// PercentilesRowCollection is the collection of percentiles rows.
+type PercentilesRowCollection model.PercentilesRowCollection
+
+// DistributionRow represent row with distribution result.
+type DistributionRow model.DistributionRow
+
+// DistributionRowCollection is the collection of distribution rows.
+type DistributionRowCollection model.DistributionRowCollection
+
+// QueryRow represent row with query results.
+type QueryRow model.QueryRow
+
+// QueryRowCollection is the collection of query rows.
+type QueryRowCollection model.QueryRowCollection
+
+// FunnelRow represents commerce funnel of single group.
+type FunnelRow model.FunnelRow
+
+// FunnelRowCollection is the collection of commerce funnel rows.
+type FunnelRowCollection model.FunnelRowCollection
+
+// RetentionRow represents single cohort of the retention matrix.
+type RetentionRow model.RetentionRow
+
+// RetentionRowCollection is the retention matrix.
+type RetentionRowCollection model.RetentionRowCollection
+
+// AttributionRow represents purchases attributed to single value of the tag.
+type AttributionRow model.AttributionRow
+
+// AttributionRowCollection is the collection of attribution rows.
+type AttributionRowCollection model.AttributionRowCollection
+
 // ToMediaType converts internal Segment representation to application one.
 func (s *Segment) ToMediaType() (*app.Segment, error) {
 	mt := &app.Segment{
-		ID:    s.ID,
-		Code:  s.Code,
-		Name:  s.Name,
-		Group: (*SegmentGroup)(&s.Group).ToMediaType(),
+		ID:     s.ID,
+		Code:   s.Code,
+		Name:   s.Name,
+		Group:  (*SegmentGroup)(&s.Group).ToMediaType(),
+		Active: &s.Active,
+	}
+	if !s.UpdatedAt.IsZero() {
+		mt.UpdatedAt = &s.UpdatedAt
+	}
+	if s.Version > 0 {
+		mt.Version = &s.Version
 	}
 
 	if s.Criteria.Valid {
@@ -177,11 +232,24 @@ func (sc SegmentCollection) ToExtendedMediaType(segmentURL string) app.SegmentEx
 
 // ToMediaType converts internal Segment representation to application one.
 func (sg *SegmentGroup) ToMediaType() *app.SegmentGroup {
-	return &app.SegmentGroup{
+	mt := &app.SegmentGroup{
 		ID:      sg.ID,
 		Name:    sg.Name,
 		Sorting: sg.Sorting,
 	}
+	if sg.Code != "" {
+		mt.Code = &sg.Code
+	}
+	if sg.Type != "" {
+		mt.Type = &sg.Type
+	}
+	if !sg.UpdatedAt.IsZero() {
+		mt.UpdatedAt = &sg.UpdatedAt
+	}
+	if sg.Version > 0 {
+		mt.Version = &sg.Version
+	}
+	return mt
 }
 
 // ToMediaType converts internal SegmentCollection representation to application one.
@@ -546,6 +614,210 @@ func (crc CountRowCollection) ToMediaType() app.CountCollection {
 	return mt
 }
 
+// ToMediaType converts internal MaxRowCollection representation to application one.
+func (mrc MaxRowCollection) ToMediaType() app.MaxCollection {
+	mt := app.MaxCollection{}
+	for _, mr := range mrc {
+		mt = append(mt, &app.Max{
+			Tags: mr.Tags,
+			Max:  mr.Max,
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal CompletionRow representation to application one.
+func (cr CompletionRow) ToMediaType() *app.Completion {
+	mt := &app.Completion{
+		Tags:          cr.Tags,
+		Pageviews:     cr.Pageviews,
+		Completed:     cr.Completed,
+		Rate:          model.CompletionRow(cr).Rate(),
+		TimeHistogram: app.CompletionHistogramCollection{},
+	}
+	for _, hi := range cr.Histogram {
+		mt.TimeHistogram = append(mt.TimeHistogram, &app.CompletionHistogram{
+			Time:      hi.Time,
+			Pageviews: hi.Pageviews,
+			Completed: hi.Completed,
+			Rate:      hi.Rate(),
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal CompletionRowCollection representation to application one.
+func (crc CompletionRowCollection) ToMediaType() app.CompletionCollection {
+	mt := app.CompletionCollection{}
+	for _, cr := range crc {
+		mt = append(mt, (CompletionRow)(cr).ToMediaType())
+	}
+	return mt
+}
+
+// ToMediaType converts internal PercentilesRow representation to application one.
+func (pr PercentilesRow) ToMediaType() *app.Percentiles {
+	mt := &app.Percentiles{
+		Tags:          pr.Tags,
+		Percentiles:   pr.Percentiles,
+		TimeHistogram: app.PercentilesHistogramCollection{},
+	}
+	for _, hi := range pr.Histogram {
+		mt.TimeHistogram = append(mt.TimeHistogram, &app.PercentilesHistogram{
+			Time:        hi.Time,
+			Percentiles: hi.Percentiles,
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal PercentilesRowCollection representation to application one.
+func (prc PercentilesRowCollection) ToMediaType() app.PercentilesCollection {
+	mt := app.PercentilesCollection{}
+	for _, pr := range prc {
+		mt = append(mt, (PercentilesRow)(pr).ToMediaType())
+	}
+	return mt
+}
+
+// ToMediaType converts internal DistributionRow representation to application one.
+func (dr DistributionRow) ToMediaType() *app.Distribution {
+	mt := &app.Distribution{
+		Tags:          dr.Tags,
+		Count:         dr.Count,
+		Buckets:       distributionBucketsToMediaType(dr.Buckets),
+		TimeHistogram: app.DistributionHistogramCollection{},
+	}
+	for _, hi := range dr.Histogram {
+		mt.TimeHistogram = append(mt.TimeHistogram, &app.DistributionHistogram{
+			Time:    hi.Time,
+			Buckets: distributionBucketsToMediaType(hi.Buckets),
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal DistributionRowCollection representation to application one.
+func (drc DistributionRowCollection) ToMediaType() app.DistributionCollection {
+	mt := app.DistributionCollection{}
+	for _, dr := range drc {
+		mt = append(mt, (DistributionRow)(dr).ToMediaType())
+	}
+	return mt
+}
+
+// distributionBucketsToMediaType converts internal DistributionBucket representations to application ones.
+func distributionBucketsToMediaType(buckets []model.DistributionBucket) app.DistributionBucketCollection {
+	mt := app.DistributionBucketCollection{}
+	for _, b := range buckets {
+		mt = append(mt, &app.DistributionBucket{
+			From:  b.From,
+			Count: b.Count,
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal QueryRow representation to application one.
+func (qr QueryRow) ToMediaType() *app.QueryRow {
+	mt := &app.QueryRow{
+		Tags:   qr.Tags,
+		Count:  qr.Count,
+		Values: qr.Values,
+	}
+	for _, hi := range qr.Histogram {
+		mt.TimeHistogram = append(mt.TimeHistogram, &app.QueryHistogram{
+			Time:   hi.Time,
+			Count:  hi.Count,
+			Values: hi.Values,
+		})
+	}
+	return mt
+}
+
+// ToMediaType converts internal QueryRowCollection representation to application one.
+func (qrc QueryRowCollection) ToMediaType() app.QueryRowCollection {
+	mt := app.QueryRowCollection{}
+	for _, qr := range qrc {
+		mt = append(mt, (QueryRow)(qr).ToMediaType())
+	}
+	return mt
+}
+
+// ToMediaType converts internal FunnelRow representation to application one.
+func (fr FunnelRow) ToMediaType() *app.Funnel {
+	steps := app.FunnelStepCollection{}
+	for _, fs := range fr.Steps {
+		step := &app.FunnelStep{
+			Step:  fs.Step,
+			Count: fs.Count,
+			Rate:  fs.Rate,
+		}
+		if fs.MedianTime != nil {
+			seconds := fs.MedianTime.Seconds()
+			step.MedianTime = &seconds
+		}
+		steps = append(steps, step)
+	}
+	return &app.Funnel{
+		Tags:  fr.Tags,
+		Steps: steps,
+	}
+}
+
+// ToMediaType converts internal FunnelRowCollection representation to application one.
+func (frc FunnelRowCollection) ToMediaType() app.FunnelCollection {
+	mt := app.FunnelCollection{}
+	for _, fr := range frc {
+		mt = append(mt, (FunnelRow)(fr).ToMediaType())
+	}
+	return mt
+}
+
+// ToMediaType converts internal RetentionRow representation to application one.
+func (rr RetentionRow) ToMediaType() *app.Retention {
+	periods := app.RetentionPeriodCollection{}
+	for _, rp := range rr.Periods {
+		periods = append(periods, &app.RetentionPeriod{
+			Period: rp.Period,
+			Active: rp.Active,
+			Share:  rp.Share,
+		})
+	}
+	return &app.Retention{
+		Cohort:  rr.Cohort,
+		Size:    rr.Size,
+		Periods: periods,
+	}
+}
+
+// ToMediaType converts internal RetentionRowCollection representation to application one.
+func (rrc RetentionRowCollection) ToMediaType() app.RetentionCollection {
+	mt := app.RetentionCollection{}
+	for _, rr := range rrc {
+		mt = append(mt, (RetentionRow)(rr).ToMediaType())
+	}
+	return mt
+}
+
+// ToMediaType converts internal AttributionRow representation to application one.
+func (ar AttributionRow) ToMediaType() *app.Attribution {
+	return &app.Attribution{
+		Tags:        ar.Tags,
+		Conversions: ar.Conversions,
+		Revenue:     ar.Revenue,
+	}
+}
+
+// ToMediaType converts internal AttributionRowCollection representation to application one.
+func (arc AttributionRowCollection) ToMediaType() app.AttributionCollection {
+	mt := app.AttributionCollection{}
+	for _, ar := range arc {
+		mt = append(mt, (AttributionRow)(ar).ToMediaType())
+	}
+	return mt
+}
+
 // ToMediaType converts internal SumRow representation to application one.
 func (sr SumRow) ToMediaType() *app.Sum {
 	thc := app.TimeHistogramCollection{}
@@ -607,3 +879,202 @@ func (arc AvgRowCollection) ToMediaType() app.AvgCollection {
 	}
 	return mt
 }
+
+// RFM represents recency, frequency and monetary scores of user.
+type RFM model.RFM
+
+// ToMediaType converts internal RFM representation to application one.
+func (r *RFM) ToMediaType() *app.Rfm {
+	return &app.Rfm{
+		UserID:     r.UserID,
+		Recency:    r.Recency,
+		Frequency:  r.Frequency,
+		Monetary:   r.Monetary,
+		Segment:    r.Segment,
+		LastVisit:  r.LastVisit,
+		Visits:     r.Visits,
+		Revenue:    r.Revenue,
+		ComputedAt: r.ComputedAt,
+	}
+}
+
+// SegmentCriteriaProblems represents problems found within segment criteria.
+type SegmentCriteriaProblems model.SegmentCriteriaProblems
+
+// ToMediaType converts internal SegmentCriteriaProblems representation to application one.
+func (scp SegmentCriteriaProblems) ToMediaType() *app.SegmentValidation {
+	mt := &app.SegmentValidation{
+		Valid:    len(scp) == 0,
+		Problems: []*app.SegmentCriteriaProblem{},
+	}
+	for _, p := range scp {
+		mt.Problems = append(mt.Problems, &app.SegmentCriteriaProblem{
+			Path:    p.Path,
+			Message: p.Message,
+		})
+	}
+	return mt
+}
+
+// SegmentExplanation represents trace of segment membership check.
+type SegmentExplanation model.SegmentExplanation
+
+// ToMediaType converts internal SegmentExplanation representation to application one.
+func (se *SegmentExplanation) ToMediaType() *app.SegmentExplanation {
+	mt := &app.SegmentExplanation{
+		Segment:      se.Segment,
+		Explicit:     se.Explicit,
+		Result:       se.Result,
+		ShortCircuit: se.ShortCircuit,
+		Rules:        []*app.SegmentRuleTrace{},
+	}
+	for _, srt := range se.Rules {
+		mt.Rules = append(mt.Rules, (*SegmentRuleTrace)(srt).ToMediaType())
+	}
+	return mt
+}
+
+// SegmentRuleTrace represents trace of single segment rule evaluation.
+type SegmentRuleTrace model.SegmentRuleTrace
+
+// ToMediaType converts internal SegmentRuleTrace representation to application one.
+func (srt *SegmentRuleTrace) ToMediaType() *app.SegmentRuleTrace {
+	sr := srt.Rule
+	mt := &app.SegmentRuleTrace{
+		ID:        sr.ID,
+		Category:  sr.EventCategory,
+		Action:    sr.EventAction,
+		Fields:    make(map[string]string),
+		Flags:     make(map[string]string),
+		Evaluated: srt.Evaluated,
+		Cached:    srt.Cached,
+		Matched:   srt.Matched,
+	}
+	if sr.Aggregate != "" {
+		mt.Aggregate = &sr.Aggregate
+	}
+	if sr.AggregateField != "" {
+		mt.AggregateField = &sr.AggregateField
+	}
+	for _, def := range sr.Fields {
+		if def["key"] != "" {
+			mt.Fields[def["key"]] = def["value"]
+		}
+	}
+	for _, def := range sr.Flags {
+		if def["key"] != "" {
+			mt.Flags[def["key"]] = def["value"]
+		}
+	}
+	if !srt.Evaluated {
+		return mt
+	}
+
+	if !srt.TimeAfter.IsZero() {
+		mt.TimeAfter = &srt.TimeAfter
+	}
+	if !srt.TimeBefore.IsZero() {
+		mt.TimeBefore = &srt.TimeBefore
+	}
+	if srt.Query != nil {
+		mt.Query = &srt.Query
+	}
+	mt.Value = &srt.Value
+	mt.Comparison = &srt.Comparison
+	return mt
+}
+
+// SegmentRevision represents immutable revision of segment.
+type SegmentRevision model.SegmentRevision
+
+// SegmentRevisionCollection is the collection of SegmentRevisions.
+type SegmentRevisionCollection model.SegmentRevisionCollection
+
+// ToMediaType converts internal SegmentRevision representation to application one.
+func (sr *SegmentRevision) ToMediaType() (*app.SegmentRevision, error) {
+	mt := &app.SegmentRevision{
+		Revision:  sr.Revision,
+		SegmentID: sr.SegmentID,
+		Name:      sr.Name,
+		GroupID:   sr.SegmentGroupID,
+		CreatedAt: sr.CreatedAt,
+	}
+	if sr.Criteria.Valid {
+		err := json.Unmarshal([]byte(sr.Criteria.String), &mt.Criteria)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to unmarshal revision's `criteria`")
+		}
+	}
+	if sr.Author.Valid {
+		mt.Author = &sr.Author.String
+	}
+	if sr.RollbackOf.Valid {
+		rollbackOf := int(sr.RollbackOf.Int64)
+		mt.RollbackOf = &rollbackOf
+	}
+	return mt, nil
+}
+
+// ToMediaType converts internal SegmentRevisionCollection representation to application one.
+func (src SegmentRevisionCollection) ToMediaType() (app.SegmentRevisionCollection, error) {
+	mt := app.SegmentRevisionCollection{}
+	for _, sr := range src {
+		srmt, err := (*SegmentRevision)(sr).ToMediaType()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to unmarshal revision [%d]", sr.Revision))
+		}
+		mt = append(mt, srmt)
+	}
+	return mt, nil
+}
+
+// SegmentRevisionDiff represents changes between two revisions of segment.
+type SegmentRevisionDiff model.SegmentRevisionDiff
+
+// ToMediaType converts internal SegmentRevisionDiff representation to application one.
+func (srd *SegmentRevisionDiff) ToMediaType() *app.SegmentRevisionDiff {
+	mt := &app.SegmentRevisionDiff{
+		From:    srd.From,
+		To:      srd.To,
+		Changes: []*app.SegmentRevisionChange{},
+	}
+	for _, c := range srd.Changes {
+		change := &app.SegmentRevisionChange{
+			Path: c.Path,
+		}
+		if c.Old != nil {
+			oldValue := c.Old
+			change.Old = &oldValue
+		}
+		if c.New != nil {
+			newValue := c.New
+			change.New = &newValue
+		}
+		mt.Changes = append(mt.Changes, change)
+	}
+	return mt
+}
+
+// SegmentMemberImport represent background import of explicit segment members.
+type SegmentMemberImport model.SegmentMemberImport
+
+// ToMediaType converts internal SegmentMemberImport representation to application one.
+func (smi *SegmentMemberImport) ToMediaType() *app.SegmentMemberImport {
+	mt := &app.SegmentMemberImport{
+		ID:         smi.ID,
+		SegmentID:  smi.SegmentID,
+		MemberType: string(smi.MemberType),
+		Mode:       smi.Mode,
+		Format:     smi.Format,
+		Status:     smi.Status,
+		Processed:  smi.Processed,
+		Changed:    smi.Changed,
+		Progress:   smi.Progress,
+		CreatedAt:  smi.CreatedAt,
+		FinishedAt: smi.FinishedAt,
+	}
+	if smi.Error != "" {
+		mt.Error = &smi.Error
+	}
+	return mt
+}
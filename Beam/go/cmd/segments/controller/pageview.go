@@ -2,6 +2,7 @@ package controller
 
 import (
 	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
 	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
 	"gitlab.com/remp/remp/Beam/go/model"
 )
@@ -27,7 +28,7 @@ func (c *PageviewController) Count(ctx *app.CountPageviewsContext) error {
 
 	crc, ok, err := c.PageviewStorage.Count(o)
 	if err != nil {
-		return err
+		return aggregateError(err)
 	}
 
 	if !ok {
@@ -50,7 +51,7 @@ func (c *PageviewController) Sum(ctx *app.SumPageviewsContext) error {
 
 	src, ok, err := c.PageviewStorage.Sum(o)
 	if err != nil {
-		return err
+		return aggregateError(err)
 	}
 
 	if !ok {
@@ -73,7 +74,7 @@ func (c *PageviewController) Avg(ctx *app.AvgPageviewsContext) error {
 
 	src, ok, err := c.PageviewStorage.Avg(o)
 	if err != nil {
-		return err
+		return aggregateError(err)
 	}
 
 	if !ok {
@@ -89,14 +90,112 @@ func (c *PageviewController) Avg(ctx *app.AvgPageviewsContext) error {
 	return ctx.OK(asrc)
 }
 
+// Percentiles runs the percentiles action.
+func (c *PageviewController) Percentiles(ctx *app.PercentilesPageviewsContext) error {
+	o := aggregateOptionsFromPageviewOptions(ctx.Payload)
+	o.Action = ctx.Action
+
+	prc, ok, err := c.PageviewStorage.Percentiles(o, ctx.Percents)
+	if err != nil {
+		return aggregateError(err)
+	}
+
+	if !ok {
+		prc = model.PercentilesRowCollection{
+			model.PercentilesRow{
+				Tags:        make(map[string]string),
+				Percentiles: make(map[string]float64),
+			},
+		}
+	}
+
+	return ctx.OK(PercentilesRowCollection(prc).ToMediaType())
+}
+
+// Distribution runs the distribution action.
+func (c *PageviewController) Distribution(ctx *app.DistributionPageviewsContext) error {
+	if ctx.Interval <= 0 {
+		return ctx.BadRequest(goa.ErrBadRequest(errors.New("interval has to be greater than zero")))
+	}
+	o := aggregateOptionsFromPageviewOptions(ctx.Payload)
+	o.Action = ctx.Action
+
+	drc, ok, err := c.PageviewStorage.Distribution(o, ctx.Interval)
+	if err != nil {
+		if err == model.ErrTooManyBuckets {
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return aggregateError(err)
+	}
+
+	if !ok {
+		drc = model.DistributionRowCollection{
+			model.DistributionRow{
+				Tags: make(map[string]string),
+			},
+		}
+	}
+
+	return ctx.OK(DistributionRowCollection(drc).ToMediaType())
+}
+
+// Max runs the max action.
+func (c *PageviewController) Max(ctx *app.MaxPageviewsContext) error {
+	o := aggregateOptionsFromPageviewOptions(ctx.Payload.Conditions)
+	o.Action = ctx.Action
+	page := listPageFromPayload(&ctx.Payload.Limit, nil, ctx.Payload.Cursor)
+
+	err := handleList(ctx.ResponseData, page, ctx.Payload.Format, func(page model.ListPage) (interface{}, string, error) {
+		mrc, next, err := c.PageviewStorage.MaxPerPageview(o, page)
+		if err != nil {
+			return nil, "", err
+		}
+		return MaxRowCollection(mrc).ToMediaType(), next, nil
+	}, func(mt interface{}) error {
+		return ctx.OK(mt.(app.MaxCollection))
+	})
+	if err == model.ErrInvalidCursor || err == model.ErrPerPageviewOptions || err == model.ErrPerPageviewLimit {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
+	}
+	return err
+}
+
+// Completion runs the completion action.
+func (c *PageviewController) Completion(ctx *app.CompletionPageviewsContext) error {
+	o := aggregateOptionsFromPageviewOptions(ctx.Payload)
+	o.Action = ctx.Action
+
+	crc, ok, err := c.PageviewStorage.Completion(o, ctx.Threshold)
+	if err != nil {
+		return aggregateError(err)
+	}
+
+	if !ok {
+		crc = model.CompletionRowCollection{
+			model.CompletionRow{
+				Tags: make(map[string]string),
+			},
+		}
+	}
+
+	return ctx.OK(CompletionRowCollection(crc).ToMediaType())
+}
+
 // Unique runs the cardinality count action.
 func (c *PageviewController) Unique(ctx *app.UniquePageviewsContext) error {
 	o := aggregateOptionsFromPageviewOptions(ctx.Payload)
 	o.Action = ctx.Action
+	o.UniqueExact = ctx.Exact
+	if ctx.PrecisionThreshold != nil {
+		o.UniquePrecision = *ctx.PrecisionThreshold
+	}
 
 	src, ok, err := c.PageviewStorage.Unique(o, ctx.Item)
 	if err != nil {
-		return err
+		if err == model.ErrTooManyUniques {
+			return ctx.BadRequest(goa.ErrBadRequest(err))
+		}
+		return aggregateError(err)
 	}
 
 	if !ok {
@@ -120,16 +219,23 @@ func (c *PageviewController) List(ctx *app.ListPageviewsContext) error {
 		SelectFields:     ctx.Payload.SelectFields,
 		LoadTimespent:    ctx.Payload.LoadTimespent,
 	}
+	page := listPageFromPayload(ctx.Payload.Limit, ctx.Payload.Sort, ctx.Payload.Cursor)
 
-	prc, err := c.PageviewStorage.List(o)
-	if err != nil {
-		return err
-	}
-	mt, err := PageviewRowCollection(prc).ToMediaType()
-	if err != nil {
-		return err
+	err := handleList(ctx.ResponseData, page, ctx.Payload.Format, func(page model.ListPage) (interface{}, string, error) {
+		o.ListPage = page
+		prc, next, err := c.PageviewStorage.List(o)
+		if err != nil {
+			return nil, "", err
+		}
+		mt, err := PageviewRowCollection(prc).ToMediaType()
+		return mt, next, err
+	}, func(mt interface{}) error {
+		return ctx.OK(mt.(app.PageviewsCollection))
+	})
+	if err == model.ErrInvalidCursor {
+		return ctx.BadRequest(goa.ErrBadRequest(err))
 	}
-	return ctx.OK(mt)
+	return err
 }
 
 // Categories runs the categories action.
@@ -156,13 +262,15 @@ func aggregateOptionsFromPageviewOptions(payload *app.PageviewOptionsPayload) mo
 
 	for _, val := range payload.FilterBy {
 		fb := &model.FilterBy{
-			Tag:    val.Tag,
-			Values: val.Values,
+			Tag:      val.Tag,
+			Values:   val.Values,
+			Operator: val.Operator,
 		}
 		o.FilterBy = append(o.FilterBy, fb)
 	}
 
 	o.GroupBy = payload.GroupBy
+	o.GroupTop = groupTopFromPayload(payload.GroupTop)
 	if payload.TimeAfter != nil {
 		o.TimeAfter = *payload.TimeAfter
 	}
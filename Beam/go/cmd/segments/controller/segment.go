@@ -1,9 +1,11 @@
 package controller
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/goadesign/goa"
@@ -12,6 +14,24 @@ import (
 	"gitlab.com/remp/remp/Beam/go/model"
 )
 
+const (
+	// nextCursorHeader is the response header containing cursor of the next page of paginated responses.
+	nextCursorHeader = "X-Next-Cursor"
+	// exportPageSize is the number of segment members evaluated at once if client doesn't limit the response.
+	exportPageSize = 1000
+)
+
+// errConflict is the class of errors returned when request conflicts with the current state of storage.
+var errConflict = goa.NewErrorClass("conflict", 409)
+
+// estimateTimeout returns count reported if the latency budget of estimate was exhausted before any
+// candidate was sampled.
+func estimateTimeout() *app.SegmentCount {
+	return &app.SegmentCount{
+		Status: "timeout",
+	}
+}
+
 // SegmentType represents type of segment (source of data used for segment)
 type SegmentType int
 
@@ -46,7 +66,8 @@ func NewSegmentController(
 
 // SegmentConfig represent configuration settings of Segment controller.
 type SegmentConfig struct {
-	URLEdit string
+	URLEdit         string
+	InvalidateToken string // token tracker authorizes invalidation of cached rule counts with, invalidation is disabled if empty
 }
 
 // Get runs the get action.
@@ -94,7 +115,7 @@ func (c *SegmentController) Groups(ctx *app.GroupsSegmentsContext) error {
 
 // CheckUser runs the check_user action.
 func (c *SegmentController) CheckUser(ctx *app.CheckUserSegmentsContext) error {
-	sc, ok, err := c.handleCheck(UserSegment, ctx.SegmentCode, ctx.UserID, ctx.Fields, ctx.Cache)
+	sc, ok, err := c.handleCheck(UserSegment, ctx.SegmentCode, ctx.UserID, ctx.Fields, ctx.Overrides, ctx.Cache, ctx.Explain)
 	if err != nil {
 		return err
 	}
@@ -106,7 +127,7 @@ func (c *SegmentController) CheckUser(ctx *app.CheckUserSegmentsContext) error {
 
 // CheckBrowser runs the check_browser action.
 func (c *SegmentController) CheckBrowser(ctx *app.CheckBrowserSegmentsContext) error {
-	sc, ok, err := c.handleCheck(BrowserSegment, ctx.SegmentCode, ctx.BrowserID, ctx.Fields, ctx.Cache)
+	sc, ok, err := c.handleCheck(BrowserSegment, ctx.SegmentCode, ctx.BrowserID, ctx.Fields, ctx.Overrides, ctx.Cache, ctx.Explain)
 	if err != nil {
 		return err
 	}
@@ -116,6 +137,80 @@ func (c *SegmentController) CheckBrowser(ctx *app.CheckBrowserSegmentsContext) e
 	return ctx.OK(sc)
 }
 
+// Check runs the check action.
+func (c *SegmentController) Check(ctx *app.CheckSegmentsContext) error {
+	p := ctx.Payload
+
+	var segmentType SegmentType
+	var identifier string
+	switch {
+	case p.UserID != nil && p.BrowserID != nil:
+		return ctx.BadRequest(goa.ErrBadRequest(errors.New("only one of user_id and browser_id can be provided")))
+	case p.UserID != nil:
+		segmentType = UserSegment
+		identifier = *p.UserID
+	case p.BrowserID != nil:
+		segmentType = BrowserSegment
+		identifier = *p.BrowserID
+	default:
+		return ctx.BadRequest(goa.ErrBadRequest(errors.New("either user_id or browser_id has to be provided")))
+	}
+
+	sc := model.SegmentCollection{}
+	missing := []string{}
+	for _, code := range p.SegmentCodes {
+		s, ok, err := c.SegmentStorage.Get(code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, code)
+			continue
+		}
+		sc = append(sc, s)
+	}
+
+	now := time.Now()
+	ro := model.RuleOverrides{
+		Fields: p.Fields,
+	}
+	if p.Overrides != nil {
+		ro.Count = p.Overrides.Count
+		ro.Value = p.Overrides.Value
+		ro.Operator = p.Overrides.Operator
+		ro.Timespan = p.Overrides.Timespan
+	}
+	segmentCache := make(model.SegmentCache)
+	for key, val := range p.Cache {
+		segmentCache[key] = &model.SegmentRuleCache{
+			SyncedAt: val.S,
+			Count:    val.C,
+		}
+	}
+	invalidateSegmentCache(segmentType, segmentCache, now)
+
+	var checks model.SegmentChecks
+	var err error
+	switch segmentType {
+	case BrowserSegment:
+		segmentCache, checks, err = c.SegmentStorage.CheckBrowserSegments(sc, identifier, now, segmentCache, ro)
+	case UserSegment:
+		segmentCache, checks, err = c.SegmentStorage.CheckUserSegments(sc, identifier, now, segmentCache, ro)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.OK(&app.SegmentsCheck{
+		Segments:          checks,
+		Missing:           missing,
+		Cache:             (SegmentCache(segmentCache)).ToMediaType(),
+		EventRules:        c.SegmentStorage.EventRules(),
+		OverridableFields: c.SegmentStorage.OverridableFields(),
+		Flags:             c.SegmentStorage.Flags(),
+	})
+}
+
 // Users runs the users action.
 func (c *SegmentController) Users(ctx *app.UsersSegmentsContext) error {
 	s, ok, err := c.SegmentStorage.Get(ctx.SegmentCode)
@@ -125,19 +220,27 @@ func (c *SegmentController) Users(ctx *app.UsersSegmentsContext) error {
 	if !ok {
 		return ctx.NotFound()
 	}
-	ro := model.RuleOverrides{}
-	if ctx.Fields != nil {
-		overrides := make(map[string]string)
-		if err := json.Unmarshal([]byte(*ctx.Fields), &overrides); err != nil {
-			return errors.Wrap(err, "invalid format of fields JSON string")
-		}
-		ro.Fields = overrides
+	ro, err := ruleOverridesFromParams(ctx.Fields, ctx.Overrides)
+	if err != nil {
+		return err
+	}
+	return c.handleMembers(ctx.ResponseData, UserSegment, s, ro, ctx.Cursor, ctx.Limit, ctx.Format, ctx.OK)
+}
+
+// Browsers runs the browsers action.
+func (c *SegmentController) Browsers(ctx *app.BrowsersSegmentsContext) error {
+	s, ok, err := c.SegmentStorage.Get(ctx.SegmentCode)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
 	}
-	uc, err := c.SegmentStorage.Users(s, time.Now(), ro)
+	ro, err := ruleOverridesFromParams(ctx.Fields, ctx.Overrides)
 	if err != nil {
 		return err
 	}
-	return ctx.OK(uc)
+	return c.handleMembers(ctx.ResponseData, BrowserSegment, s, ro, ctx.Cursor, ctx.Limit, ctx.Format, ctx.OK)
 }
 
 // Criteria runs the criteria action.
@@ -155,6 +258,195 @@ func (c *SegmentController) Criteria(ctx *app.CriteriaSegmentsContext) error {
 	return ctx.OK(mtsb)
 }
 
+// Create runs the create action.
+func (c *SegmentController) Create(ctx *app.CreateSegmentsContext) error {
+	p := ctx.Payload
+
+	scp, criteriaJSON, err := c.validateCriteria(p.Criteria)
+	if err != nil {
+		return err
+	}
+	if len(scp) > 0 {
+		return ctx.BadRequest(goa.ErrBadRequest(criteriaError(scp)))
+	}
+
+	sd := model.SegmentData{
+		Name:           p.Name,
+		Code:           p.Code,
+		Active:         p.Active,
+		SegmentGroupID: p.GroupID,
+		Criteria: sql.NullString{
+			String: criteriaJSON,
+			Valid:  true,
+		},
+	}
+	s, err := c.SegmentStorage.Create(sd, author(p.Author))
+	if err != nil {
+		if conflict, badRequest := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		} else if badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+
+	response, err := (*Segment)(s).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.Created(response)
+}
+
+// Update runs the update action.
+func (c *SegmentController) Update(ctx *app.UpdateSegmentsContext) error {
+	p := ctx.Payload
+
+	scp, criteriaJSON, err := c.validateCriteria(p.Criteria)
+	if err != nil {
+		return err
+	}
+	if len(scp) > 0 {
+		return ctx.BadRequest(goa.ErrBadRequest(criteriaError(scp)))
+	}
+
+	current, ok, err := c.SegmentStorage.GetByID(ctx.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	sd := model.SegmentData{
+		Name:           p.Name,
+		Active:         current.Active,
+		SegmentGroupID: p.GroupID,
+		Criteria: sql.NullString{
+			String: criteriaJSON,
+			Valid:  true,
+		},
+	}
+	if p.Code != nil {
+		sd.Code = *p.Code
+	}
+	s, ok, err := c.SegmentStorage.Update(ctx.ID, sd, author(p.Author), p.Version)
+	if err != nil {
+		if conflict, badRequest := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		} else if badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	response, err := (*Segment)(s).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.OK(response)
+}
+
+// Delete runs the delete action.
+func (c *SegmentController) Delete(ctx *app.DeleteSegmentsContext) error {
+	ok, err := c.SegmentStorage.Delete(ctx.ID, ctx.Version)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.NoContent()
+}
+
+// Activate runs the activate action.
+func (c *SegmentController) Activate(ctx *app.ActivateSegmentsContext) error {
+	s, ok, err := c.SegmentStorage.SetActive(ctx.ID, true, ctx.Version)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	response, err := (*Segment)(s).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.OK(response)
+}
+
+// Deactivate runs the deactivate action.
+func (c *SegmentController) Deactivate(ctx *app.DeactivateSegmentsContext) error {
+	s, ok, err := c.SegmentStorage.SetActive(ctx.ID, false, ctx.Version)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	response, err := (*Segment)(s).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.OK(response)
+}
+
+// InvalidateCache runs the invalidate_cache action. Only the tracker, authorized by the shared token, is allowed
+// to invalidate the cache.
+func (c *SegmentController) InvalidateCache(ctx *app.InvalidateCacheSegmentsContext) error {
+	token := []byte("Bearer " + c.Config.InvalidateToken)
+	if c.Config.InvalidateToken == "" || subtle.ConstantTimeCompare([]byte(ctx.Request.Header.Get("Authorization")), token) != 1 {
+		return ctx.Unauthorized()
+	}
+	events := make([]model.TrackedEvent, 0, len(ctx.Payload.Events))
+	for _, e := range ctx.Payload.Events {
+		te := model.TrackedEvent{
+			Category: e.Category,
+			Action:   e.Action,
+		}
+		if e.UserID != nil {
+			te.UserID = *e.UserID
+		}
+		if e.BrowserID != nil {
+			te.BrowserID = *e.BrowserID
+		}
+		events = append(events, te)
+	}
+	c.SegmentStorage.InvalidateRuleCache(events)
+	return ctx.NoContent()
+}
+
+// ImportStatus runs the import_status action.
+func (c *SegmentController) ImportStatus(ctx *app.ImportStatusSegmentsContext) error {
+	smi, ok := c.SegmentStorage.MemberImport(ctx.ImportID)
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK((*SegmentMemberImport)(smi).ToMediaType())
+}
+
+// Validate runs the validate action.
+func (c *SegmentController) Validate(ctx *app.ValidateSegmentsContext) error {
+	scp, _, err := c.validateCriteria(ctx.Payload.Criteria)
+	if err != nil {
+		return err
+	}
+	return ctx.OK((SegmentCriteriaProblems)(scp).ToMediaType())
+}
+
 // CreateOrUpdate runs the create_or_update action.
 func (c *SegmentController) CreateOrUpdate(ctx *app.CreateOrUpdateSegmentsContext) error {
 	if ctx.ID != nil {
@@ -165,43 +457,70 @@ func (c *SegmentController) CreateOrUpdate(ctx *app.CreateOrUpdateSegmentsContex
 
 // Count runs the count action.
 func (c *SegmentController) Count(ctx *app.CountSegmentsContext) error {
-	var usersCount int
 	if ctx.Payload.Criteria != nil && len(ctx.Payload.Criteria.Nodes) != 0 && len(ctx.Payload.Criteria.Nodes[0].Nodes) != 0 {
 		var s model.Segment
 
-		criteriaJSON, err := json.Marshal(ctx.Payload.Criteria)
+		scp, criteriaJSON, err := c.validateCriteria(ctx.Payload.Criteria)
 		if err != nil {
-			return errors.Wrap(err, "unable to marshal segment's criteria payload")
+			return err
+		}
+		if len(scp) > 0 {
+			return ctx.BadRequest(goa.ErrBadRequest(criteriaError(scp)))
 		}
 
 		s.SegmentData = model.SegmentData{
 			Criteria: sql.NullString{
-				String: string(criteriaJSON),
+				String: criteriaJSON,
 				Valid:  true,
 			},
 		}
 		sr, ok, err := c.SegmentStorage.BuildRules(&s)
 		if err != nil {
-			return nil
+			return err
+		}
+		if !ok {
+			return ctx.OK(&app.SegmentCount{
+				Status: "ok",
+				Exact:  true,
+			})
 		}
-		if ok {
-			s.Rules = sr
+		s.Rules = sr
+
+		if ctx.Mode == "exact" {
 			users, err := c.SegmentStorage.Users(&s, time.Now(), model.RuleOverrides{})
 			if err != nil {
 				return err
 			}
-			usersCount = len(users)
+			return ctx.OK(&app.SegmentCount{
+				Count:  len(users),
+				Status: "ok",
+				Exact:  true,
+			})
+		}
+
+		budget := time.Duration(ctx.Timeout) * time.Millisecond
+		se, err := c.SegmentStorage.EstimateUsers(&s, time.Now(), model.RuleOverrides{}, budget)
+		if err == model.ErrEstimateBudget {
+			return ctx.OK(estimateTimeout())
 		}
-	} else {
-		ca, err := c.SegmentStorage.CountAll()
 		if err != nil {
 			return err
 		}
-		usersCount = ca
+		return ctx.OK(&app.SegmentCount{
+			Count:      se.Count,
+			Status:     "ok",
+			Exact:      se.Exact,
+			ErrorBound: se.ErrorBound,
+		})
 	}
 
+	// count of all users is based on cardinality aggregation and therefore never exact
+	ca, err := c.SegmentStorage.CountAll()
+	if err != nil {
+		return err
+	}
 	return ctx.OK(&app.SegmentCount{
-		Count:  usersCount,
+		Count:  ca,
 		Status: "ok",
 	})
 }
@@ -230,19 +549,143 @@ func (c *SegmentController) Related(ctx *app.RelatedSegmentsContext) error {
 	return ctx.OK(&mt)
 }
 
+// Revisions runs the revisions action.
+func (c *SegmentController) Revisions(ctx *app.RevisionsSegmentsContext) error {
+	src, ok, err := c.SegmentStorage.Revisions(ctx.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	mt, err := (SegmentRevisionCollection)(src).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.OK(mt)
+}
+
+// RevisionDiff runs the revision_diff action.
+func (c *SegmentController) RevisionDiff(ctx *app.RevisionDiffSegmentsContext) error {
+	from, ok, err := c.SegmentStorage.Revision(ctx.ID, ctx.From)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	to, ok, err := c.SegmentStorage.Revision(ctx.ID, ctx.To)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	srd, err := model.DiffRevisions(from, to)
+	if err != nil {
+		return err
+	}
+	return ctx.OK((*SegmentRevisionDiff)(srd).ToMediaType())
+}
+
+// Rollback runs the rollback action.
+func (c *SegmentController) Rollback(ctx *app.RollbackSegmentsContext) error {
+	s, ok, err := c.SegmentStorage.Rollback(ctx.ID, ctx.Revision, author(ctx.Author))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+
+	response, err := (*Segment)(s).ToMediaType()
+	if err != nil {
+		return err
+	}
+	return ctx.OK(response)
+}
+
+// RevisionCount runs the revision_count action.
+func (c *SegmentController) RevisionCount(ctx *app.RevisionCountSegmentsContext) error {
+	sr, ok, err := c.SegmentStorage.Revision(ctx.ID, ctx.Revision)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	if !sr.Criteria.Valid {
+		return ctx.BadRequest(goa.ErrBadRequest(errors.New("revision has no criteria, its size can't be computed")))
+	}
+
+	s := &model.Segment{
+		ID: sr.SegmentID,
+		SegmentData: model.SegmentData{
+			Name:           sr.Name,
+			SegmentGroupID: sr.SegmentGroupID,
+			Criteria:       sr.Criteria,
+		},
+	}
+	rules, _, err := c.SegmentStorage.BuildRules(s)
+	if err != nil {
+		return ctx.BadRequest(goa.ErrBadRequest(errors.Wrap(err, "unable to build rules of revision")))
+	}
+	s.Rules = rules
+
+	at := time.Now()
+	if ctx.At != nil {
+		at = *ctx.At
+	}
+
+	if ctx.Mode == "exact" {
+		users, err := c.SegmentStorage.Users(s, at, model.RuleOverrides{})
+		if err != nil {
+			return err
+		}
+		return ctx.OK(&app.SegmentCount{
+			Count:  len(users),
+			Status: "ok",
+			Exact:  true,
+		})
+	}
+
+	budget := time.Duration(ctx.Timeout) * time.Millisecond
+	se, err := c.SegmentStorage.EstimateUsers(s, at, model.RuleOverrides{}, budget)
+	if err == model.ErrEstimateBudget {
+		return ctx.OK(estimateTimeout())
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.OK(&app.SegmentCount{
+		Count:      se.Count,
+		Status:     "ok",
+		Exact:      se.Exact,
+		ErrorBound: se.ErrorBound,
+	})
+}
+
 // handleCreate handles creation of Segment.
 func (c *SegmentController) handleCreate(ctx *app.CreateOrUpdateSegmentsContext) error {
 	p := ctx.Payload
 
-	criteriaJSON, err := json.Marshal(ctx.Payload.Criteria)
+	scp, criteriaJSON, err := c.validateCriteria(ctx.Payload.Criteria)
 	if err != nil {
-		return errors.Wrap(err, "unable to marshal segment's criteria payload")
+		return err
+	}
+	if len(scp) > 0 {
+		return ctx.BadRequest(goa.ErrBadRequest(criteriaError(scp)))
 	}
 
-	// TODO: maybe code should be also part of payload? check with CRM
-	code, err := model.Webalize(p.Name)
-	if err != nil {
-		return err
+	var code string
+	if p.Code != nil {
+		code = *p.Code
+	} else {
+		code, err = model.Webalize(p.Name)
+		if err != nil {
+			return err
+		}
 	}
 
 	sd := model.SegmentData{
@@ -251,12 +694,17 @@ func (c *SegmentController) handleCreate(ctx *app.CreateOrUpdateSegmentsContext)
 		Active:         true,
 		SegmentGroupID: p.GroupID,
 		Criteria: sql.NullString{
-			String: string(criteriaJSON),
+			String: criteriaJSON,
 			Valid:  true,
 		},
 	}
-	s, err := c.SegmentStorage.Create(sd)
+	s, err := c.SegmentStorage.Create(sd, author(p.Author))
 	if err != nil {
+		if conflict, badRequest := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		} else if badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
 		return err
 	}
 
@@ -271,21 +719,32 @@ func (c *SegmentController) handleCreate(ctx *app.CreateOrUpdateSegmentsContext)
 func (c *SegmentController) handleUpdate(ctx *app.CreateOrUpdateSegmentsContext) error {
 	p := ctx.Payload
 
-	criteriaJSON, err := json.Marshal(ctx.Payload.Criteria)
+	scp, criteriaJSON, err := c.validateCriteria(ctx.Payload.Criteria)
 	if err != nil {
-		return errors.Wrap(err, "unable to marshal segment's criteria payload")
+		return err
+	}
+	if len(scp) > 0 {
+		return ctx.BadRequest(goa.ErrBadRequest(criteriaError(scp)))
 	}
 	sd := model.SegmentData{
 		Name:           p.Name,
 		Active:         true,
 		SegmentGroupID: p.GroupID,
 		Criteria: sql.NullString{
-			String: string(criteriaJSON),
+			String: criteriaJSON,
 			Valid:  true,
 		},
 	}
-	s, ok, err := c.SegmentStorage.Update(*ctx.ID, sd)
+	if p.Code != nil {
+		sd.Code = *p.Code
+	}
+	s, ok, err := c.SegmentStorage.Update(*ctx.ID, sd, author(p.Author), nil)
 	if err != nil {
+		if conflict, badRequest := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		} else if badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
 		return err
 	}
 	if !ok {
@@ -299,8 +758,82 @@ func (c *SegmentController) handleUpdate(ctx *app.CreateOrUpdateSegmentsContext)
 	return ctx.OK(response)
 }
 
+// validateCriteria validates criteria payload against the segment blueprint and tries to build segment rules
+// from them. It returns all the problems found and the criteria in JSON to be stored if there are none.
+func (c *SegmentController) validateCriteria(payload interface{}) (model.SegmentCriteriaProblems, string, error) {
+	criteriaJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to marshal segment's criteria payload")
+	}
+	var criteria model.SegmentCriteria
+	if err := criteria.Scan(string(criteriaJSON)); err != nil {
+		return nil, "", errors.Wrap(err, "unable to scan segment's criteria payload")
+	}
+
+	scp, err := c.SegmentBlueprintStorage.Validate(criteria)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to validate segment's criteria")
+	}
+	if len(scp) > 0 {
+		return scp, string(criteriaJSON), nil
+	}
+
+	// dry run of rule building catches problems spanning multiple values (e.g. reversed timespan)
+	s := &model.Segment{
+		SegmentData: model.SegmentData{
+			Criteria: sql.NullString{
+				String: string(criteriaJSON),
+				Valid:  true,
+			},
+		},
+	}
+	if _, _, err := c.SegmentStorage.BuildRules(s); err != nil {
+		scp = append(scp, model.SegmentCriteriaProblem{
+			Path:    "nodes",
+			Message: err.Error(),
+		})
+	}
+	return scp, string(criteriaJSON), nil
+}
+
+// storageError sorts out errors caused by the current state of storage from internal ones. It returns error
+// to be sent within conflict or bad request response; both are nil for internal errors.
+func storageError(err error) (conflict, badRequest error) {
+	switch errors.Cause(err) {
+	case model.ErrSegmentCodeConflict,
+		model.ErrSegmentModified,
+		model.ErrSegmentGroupCodeConflict,
+		model.ErrSegmentGroupModified,
+		model.ErrSegmentGroupNotEmpty,
+		model.ErrSegmentGroupProtected:
+		return errConflict(err), nil
+	case model.ErrSegmentGroupNotFound,
+		model.ErrSegmentNotExplicit:
+		return nil, goa.ErrBadRequest(err)
+	}
+	return nil, nil
+}
+
+// author returns author of the change provided by the client, empty string if not provided.
+func author(a *string) string {
+	if a == nil {
+		return ""
+	}
+	return *a
+}
+
+// criteriaError returns error describing all the problems found within segment criteria.
+func criteriaError(scp model.SegmentCriteriaProblems) error {
+	problems := make([]string, 0, len(scp))
+	for _, p := range scp {
+		problems = append(problems, fmt.Sprintf("%s: %s", p.Path, p.Message))
+	}
+	return fmt.Errorf("invalid segment criteria: %s", strings.Join(problems, "; "))
+}
+
 // handleCheck determines whether provided identifier is part of segment based on given segment type.
-func (c *SegmentController) handleCheck(segmentType SegmentType, segmentCode, identifier string, fields, cache *string) (*app.SegmentCheck, bool, error) {
+// If explain is set, trace of evaluation of segment rules is included in the response.
+func (c *SegmentController) handleCheck(segmentType SegmentType, segmentCode, identifier string, fields, overrides, cache *string, explain bool) (*app.SegmentCheck, bool, error) {
 	s, ok, err := c.SegmentStorage.Get(segmentCode)
 	if err != nil {
 		return nil, false, err
@@ -310,14 +843,10 @@ func (c *SegmentController) handleCheck(segmentType SegmentType, segmentCode, id
 	}
 	now := time.Now()
 
-	// unmarshal fields and cache
-	var ro model.RuleOverrides
-	if fields != nil {
-		overrides := make(map[string]string)
-		if err := json.Unmarshal([]byte(*fields), &overrides); err != nil {
-			return nil, false, errors.Wrap(err, "invalid format of fields JSON string")
-		}
-		ro.Fields = overrides
+	// unmarshal fields, overrides and cache
+	ro, err := ruleOverridesFromParams(fields, overrides)
+	if err != nil {
+		return nil, false, err
 	}
 	var segmentCache model.SegmentCache
 	if cache != nil {
@@ -326,22 +855,17 @@ func (c *SegmentController) handleCheck(segmentType SegmentType, segmentCode, id
 		}
 	}
 
-	switch segmentType {
-	case BrowserSegment:
-		// unset invalidated elements, keeping the cache longer as single-browser doesn't need count syncing that often (no other devices affect the count)
-		for id, c := range segmentCache {
-			if c.SyncedAt.Before(now.Add(-24 * time.Hour)) {
-				delete(segmentCache, id)
-			}
-		}
+	invalidateSegmentCache(segmentType, segmentCache, now)
+
+	var se *model.SegmentExplanation
+	switch {
+	case segmentType == BrowserSegment && explain:
+		segmentCache, se, err = c.SegmentStorage.ExplainBrowser(s, identifier, now, segmentCache, ro)
+	case segmentType == BrowserSegment:
 		segmentCache, ok, err = c.SegmentStorage.CheckBrowser(s, identifier, now, segmentCache, ro)
-	case UserSegment:
-		// unset invalidated elements, removing the cache after one hour to sync count which could include other user's devices
-		for id, c := range segmentCache {
-			if c.SyncedAt.Before(now.Add(-1 * time.Hour)) {
-				delete(segmentCache, id)
-			}
-		}
+	case segmentType == UserSegment && explain:
+		segmentCache, se, err = c.SegmentStorage.ExplainUser(s, identifier, now, segmentCache, ro)
+	case segmentType == UserSegment:
 		segmentCache, ok, err = c.SegmentStorage.CheckUser(s, identifier, now, segmentCache, ro)
 	default:
 		return nil, false, fmt.Errorf("unhandled segment type: %d", segmentType)
@@ -350,15 +874,140 @@ func (c *SegmentController) handleCheck(segmentType SegmentType, segmentCode, id
 	if err != nil {
 		return nil, false, err
 	}
+	if se != nil {
+		ok = se.Result
+	}
 	er := c.SegmentStorage.EventRules()
 	of := c.SegmentStorage.OverridableFields()
 	flags := c.SegmentStorage.Flags()
 
-	return &app.SegmentCheck{
+	mt := &app.SegmentCheck{
 		Check:             ok,
 		Cache:             (SegmentCache(segmentCache)).ToMediaType(),
 		EventRules:        er,
 		OverridableFields: of,
 		Flags:             flags,
-	}, true, nil
+	}
+	if se != nil {
+		mt.Explanation = (*SegmentExplanation)(se).ToMediaType()
+	}
+	return mt, true, nil
+}
+
+// invalidateSegmentCache unsets cache elements which should be synced with DB based on given segment type.
+func invalidateSegmentCache(segmentType SegmentType, segmentCache model.SegmentCache, now time.Time) {
+	var threshold time.Time
+	switch segmentType {
+	case BrowserSegment:
+		// keeping the cache longer as single-browser doesn't need count syncing that often (no other devices affect the count)
+		threshold = now.Add(-24 * time.Hour)
+	case UserSegment:
+		// removing the cache after one hour to sync count which could include other user's devices
+		threshold = now.Add(-1 * time.Hour)
+	default:
+		return
+	}
+	for id, c := range segmentCache {
+		if c.SyncedAt.Before(threshold) {
+			delete(segmentCache, id)
+		}
+	}
+}
+
+// handleMembers lists members of segment identified by given segment type in requested format.
+//
+// If limit is provided, only single page of members is returned with cursor of the next page within
+// the response header. Otherwise all members are returned; streamed formats don't keep them in memory
+// and end with an error record if the stream can't be completed.
+func (c *SegmentController) handleMembers(rd *goa.ResponseData, segmentType SegmentType, s *model.Segment, ro model.RuleOverrides,
+	cursor *string, limit *int, format string, ok func([]string) error) error {
+	now := time.Now()
+
+	var tagName string
+	var page func(cursor string, limit int) ([]string, string, error)
+	switch segmentType {
+	case UserSegment:
+		tagName = "user_id"
+		page = func(cursor string, limit int) ([]string, string, error) {
+			return c.SegmentStorage.UsersPage(s, now, ro, cursor, limit)
+		}
+	case BrowserSegment:
+		tagName = "browser_id"
+		page = func(cursor string, limit int) ([]string, string, error) {
+			return c.SegmentStorage.BrowsersPage(s, now, ro, cursor, limit)
+		}
+	default:
+		return fmt.Errorf("unhandled segment type: %d", segmentType)
+	}
+
+	pageSize := exportPageSize
+	if limit != nil {
+		pageSize = *limit
+	}
+	var next string
+	if cursor != nil {
+		next = *cursor
+	}
+
+	// first page is loaded before writing anything so the errors are still reported properly
+	ids, next, err := page(next, pageSize)
+	if err != nil {
+		return err
+	}
+	if limit != nil && next != "" {
+		rd.Header().Set(nextCursorHeader, next)
+	}
+
+	switch format {
+	case "ndjson", "csv":
+		w := newMemberWriter(rd, format, tagName)
+		for {
+			if err := w.write(ids); err != nil {
+				return w.fail(err)
+			}
+			if limit != nil || next == "" {
+				return w.close()
+			}
+			ids, next, err = page(next, pageSize)
+			if err != nil {
+				return w.fail(err)
+			}
+		}
+	}
+
+	all := ids
+	for limit == nil && next != "" {
+		ids, next, err = page(next, pageSize)
+		if err != nil {
+			return err
+		}
+		all = append(all, ids...)
+	}
+	return ok(all)
+}
+
+// ruleOverridesFromParams unmarshals RuleOverrides from JSON-encoded fields and overrides parameters.
+func ruleOverridesFromParams(fields, overrides *string) (model.RuleOverrides, error) {
+	var ro model.RuleOverrides
+	if fields != nil {
+		fo := make(map[string]string)
+		if err := json.Unmarshal([]byte(*fields), &fo); err != nil {
+			return ro, errors.Wrap(err, "invalid format of fields JSON string")
+		}
+		ro.Fields = fo
+	}
+	if overrides != nil {
+		var po app.RuleOverridesPayload
+		if err := json.Unmarshal([]byte(*overrides), &po); err != nil {
+			return ro, errors.Wrap(err, "invalid format of overrides JSON string")
+		}
+		if err := po.Validate(); err != nil {
+			return ro, errors.Wrap(err, "invalid overrides")
+		}
+		ro.Count = po.Count
+		ro.Value = po.Value
+		ro.Operator = po.Operator
+		ro.Timespan = po.Timespan
+	}
+	return ro, nil
 }
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/goadesign/goa"
+)
+
+// csvErrorMarker is the first column of the CSV row reporting the error which ended the stream.
+const csvErrorMarker = "#error"
+
+// streamError is the last record of the NDJSON stream ended by an error after the response status was sent,
+// so the clients can tell the truncated stream from the complete one.
+type streamError struct {
+	Error string `json:"error"`
+}
+
+// memberWriter streams identifiers of segment members to the response.
+type memberWriter struct {
+	rd      *goa.ResponseData
+	tagName string
+	started bool
+
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// newMemberWriter creates memberWriter for given format ("ndjson" or "csv").
+func newMemberWriter(rd *goa.ResponseData, format, tagName string) *memberWriter {
+	w := &memberWriter{
+		rd:      rd,
+		tagName: tagName,
+	}
+	switch format {
+	case "csv":
+		rd.Header().Set("Content-Type", "text/csv")
+		w.csv = csv.NewWriter(rd)
+	default:
+		rd.Header().Set("Content-Type", "application/x-ndjson")
+		w.json = json.NewEncoder(rd)
+	}
+	return w
+}
+
+// write writes provided identifiers and flushes them to the client.
+func (w *memberWriter) write(ids []string) error {
+	if !w.started {
+		w.rd.WriteHeader(http.StatusOK)
+		if w.csv != nil {
+			if err := w.csv.Write([]string{w.tagName}); err != nil {
+				return err
+			}
+		}
+		w.started = true
+	}
+
+	for _, id := range ids {
+		if w.csv != nil {
+			if err := w.csv.Write([]string{id}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.json.Encode(map[string]string{w.tagName: id}); err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+// fail ends the stream with provided error. Response status was already sent, so the error is logged and
+// written as the last record (CSV row starting with csvErrorMarker) instead of being returned.
+func (w *memberWriter) fail(err error) error {
+	log.Println("Failed to export segment members:", err)
+	if w.csv != nil {
+		w.csv.Write([]string{csvErrorMarker, err.Error()})
+	} else {
+		w.json.Encode(streamError{Error: err.Error()})
+	}
+	w.flush()
+	return nil
+}
+
+// close flushes any buffered data.
+func (w *memberWriter) close() error {
+	return w.flush()
+}
+
+func (w *memberWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.rd.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
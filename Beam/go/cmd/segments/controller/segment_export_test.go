@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goadesign/goa"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// pagedSegmentStorage serves sorted users in pages following the SegmentStorage paging contract.
+type pagedSegmentStorage struct {
+	model.SegmentStorage
+	users     []string
+	calls     int
+	failAfter int // number of pages served before returning an error, zero to serve all
+}
+
+func (s *pagedSegmentStorage) UsersPage(segment *model.Segment, now time.Time, ro model.RuleOverrides, cursor string, limit int) ([]string, string, error) {
+	s.calls++
+	if s.failAfter > 0 && s.calls > s.failAfter {
+		return nil, "", errors.New("segment storage unavailable")
+	}
+	page := []string{}
+	for _, id := range s.users {
+		if id > cursor {
+			page = append(page, id)
+		}
+	}
+	if len(page) <= limit {
+		return page, "", nil
+	}
+	return page[:limit], page[limit-1], nil
+}
+
+func TestSegmentController_HandleMembers(t *testing.T) {
+	users := []string{"u1", "u2", "u3", "u4", "u5"}
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+
+	var membersTests = []struct {
+		Name    string
+		Cursor  *string
+		Limit   *int
+		Format  string
+		Members []string
+		Body    string
+		Next    string
+	}{
+		{Name: "all", Format: "json", Members: users},
+		{Name: "first page", Limit: num(2), Format: "json", Members: []string{"u1", "u2"}, Next: "u2"},
+		{Name: "next page", Cursor: str("u2"), Limit: num(2), Format: "json", Members: []string{"u3", "u4"}, Next: "u4"},
+		{Name: "last page", Cursor: str("u4"), Limit: num(2), Format: "json", Members: []string{"u5"}},
+		{Name: "ndjson page", Limit: num(2), Format: "ndjson", Body: "{\"user_id\":\"u1\"}\n{\"user_id\":\"u2\"}\n", Next: "u2"},
+		{Name: "csv all", Format: "csv", Body: "user_id\nu1\nu2\nu3\nu4\nu5\n"},
+	}
+
+	for _, mt := range membersTests {
+		storage := &pagedSegmentStorage{users: users}
+		c := &SegmentController{SegmentStorage: storage}
+		rec := httptest.NewRecorder()
+		rd := &goa.ResponseData{ResponseWriter: rec}
+
+		var members []string
+		err := c.handleMembers(rd, UserSegment, &model.Segment{}, model.RuleOverrides{}, mt.Cursor, mt.Limit, mt.Format, func(ids []string) error {
+			members = ids
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: returned error: %v", mt.Name, err)
+			continue
+		}
+		if mt.Members != nil && !reflect.DeepEqual(members, mt.Members) {
+			t.Errorf("%s: returned members %v, expected %v", mt.Name, members, mt.Members)
+		}
+		if mt.Body != "" {
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: returned status %d, expected %d", mt.Name, rec.Code, http.StatusOK)
+			}
+			if body := rec.Body.String(); body != mt.Body {
+				t.Errorf("%s: returned body %q, expected %q", mt.Name, body, mt.Body)
+			}
+		}
+		if next := rec.Header().Get(nextCursorHeader); next != mt.Next {
+			t.Errorf("%s: returned next cursor %q, expected %q", mt.Name, next, mt.Next)
+		}
+		if mt.Limit != nil && storage.calls != 1 {
+			t.Errorf("%s: limited response loaded %d pages, expected 1", mt.Name, storage.calls)
+		}
+	}
+}
+
+func TestMemberWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rd := &goa.ResponseData{ResponseWriter: rec}
+	w := newMemberWriter(rd, "csv", "browser_id")
+	for _, ids := range [][]string{{"b1", "b2"}, {}, {"b3"}} {
+		if err := w.write(ids); err != nil {
+			t.Fatalf("returned error: %v", err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("returned content type %s, expected text/csv", ct)
+	}
+	// header row is written only once
+	if body := rec.Body.String(); body != strings.Join([]string{"browser_id", "b1", "b2", "b3", ""}, "\n") {
+		t.Errorf("returned body %q", body)
+	}
+}
+
+func TestSegmentController_HandleMembersLateError(t *testing.T) {
+	users := make([]string, exportPageSize+1)
+	for i := range users {
+		users[i] = fmt.Sprintf("u%04d", i)
+	}
+
+	var errorTests = []struct {
+		Format  string
+		LastRow string
+	}{
+		{"ndjson", `{"error":"segment storage unavailable"}`},
+		{"csv", csvErrorMarker + ",segment storage unavailable"},
+	}
+
+	for _, et := range errorTests {
+		storage := &pagedSegmentStorage{users: users, failAfter: 1}
+		c := &SegmentController{SegmentStorage: storage}
+		rec := httptest.NewRecorder()
+		rd := &goa.ResponseData{ResponseWriter: rec}
+
+		err := c.handleMembers(rd, UserSegment, &model.Segment{}, model.RuleOverrides{}, nil, nil, et.Format, func(ids []string) error {
+			t.Errorf("%s: streamed members passed to json response", et.Format)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: returned error %v after the response was written", et.Format, err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: returned status %d, expected %d", et.Format, rec.Code, http.StatusOK)
+		}
+		rows := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+		if last := rows[len(rows)-1]; last != et.LastRow {
+			t.Errorf("%s: stream ended with %q, expected %q", et.Format, last, et.LastRow)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
+	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// SegmentGroupController implements the segment_groups resource.
+type SegmentGroupController struct {
+	*goa.Controller
+	SegmentStorage model.SegmentStorage
+}
+
+// NewSegmentGroupController creates a segment_groups controller.
+func NewSegmentGroupController(service *goa.Service, segmentStorage model.SegmentStorage) *SegmentGroupController {
+	return &SegmentGroupController{
+		Controller:     service.NewController("SegmentGroupController"),
+		SegmentStorage: segmentStorage,
+	}
+}
+
+// Show runs the show action.
+func (c *SegmentGroupController) Show(ctx *app.ShowSegmentGroupsContext) error {
+	sg, ok, err := c.SegmentStorage.GetGroup(ctx.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK((*SegmentGroup)(sg).ToMediaType())
+}
+
+// Create runs the create action.
+func (c *SegmentGroupController) Create(ctx *app.CreateSegmentGroupsContext) error {
+	sg := segmentGroupFromPayload(ctx.Payload)
+	if sg.Code == "" {
+		code, err := model.Webalize(sg.Name)
+		if err != nil {
+			return err
+		}
+		if code == "" {
+			return ctx.BadRequest(goa.ErrBadRequest(errors.New("code of segment group can't be derived from its name, provide code")))
+		}
+		sg.Code = code
+	}
+
+	created, err := c.SegmentStorage.CreateGroup(sg)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	return ctx.Created((*SegmentGroup)(created).ToMediaType())
+}
+
+// Update runs the update action.
+func (c *SegmentGroupController) Update(ctx *app.UpdateSegmentGroupsContext) error {
+	sg, ok, err := c.SegmentStorage.UpdateGroup(ctx.ID, segmentGroupFromPayload(ctx.Payload), ctx.Payload.Version)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK((*SegmentGroup)(sg).ToMediaType())
+}
+
+// Delete runs the delete action.
+func (c *SegmentGroupController) Delete(ctx *app.DeleteSegmentGroupsContext) error {
+	ok, err := c.SegmentStorage.DeleteGroup(ctx.ID)
+	if err != nil {
+		if conflict, _ := storageError(err); conflict != nil {
+			return ctx.Conflict(conflict)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.NoContent()
+}
+
+// Sort runs the sort action.
+func (c *SegmentGroupController) Sort(ctx *app.SortSegmentGroupsContext) error {
+	sgc, err := c.SegmentStorage.SortGroups(ctx.Payload.Ids)
+	if err != nil {
+		if _, badRequest := storageError(err); badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	return ctx.OK((SegmentGroupCollection)(sgc).ToMediaType())
+}
+
+// segmentGroupFromPayload converts segment group payload to internal representation.
+func segmentGroupFromPayload(p *app.SegmentGroupPayload) model.SegmentGroup {
+	sg := model.SegmentGroup{
+		Name: p.Name,
+	}
+	if p.Type != nil {
+		sg.Type = *p.Type
+	}
+	if p.Code != nil {
+		sg.Code = *p.Code
+	}
+	if p.Sorting != nil {
+		sg.Sorting = *p.Sorting
+	}
+	return sg
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"github.com/goadesign/goa"
+	"github.com/pkg/errors"
+	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// SegmentMemberController implements the segment_members resource.
+type SegmentMemberController struct {
+	*goa.Controller
+	SegmentStorage model.SegmentStorage
+}
+
+// NewSegmentMemberController creates a segment_members controller.
+func NewSegmentMemberController(service *goa.Service, segmentStorage model.SegmentStorage) *SegmentMemberController {
+	return &SegmentMemberController{
+		Controller:     service.NewController("SegmentMemberController"),
+		SegmentStorage: segmentStorage,
+	}
+}
+
+// List runs the list action. All the members are listed page by page if client doesn't limit the response.
+func (c *SegmentMemberController) List(ctx *app.ListSegmentMembersContext) error {
+	pageSize := exportPageSize
+	if ctx.Limit != nil {
+		pageSize = *ctx.Limit
+	}
+	var cursor string
+	if ctx.Cursor != nil {
+		cursor = *ctx.Cursor
+	}
+
+	all := []string{}
+	for {
+		members, next, ok, err := c.SegmentStorage.Members(ctx.ID, model.MemberType(ctx.MemberType), cursor, pageSize)
+		if err != nil {
+			if err == model.ErrInvalidCursor {
+				return ctx.BadRequest(goa.ErrBadRequest(err))
+			}
+			if _, badRequest := storageError(err); badRequest != nil {
+				return ctx.BadRequest(badRequest)
+			}
+			return err
+		}
+		if !ok {
+			return ctx.NotFound()
+		}
+		all = append(all, members...)
+		if ctx.Limit != nil {
+			if next != "" {
+				ctx.ResponseData.Header().Set(nextCursorHeader, next)
+			}
+			break
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return ctx.OK(all)
+}
+
+// Add runs the add action.
+func (c *SegmentMemberController) Add(ctx *app.AddSegmentMembersContext) error {
+	changed, ok, err := c.SegmentStorage.AddMembers(ctx.ID, model.MemberType(ctx.MemberType), ctx.Payload.Ids)
+	if err != nil {
+		if _, badRequest := storageError(err); badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK(&app.SegmentMembersChange{
+		Changed: changed,
+	})
+}
+
+// Remove runs the remove action.
+func (c *SegmentMemberController) Remove(ctx *app.RemoveSegmentMembersContext) error {
+	changed, ok, err := c.SegmentStorage.RemoveMembers(ctx.ID, model.MemberType(ctx.MemberType), ctx.Payload.Ids)
+	if err != nil {
+		if _, badRequest := storageError(err); badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK(&app.SegmentMembersChange{
+		Changed: changed,
+	})
+}
+
+// Replace runs the replace action.
+func (c *SegmentMemberController) Replace(ctx *app.ReplaceSegmentMembersContext) error {
+	changed, ok, err := c.SegmentStorage.ReplaceMembers(ctx.ID, model.MemberType(ctx.MemberType), ctx.Payload.Ids)
+	if err != nil {
+		if _, badRequest := storageError(err); badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK(&app.SegmentMembersChange{
+		Changed: changed,
+	})
+}
+
+// Import runs the import action.
+func (c *SegmentMemberController) Import(ctx *app.ImportSegmentMembersContext) error {
+	defer ctx.Request.Body.Close()
+
+	smi, ok, err := c.SegmentStorage.ImportMembers(ctx.ID, model.MemberType(ctx.MemberType), ctx.Mode, ctx.Format, ctx.Request.Body)
+	if err != nil {
+		if errors.Cause(err) == model.ErrImportTooLarge {
+			return ctx.RequestEntityTooLarge(goa.ErrRequestBodyTooLarge(err))
+		}
+		if _, badRequest := storageError(err); badRequest != nil {
+			return ctx.BadRequest(badRequest)
+		}
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.Accepted((*SegmentMemberImport)(smi).ToMediaType())
+}
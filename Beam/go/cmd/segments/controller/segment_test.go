@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goadesign/goa"
+	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// invalidatingSegmentStorage records events the rule cache was invalidated with.
+type invalidatingSegmentStorage struct {
+	model.SegmentStorage
+	invalidated []model.TrackedEvent
+}
+
+func (s *invalidatingSegmentStorage) InvalidateRuleCache(events []model.TrackedEvent) {
+	s.invalidated = append(s.invalidated, events...)
+}
+
+func TestSegmentController_InvalidateCache(t *testing.T) {
+	var invalidateTests = []struct {
+		Name          string
+		Token         string
+		Authorization string
+		Status        int
+	}{
+		{"authorized", "secret", "Bearer secret", http.StatusNoContent},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"invalidation disabled", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, it := range invalidateTests {
+		storage := &invalidatingSegmentStorage{}
+		c := &SegmentController{SegmentStorage: storage, Config: SegmentConfig{InvalidateToken: it.Token}}
+		req := httptest.NewRequest(http.MethodPost, "/segments/cache/invalidate", nil)
+		if it.Authorization != "" {
+			req.Header.Set("Authorization", it.Authorization)
+		}
+		rec := httptest.NewRecorder()
+		ctx := &app.InvalidateCacheSegmentsContext{
+			Context:      context.Background(),
+			ResponseData: &goa.ResponseData{ResponseWriter: rec},
+			RequestData:  &goa.RequestData{Request: req},
+			Payload: &app.TrackedEventsPayload{
+				Events: []*app.TrackedEvent{{Category: "pageview", Action: "load"}},
+			},
+		}
+
+		if err := c.InvalidateCache(ctx); err != nil {
+			t.Errorf("%s: returned error: %v", it.Name, err)
+			continue
+		}
+		if rec.Code != it.Status {
+			t.Errorf("%s: returned status %d, expected %d", it.Name, rec.Code, it.Status)
+		}
+		if invalidated := len(storage.invalidated) > 0; invalidated != (it.Status == http.StatusNoContent) {
+			t.Errorf("%s: invalidated cache %t", it.Name, invalidated)
+		}
+	}
+}
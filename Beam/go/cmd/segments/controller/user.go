@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"github.com/goadesign/goa"
+	"gitlab.com/remp/remp/Beam/go/cmd/segments/app"
+	"gitlab.com/remp/remp/Beam/go/model"
+)
+
+// UserController implements the users resource.
+type UserController struct {
+	*goa.Controller
+	RFMStorage model.RFMStorage
+}
+
+// NewUserController creates a user controller.
+func NewUserController(service *goa.Service, rs model.RFMStorage) *UserController {
+	return &UserController{
+		Controller: service.NewController("UserController"),
+		RFMStorage: rs,
+	}
+}
+
+// Rfm runs the rfm action.
+func (c *UserController) Rfm(ctx *app.RfmUsersContext) error {
+	rfm, ok, err := c.RFMStorage.Get(ctx.UserID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ctx.NotFound()
+	}
+	return ctx.OK((*RFM)(rfm).ToMediaType())
+}
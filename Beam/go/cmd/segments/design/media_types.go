@@ -61,6 +61,126 @@ var Avg = MediaType("application/vnd.avg+json", func() {
 	Required("tags", "avg")
 })
 
+var Max = MediaType("application/vnd.max+json", func() {
+	Description("Max")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String))
+		Attribute("max", Number)
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("max")
+	})
+	Required("tags", "max")
+})
+
+var Completion = MediaType("application/vnd.completion+json", func() {
+	Description("Share of pageviews which reached the progress threshold")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String))
+		Attribute("pageviews", Integer, "Number of pageviews")
+		Attribute("completed", Integer, "Number of pageviews which reached the threshold")
+		Attribute("rate", Number, "Share of pageviews which reached the threshold")
+		Attribute("time_histogram", CollectionOf(CompletionHistogram))
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("pageviews")
+		Attribute("completed")
+		Attribute("rate")
+		Attribute("time_histogram")
+	})
+	Required("tags", "pageviews", "completed", "rate")
+})
+
+var CompletionHistogram = MediaType("application/vnd.completion.histogram+json", func() {
+	Description("Share of pageviews which reached the progress threshold within time bucket")
+	Attributes(func() {
+		Attribute("time", DateTime)
+		Attribute("pageviews", Integer)
+		Attribute("completed", Integer)
+		Attribute("rate", Number)
+	})
+	View("default", func() {
+		Attribute("time")
+		Attribute("pageviews")
+		Attribute("completed")
+		Attribute("rate")
+	})
+	Required("time", "pageviews", "completed", "rate")
+})
+
+var Percentiles = MediaType("application/vnd.percentiles+json", func() {
+	Description("Percentiles")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String))
+		Attribute("percentiles", HashOf(String, Number), "Values of percentiles keyed by the percent")
+		Attribute("time_histogram", CollectionOf(PercentilesHistogram))
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("percentiles")
+		Attribute("time_histogram")
+	})
+	Required("tags", "percentiles")
+})
+
+var PercentilesHistogram = MediaType("application/vnd.percentiles.histogram+json", func() {
+	Description("Percentiles within time bucket")
+	Attributes(func() {
+		Attribute("time", DateTime)
+		Attribute("percentiles", HashOf(String, Number), "Values of percentiles keyed by the percent")
+	})
+	View("default", func() {
+		Attribute("time")
+		Attribute("percentiles")
+	})
+	Required("time", "percentiles")
+})
+
+var Distribution = MediaType("application/vnd.distribution+json", func() {
+	Description("Distribution of values")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String))
+		Attribute("count", Integer, "Number of all the values")
+		Attribute("buckets", CollectionOf(DistributionBucket))
+		Attribute("time_histogram", CollectionOf(DistributionHistogram))
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("count")
+		Attribute("buckets")
+		Attribute("time_histogram")
+	})
+	Required("tags", "count", "buckets")
+})
+
+var DistributionBucket = MediaType("application/vnd.distribution.bucket+json", func() {
+	Description("Number of values within the bucket")
+	Attributes(func() {
+		Attribute("from", Number, "Lower bound of the bucket (inclusive)")
+		Attribute("count", Integer)
+	})
+	View("default", func() {
+		Attribute("from")
+		Attribute("count")
+	})
+	Required("from", "count")
+})
+
+var DistributionHistogram = MediaType("application/vnd.distribution.histogram+json", func() {
+	Description("Distribution of values within time bucket")
+	Attributes(func() {
+		Attribute("time", DateTime)
+		Attribute("buckets", CollectionOf(DistributionBucket))
+	})
+	View("default", func() {
+		Attribute("time")
+		Attribute("buckets")
+	})
+	Required("time", "buckets")
+})
+
 var Pageviews = MediaType("application/vnd.pageviews+json", func() {
 	Description("Pageviews")
 	Attributes(func() {
@@ -109,6 +229,9 @@ var Segment = MediaType("application/vnd.segment+json", func() {
 		Attribute("group", SegmentGroup)
 		Attribute("criteria", Any, "Criteria used to build segment")
 		Attribute("url", String, "URL to segment")
+		Attribute("active", Boolean, "Flag whether segment is active")
+		Attribute("updated_at", DateTime, "Time of the last modification of segment")
+		Attribute("version", Integer, "Version of segment incremented on every modification, used for optimistic concurrency")
 
 		Attribute("table_name", String)
 		Attribute("fields", ArrayOf(String))
@@ -120,6 +243,9 @@ var Segment = MediaType("application/vnd.segment+json", func() {
 		Attribute("name")
 		Attribute("group")
 		Attribute("criteria")
+		Attribute("active")
+		Attribute("updated_at")
+		Attribute("version")
 	})
 	View("tiny", func() {
 		Attribute("id")
@@ -184,6 +310,7 @@ var SegmentCheck = MediaType("application/vnd.segment.check+json", func() {
 		Attribute("event_rules", HashOf(String, ArrayOf(Integer)), "Map of which rules should be incremented for selected events.")
 		Attribute("overridable_fields", HashOf(Integer, ArrayOf(String)), "Array of overridable fields belonging to rules.")
 		Attribute("flags", HashOf(Integer, HashOf(String, String)), "Array of flags belonging to rules.")
+		Attribute("explanation", SegmentExplanation, "Trace of evaluation of segment rules, provided only if requested")
 	})
 	View("default", func() {
 		Attribute("check")
@@ -191,21 +318,216 @@ var SegmentCheck = MediaType("application/vnd.segment.check+json", func() {
 		Attribute("event_rules")
 		Attribute("overridable_fields")
 		Attribute("flags")
+		Attribute("explanation")
 	})
 	Required("check", "cache", "event_rules", "overridable_fields", "flags")
 })
 
+var SegmentExplanation = MediaType("application/vnd.segment.explanation+json", func() {
+	Description("Trace of segment membership check")
+	Attributes(func() {
+		Attribute("segment", String, "Code of checked segment")
+		Attribute("explicit", Boolean, "Flag whether segment is explicit and its membership is not evaluated by rules")
+		Attribute("result", Boolean, "Result of the check")
+		Attribute("short_circuit", Integer, "Index of rule which stopped the evaluation, missing if all the rules were evaluated")
+		Attribute("rules", ArrayOf(SegmentRuleTrace), "Trace of each segment rule in the order of evaluation")
+	})
+	View("default", func() {
+		Attribute("segment")
+		Attribute("explicit")
+		Attribute("result")
+		Attribute("short_circuit")
+		Attribute("rules")
+	})
+	Required("segment", "explicit", "result", "rules")
+})
+
+var SegmentRuleTrace = MediaType("application/vnd.segment.rule.trace+json", func() {
+	Description("Trace of single segment rule evaluation")
+	Attributes(func() {
+		Attribute("id", Integer, "ID of segment rule, zero for rules built from criteria")
+		Attribute("category", String, "Category of evaluated events")
+		Attribute("action", String, "Action of evaluated events")
+		Attribute("aggregate", String, "Aggregate function of value-aggregate rule")
+		Attribute("aggregate_field", String, "Field aggregated by distinct aggregate function")
+		Attribute("fields", HashOf(String, String), "Fields of the rule after overrides were applied")
+		Attribute("flags", HashOf(String, String), "Flags of the rule")
+		Attribute("time_after", DateTime, "Resolved start of time window")
+		Attribute("time_before", DateTime, "Resolved end of time window")
+		Attribute("query", Any, "Filter query used by the storage")
+		Attribute("evaluated", Boolean, "Flag whether rule was evaluated, false if the evaluation short-circuited before")
+		Attribute("cached", Boolean, "Flag whether count was provided by client cache")
+		Attribute("value", Number, "Counted (or aggregated) value")
+		Attribute("comparison", String, "Comparison of value against thresholds of the rule, e.g. `12 >= 5`")
+		Attribute("matched", Boolean, "Flag whether the value matched the rule")
+	})
+	View("default", func() {
+		Attribute("id")
+		Attribute("category")
+		Attribute("action")
+		Attribute("aggregate")
+		Attribute("aggregate_field")
+		Attribute("fields")
+		Attribute("flags")
+		Attribute("time_after")
+		Attribute("time_before")
+		Attribute("query")
+		Attribute("evaluated")
+		Attribute("cached")
+		Attribute("value")
+		Attribute("comparison")
+		Attribute("matched")
+	})
+	Required("id", "category", "action", "fields", "flags", "evaluated", "cached", "matched")
+})
+
+var SegmentsCheck = MediaType("application/vnd.segments.check+json", func() {
+	Description("Check of multiple segments")
+	Attributes(func() {
+		Attribute("segments", HashOf(String, Boolean), "Flags whether user (browser) is in the segment or not indexed by segment code")
+		Attribute("missing", ArrayOf(String), "Codes of requested segments which don't exist")
+		Attribute("cache", HashOf(Integer, SegmentRuleCache), "Cache object for third party (remplib.js) to use indexed by SegmentRule-based key")
+		Attribute("event_rules", HashOf(String, ArrayOf(Integer)), "Map of which rules should be incremented for selected events.")
+		Attribute("overridable_fields", HashOf(Integer, ArrayOf(String)), "Array of overridable fields belonging to rules.")
+		Attribute("flags", HashOf(Integer, HashOf(String, String)), "Array of flags belonging to rules.")
+	})
+	View("default", func() {
+		Attribute("segments")
+		Attribute("missing")
+		Attribute("cache")
+		Attribute("event_rules")
+		Attribute("overridable_fields")
+		Attribute("flags")
+	})
+	Required("segments", "missing", "cache", "event_rules", "overridable_fields", "flags")
+})
+
+var SegmentRevision = MediaType("application/vnd.segment.revision+json", func() {
+	Description("Immutable revision of segment")
+	Attributes(func() {
+		Attribute("revision", Integer, "Sequence number of revision within the segment")
+		Attribute("segment_id", Integer, "ID of segment")
+		Attribute("name", String, "Name of segment")
+		Attribute("group_id", Integer, "ID of segment group")
+		Attribute("criteria", Any, "Criteria of segment")
+		Attribute("author", String, "Author of the change")
+		Attribute("rollback_of", Integer, "Revision the segment was rolled back to")
+		Attribute("created_at", DateTime, "Time of the change")
+	})
+	View("default", func() {
+		Attribute("revision")
+		Attribute("segment_id")
+		Attribute("name")
+		Attribute("group_id")
+		Attribute("criteria")
+		Attribute("author")
+		Attribute("rollback_of")
+		Attribute("created_at")
+	})
+	Required("revision", "segment_id", "name", "group_id", "created_at")
+})
+
+var SegmentMembersChange = MediaType("application/vnd.segment.members.change+json", func() {
+	Description("Result of change of explicit segment members")
+	Attributes(func() {
+		Attribute("changed", Integer, "Number of added, removed or stored members")
+	})
+	View("default", func() {
+		Attribute("changed")
+	})
+	Required("changed")
+})
+
+var SegmentMemberImport = MediaType("application/vnd.segment.member.import+json", func() {
+	Description("Background import of explicit segment members")
+	Attributes(func() {
+		Attribute("id", String, "ID of import")
+		Attribute("segment_id", Integer, "ID of segment the members are imported to")
+		Attribute("member_type", String, "Type of imported members", func() {
+			Enum("users", "browsers")
+		})
+		Attribute("mode", String, "Import mode", func() {
+			Enum("add", "replace")
+		})
+		Attribute("format", String, "Format of imported data", func() {
+			Enum("csv", "ndjson")
+		})
+		Attribute("status", String, "Status of import", func() {
+			Enum("pending", "running", "finished", "failed")
+		})
+		Attribute("processed", Integer, "Number of identifiers read so far")
+		Attribute("changed", Integer, "Number of members added so far")
+		Attribute("progress", Number, "Ratio of already processed data, within [0, 1]")
+		Attribute("error", String, "Reason of failure of import")
+		Attribute("created_at", DateTime, "Time the import was started")
+		Attribute("finished_at", DateTime, "Time the import finished")
+	})
+	View("default", func() {
+		Attribute("id")
+		Attribute("segment_id")
+		Attribute("member_type")
+		Attribute("mode")
+		Attribute("format")
+		Attribute("status")
+		Attribute("processed")
+		Attribute("changed")
+		Attribute("progress")
+		Attribute("error")
+		Attribute("created_at")
+		Attribute("finished_at")
+	})
+	Required("id", "segment_id", "member_type", "mode", "format", "status", "processed", "changed", "progress", "created_at")
+})
+
+var SegmentRevisionDiff = MediaType("application/vnd.segment.revision.diff+json", func() {
+	Description("Changes between two revisions of segment")
+	Attributes(func() {
+		Attribute("from", Integer, "Revision compared from")
+		Attribute("to", Integer, "Revision compared to")
+		Attribute("changes", ArrayOf(SegmentRevisionChange), "Changed values")
+	})
+	View("default", func() {
+		Attribute("from")
+		Attribute("to")
+		Attribute("changes")
+	})
+	Required("from", "to", "changes")
+})
+
+var SegmentRevisionChange = MediaType("application/vnd.segment.revision.change+json", func() {
+	Description("Single value changed between two revisions of segment")
+	Attributes(func() {
+		Attribute("path", String, "Path to the changed value, e.g. `criteria.nodes[0].nodes[1].values.count.gte`")
+		Attribute("old", Any, "Value within the older revision, missing if the value was added")
+		Attribute("new", Any, "Value within the newer revision, missing if the value was removed")
+	})
+	View("default", func() {
+		Attribute("path")
+		Attribute("old")
+		Attribute("new")
+	})
+	Required("path")
+})
+
 var SegmentGroup = MediaType("application/vnd.segment.group+json", func() {
 	Description("Segment group")
 	Attributes(func() {
 		Attribute("id", Integer, "Internal ID of segment group")
 		Attribute("name", String, "User-friendly name of segment group")
+		Attribute("code", String, "Code-friendly identificator of segment group")
+		Attribute("type", String, "Type of segments within the group")
 		Attribute("sorting", Integer, "Sort order index")
+		Attribute("updated_at", DateTime, "Time of the last modification of segment group")
+		Attribute("version", Integer, "Version of segment group incremented on every modification, used for optimistic concurrency")
 	})
 	View("default", func() {
 		Attribute("id")
 		Attribute("name")
+		Attribute("code")
+		Attribute("type")
 		Attribute("sorting")
+		Attribute("updated_at")
+		Attribute("version")
 	})
 	Required("id", "name", "sorting")
 })
@@ -257,13 +579,43 @@ var SegmentCount = MediaType("application/vnd.segment.count+json", func() {
 	Description("Segment count")
 	Attributes(func() {
 		Attribute("count", Integer, "Number of users in segment based on provided criteria")
-		Attribute("status", String, "Status of count. If everything is fine, returns `ok`.")
+		Attribute("status", String, "Status of count. If everything is fine, returns `ok`; `timeout` is returned without any count if the latency budget of estimate was exhausted before any user was sampled.")
+		Attribute("exact", Boolean, "Flag whether count is exact or estimated")
+		Attribute("error_bound", Integer, "Half-width of ~95% confidence interval of estimated count")
 	})
 	View("default", func() {
 		Attribute("count")
 		Attribute("status")
+		Attribute("exact")
+		Attribute("error_bound")
+	})
+	Required("count", "status", "exact", "error_bound")
+})
+
+var SegmentValidation = MediaType("application/vnd.segment.validation+json", func() {
+	Description("Result of segment criteria validation")
+	Attributes(func() {
+		Attribute("valid", Boolean, "Flag whether criteria are valid")
+		Attribute("problems", ArrayOf(SegmentCriteriaProblem), "All the problems found within criteria")
 	})
-	Required("count", "status")
+	View("default", func() {
+		Attribute("valid")
+		Attribute("problems")
+	})
+	Required("valid", "problems")
+})
+
+var SegmentCriteriaProblem = MediaType("application/vnd.segment.criteria.problem+json", func() {
+	Description("Problem found within segment criteria")
+	Attributes(func() {
+		Attribute("path", String, "Path to the invalid node or value, e.g. `nodes[0].nodes[1].values.count`")
+		Attribute("message", String, "Description of the problem")
+	})
+	View("default", func() {
+		Attribute("path")
+		Attribute("message")
+	})
+	Required("path", "message")
 })
 
 var Event = MediaType("application/vnd.event+json", func() {
@@ -293,6 +645,113 @@ var Event = MediaType("application/vnd.event+json", func() {
 	Required("id", "system", "category", "action")
 })
 
+var QueryRow = MediaType("application/vnd.query.row+json", func() {
+	Description("Metrics of single row of the query")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String), "Values of grouped tags")
+		Attribute("count", Integer, "Number of records within the row")
+		Attribute("values", HashOf(String, Number), "Values of the metrics keyed by their names (percentiles are suffixed by _p<percent>)")
+		Attribute("time_histogram", CollectionOf(QueryHistogram), "Metrics split into time buckets")
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("count")
+		Attribute("values")
+		Attribute("time_histogram")
+	})
+	Required("tags", "count", "values")
+})
+
+var Attribution = MediaType("application/vnd.attribution+json", func() {
+	Description("Purchases attributed to single value of the tag")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String), "Value of the attributed tag (empty for purchases without preceding pageviews)")
+		Attribute("conversions", Number, "Number of attributed purchases")
+		Attribute("revenue", Number, "Attributed revenue of the purchases")
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("conversions")
+		Attribute("revenue")
+	})
+	Required("tags", "conversions", "revenue")
+})
+
+var Funnel = MediaType("application/vnd.funnel+json", func() {
+	Description("Commerce funnel of single group")
+	Attributes(func() {
+		Attribute("tags", HashOf(String, String), "Values of funnel_id and grouped tags")
+		Attribute("steps", CollectionOf(FunnelStep), "Commerce steps in order of the funnel")
+	})
+	View("default", func() {
+		Attribute("tags")
+		Attribute("steps")
+	})
+	Required("tags", "steps")
+})
+
+var FunnelStep = MediaType("application/vnd.funnel.step+json", func() {
+	Description("Number of users or browsers which reached the commerce step")
+	Attributes(func() {
+		Attribute("step", String, "Commerce step")
+		Attribute("count", Integer, "Number of users or browsers which reached the step after all the previous steps")
+		Attribute("rate", Number, "Share of users or browsers of the previous step which reached this step")
+		Attribute("median_time", Number, "Median number of seconds between reaching the previous step and this step")
+	})
+	View("default", func() {
+		Attribute("step")
+		Attribute("count")
+		Attribute("rate")
+		Attribute("median_time")
+	})
+	Required("step", "count", "rate")
+})
+
+var Retention = MediaType("application/vnd.retention+json", func() {
+	Description("Single cohort of the retention matrix")
+	Attributes(func() {
+		Attribute("cohort", DateTime, "Start of the period the cohort members joined the cohort")
+		Attribute("size", Integer, "Number of cohort members")
+		Attribute("periods", CollectionOf(RetentionPeriod), "Activity within the cohort period and the following periods")
+	})
+	View("default", func() {
+		Attribute("cohort")
+		Attribute("size")
+		Attribute("periods")
+	})
+	Required("cohort", "size", "periods")
+})
+
+var RetentionPeriod = MediaType("application/vnd.retention.period+json", func() {
+	Description("Activity of the cohort members within single period")
+	Attributes(func() {
+		Attribute("period", Integer, "Number of periods since the cohort period (zero for the cohort period)")
+		Attribute("active", Integer, "Number of cohort members active within the period")
+		Attribute("share", Number, "Share of cohort members active within the period")
+	})
+	View("default", func() {
+		Attribute("period")
+		Attribute("active")
+		Attribute("share")
+	})
+	Required("period", "active", "share")
+})
+
+var QueryHistogram = MediaType("application/vnd.query.histogram+json", func() {
+	Description("Metrics of single time bucket")
+	Attributes(func() {
+		Attribute("time", DateTime)
+		Attribute("count", Integer)
+		Attribute("values", HashOf(String, Number))
+	})
+	View("default", func() {
+		Attribute("time")
+		Attribute("count")
+		Attribute("values")
+	})
+	Required("time", "count", "values")
+})
+
 var TimeHistogram = MediaType("application/vnd.time.histogram+json", func() {
 	Description("Time histogram data")
 	Attributes(func() {
@@ -502,3 +961,32 @@ var SegmentGroupsFallback = MediaType("application/vnd.segment.groups.fallback",
 	})
 	Required("status", "groups")
 })
+
+var RFM = MediaType("application/vnd.rfm+json", func() {
+	Description("Recency, frequency and monetary scores of user")
+	Attributes(func() {
+		Attribute("user_id", String, "ID of user")
+		Attribute("recency", Integer, "Recency score (1-5) based on the last visit")
+		Attribute("frequency", Integer, "Frequency score (1-5) based on the number of visits")
+		Attribute("monetary", Integer, "Monetary score (1-5) based on the revenue of purchases")
+		Attribute("segment", String, "RFM segment based on the combination of scores", func() {
+			Enum("champions", "loyal", "potential", "new", "need_attention", "at_risk", "hibernating")
+		})
+		Attribute("last_visit", DateTime, "Time of the last visit")
+		Attribute("visits", Integer, "Number of visits within the scoring period")
+		Attribute("revenue", Number, "Revenue of purchases within the scoring period")
+		Attribute("computed_at", DateTime, "Time the scores were computed")
+	})
+	View("default", func() {
+		Attribute("user_id")
+		Attribute("recency")
+		Attribute("frequency")
+		Attribute("monetary")
+		Attribute("segment")
+		Attribute("last_visit")
+		Attribute("visits")
+		Attribute("revenue")
+		Attribute("computed_at")
+	})
+	Required("user_id", "recency", "frequency", "monetary", "segment", "last_visit", "visits", "revenue", "computed_at")
+})
@@ -23,6 +23,44 @@ const (
 		"utm_campaign": "custom-campaign-id",
 		// ...
 	}`
+	OverridesParamDescription = `JSON-encoded object of overriden rule parameters, applied only to rules marking the parameter
+	as overridable, e.g.:
+
+	{
+		"count": 5, // threshold of event count
+		"value": 12.5, // threshold of aggregated value of value-aggregate rules
+		"operator": ">=", // one of =, >, >=, <, <=
+		"timespan": 720 // timespan in minutes
+	}`
+	ExplainParamDescription = `Flag whether the response should contain trace of evaluation of each segment rule`
+	LimitParamDescription   = `Maximum number of items returned within one page. If not provided, all items are returned.`
+	CursorParamDescription  = `Cursor of the page to return, as provided by X-Next-Cursor header of the previous page.`
+	FormatParamDescription  = `Format of the response:
+
+	- json: JSON array of identifiers
+	- ndjson: newline-delimited JSON objects streamed as they're being evaluated; stream which couldn't
+	  be completed ends with {"error": "..."} object
+	- csv: CSV with header streamed as they're being evaluated; stream which couldn't be completed ends
+	  with "#error,..." row`
+	FilterOperatorDescription = `Operator used to match values of TAG:
+
+	- in, not_in: tag equals (doesn't equal) any of the values
+	- gt, gte, lt, lte: tag is within range bounded by single numeric or RFC3339 datetime value
+	- prefix, wildcard: tag matches any of the prefixes or wildcard patterns (e.g. https://*.example.com/*)
+	- exists, missing: tag is (not) set, values are ignored`
+	ListFormatDescription = `Format of the response:
+
+	- json: JSON array of rows; without limit at most 10000 records can be returned, larger results need
+	  to be paged or streamed
+	- ndjson: newline-delimited JSON rows streamed page by page; records are grouped within each page; stream
+	  which couldn't be completed ends with {"error": "..."} object`
+	UniqueItemParamDescription = `Identification of queried unique items: browsers, users, sessions, pageviews, articles,
+	authors or name of any other tag`
+	PrecisionThresholdParamDescription = `Precision threshold of approximated unique count; counts below the threshold are
+	expected to be close to accurate (defaults to configured precision)`
+	ExactParamDescription = `Flag whether unique values should be counted exactly (up to 10000 values per group)`
+	NextCursorHeader      = "X-Next-Cursor"
+	MaxExportPageSize     = 10000
 )
 
 var _ = Resource("swagger", func() {
@@ -82,7 +120,11 @@ var _ = Resource("segments", func() {
 				Pattern(UserPattern)
 			})
 			Param("fields", String, FieldsParamDescription)
+			Param("overrides", String, OverridesParamDescription)
 			Param("cache", String, CacheParamDescription)
+			Param("explain", Boolean, ExplainParamDescription, func() {
+				Default(false)
+			})
 		})
 		Response(NotFound)
 		Response(BadRequest)
@@ -101,7 +143,11 @@ var _ = Resource("segments", func() {
 				Pattern(UserPattern)
 			})
 			Param("fields", String, FieldsParamDescription)
+			Param("overrides", String, OverridesParamDescription)
 			Param("cache", String, CacheParamDescription)
+			Param("explain", Boolean, ExplainParamDescription, func() {
+				Default(false)
+			})
 		})
 		Response(NotFound)
 		Response(BadRequest)
@@ -109,6 +155,17 @@ var _ = Resource("segments", func() {
 			Media(SegmentCheck)
 		})
 	})
+	Action("check", func() {
+		Description("Check whether given user ID or browser ID belongs to each of provided segments.")
+		Routing(POST("/check"))
+		Payload(SegmentsCheckPayload)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(SegmentsCheck)
+		})
+	})
 	Action("users", func() {
 		Description("List users of segment.")
 		Routing(
@@ -119,10 +176,53 @@ var _ = Resource("segments", func() {
 				Pattern(SegmentPattern)
 			})
 			Param("fields", String, FieldsParamDescription)
+			Param("overrides", String, OverridesParamDescription)
+			Param("limit", Integer, LimitParamDescription, func() {
+				Minimum(1)
+				Maximum(MaxExportPageSize)
+			})
+			Param("cursor", String, CursorParamDescription)
+			Param("format", String, FormatParamDescription, func() {
+				Enum("json", "ndjson", "csv")
+				Default("json")
+			})
 		})
 		Response(NotFound)
 		Response(BadRequest)
-		Response(OK, ArrayOf(String))
+		Response(OK, ArrayOf(String), func() {
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
+		})
+	})
+	Action("browsers", func() {
+		Description("List browsers of segment.")
+		Routing(
+			GET("/:segment_code/browsers"),
+		)
+		Params(func() {
+			Param("segment_code", String, "Segment code", func() {
+				Pattern(SegmentPattern)
+			})
+			Param("fields", String, FieldsParamDescription)
+			Param("overrides", String, OverridesParamDescription)
+			Param("limit", Integer, LimitParamDescription, func() {
+				Minimum(1)
+				Maximum(MaxExportPageSize)
+			})
+			Param("cursor", String, CursorParamDescription)
+			Param("format", String, FormatParamDescription, func() {
+				Enum("json", "ndjson", "csv")
+				Default("json")
+			})
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, ArrayOf(String), func() {
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
+		})
 	})
 	Action("criteria", func() {
 		Description("Provide segment blueprint with criteria for individual tables and fields")
@@ -140,17 +240,132 @@ var _ = Resource("segments", func() {
 			Param("id", Integer, "Segment ID")
 		})
 		Response(BadRequest, func() {
-			Description("Returned when request does not comply with Swagger specification")
+			Description("Returned when request does not comply with Swagger specification or provided criteria are invalid")
 		})
 		Response(NotFound, func() {
 			Description("Returned when segment with provided ID doesn't exist")
 		})
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when provided segment code is already used by another segment")
+		})
 		Response(OK, Segment)
 	})
+	Action("create", func() {
+		Description("Create segment with client-specified code")
+		Payload(SegmentCreatePayload)
+		Routing(POST("/"))
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification, provided criteria are invalid or segment group doesn't exist")
+		})
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when provided segment code is already used by another segment")
+		})
+		Response(Created, Segment)
+	})
+	Action("update", func() {
+		Description("Update segment. If version is provided, segment is updated only if it wasn't modified since.")
+		Payload(SegmentUpdatePayload)
+		Routing(PUT("/detail/:id"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification, provided criteria are invalid or segment group doesn't exist")
+		})
+		Response(NotFound, func() {
+			Description("Returned when segment with provided ID doesn't exist")
+		})
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when provided segment code is already used or segment was modified since version")
+		})
+		Response(OK, Segment)
+	})
+	Action("delete", func() {
+		Description("Soft-delete segment. Code of deleted segment stays reserved.")
+		Routing(DELETE("/detail/:id"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("version", Integer, "Last known version of segment")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when segment was modified since version")
+		})
+		Response(NoContent)
+	})
+	Action("activate", func() {
+		Description("Activate segment")
+		Routing(POST("/detail/:id/activate"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("version", Integer, "Last known version of segment")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when segment was modified since version")
+		})
+		Response(OK, Segment)
+	})
+	Action("deactivate", func() {
+		Description("Deactivate segment")
+		Routing(POST("/detail/:id/deactivate"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("version", Integer, "Last known version of segment")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when segment was modified since version")
+		})
+		Response(OK, Segment)
+	})
+	Action("invalidate_cache", func() {
+		Description("Invalidate cached rule counts of users and browsers affected by newly tracked events")
+		Payload(TrackedEventsPayload)
+		Routing(POST("/cache/invalidate"))
+		Headers(func() {
+			Header("Authorization", String, "Bearer token shared with the tracker (SEGMENTS_CACHE_INVALIDATE_TOKEN)")
+		})
+		Response(BadRequest)
+		Response(Unauthorized)
+		Response(NoContent)
+	})
+	Action("import_status", func() {
+		Description("Get progress of background import of explicit segment members")
+		Routing(GET("/imports/:import_id"))
+		Params(func() {
+			Param("import_id", String, "ID of import")
+		})
+		Response(NotFound)
+		Response(OK, SegmentMemberImport)
+	})
+	Action("validate", func() {
+		Description("Validates provided criteria against the segment blueprint and returns all the problems found")
+		Payload(SegmentTinyPayload)
+		Routing(POST("/validate"))
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, SegmentValidation)
+	})
 	Action("count", func() {
 		Description("Returns number of users in segment based on provided criteria")
 		Payload(SegmentTinyPayload)
 		Routing(POST("/count"))
+		Params(func() {
+			Param("mode", String, "Counting mode. Estimate samples segment candidates within provided timeout and returns approximate count with its error bound.", func() {
+				Enum("estimate", "exact")
+				Default("estimate")
+			})
+			Param("timeout", Integer, "Latency budget of estimation in milliseconds", func() {
+				Minimum(1)
+				Maximum(60000)
+				Default(1000)
+			})
+		})
 		Response(BadRequest, func() {
 			Description("Returned when request does not comply with Swagger specification")
 		})
@@ -165,6 +380,221 @@ var _ = Resource("segments", func() {
 		})
 		Response(OK, RelatedSegments)
 	})
+	Action("revisions", func() {
+		Description("List all revisions of segment ordered from the oldest one")
+		Routing(GET("/:id/revisions"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, func() {
+			Media(CollectionOf(SegmentRevision, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("revision_diff", func() {
+		Description("Compare two revisions of segment")
+		Routing(GET("/:id/revisions/diff"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("from", Integer, "Revision to compare from")
+			Param("to", Integer, "Revision to compare to")
+			Required("from", "to")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, SegmentRevisionDiff)
+	})
+	Action("rollback", func() {
+		Description("Restore segment to provided revision. Rollback is stored as a new revision.")
+		Routing(POST("/:id/revisions/rollback"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("revision", Integer, "Revision to restore")
+			Param("author", String, "Author of the rollback")
+			Required("revision")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, Segment)
+	})
+	Action("revision_count", func() {
+		Description("Returns number of users in segment based on criteria of provided revision")
+		Routing(GET("/:id/revisions/count"))
+		Params(func() {
+			Param("id", Integer, "Segment ID")
+			Param("revision", Integer, "Revision which criteria should be used")
+			Param("at", DateTime, "Time the segment size should be computed for (RFC3339), current time if not provided")
+			Param("mode", String, "Counting mode. Estimate samples segment candidates within provided timeout and returns approximate count with its error bound.", func() {
+				Enum("estimate", "exact")
+				Default("estimate")
+			})
+			Param("timeout", Integer, "Latency budget of estimation in milliseconds", func() {
+				Minimum(1)
+				Maximum(60000)
+				Default(1000)
+			})
+			Required("revision")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, SegmentCount)
+	})
+})
+
+var _ = Resource("segment_members", func() {
+	Description("Management of explicit segment members")
+	BasePath("/segments/detail/:id/:member_type")
+	Params(func() {
+		Param("id", Integer, "Segment ID")
+		Param("member_type", String, "Type of members", func() {
+			Enum("users", "browsers")
+		})
+	})
+	NoSecurity()
+
+	Action("list", func() {
+		Description("List members of explicit segment ordered by the time they were added")
+		Routing(GET(""))
+		Params(func() {
+			Param("limit", Integer, LimitParamDescription, func() {
+				Minimum(1)
+				Maximum(MaxExportPageSize)
+			})
+			Param("cursor", String, CursorParamDescription)
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, ArrayOf(String), func() {
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
+		})
+	})
+	Action("add", func() {
+		Description("Add members to explicit segment. Identifiers already present within the segment are skipped.")
+		Payload(SegmentMembersPayload)
+		Routing(POST(""))
+		Response(NotFound)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or segment is not explicit")
+		})
+		Response(OK, SegmentMembersChange)
+	})
+	Action("remove", func() {
+		Description("Remove members from explicit segment")
+		Payload(SegmentMembersPayload)
+		Routing(DELETE(""))
+		Response(NotFound)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or segment is not explicit")
+		})
+		Response(OK, SegmentMembersChange)
+	})
+	Action("replace", func() {
+		Description("Atomically replace all members of explicit segment")
+		Payload(SegmentMembersPayload)
+		Routing(PUT(""))
+		Response(NotFound)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or segment is not explicit")
+		})
+		Response(OK, SegmentMembersChange)
+	})
+	Action("import", func() {
+		Description(`Start background import of members of explicit segment. Request body contains raw data in provided format:
+
+	- csv: identifier is read from the first column, header (user_id, browser_id or id) is optional
+	- ndjson: each line is JSON string or object with user_id, browser_id or id key
+
+Request body can't exceed 256 MiB. Progress of the import is available via segments/imports endpoint.`)
+		Routing(POST("/import"))
+		Params(func() {
+			Param("mode", String, "Import mode. Replace removes all the existing members once the import succeeds.", func() {
+				Enum("add", "replace")
+				Default("add")
+			})
+			Param("format", String, "Format of imported data", func() {
+				Enum("csv", "ndjson")
+				Default("csv")
+			})
+		})
+		Response(NotFound)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or segment is not explicit")
+		})
+		Response(RequestEntityTooLarge, ErrorMedia, func() {
+			Description("Returned when request body exceeds the size limit")
+		})
+		Response(Accepted, SegmentMemberImport)
+	})
+})
+
+var _ = Resource("segment_groups", func() {
+	Description("Segment group operations")
+	BasePath("/segments/groups")
+	NoSecurity()
+
+	Action("show", func() {
+		Description("Get segment group")
+		Routing(GET("/:id"))
+		Params(func() {
+			Param("id", Integer, "Segment group ID")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(OK, SegmentGroup)
+	})
+	Action("create", func() {
+		Description("Create segment group. Group is placed after all the existing groups if sorting is not provided.")
+		Payload(SegmentGroupPayload)
+		Routing(POST(""))
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when provided code is already used by another segment group")
+		})
+		Response(Created, SegmentGroup)
+	})
+	Action("update", func() {
+		Description("Update segment group. If version is provided, group is updated only if it wasn't modified since.")
+		Payload(SegmentGroupPayload)
+		Routing(PUT("/:id"))
+		Params(func() {
+			Param("id", Integer, "Segment group ID")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when provided code is already used, code of group required by Beam is changed or group was modified since version")
+		})
+		Response(OK, SegmentGroup)
+	})
+	Action("delete", func() {
+		Description("Delete segment group without any segments")
+		Routing(DELETE("/:id"))
+		Params(func() {
+			Param("id", Integer, "Segment group ID")
+		})
+		Response(NotFound)
+		Response(BadRequest)
+		Response(Conflict, ErrorMedia, func() {
+			Description("Returned when group contains segments (including deleted ones) or it's required by Beam")
+		})
+		Response(NoContent)
+	})
+	Action("sort", func() {
+		Description("Sort segment groups based on the order of provided IDs. Groups not listed are placed after the listed ones.")
+		Payload(SegmentGroupsSortPayload)
+		Routing(POST("/sort"))
+		Response(BadRequest)
+		Response(OK, func() {
+			Media(CollectionOf(SegmentGroup, func() {
+				View("default")
+			}))
+		})
+	})
 })
 
 var _ = Resource("journal", func() {
@@ -177,6 +607,45 @@ var _ = Resource("journal", func() {
 		Routing(GET("/flags"))
 		Response(OK, Flags)
 	})
+	Action("query", func() {
+		Description("Returns metrics computed over single journal, optionally grouped and split into time buckets")
+		Routing(POST("/query"))
+		Payload(JournalQueryPayload)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or the query is invalid")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(QueryRow, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("attribution", func() {
+		Description("Returns purchases attributed to the values of pageview tag based on pageviews preceding each purchase")
+		Routing(POST("/attribution"))
+		Payload(AttributionPayload)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Attribution, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("retention", func() {
+		Description("Returns retention matrix of users or browsers active within periods following the period they joined the cohort")
+		Routing(POST("/retention"))
+		Payload(RetentionPayload)
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Retention, func() {
+				View("default")
+			}))
+		})
+	})
 })
 
 var _ = Resource("events", func() {
@@ -214,14 +683,41 @@ var _ = Resource("events", func() {
 			}))
 		})
 	})
+	Action("unique", func() {
+		Description("Returns unique count of items within events")
+		Routing(POST("/unique/:item"))
+		Payload(EventOptionsPayload)
+		Params(func() {
+			Param("item", String, UniqueItemParamDescription)
+			Param("precision_threshold", Integer, PrecisionThresholdParamDescription, func() {
+				Minimum(1)
+				Maximum(40000)
+			})
+			Param("exact", Boolean, ExactParamDescription, func() {
+				Default(false)
+			})
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Count, func() {
+				View("default")
+			}))
+		})
+	})
 	Action("list", func() {
-		Description("Returns full list of events")
+		Description("Returns list of events, paginated if limit is provided")
 		Routing(POST("/list"))
 		Payload(ListEventOptionsPayload)
+		Response(BadRequest)
 		Response(OK, func() {
 			Media(CollectionOf(Events, func() {
 				View("default")
 			}))
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
 		})
 	})
 	Action("categories", func() {
@@ -315,14 +811,60 @@ var _ = Resource("commerce", func() {
 		})
 	})
 
+	Action("unique", func() {
+		Description("Returns unique count of items within commerce events")
+		Routing(POST("/unique/:item"))
+		Payload(CommerceOptionsPayload)
+		Params(func() {
+			Param("item", String, UniqueItemParamDescription)
+			Param("precision_threshold", Integer, PrecisionThresholdParamDescription, func() {
+				Minimum(1)
+				Maximum(40000)
+			})
+			Param("exact", Boolean, ExactParamDescription, func() {
+				Default(false)
+			})
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Count, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("funnel", func() {
+		Description("Returns number of users or browsers reaching each commerce step, split by funnel and grouped tags. " +
+			"Both time_after and time_before have to be provided; step and group_top filters are not supported.")
+		Routing(POST("/funnel/:item"))
+		Payload(CommerceOptionsPayload)
+		Params(func() {
+			Param("item", String, "Identification of counted items", func() {
+				Enum("users", "browsers")
+			})
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification or time range is not bounded")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Funnel, func() {
+				View("default")
+			}))
+		})
+	})
 	Action("list", func() {
-		Description("Returns full list of events")
+		Description("Returns list of commerce events, paginated if limit is provided")
 		Routing(POST("/list"))
 		Payload(ListCommerceOptionsPayload)
+		Response(BadRequest)
 		Response(OK, func() {
 			Media(CollectionOf(Commerces, func() {
 				View("default")
 			}))
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
 		})
 	})
 	Action("categories", func() {
@@ -351,7 +893,7 @@ var _ = Resource("pageviews", func() {
 		Routing(POST("/actions/:action/count"))
 		Params(func() {
 			Param("action", String, "Identification of pageview action", func() {
-				Enum("load")
+				Enum("load", "progress")
 			})
 		})
 		Response(BadRequest, func() {
@@ -387,7 +929,7 @@ var _ = Resource("pageviews", func() {
 		Routing(POST("/actions/:action/avg"))
 		Params(func() {
 			Param("action", String, "Identification of pageview action", func() {
-				Enum("timespent")
+				Enum("timespent", "progress")
 			})
 		})
 		Response(BadRequest, func() {
@@ -399,16 +941,107 @@ var _ = Resource("pageviews", func() {
 			}))
 		})
 	})
+	Action("percentiles", func() {
+		Description("Returns percentiles of time spent or reading progress of pageviews")
+		Payload(PageviewOptionsPayload)
+		Routing(POST("/actions/:action/percentiles"))
+		Params(func() {
+			Param("action", String, "Identification of pageview action", func() {
+				Enum("timespent", "progress")
+			})
+			Param("percents", ArrayOf(Number, func() {
+				Minimum(0)
+				Maximum(100)
+			}), "Percentiles to compute, defaults to 50, 75, 90, 95 and 99")
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Percentiles, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("distribution", func() {
+		Description("Returns distribution of time spent or reading progress of pageviews split into buckets")
+		Payload(PageviewOptionsPayload)
+		Routing(POST("/actions/:action/distribution"))
+		Params(func() {
+			Param("action", String, "Identification of pageview action", func() {
+				Enum("timespent", "progress")
+			})
+			Param("interval", Number, "Width of the buckets (seconds for timespent, ratio for progress, e.g. 0.1), values can be split into at most 1000 buckets")
+			Required("interval")
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Distribution, func() {
+				View("default")
+			}))
+		})
+	})
+	Action("max", func() {
+		Description("Returns final (maximal) progress of each pageview identified by remp_pageview_id, page by page limited by the required limit")
+		Payload(MaxPageviewOptionsPayload)
+		Routing(POST("/actions/:action/max"))
+		Params(func() {
+			Param("action", String, "Identification of pageview action", func() {
+				Enum("progress")
+			})
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Max, func() {
+				View("default")
+			}))
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more pageviews available)")
+			})
+		})
+	})
+	Action("completion", func() {
+		Description("Returns share of pageviews which reached the progress threshold. Pageviews are counted by cardinality aggregation, therefore the counts are approximate.")
+		Payload(PageviewOptionsPayload)
+		Routing(POST("/actions/:action/completion"))
+		Params(func() {
+			Param("action", String, "Identification of pageview action", func() {
+				Enum("progress")
+			})
+			Param("threshold", Number, "Article progress ratio the pageview has to reach to be considered completed", func() {
+				Minimum(0)
+				Maximum(1)
+				Default(0.9)
+			})
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, func() {
+			Media(CollectionOf(Completion, func() {
+				View("default")
+			}))
+		})
+	})
 	Action("unique", func() {
-		Description("Returns unique count of amounts within events")
+		Description("Returns unique count of items within pageviews")
 		Payload(PageviewOptionsPayload)
 		Routing(POST("/actions/:action/unique/:item"))
 		Params(func() {
 			Param("action", String, "Identification of pageview action", func() {
-				Enum("load")
+				Enum("load", "timespent", "progress")
 			})
-			Param("item", String, "Identification of queried unique items", func() {
-				Enum("browsers")
+			Param("item", String, UniqueItemParamDescription)
+			Param("precision_threshold", Integer, PrecisionThresholdParamDescription, func() {
+				Minimum(1)
+				Maximum(40000)
+			})
+			Param("exact", Boolean, ExactParamDescription, func() {
+				Default(false)
 			})
 		})
 		Response(BadRequest, func() {
@@ -421,13 +1054,17 @@ var _ = Resource("pageviews", func() {
 		})
 	})
 	Action("list", func() {
-		Description("Returns full list of pageviews")
+		Description("Returns list of pageviews, paginated if limit is provided")
 		Routing(POST("/list"))
 		Payload(ListPageviewOptionsPayload)
+		Response(BadRequest)
 		Response(OK, func() {
 			Media(CollectionOf(Pageviews, func() {
 				View("default")
 			}))
+			Headers(func() {
+				Header(NextCursorHeader, String, "Cursor of the next page (present only if there are more items available)")
+			})
 		})
 	})
 	Action("categories", func() {
@@ -461,3 +1098,26 @@ var _ = Resource("concurrents", func() {
 		})
 	})
 })
+
+var _ = Resource("users", func() {
+	Description("User-level metrics")
+	BasePath("/users")
+	NoSecurity()
+
+	Action("rfm", func() {
+		Description("Returns recency, frequency and monetary scores of user")
+		Routing(GET("/:user_id/rfm"))
+		Params(func() {
+			Param("user_id", String, "ID of user", func() {
+				Pattern(UserPattern)
+			})
+		})
+		Response(NotFound, func() {
+			Description("Returned when user has no RFM scores (wasn't active within the scoring period)")
+		})
+		Response(BadRequest, func() {
+			Description("Returned when request does not comply with Swagger specification")
+		})
+		Response(OK, RFM)
+	})
+})
@@ -20,20 +20,183 @@ var SegmentRuleCache = Type("SegmentRuleCache", func() {
 	Required("s", "c")
 })
 
+var SegmentsCheckPayload = Type("SegmentsCheckPayload", func() {
+	Description("Parameters to check membership of user or browser within multiple segments")
+
+	Attribute("user_id", String, "User ID (required if browser_id is not provided)", func() {
+		Pattern(UserPattern)
+	})
+	Attribute("browser_id", String, "Browser ID (required if user_id is not provided)", func() {
+		Pattern(UserPattern)
+	})
+	Attribute("segment_codes", ArrayOf(String, func() {
+		Pattern(SegmentPattern)
+	}), "Codes of segments to check", func() {
+		MinLength(1)
+	})
+	Attribute("fields", HashOf(String, String), "Overriden field values shared by all checked segments")
+	Attribute("overrides", RuleOverridesPayload, "Overriden rule parameters shared by all checked segments")
+	Attribute("cache", HashOf(Integer, SegmentRuleCache), "Internal cache object with count of events indexed by SegmentRule-based key")
+
+	Required("segment_codes")
+})
+
+var RuleOverridesPayload = Type("RuleOverridesPayload", func() {
+	Description("Rule parameters overriding the ones of rules marking them as overridable")
+
+	Attribute("count", Integer, "Threshold of event count", func() {
+		Minimum(0)
+	})
+	Attribute("value", Number, "Threshold of aggregated value (e.g. sum or average), takes precedence over count within value-aggregate rules")
+	Attribute("operator", String, "Operator comparing event count with the threshold", func() {
+		Enum("=", ">", ">=", "<", "<=")
+	})
+	Attribute("timespan", Integer, "Timespan of counted events in minutes", func() {
+		Minimum(1)
+	})
+})
+
+var JournalQueryPayload = Type("JournalQueryPayload", func() {
+	Description("Parameters of the metrics computed over single journal")
+
+	Attribute("source", String, "Data source of the query", func() {
+		Enum("events", "commerce", "pageviews", "timespent", "progress", "concurrents")
+	})
+	Attribute("metrics", ArrayOf(QueryMetricPayload), "Metrics computed for each row", func() {
+		MinLength(1)
+	})
+	Attribute("filter_by", ArrayOf(QueryFilterBy), "Selection of data filtering type")
+	Attribute("group_by", ArrayOf(String), "Select tags by which should be data grouped")
+	Attribute("group_top", ArrayOf(GroupTop), "Limits of grouping by particular tags to the top buckets")
+	Attribute("time_after", DateTime, "Include all records that happened after specified RFC3339 datetime")
+	Attribute("time_before", DateTime, "Include all records that happened before specified RFC3339 datetime")
+	Attribute("time_histogram", OptionsTimeHistogram, "Attribute containing values for splitting result into buckets")
+
+	Required("source", "metrics")
+})
+
+var AttributionPayload = Type("AttributionPayload", func() {
+	Description("Parameters of attribution of purchases to the preceding pageviews")
+
+	Attribute("tag", String, "Pageview tag the purchases are attributed to", func() {
+		Enum("article_id", "author_id", "utm_campaign", "derived_referer_medium")
+	})
+	Attribute("model", String, `Model splitting credit of purchase among the preceding pageviews:
+
+	- first_touch, last_touch: whole credit goes to the first (last) pageview
+	- linear: credit is split equally among the pageviews
+	- time_decay: credit of pageview halves with each half_life before the purchase`, func() {
+		Enum("first_touch", "last_touch", "linear", "time_decay")
+		Default("last_touch")
+	})
+	Attribute("window", Integer, "Number of minutes before the purchase the pageviews are taken into account", func() {
+		Minimum(1)
+		Default(43200)
+	})
+	Attribute("half_life", Integer, "Number of minutes credit of pageview halves within time_decay model", func() {
+		Minimum(1)
+		Default(10080)
+	})
+	Attribute("filter_by", ArrayOf(CommerceOptionsFilterBy), "Selection of attributed purchases")
+	Attribute("time_after", DateTime, "Include all purchases that happened after specified RFC3339 datetime (30 days before time_before by default, the range can't be longer than 92 days)")
+	Attribute("time_before", DateTime, "Include all purchases that happened before specified RFC3339 datetime (now by default)")
+
+	Required("tag")
+})
+
+var RetentionPayload = Type("RetentionPayload", func() {
+	Description("Parameters of retention analysis based on pageview loads")
+
+	Attribute("item", String, "Identification of cohort members", func() {
+		Enum("users", "browsers")
+		Default("users")
+	})
+	Attribute("cohort", String, "Cohort of the member is the period of its first pageview or its first purchase", func() {
+		Enum("first_seen", "first_purchase")
+		Default("first_seen")
+	})
+	Attribute("period", String, "Length of the periods", func() {
+		Enum("day", "week", "month")
+		Default("week")
+	})
+	Attribute("periods", Integer, "Number of periods following the cohort period reported for each cohort", func() {
+		Minimum(1)
+		Maximum(366)
+		Default(8)
+	})
+	Attribute("filter_by", ArrayOf(PageviewOptionsFilterBy), "Selection of pageviews determining the activity (e.g. property_token, derived_referer_medium or subscriber)")
+	Attribute("time_after", DateTime, "Include all records that happened after specified RFC3339 datetime")
+	Attribute("time_before", DateTime, "Include all records that happened before specified RFC3339 datetime")
+})
+
+var QueryMetricPayload = Type("QueryMetricPayload", func() {
+	Description("Metric computed over the field of the data source")
+
+	Attribute("name", String, "Name of the metric within the rows, defaults to type and field joined by underscore", func() {
+		Pattern("^[a-zA-Z0-9_]+$")
+	})
+	Attribute("type", String, "Type of the metric", func() {
+		Enum("count", "sum", "avg", "min", "max", "unique", "percentiles")
+	})
+	Attribute("field", String, "Field the metric is computed over (optional for count)")
+	Attribute("percents", ArrayOf(Number, func() {
+		Minimum(0)
+		Maximum(100)
+	}), "Percentiles computed by percentiles metric, defaults to 50, 75, 90, 95 and 99")
+
+	Required("type")
+})
+
+var QueryFilterBy = Type("QueryFilterBy", func() {
+	Description("Tags and values used to filter results")
+
+	Attribute("tag", String, "Tag used to filter results (use tag name or flag listed by /journal/flags)")
+	Attribute("values", ArrayOf(String), "Values of TAG used to filter result (flags accept 1/0 or true/false)")
+	Attribute("operator", String, FilterOperatorDescription, func() {
+		Enum("in", "not_in", "gt", "gte", "lt", "lte", "prefix", "wildcard", "exists", "missing")
+		Default("in")
+	})
+
+	Required("tag")
+})
+
 var ListEventOptionsPayload = Type("ListEventOptionsPayload", func() {
 	Description("Parameters to filter events list")
 
 	Attribute("select_fields", ArrayOf(String), "List of fields to select")
+	Attribute("limit", Integer, LimitParamDescription, func() {
+		Minimum(1)
+		Maximum(MaxExportPageSize)
+	})
+	Attribute("sort", ArrayOf(ListSort), "Fields by which the records are ordered (by time if not provided)")
+	Attribute("cursor", String, CursorParamDescription)
+	Attribute("format", String, ListFormatDescription, func() {
+		Enum("json", "ndjson")
+		Default("json")
+	})
 	Attribute("conditions", EventOptionsPayload, "Condition definition")
 
 	Required("conditions")
 })
 
+var ListSort = Type("ListSort", func() {
+	Description("Field by which the listed records are ordered")
+
+	Attribute("field", String, "Name of the field")
+	Attribute("order", String, "Order of the records", func() {
+		Enum("asc", "desc")
+		Default("asc")
+	})
+
+	Required("field")
+})
+
 var EventOptionsPayload = Type("EventOptionsPayload", func() {
 	Description("Parameters to filter event counts")
 
 	Attribute("filter_by", ArrayOf(EventOptionsFilterBy), "Selection of data filtering type")
 	Attribute("group_by", ArrayOf(String), "Select tags by which should be data grouped")
+	Attribute("group_top", ArrayOf(GroupTop), "Limits of grouping by particular tags to the top buckets")
 	Attribute("time_after", DateTime, "Include all pageviews that happened after specified RFC3339 datetime")
 	Attribute("time_before", DateTime, "Include all pageviews that happened before specified RFC3339 datetime")
 	Attribute("time_histogram", OptionsTimeHistogram, "Attribute containing values for splitting result into buckets")
@@ -42,6 +205,30 @@ var EventOptionsPayload = Type("EventOptionsPayload", func() {
 	Attribute("category", String, "Event category")
 })
 
+var GroupTop = Type("GroupTop", func() {
+	Description("Limit of grouping by the tag to the top buckets ordered by the metric")
+
+	Attribute("tag", String, "Tag of group_by limited to the top buckets")
+	Attribute("size", Integer, "Number of the top buckets", func() {
+		Minimum(1)
+		Maximum(MaxExportPageSize)
+	})
+	Attribute("metric", String, "Metric ordering the buckets", func() {
+		Enum("count", "sum", "avg", "unique")
+		Default("count")
+	})
+	Attribute("field", String, "Field of the metric (required by sum, avg and unique metrics)")
+	Attribute("order", String, "Order of the buckets", func() {
+		Enum("asc", "desc")
+		Default("desc")
+	})
+	Attribute("other", Boolean, "If true, number of remaining records is reported within bucket tagged as _other (only counts without time histogram are supported)", func() {
+		Default(false)
+	})
+
+	Required("tag", "size")
+})
+
 var OptionsTimeHistogram = Type("OptionsTimeHistogram", func() {
 	Description("Values used to split results in time buckets")
 
@@ -54,10 +241,14 @@ var OptionsTimeHistogram = Type("OptionsTimeHistogram", func() {
 var EventOptionsFilterBy = Type("EventOptionsFilterBy", func() {
 	Description("Tags and values used to filter results")
 
-	Attribute("tag", String, "Tag used to filter results")
-	Attribute("values", ArrayOf(String), "Values of TAG used to filter result")
+	Attribute("tag", String, "Tag used to filter results (use tag name or flag listed by /journal/flags)")
+	Attribute("values", ArrayOf(String), "Values of TAG used to filter result (flags accept 1/0 or true/false)")
+	Attribute("operator", String, FilterOperatorDescription, func() {
+		Enum("in", "not_in", "gt", "gte", "lt", "lte", "prefix", "wildcard", "exists", "missing")
+		Default("in")
+	})
 
-	Required("tag", "values")
+	Required("tag")
 })
 
 var ListPageviewOptionsPayload = Type("ListPageviewOptionsPayload", func() {
@@ -67,16 +258,44 @@ var ListPageviewOptionsPayload = Type("ListPageviewOptionsPayload", func() {
 	Attribute("load_timespent", Boolean, "If true, load timespent for each pageview", func() {
 		Default(false)
 	})
+	Attribute("limit", Integer, LimitParamDescription, func() {
+		Minimum(1)
+		Maximum(MaxExportPageSize)
+	})
+	Attribute("sort", ArrayOf(ListSort), "Fields by which the records are ordered (by time if not provided)")
+	Attribute("cursor", String, CursorParamDescription)
+	Attribute("format", String, ListFormatDescription, func() {
+		Enum("json", "ndjson")
+		Default("json")
+	})
 	Attribute("conditions", PageviewOptionsPayload, "Condition definition")
 
 	Required("conditions")
 })
 
+var MaxPageviewOptionsPayload = Type("MaxPageviewOptionsPayload", func() {
+	Description("Parameters to filter pageviews of which the final progress is returned")
+
+	Attribute("limit", Integer, LimitParamDescription, func() {
+		Minimum(1)
+		Maximum(MaxExportPageSize)
+	})
+	Attribute("cursor", String, CursorParamDescription)
+	Attribute("format", String, ListFormatDescription, func() {
+		Enum("json", "ndjson")
+		Default("json")
+	})
+	Attribute("conditions", PageviewOptionsPayload, "Condition definition, time_histogram and group_top are not supported")
+
+	Required("limit", "conditions")
+})
+
 var PageviewOptionsPayload = Type("PageviewOptionsPayload", func() {
 	Description("Parameters to filter pageview counts")
 
 	Attribute("filter_by", ArrayOf(PageviewOptionsFilterBy), "Selection of data filtering type")
 	Attribute("group_by", ArrayOf(String), "Select tags by which should be data grouped")
+	Attribute("group_top", ArrayOf(GroupTop), "Limits of grouping by particular tags to the top buckets")
 	Attribute("time_after", DateTime, "Include all pageviews that happened after specified RFC3339 datetime")
 	Attribute("time_before", DateTime, "Include all pageviews that happened before specified RFC3339 datetime")
 	Attribute("time_histogram", OptionsTimeHistogram, "Attribute containing values for splitting result into buckets")
@@ -85,10 +304,14 @@ var PageviewOptionsPayload = Type("PageviewOptionsPayload", func() {
 var PageviewOptionsFilterBy = Type("PageviewOptionsFilterBy", func() {
 	Description("Tags and values used to filter results")
 
-	Attribute("tag", String, "Tag used to filter results (use tag name: user_id, article_id, ...)")
-	Attribute("values", ArrayOf(String), "Values of TAG used to filter result")
+	Attribute("tag", String, "Tag used to filter results (use tag name: user_id, article_id, ... or flag listed by /journal/flags, e.g. _article)")
+	Attribute("values", ArrayOf(String), "Values of TAG used to filter result (flags accept 1/0 or true/false)")
+	Attribute("operator", String, FilterOperatorDescription, func() {
+		Enum("in", "not_in", "gt", "gte", "lt", "lte", "prefix", "wildcard", "exists", "missing")
+		Default("in")
+	})
 
-	Required("tag", "values")
+	Required("tag")
 })
 
 var ConcurrentsOptionsPayload = Type("ConcurrentsOptionsPayload", func() {
@@ -98,12 +321,23 @@ var ConcurrentsOptionsPayload = Type("ConcurrentsOptionsPayload", func() {
 	Attribute("time_before", DateTime, "Include all pageviews that happened before specified RFC3339 datetime")
 	Attribute("filter_by", ArrayOf(PageviewOptionsFilterBy), "Selection of data filtering type")
 	Attribute("group_by", ArrayOf(String), "Select tags by which should be data grouped")
+	Attribute("group_top", ArrayOf(GroupTop), "Limits of grouping by particular tags to the top buckets")
 })
 
 var ListCommerceOptionsPayload = Type("ListCommerceOptionsPayload", func() {
 	Description("Parameters to filter pageview list")
 
 	Attribute("select_fields", ArrayOf(String), "List of fields to select")
+	Attribute("limit", Integer, LimitParamDescription, func() {
+		Minimum(1)
+		Maximum(MaxExportPageSize)
+	})
+	Attribute("sort", ArrayOf(ListSort), "Fields by which the records are ordered (by time if not provided)")
+	Attribute("cursor", String, CursorParamDescription)
+	Attribute("format", String, ListFormatDescription, func() {
+		Enum("json", "ndjson")
+		Default("json")
+	})
 	Attribute("conditions", CommerceOptionsPayload, "Condition definition")
 
 	Required("conditions")
@@ -114,6 +348,7 @@ var CommerceOptionsPayload = Type("CommerceOptionsPayload", func() {
 
 	Attribute("filter_by", ArrayOf(CommerceOptionsFilterBy), "Selection of data filtering type")
 	Attribute("group_by", ArrayOf(String), "Select tags by which should be data grouped")
+	Attribute("group_top", ArrayOf(GroupTop), "Limits of grouping by particular tags to the top buckets")
 	Attribute("time_after", DateTime, "Include all pageviews that happened after specified RFC3339 datetime")
 	Attribute("time_before", DateTime, "Include all pageviews that happened before specified RFC3339 datetime")
 	Attribute("time_histogram", OptionsTimeHistogram, "Attribute containing values for splitting result into buckets")
@@ -125,25 +360,116 @@ var CommerceOptionsPayload = Type("CommerceOptionsPayload", func() {
 var CommerceOptionsFilterBy = Type("CommerceOptionsFilterBy", func() {
 	Description("Tags and values used to filter results")
 
-	Attribute("tag", String, "Tag used to filter results")
-	Attribute("values", ArrayOf(String), "Values of TAG used to filter result")
+	Attribute("tag", String, "Tag used to filter results (use tag name or flag listed by /journal/flags)")
+	Attribute("values", ArrayOf(String), "Values of TAG used to filter result (flags accept 1/0 or true/false)")
+	Attribute("operator", String, FilterOperatorDescription, func() {
+		Enum("in", "not_in", "gt", "gte", "lt", "lte", "prefix", "wildcard", "exists", "missing")
+		Default("in")
+	})
 
-	Required("tag", "values")
+	Required("tag")
 })
 
 var SegmentPayload = Type("SegmentPayload", func() {
 	Description("Request parameters for segment creation")
 
 	Attribute("name", String, "Name of segment")
+	Attribute("code", String, "Code-friendly identificator of segment, derived from name if not provided", func() {
+		Pattern(SegmentPattern)
+	})
 	Attribute("table_name", String, "Name of table above which this segment is calculated")
 	Attribute("group_id", Integer, "ID of parent group")
 	Attribute("fields", ArrayOf(String), "List of fields to select")
 
 	Attribute("criteria", SegmentCreateCriteria, "Segment's criteria")
+	Attribute("author", String, "Author of the change stored within segment's revision")
 
 	Required("name", "table_name", "group_id", "fields", "criteria")
 })
 
+var SegmentCreatePayload = Type("SegmentCreatePayload", func() {
+	Description("Request parameters for segment creation")
+
+	Attribute("code", String, "Code-friendly identificator of segment", func() {
+		Pattern(SegmentPattern)
+	})
+	Attribute("name", String, "Name of segment")
+	Attribute("group_id", Integer, "ID of parent group")
+	Attribute("criteria", SegmentCreateCriteria, "Segment's criteria")
+	Attribute("active", Boolean, "Flag whether segment is active", func() {
+		Default(true)
+	})
+	Attribute("author", String, "Author of the change stored within segment's revision")
+
+	Required("code", "name", "group_id", "criteria")
+})
+
+var SegmentUpdatePayload = Type("SegmentUpdatePayload", func() {
+	Description("Request parameters for segment update")
+
+	Attribute("code", String, "Code-friendly identificator of segment, kept if not provided", func() {
+		Pattern(SegmentPattern)
+	})
+	Attribute("name", String, "Name of segment")
+	Attribute("group_id", Integer, "ID of parent group")
+	Attribute("criteria", SegmentCreateCriteria, "Segment's criteria")
+	Attribute("author", String, "Author of the change stored within segment's revision")
+	Attribute("version", Integer, "Last known version of segment")
+
+	Required("name", "group_id", "criteria")
+})
+
+var SegmentGroupPayload = Type("SegmentGroupPayload", func() {
+	Description("Request parameters for segment group creation and update")
+
+	Attribute("name", String, "User-friendly name of segment group")
+	Attribute("code", String, "Code-friendly identificator of segment group, derived from name on creation and kept on update if not provided", func() {
+		Pattern(SegmentPattern)
+	})
+	Attribute("type", String, "Type of segments within the group, `rule` on creation and kept on update if not provided", func() {
+		Enum("rule", "explicit")
+	})
+	Attribute("sorting", Integer, "Sort order index, kept on update or placed after existing groups on creation if not provided")
+	Attribute("version", Integer, "Last known version of segment group")
+
+	Required("name")
+})
+
+var SegmentGroupsSortPayload = Type("SegmentGroupsSortPayload", func() {
+	Description("Request parameters for sorting of segment groups")
+
+	Attribute("ids", ArrayOf(Integer), "IDs of segment groups in the requested order")
+
+	Required("ids")
+})
+
+var SegmentMembersPayload = Type("SegmentMembersPayload", func() {
+	Description("Request parameters for changes of explicit segment members")
+
+	Attribute("ids", ArrayOf(String), "Identifiers of users or browsers")
+
+	Required("ids")
+})
+
+var TrackedEventsPayload = Type("TrackedEventsPayload", func() {
+	Description("Events tracked for users or browsers, reported by tracker")
+
+	Attribute("events", ArrayOf(TrackedEvent), "Tracked events")
+
+	Required("events")
+})
+
+var TrackedEvent = Type("TrackedEvent", func() {
+	Description("Single tracked event")
+
+	Attribute("category", String, "Category of event, e.g. pageview or commerce")
+	Attribute("action", String, "Action of event, e.g. load or purchase")
+	Attribute("user_id", String, "ID of user the event was tracked for")
+	Attribute("browser_id", String, "ID of browser the event was tracked for")
+
+	Required("category", "action")
+})
+
 var SegmentTinyPayload = Type("SegmentTinyPayload", func() {
 	Description("Request parameters for endpoints segments/count and segments/related")
 
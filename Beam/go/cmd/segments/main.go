@@ -72,12 +72,28 @@ func main() {
 	var pageviewStorage model.PageviewStorage
 	var commerceStorage model.CommerceStorage
 	var concurrentsStorage model.ConcurrentsStorage
+	var queryStorage model.QueryStorage
 
-	eventStorage, pageviewStorage, commerceStorage, concurrentsStorage, err = initElasticEventStorages(ctx, c)
+	eventStorage, pageviewStorage, commerceStorage, concurrentsStorage, queryStorage, err = initElasticEventStorages(ctx, c)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	rfmStorage := &model.RFMDB{
+		PageviewStorage: pageviewStorage,
+		CommerceStorage: commerceStorage,
+		Period:          c.RFMPeriod,
+	}
+
+	attributionStorage := &model.AttributionDB{
+		PageviewStorage: pageviewStorage,
+		CommerceStorage: commerceStorage,
+	}
+	retentionStorage := &model.RetentionDB{
+		PageviewStorage: pageviewStorage,
+		CommerceStorage: commerceStorage,
+	}
+
 	countCache := cache.New(5*time.Minute, 10*time.Minute)
 	segmentStorage := &model.SegmentDB{
 		MySQL:           mysqlDB,
@@ -85,6 +101,11 @@ func main() {
 		EventStorage:    eventStorage,
 		PageviewStorage: pageviewStorage,
 		CommerceStorage: commerceStorage,
+		RFMStorage:      rfmStorage,
+	}
+	if c.RuleCacheSize > 0 {
+		// cache is enabled only if explicitly configured, it relies on tracker reporting the tracked events
+		segmentStorage.RuleCache = model.NewRuleCountCache(c.RuleCacheSize, c.RuleCacheTTL, c.RuleCacheIngestLag)
 	}
 
 	segmentBlueprintStorage := &model.SegmentBlueprintDB{
@@ -128,6 +149,11 @@ func main() {
 			service.LogError("unable to cache events", "err", err)
 		}
 	}
+	cacheRFM := func() {
+		if err := rfmStorage.Cache(); err != nil {
+			service.LogError("unable to cache RFM scores", "err", err)
+		}
+	}
 
 	wg.Add(1)
 	cacheSegmentDB()
@@ -137,6 +163,9 @@ func main() {
 	go func() {
 		defer wg.Done()
 		service.LogInfo("starting property caching")
+		// RFM scores are computed from all active users, initial caching shouldn't block the startup;
+		// checks of RFM criteria wait for the scores to be computed
+		cacheRFM()
 		for {
 			select {
 			case <-ticker10s.C:
@@ -146,6 +175,7 @@ func main() {
 				cacheSegmentsCount()
 			case <-ticker1h.C:
 				cacheEventDB()
+				cacheRFM()
 			case <-ctx.Done():
 				service.LogInfo("property caching stopped")
 				return
@@ -156,15 +186,19 @@ func main() {
 	// controllers init
 
 	segmentConfig := controller.SegmentConfig{
-		URLEdit: c.URLEdit,
+		URLEdit:         c.URLEdit,
+		InvalidateToken: c.CacheInvalidateToken,
 	}
 
-	app.MountJournalController(service, controller.NewJournalController(service, eventStorage, commerceStorage, pageviewStorage))
+	app.MountJournalController(service, controller.NewJournalController(service, eventStorage, commerceStorage, pageviewStorage, queryStorage, attributionStorage, retentionStorage))
 	app.MountEventsController(service, controller.NewEventController(service, eventStorage))
 	app.MountCommerceController(service, controller.NewCommerceController(service, commerceStorage))
 	app.MountPageviewsController(service, controller.NewPageviewController(service, pageviewStorage))
 	app.MountSegmentsController(service, controller.NewSegmentController(service, segmentStorage, segmentBlueprintStorage, segmentConfig))
+	app.MountSegmentGroupsController(service, controller.NewSegmentGroupController(service, segmentStorage))
+	app.MountSegmentMembersController(service, controller.NewSegmentMemberController(service, segmentStorage))
 	app.MountConcurrentsController(service, controller.NewConcurrentsController(service, concurrentsStorage))
+	app.MountUsersController(service, controller.NewUserController(service, rfmStorage))
 
 	// server init
 
@@ -193,7 +227,7 @@ func main() {
 	service.LogInfo("bye bye")
 }
 
-func initElasticEventStorages(ctx context.Context, c Config) (model.EventStorage, model.PageviewStorage, model.CommerceStorage, model.ConcurrentsStorage, error) {
+func initElasticEventStorages(ctx context.Context, c Config) (model.EventStorage, model.PageviewStorage, model.CommerceStorage, model.ConcurrentsStorage, model.QueryStorage, error) {
 	eopts := []elastic.ClientOptionFunc{
 		elastic.SetBasicAuth(c.ElasticUser, c.ElasticPasswd),
 		elastic.SetURL(c.ElasticAddr),
@@ -210,9 +244,10 @@ func initElasticEventStorages(ctx context.Context, c Config) (model.EventStorage
 	}
 	ec, err := elastic.NewClient(eopts...)
 	if err != nil {
-		return nil, nil, nil, nil, errors.Wrap(err, "unable to initialize elasticsearch client")
+		return nil, nil, nil, nil, nil, errors.Wrap(err, "unable to initialize elasticsearch client")
 	}
 	elasticDB := model.NewElasticDB(ctx, ec, c.Debug)
+	elasticDB.UniquePrecision = c.UniquePrecision
 
 	eventStorage := &model.EventElastic{
 		DB: elasticDB,
@@ -226,6 +261,9 @@ func initElasticEventStorages(ctx context.Context, c Config) (model.EventStorage
 	concurrentsStorage := &model.ConcurrentElastic{
 		DB: elasticDB,
 	}
+	queryStorage := &model.QueryElastic{
+		DB: elasticDB,
+	}
 
-	return eventStorage, pageviewStorage, commerceStorage, concurrentsStorage, nil
+	return eventStorage, pageviewStorage, commerceStorage, concurrentsStorage, queryStorage, nil
 }
@@ -6,6 +6,9 @@ type Config struct {
 	BrokerAddrs string `envconfig:"broker_addrs" required:"true"`
 	Debug       bool   `envconfig:"debug" required:"false"`
 
+	SegmentsAddr  string `envconfig:"segments_addr" required:"false"`
+	SegmentsToken string `envconfig:"segments_token" required:"false"`
+
 	MysqlNet    string `envconfig:"mysql_net" required:"true"`
 	MysqlAddr   string `envconfig:"mysql_addr" required:"true"`
 	MysqlUser   string `envconfig:"mysql_user" required:"true"`
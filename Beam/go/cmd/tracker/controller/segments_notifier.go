@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// notifyBatchSize is the maximum number of events reported to Segments API at once.
+	notifyBatchSize = 100
+	// notifyInterval is the maximum delay of reporting of tracked event.
+	notifyInterval = 500 * time.Millisecond
+)
+
+// SegmentsNotifier reports tracked events to Segments API in batches, so it can invalidate cached rule
+// counts of users and browsers. Events are dropped rather than blocking the tracking if the API can't keep up;
+// number of dropped events is logged.
+type SegmentsNotifier struct {
+	url     string
+	token   string
+	client  *http.Client
+	events  chan trackedEvent
+	dropped uint64 // number of events dropped since the last report, accessed atomically
+}
+
+// trackedEvent represents event reported to Segments API.
+type trackedEvent struct {
+	Category  string `json:"category"`
+	Action    string `json:"action"`
+	UserID    string `json:"user_id,omitempty"`
+	BrowserID string `json:"browser_id,omitempty"`
+}
+
+// NewSegmentsNotifier creates notifier reporting events to Segments API running on provided address. Requests
+// are authorized by provided token, which needs to match the cache invalidation token of Segments API.
+func NewSegmentsNotifier(segmentsAddr, token string) *SegmentsNotifier {
+	return &SegmentsNotifier{
+		url:    strings.TrimRight(segmentsAddr, "/") + "/segments/cache/invalidate",
+		token:  token,
+		client: &http.Client{Timeout: 5 * time.Second},
+		events: make(chan trackedEvent, 10*notifyBatchSize),
+	}
+}
+
+// Notify queues event tracked for user and/or browser identified by provided tags.
+func (sn *SegmentsNotifier) Notify(category, action string, tags map[string]string) {
+	if sn == nil {
+		return
+	}
+	e := trackedEvent{
+		Category:  category,
+		Action:    action,
+		UserID:    tags["user_id"],
+		BrowserID: tags["browser_id"],
+	}
+	if e.UserID == "" && e.BrowserID == "" {
+		return
+	}
+	select {
+	case sn.events <- e:
+	default:
+		atomic.AddUint64(&sn.dropped, 1)
+	}
+}
+
+// Run reports queued events until the context is cancelled.
+func (sn *SegmentsNotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	batch := make([]trackedEvent, 0, notifyBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sn.send(batch); err != nil {
+			log.Println("Failed to notify segments about tracked events:", err)
+		}
+		batch = make([]trackedEvent, 0, notifyBatchSize)
+	}
+
+	for {
+		select {
+		case e := <-sn.events:
+			batch = append(batch, e)
+			if len(batch) >= notifyBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if dropped := atomic.SwapUint64(&sn.dropped, 0); dropped > 0 {
+				log.Printf("Segments notifier queue is full, %d tracked events were not reported\n", dropped)
+			}
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// send reports batch of events to Segments API.
+func (sn *SegmentsNotifier) send(events []trackedEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"events": events,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, sn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sn.token)
+	resp, err := sn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
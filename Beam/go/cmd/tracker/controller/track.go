@@ -23,6 +23,7 @@ type TrackController struct {
 	EventProducer       sarama.AsyncProducer
 	PropertyStorage     model.PropertyStorage
 	EntitySchemaStorage model.EntitySchemaStorage
+	SegmentsNotifier    *SegmentsNotifier
 }
 
 // Event represents Influx event structure
@@ -34,12 +35,15 @@ type Event struct {
 }
 
 // NewTrackController creates a track controller.
-func NewTrackController(service *goa.Service, ep sarama.AsyncProducer, ps model.PropertyStorage, ess model.EntitySchemaStorage) *TrackController {
+// Segments notifier is optional, tracked events are not reported to Segments API if it's nil.
+func NewTrackController(service *goa.Service, ep sarama.AsyncProducer, ps model.PropertyStorage, ess model.EntitySchemaStorage,
+	sn *SegmentsNotifier) *TrackController {
 	return &TrackController{
 		Controller:          service.NewController("TrackController"),
 		EventProducer:       ep,
 		PropertyStorage:     ps,
 		EntitySchemaStorage: ess,
+		SegmentsNotifier:    sn,
 	}
 }
 
@@ -107,6 +111,7 @@ func (c *TrackController) Commerce(ctx *app.CommerceTrackContext) error {
 	if err := c.pushInternal(model.TableCommerce, ctx.Payload.System.Time, tags, fields); err != nil {
 		return err
 	}
+	c.SegmentsNotifier.Notify(model.CategoryCommerce, ctx.Payload.Step, tags)
 
 	topic := fmt.Sprintf("%s_%s", "commerce", ctx.Payload.Step)
 	value, err := json.Marshal(ctx.Payload)
@@ -150,6 +155,7 @@ func (c *TrackController) Event(ctx *app.EventTrackContext) error {
 	if err := c.pushInternal(model.TableEvents, ctx.Payload.System.Time, tags, fields); err != nil {
 		return err
 	}
+	c.SegmentsNotifier.Notify(ctx.Payload.Category, ctx.Payload.Action, tags)
 
 	// push public
 
@@ -227,6 +233,7 @@ func (c *TrackController) Pageview(ctx *app.PageviewTrackContext) error {
 	if err := c.pushInternal(measurement, ctx.Payload.System.Time, tags, fields); err != nil {
 		return err
 	}
+	c.SegmentsNotifier.Notify(model.CategoryPageview, ctx.Payload.Action, tags)
 
 	return ctx.Accepted()
 }
@@ -123,6 +123,18 @@ func main() {
 		}
 	}()
 
+	var segmentsNotifier *controller.SegmentsNotifier
+	if c.SegmentsAddr != "" {
+		segmentsNotifier = controller.NewSegmentsNotifier(c.SegmentsAddr, c.SegmentsToken)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.LogInfo("starting segments notifier", "addr", c.SegmentsAddr)
+			segmentsNotifier.Run(ctx)
+			service.LogInfo("segments notifier stopped")
+		}()
+	}
+
 	cacheEntities()
 	go func() {
 		defer wg.Done()
@@ -146,6 +158,7 @@ func main() {
 		eventProducer,
 		propertyDB,
 		entitySchemaDB,
+		segmentsNotifier,
 	))
 
 	// server init
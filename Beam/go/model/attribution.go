@@ -0,0 +1,336 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Attribution models splitting credit of the conversion among the preceding pageviews.
+const (
+	AttributionFirstTouch = "first_touch"
+	AttributionLastTouch  = "last_touch"
+	AttributionLinear     = "linear"
+	AttributionTimeDecay  = "time_decay"
+)
+
+// DefaultAttributionHalfLife is the half-life of pageview credit used by time-decay model if none is provided.
+const DefaultAttributionHalfLife = 7 * 24 * time.Hour
+
+// DefaultAttributionRange is the time range of attributed purchases used if its beginning isn't provided,
+// MaxAttributionRange is the longest time range of attributed purchases.
+const (
+	DefaultAttributionRange = 30 * 24 * time.Hour
+	MaxAttributionRange     = 92 * 24 * time.Hour
+)
+
+// maxAttributionCandidates is the highest number of purchases attributed at once, and so the highest number
+// of identifiers used to filter single listing of pageviews.
+const maxAttributionCandidates = 10000
+
+// AttributionStorage is an interface to attribute conversions to the content.
+type AttributionStorage interface {
+	// Attribute returns conversions and revenue of purchases attributed to the values of pageview tag.
+	Attribute(o AttributionOptions) (AttributionRowCollection, error)
+}
+
+// AttributionOptions represent options of the attribution. Embedded AggregateOptions filter attributed
+// purchases, conversions are attributed to the values of pageview tag.
+type AttributionOptions struct {
+	AggregateOptions
+
+	Tag      string        // pageview tag credit is assigned to (e.g. article_id, utm_campaign)
+	Model    string        // one of the Attribution* models
+	Window   time.Duration // how long before the purchase the pageviews are taken into account
+	HalfLife time.Duration // half-life of pageview credit within AttributionTimeDecay model
+}
+
+// AttributionRow represents conversions and revenue attributed to single value of the tag. Purchases
+// without any preceding pageview are reported within row with empty tag value.
+type AttributionRow struct {
+	Tags        map[string]string
+	Conversions float64
+	Revenue     float64
+}
+
+// AttributionRowCollection represents collection of attribution rows.
+type AttributionRowCollection []AttributionRow
+
+// attributionTouch represents pageview preceding the purchase.
+type attributionTouch struct {
+	Time  time.Time
+	Value string // value of the attributed tag
+}
+
+// Validate checks whether the attribution can be computed.
+func (o AttributionOptions) Validate() error {
+	switch o.Model {
+	case AttributionFirstTouch, AttributionLastTouch, AttributionLinear, AttributionTimeDecay:
+	default:
+		return fmt.Errorf("unknown attribution model: %s", o.Model)
+	}
+	if o.Tag == "" {
+		return errors.New("attributed tag has to be provided")
+	}
+	if o.Window <= 0 {
+		return errors.New("attribution window has to be positive")
+	}
+	if o.TimeAfter.IsZero() || o.TimeBefore.IsZero() {
+		return errors.New("time range of attributed purchases has to be bounded")
+	}
+	if !o.TimeAfter.Before(o.TimeBefore) {
+		return errors.New("time range of attributed purchases has to end after its beginning")
+	}
+	if o.TimeBefore.Sub(o.TimeAfter) > MaxAttributionRange {
+		return fmt.Errorf("time range of attributed purchases can't be longer than %s", MaxAttributionRange)
+	}
+	return nil
+}
+
+// AttributionDB represents attribution computed from the data of pageview and commerce storages.
+type AttributionDB struct {
+	PageviewStorage PageviewStorage
+	CommerceStorage CommerceStorage
+}
+
+// Attribute returns conversions and revenue of purchases attributed to the values of pageview tag.
+//
+// Each purchase is attributed to the pageviews of the same user (or browser) which happened within
+// the window before the purchase. Rows are ordered by attributed revenue.
+//
+// Purchases are listed page by page and each page is attributed separately, so only pageviews preceding
+// single page of purchases are held in memory.
+func (aDB *AttributionDB) Attribute(o AttributionOptions) (AttributionRowCollection, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	po := o.AggregateOptions
+	po.Category = ""
+	po.Action = ""
+	po.Step = "purchase"
+	po.GroupBy = nil
+	po.GroupTop = nil
+	po.TimeHistogram = nil
+	po.Page = nil
+	page := ListPage{
+		Limit: maxAttributionCandidates,
+	}
+
+	conversions := make(map[string]float64)
+	revenue := make(map[string]float64)
+	for {
+		crc, next, err := aDB.CommerceStorage.List(ListOptions{
+			AggregateOptions: po,
+			ListPage:         page,
+			SelectFields:     []string{"time", "user_id", "browser_id", "revenue"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list purchases")
+		}
+		var purchases []*Commerce
+		for _, cr := range crc {
+			purchases = append(purchases, cr.Commerces...)
+		}
+		if err := aDB.attributePurchases(o, purchases, conversions, revenue); err != nil {
+			return nil, err
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	arc := AttributionRowCollection{}
+	for value := range conversions {
+		arc = append(arc, AttributionRow{
+			Tags:        map[string]string{o.Tag: value},
+			Conversions: conversions[value],
+			Revenue:     revenue[value],
+		})
+	}
+	sort.Slice(arc, func(i, j int) bool {
+		if arc[i].Revenue != arc[j].Revenue {
+			return arc[i].Revenue > arc[j].Revenue
+		}
+		return arc[i].Tags[o.Tag] < arc[j].Tags[o.Tag]
+	})
+	return arc, nil
+}
+
+// attributePurchases attributes provided purchases to the pageviews preceding them and adds their credits
+// to the conversions and revenue of the attributed tag values.
+func (aDB *AttributionDB) attributePurchases(o AttributionOptions, purchases []*Commerce, conversions, revenue map[string]float64) error {
+	if len(purchases) == 0 {
+		return nil
+	}
+
+	var after, before time.Time
+	users := make(map[string]bool)
+	browsers := make(map[string]bool)
+	for _, c := range purchases {
+		if after.IsZero() || c.Time.Before(after) {
+			after = c.Time
+		}
+		if c.Time.After(before) {
+			before = c.Time
+		}
+		if c.UserID != "" {
+			users[c.UserID] = true
+		} else if c.BrowserID != "" {
+			browsers[c.BrowserID] = true
+		}
+	}
+
+	// pageviews of purchasing users are matched by user_id, anonymous purchases are matched by browser_id
+	window := attributionWindow{
+		after:  after.Add(-o.Window),
+		before: before.Add(time.Millisecond),
+		tag:    o.Tag,
+	}
+	userTouches, err := aDB.touches(window, "user_id", users)
+	if err != nil {
+		return err
+	}
+	browserTouches, err := aDB.touches(window, "browser_id", browsers)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range purchases {
+		var candidates []attributionTouch
+		if c.UserID != "" {
+			candidates = userTouches[c.UserID]
+		} else {
+			candidates = browserTouches[c.BrowserID]
+		}
+
+		var touches []attributionTouch
+		for _, t := range candidates {
+			if t.Time.Before(c.Time) && !t.Time.Before(c.Time.Add(-o.Window)) {
+				touches = append(touches, t)
+			}
+		}
+
+		credits := attributionCredits(o.Model, o.HalfLife, touches)
+		if len(credits) == 0 {
+			// purchase without preceding pageview stays unattributed
+			credits = map[string]float64{"": 1}
+		}
+		for value, credit := range credits {
+			conversions[value] += credit
+			revenue[value] += credit * c.Revenue
+		}
+	}
+	return nil
+}
+
+// attributionWindow represents time range and tag of pageviews listed for attribution.
+type attributionWindow struct {
+	after  time.Time
+	before time.Time
+	tag    string
+}
+
+// touches lists pageviews of provided identifiers (values of idTag) within the window. Pageviews are grouped
+// by the identifier and ordered by time; pageviews without value of the attributed tag are omitted.
+func (aDB *AttributionDB) touches(w attributionWindow, idTag string, ids map[string]bool) (map[string][]attributionTouch, error) {
+	touches := make(map[string][]attributionTouch)
+	if len(ids) == 0 {
+		return touches, nil
+	}
+
+	candidates := make([]string, 0, len(ids))
+	for id := range ids {
+		candidates = append(candidates, id)
+	}
+	sort.Strings(candidates)
+
+	for len(candidates) > 0 {
+		chunk := candidates
+		if len(chunk) > maxAttributionCandidates {
+			chunk = candidates[:maxAttributionCandidates]
+		}
+		candidates = candidates[len(chunk):]
+
+		prc, _, err := aDB.PageviewStorage.List(ListPageviewsOptions{
+			AggregateOptions: AggregateOptions{
+				FilterBy: []*FilterBy{
+					{Tag: idTag, Values: chunk},
+					{Tag: w.tag, Operator: FilterExists},
+				},
+				GroupBy:    []string{w.tag},
+				TimeAfter:  w.after,
+				TimeBefore: w.before,
+			},
+			SelectFields: []string{"time", idTag},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list pageviews preceding purchases")
+		}
+
+		for _, pr := range prc {
+			value := pr.Tags[w.tag]
+			if value == "" {
+				continue
+			}
+			for _, pv := range pr.Pageviews {
+				id := pv.UserID
+				if idTag == "browser_id" {
+					id = pv.BrowserID
+				}
+				touches[id] = append(touches[id], attributionTouch{
+					Time:  pv.Time,
+					Value: value,
+				})
+			}
+		}
+	}
+
+	for id := range touches {
+		sort.Slice(touches[id], func(i, j int) bool {
+			return touches[id][i].Time.Before(touches[id][j].Time)
+		})
+	}
+	return touches, nil
+}
+
+// attributionCredits splits credit of single conversion among the values of touches based on the attribution
+// model. Touches are expected to be ordered by time; credits of all values sum up to one.
+func attributionCredits(model string, halfLife time.Duration, touches []attributionTouch) map[string]float64 {
+	credits := make(map[string]float64)
+	if len(touches) == 0 {
+		return credits
+	}
+
+	switch model {
+	case AttributionFirstTouch:
+		credits[touches[0].Value] = 1
+	case AttributionLastTouch:
+		credits[touches[len(touches)-1].Value] = 1
+	case AttributionLinear:
+		for _, t := range touches {
+			credits[t.Value] += 1 / float64(len(touches))
+		}
+	case AttributionTimeDecay:
+		if halfLife <= 0 {
+			halfLife = DefaultAttributionHalfLife
+		}
+		// weights are relative to the newest touch, which gets weight 1, so they can't all underflow to zero
+		// regardless of the time between the touches and the conversion
+		newest := touches[len(touches)-1].Time
+		var total float64
+		weights := make([]float64, len(touches))
+		for i, t := range touches {
+			weights[i] = math.Exp2(-float64(newest.Sub(t.Time)) / float64(halfLife))
+			total += weights[i]
+		}
+		for i, t := range touches {
+			credits[t.Value] += weights[i] / total
+		}
+	}
+	return credits
+}
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAttributionCredits(t *testing.T) {
+	at := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+	touches := []attributionTouch{
+		{Time: at.Add(-14 * 24 * time.Hour), Value: "a"},
+		{Time: at.Add(-7 * 24 * time.Hour), Value: "b"},
+		{Time: at.Add(-time.Hour), Value: "a"},
+		{Time: at.Add(-time.Minute), Value: "c"},
+	}
+
+	cases := []struct {
+		name     string
+		model    string
+		halfLife time.Duration
+		touches  []attributionTouch
+		expected map[string]float64
+	}{
+		{
+			name:     "first touch",
+			model:    AttributionFirstTouch,
+			touches:  touches,
+			expected: map[string]float64{"a": 1},
+		},
+		{
+			name:     "last touch",
+			model:    AttributionLastTouch,
+			touches:  touches,
+			expected: map[string]float64{"c": 1},
+		},
+		{
+			name:     "linear",
+			model:    AttributionLinear,
+			touches:  touches,
+			expected: map[string]float64{"a": 0.5, "b": 0.25, "c": 0.25},
+		},
+		{
+			name:    "time decay",
+			model:   AttributionTimeDecay,
+			touches: touches[:2],
+			// touch older by single half-life gets half of the weight
+			expected: map[string]float64{"a": 1.0 / 3, "b": 2.0 / 3},
+		},
+		{
+			name:     "time decay of old touches",
+			model:    AttributionTimeDecay,
+			halfLife: time.Minute,
+			touches:  []attributionTouch{{Time: at.Add(-2 * 365 * 24 * time.Hour), Value: "a"}, {Time: at.Add(-2*365*24*time.Hour + time.Minute), Value: "b"}},
+			// weights relative to the purchase made millions of half-lives later would underflow to zero
+			expected: map[string]float64{"a": 1.0 / 3, "b": 2.0 / 3},
+		},
+		{
+			name:     "no touches",
+			model:    AttributionLinear,
+			expected: map[string]float64{},
+		},
+	}
+
+	for _, c := range cases {
+		halfLife := c.halfLife
+		if halfLife == 0 {
+			halfLife = 7 * 24 * time.Hour
+		}
+		credits := attributionCredits(c.model, halfLife, c.touches)
+		if len(credits) != len(c.expected) {
+			t.Errorf("%s: returned credits %v, expected %v", c.name, credits, c.expected)
+			continue
+		}
+		for value, credit := range c.expected {
+			if math.Abs(credits[value]-credit) > 1e-9 {
+				t.Errorf("%s: returned credits %v, expected %v", c.name, credits, c.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestAttributionOptions_Validate(t *testing.T) {
+	before := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+	bounded := func(o AttributionOptions, span time.Duration) AttributionOptions {
+		o.TimeAfter = before.Add(-span)
+		o.TimeBefore = before
+		return o
+	}
+
+	valid := bounded(AttributionOptions{Tag: "article_id", Model: AttributionLinear, Window: time.Hour}, MaxAttributionRange)
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid options returned error: %v", err)
+	}
+
+	invalid := []AttributionOptions{
+		bounded(AttributionOptions{Tag: "article_id", Model: "position", Window: time.Hour}, time.Hour),
+		bounded(AttributionOptions{Model: AttributionLinear, Window: time.Hour}, time.Hour),
+		bounded(AttributionOptions{Tag: "article_id", Model: AttributionLinear}, time.Hour),
+		{Tag: "article_id", Model: AttributionLinear, Window: time.Hour},
+		bounded(AttributionOptions{Tag: "article_id", Model: AttributionLinear, Window: time.Hour}, -time.Hour),
+		bounded(AttributionOptions{Tag: "article_id", Model: AttributionLinear, Window: time.Hour}, MaxAttributionRange+time.Hour),
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("invalid options %+v returned no error", o)
+		}
+	}
+}
+
+// pagedCommerceStorage lists commerce events page by page following the ListPage contract.
+type pagedCommerceStorage struct {
+	CommerceStorage
+	commerces []*Commerce
+	calls     int
+}
+
+func (s *pagedCommerceStorage) List(o ListOptions) (CommerceRowCollection, string, error) {
+	s.calls++
+	start := 0
+	if o.Cursor != "" {
+		start, _ = strconv.Atoi(o.Cursor)
+	}
+	end := len(s.commerces)
+	if o.Limit > 0 && start+o.Limit < end {
+		end = start + o.Limit
+	}
+	var next string
+	if end < len(s.commerces) {
+		next = strconv.Itoa(end)
+	}
+	return CommerceRowCollection{{Commerces: s.commerces[start:end]}}, next, nil
+}
+
+// touchPageviewStorage lists pageviews of filtered users grouped by article and records the number of users
+// each listing was narrowed to.
+type touchPageviewStorage struct {
+	PageviewStorage
+	pageviews []*Pageview
+	listed    []int
+}
+
+func (s *touchPageviewStorage) List(o ListPageviewsOptions) (PageviewRowCollection, string, error) {
+	users := make(map[string]bool)
+	for _, fb := range o.FilterBy {
+		if fb.Tag == "user_id" {
+			for _, v := range fb.Values {
+				users[v] = true
+			}
+		}
+	}
+	s.listed = append(s.listed, len(users))
+
+	articles := make(map[string][]*Pageview)
+	for _, pv := range s.pageviews {
+		if users[pv.UserID] && !pv.Time.Before(o.TimeAfter) && pv.Time.Before(o.TimeBefore) {
+			articles[pv.ArticleID] = append(articles[pv.ArticleID], pv)
+		}
+	}
+	prc := PageviewRowCollection{}
+	for article, pvs := range articles {
+		prc = append(prc, &PageviewRow{Tags: map[string]string{"article_id": article}, Pageviews: pvs})
+	}
+	return prc, "", nil
+}
+
+func TestAttributionDB_Attribute(t *testing.T) {
+	now := time.Date(2019, 1, 10, 12, 0, 0, 0, time.UTC)
+	commerces := &pagedCommerceStorage{}
+	pageviews := &touchPageviewStorage{}
+	purchases := maxAttributionCandidates + 5
+	for i := 0; i < purchases; i++ {
+		userID := fmt.Sprintf("u%05d", i)
+		at := now.Add(time.Duration(i) * time.Second)
+		commerces.commerces = append(commerces.commerces, &Commerce{UserID: userID, Time: at, Revenue: 2})
+		// the last purchase has no preceding pageview
+		if i < purchases-1 {
+			pageviews.pageviews = append(pageviews.pageviews, &Pageview{UserID: userID, ArticleID: "a", Time: at.Add(-time.Hour)})
+		}
+	}
+
+	aDB := &AttributionDB{PageviewStorage: pageviews, CommerceStorage: commerces}
+	arc, err := aDB.Attribute(AttributionOptions{
+		AggregateOptions: AggregateOptions{TimeAfter: now.Add(-time.Hour), TimeBefore: now.Add(24 * time.Hour)},
+		Tag:              "article_id",
+		Model:            AttributionLastTouch,
+		Window:           24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+
+	expected := map[string]float64{"a": float64(purchases - 1), "": 1}
+	if len(arc) != len(expected) {
+		t.Fatalf("returned %d rows, expected %d", len(arc), len(expected))
+	}
+	for _, ar := range arc {
+		value := ar.Tags["article_id"]
+		if ar.Conversions != expected[value] || ar.Revenue != 2*expected[value] {
+			t.Errorf("%q: returned %g conversions with revenue %g, expected %g", value, ar.Conversions, ar.Revenue, expected[value])
+		}
+	}
+	if commerces.calls != 2 {
+		t.Errorf("listed %d pages of purchases, expected 2", commerces.calls)
+	}
+	for _, n := range pageviews.listed {
+		if n == 0 || n > maxAttributionCandidates {
+			t.Errorf("listing of pageviews was narrowed to %d users, expected at most %d", n, maxAttributionCandidates)
+		}
+	}
+}
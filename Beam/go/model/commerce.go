@@ -10,6 +10,9 @@ const (
 	TableCommerce    = "commerce"
 )
 
+// CommerceSteps are the steps of commerce funnel in order in which they're expected to happen.
+var CommerceSteps = []string{"checkout", "payment", "purchase", "refund"}
+
 // CommerceOptions represent filter options for commerce-related calls.
 type CommerceOptions struct {
 	IDs        []string
@@ -29,6 +32,8 @@ type Commerce struct {
 	Host        string
 	IP          string
 	UserID      string  `json:"user_id"`
+	BrowserID   string  `json:"browser_id"`
+	SessionID   string  `json:"remp_session_id"`
 	URL         string  `json:"url"`
 	UserAgent   string  `json:"user_agent"`
 	FunnelID    string  `json:"funnel_id"`
@@ -56,12 +61,20 @@ type CommerceStorage interface {
 	Count(o AggregateOptions) (CountRowCollection, bool, error)
 	// Sum returns sum of events based on the provided filter options.
 	Sum(o AggregateOptions) (SumRowCollection, bool, error)
-	// List returns list of all events based on given CommerceOptions.
-	List(o ListOptions) (CommerceRowCollection, error)
+	// Unique returns unique count of given item based on the provided filter options.
+	Unique(o AggregateOptions, item string) (CountRowCollection, bool, error)
+	// Funnel returns number of users or browsers reaching each of CommerceSteps, split by funnel_id and
+	// grouped tags, based on the provided filter options.
+	Funnel(o AggregateOptions, item string) (FunnelRowCollection, error)
+	// List returns list of commerce events based on given CommerceOptions and the cursor of the next page
+	// (empty if there are no more events).
+	List(o ListOptions) (CommerceRowCollection, string, error)
 	// Categories lists all available categories.
 	Categories() ([]string, error)
 	// Flags lists all available flags.
 	Flags() []string
 	// Actions lists all available actions under the given category.
 	Actions(category string) ([]string, error)
+	// Fields lists all fields of records of the given category.
+	Fields(category string) ([]string, error)
 }
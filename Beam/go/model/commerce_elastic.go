@@ -3,7 +3,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"sort"
 	"strconv"
 
 	"github.com/olivere/elastic"
@@ -27,6 +27,10 @@ func (cDB *CommerceElastic) Count(options AggregateOptions) (CountRowCollection,
 		return nil, false, err
 	}
 
+	if options.Page != nil {
+		return cDB.DB.countPage(search, "commerce", options)
+	}
+
 	var dateHistogramAgg *elastic.DateHistogramAggregation
 	if options.TimeHistogram != nil {
 		dateHistogramAgg = elastic.NewDateHistogramAggregation().
@@ -62,47 +66,27 @@ func (cDB *CommerceElastic) Count(options AggregateOptions) (CountRowCollection,
 	return cDB.DB.countRowCollectionFromAggregations(result, options)
 }
 
-// List returns list of all events based on given CommerceOptions.
-func (cDB *CommerceElastic) List(options ListOptions) (CommerceRowCollection, error) {
+// List returns list of events based on given CommerceOptions and the cursor of the next page. All the events
+// are listed if the limit is not set, otherwise events are grouped within the listed page.
+func (cDB *CommerceElastic) List(options ListOptions) (CommerceRowCollection, string, error) {
 	var crc CommerceRowCollection
 
-	fsc := elastic.NewFetchSourceContext(true).Include(options.SelectFields...)
-	scroll := cDB.DB.Client.Scroll("commerce").
-		Type("_doc").
-		Size(1000).
-		FetchSourceContext(fsc)
-
-	scroll, err := cDB.DB.addScrollFilters(scroll, "commerce", options.AggregateOptions)
-	if err != nil {
-		return nil, err
-	}
-
 	// prepare EventRow buckets
 	crBuckets := make(map[string]*CommerceRow)
 
-	// get results
-	for {
-		results, err := scroll.Do(cDB.DB.Context)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, errors.Wrap(err, "error while reading list data from elastic")
-		}
-
-		// Send the hits to the hits channel
-		for _, hit := range results.Hits.Hits {
+	next, err := cDB.DB.list("commerce", options.AggregateOptions, options.ListPage, options.SelectFields, func(hits []*elastic.SearchHit) error {
+		for _, hit := range hits {
 			// populate commerce for collection
 			commerce := &Commerce{}
 			if err := json.Unmarshal(*hit.Source, commerce); err != nil {
-				return nil, errors.Wrap(err, "error reading commerce record from elastic")
+				return errors.Wrap(err, "error reading commerce record from elastic")
 			}
 			commerce.ID = hit.Id
 
 			// extract raw event data to build tags map
 			rawCommerce := make(map[string]interface{})
 			if err := json.Unmarshal(*hit.Source, &rawCommerce); err != nil {
-				return nil, errors.Wrap(err, "error reading pageview record from elastic")
+				return errors.Wrap(err, "error reading pageview record from elastic")
 			}
 
 			// we need to get string value for tags by type casting
@@ -126,7 +110,7 @@ func (cDB *CommerceElastic) List(options ListOptions) (CommerceRowCollection, er
 				case int64:
 					tagVal = strconv.FormatInt(val, 10)
 				default:
-					return nil, fmt.Errorf("unhandled tag type in pageview listing: %T", rawCommerce[field])
+					return fmt.Errorf("unhandled tag type in pageview listing: %T", rawCommerce[field])
 				}
 
 				tags[field] = fmt.Sprintf("%s", tagVal)
@@ -140,16 +124,17 @@ func (cDB *CommerceElastic) List(options ListOptions) (CommerceRowCollection, er
 					Tags: tags,
 				}
 				crBuckets[key] = cr
+				crc = append(crc, cr)
 			}
 			cr.Commerces = append(cr.Commerces, commerce)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
-	for _, cr := range crBuckets {
-		crc = append(crc, cr)
-	}
-
-	return crc, nil
+	return crc, next, nil
 }
 
 // Sum returns sum of events based on the provided filter options.
@@ -191,6 +176,24 @@ func (cDB *CommerceElastic) Sum(options AggregateOptions) (SumRowCollection, boo
 	return cDB.DB.sumRowCollectionFromAggregations(result, options, targetAgg, "revenue")
 }
 
+// Unique returns unique count of given item within events matching the filter defined by AggregateOptions.
+func (cDB *CommerceElastic) Unique(options AggregateOptions, item string) (CountRowCollection, bool, error) {
+	tag, err := uniqueItemTag(item)
+	if err != nil {
+		return nil, false, err
+	}
+	field, err := cDB.DB.resolveKeyword("commerce", tag)
+	if err != nil {
+		return nil, false, err
+	}
+	return cDB.DB.unique("commerce", field, options)
+}
+
+// Fields lists all fields of records of the given category.
+func (cDB *CommerceElastic) Fields(category string) ([]string, error) {
+	return cDB.DB.fieldNames("commerce")
+}
+
 // Categories lists all available categories.
 func (cDB *CommerceElastic) Categories() ([]string, error) {
 	return []string{
@@ -198,6 +201,90 @@ func (cDB *CommerceElastic) Categories() ([]string, error) {
 	}, nil
 }
 
+// funnelPageSize is the number of commerce events loaded at once while computing the funnel.
+const funnelPageSize = 10000
+
+// Funnel returns number of users or browsers reaching each of CommerceSteps, split by funnel_id and
+// grouped tags, based on the provided filter options. Time range of the options has to be bounded.
+//
+// Identity is counted within the group based on tags of its first commerce event of the funnel. Events
+// are listed ordered by funnel and identity so only events of single identity are held at once.
+func (cDB *CommerceElastic) Funnel(options AggregateOptions, item string) (FunnelRowCollection, error) {
+	var idTag string
+	switch item {
+	case UniqueCountUsers:
+		idTag = "user_id"
+	case UniqueCountBrowsers:
+		idTag = "browser_id"
+	default:
+		return nil, fmt.Errorf("unable to compute funnel of %s", item)
+	}
+	if err := validateFunnelOptions(options); err != nil {
+		return nil, err
+	}
+
+	groupBy := append([]string{"funnel_id"}, options.GroupBy...)
+	lo := ListOptions{
+		AggregateOptions: options,
+		SelectFields:     append([]string{"time", "step", idTag}, groupBy...),
+		ListPage: ListPage{
+			Limit: funnelPageSize,
+			Sort:  []ListSort{{Field: "funnel_id"}, {Field: idTag}},
+		},
+	}
+	lo.GroupBy = groupBy
+
+	fc := newFunnelCounter(groupBy)
+	var identity string
+	var events []funnelEvent
+	for {
+		crc, next, err := cDB.List(lo)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows split the page by tags, events are ordered back by identity
+		var page []funnelEvent
+		for _, cr := range crc {
+			for _, c := range cr.Commerces {
+				id := c.UserID
+				if idTag == "browser_id" {
+					id = c.BrowserID
+				}
+				if id == "" {
+					continue
+				}
+				page = append(page, funnelEvent{
+					ID:   id,
+					Tags: cr.Tags,
+					Step: c.Step,
+					Time: c.Time,
+				})
+			}
+		}
+		sort.SliceStable(page, func(i, j int) bool {
+			return funnelIdentityKey(page[i]) < funnelIdentityKey(page[j])
+		})
+
+		// events of the identity might continue on the next page
+		for _, e := range page {
+			if key := funnelIdentityKey(e); key != identity {
+				fc.add(events)
+				identity = key
+				events = nil
+			}
+			events = append(events, e)
+		}
+
+		if next == "" {
+			break
+		}
+		lo.Cursor = next
+	}
+	fc.add(events)
+	return fc.rows(), nil
+}
+
 // Flags lists all available flags.
 func (cDB *CommerceElastic) Flags() []string {
 	return []string{}
@@ -207,12 +294,12 @@ func (cDB *CommerceElastic) Flags() []string {
 func (cDB *CommerceElastic) Actions(category string) ([]string, error) {
 	switch category {
 	case CategoryCommerce:
-		return []string{
-			"checkout",
-			"payment",
-			"purchase",
-			"refund",
-		}, nil
+		return append([]string{}, CommerceSteps...), nil
 	}
 	return nil, fmt.Errorf("unknown commerce category: %s", category)
 }
+
+// QuerySource returns source of the filter query used to count commerce events matching provided options.
+func (cDB *CommerceElastic) QuerySource(options AggregateOptions) (interface{}, error) {
+	return cDB.DB.querySource("commerce", options)
+}
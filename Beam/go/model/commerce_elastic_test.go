@@ -0,0 +1,137 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pkg/errors"
+)
+
+func TestCommerceElastic_FunnelOptions(t *testing.T) {
+	cDB := &CommerceElastic{}
+	now := time.Now()
+
+	var optionsTests = []struct {
+		Name    string
+		Options AggregateOptions
+	}{
+		{"unbounded time range", AggregateOptions{TimeAfter: now.Add(-time.Hour)}},
+		{"step", AggregateOptions{TimeAfter: now.Add(-time.Hour), TimeBefore: now, Step: "payment"}},
+		{"group top", AggregateOptions{TimeAfter: now.Add(-time.Hour), TimeBefore: now, GroupTop: []GroupTop{{Tag: "utm_campaign", Size: 5}}}},
+	}
+
+	for _, ot := range optionsTests {
+		if _, err := cDB.Funnel(ot.Options, UniqueCountUsers); errors.Cause(err) != ErrFunnelOptions {
+			t.Errorf("%s: returned error %v, expected %v", ot.Name, err, ErrFunnelOptions)
+		}
+	}
+}
+
+func TestCommerceElastic_Funnel(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// user u00000 only reaches the checkout, the rest of the users reach the payment a minute later
+	type hit struct {
+		ID     string                 `json:"_id"`
+		Source map[string]interface{} `json:"_source"`
+		Sort   []interface{}          `json:"sort"`
+	}
+	var hits []hit
+	for i := 0; i <= funnelPageSize/2; i++ {
+		user := fmt.Sprintf("u%05d", i)
+		steps := []string{"checkout", "payment"}
+		if i == 0 {
+			steps = steps[:1]
+		}
+		for j, step := range steps {
+			tm := now.Add(time.Duration(j) * time.Minute)
+			hits = append(hits, hit{
+				ID:     fmt.Sprintf("%s-%s", user, step),
+				Source: map[string]interface{}{"funnel_id": "f1", "user_id": user, "step": step, "time": tm},
+				Sort:   []interface{}{"f1", user, tm.UnixNano() / int64(time.Millisecond), user + step, ""},
+			})
+		}
+	}
+
+	var searches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/_search") {
+			fmt.Fprintf(w, `{"%s": {"mappings": {"_doc": {"properties": {
+				"funnel_id": {"type": "keyword"},
+				"user_id": {"type": "keyword"},
+				"browser_id": {"type": "keyword"},
+				"remp_commerce_id": {"type": "keyword"},
+				"step": {"type": "keyword"},
+				"time": {"type": "date"}
+			}}}}}`, TableCommerce)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		searches = append(searches, string(body))
+
+		// the first page ends in the middle of the events of the last user
+		page := hits[:funnelPageSize+1]
+		if strings.Contains(string(body), "search_after") {
+			page = hits[funnelPageSize:]
+		}
+		result, err := json.Marshal(map[string]interface{}{
+			"hits": map[string]interface{}{
+				"total": len(hits),
+				"hits":  page,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(result)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cDB := &CommerceElastic{DB: NewElasticDB(context.Background(), client, false)}
+
+	frc, err := cDB.Funnel(AggregateOptions{TimeAfter: now.Add(-time.Hour), TimeBefore: now.Add(time.Hour)}, UniqueCountUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(searches) != 2 {
+		t.Fatalf("funnel loaded %d pages, expected 2", len(searches))
+	}
+	if !strings.Contains(searches[0], `"sort":[{"funnel_id":{"order":"asc"}},{"user_id":{"order":"asc"}}`) {
+		t.Errorf("events are not ordered by funnel and user: %s", searches[0])
+	}
+
+	minute := time.Minute
+	expected := FunnelRowCollection{
+		{
+			Tags: map[string]string{"funnel_id": "f1"},
+			Steps: []FunnelStep{
+				{Step: "checkout", Count: 5001, Rate: 1},
+				{Step: "payment", Count: 5000, Rate: 5000.0 / 5001, MedianTime: &minute},
+				{Step: "purchase", Count: 0, Rate: 0},
+				{Step: "refund", Count: 0, Rate: 0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(frc, expected) {
+		t.Errorf("returned funnel %+v, expected %+v", frc, expected)
+	}
+}
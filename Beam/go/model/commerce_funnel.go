@@ -0,0 +1,189 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// funnelEvent represents commerce step done by the identity (user or browser).
+type funnelEvent struct {
+	ID   string
+	Tags map[string]string // tags of the event including funnel_id
+	Step string
+	Time time.Time
+}
+
+// funnelGroup accumulates identities of single row of the funnel.
+type funnelGroup struct {
+	tags      map[string]string
+	counts    []int
+	durations [][]time.Duration
+}
+
+// ErrFunnelOptions is returned if the funnel can't be computed with provided options.
+var ErrFunnelOptions = errors.New("funnel requires bounded time range and doesn't support step, group top or time histogram")
+
+// validateFunnelOptions checks whether the funnel can be computed with provided options. Funnel is evaluated
+// over all the commerce steps and events of bounded time range only.
+func validateFunnelOptions(o AggregateOptions) error {
+	if o.TimeAfter.IsZero() || o.TimeBefore.IsZero() {
+		return errors.Wrap(ErrFunnelOptions, "time range has to be bounded")
+	}
+	if o.Step != "" {
+		return errors.Wrap(ErrFunnelOptions, "step can't be filtered")
+	}
+	if len(o.GroupTop) > 0 || o.TimeHistogram != nil {
+		return errors.Wrap(ErrFunnelOptions, "group top and time histogram are not supported")
+	}
+	return nil
+}
+
+// funnelCounter counts identities reaching each of CommerceSteps within the groups of provided tags.
+type funnelCounter struct {
+	groupBy   []string
+	stepIndex map[string]int
+	groups    map[string]*funnelGroup
+}
+
+// newFunnelCounter creates counter of identities grouped by provided tags.
+func newFunnelCounter(groupBy []string) *funnelCounter {
+	stepIndex := make(map[string]int)
+	for i, step := range CommerceSteps {
+		stepIndex[step] = i
+	}
+	return &funnelCounter{
+		groupBy:   groupBy,
+		stepIndex: stepIndex,
+		groups:    make(map[string]*funnelGroup),
+	}
+}
+
+// funnelRows evaluates the funnel of each identity and counts identities reaching each of CommerceSteps
+// within the groups of provided tags.
+//
+// Identity reaches the step only if it reached all the previous steps before; the identity belongs to the group
+// based on tags of its first event within the funnel.
+func funnelRows(events []funnelEvent, groupBy []string) FunnelRowCollection {
+	// each identity goes through the funnel separately
+	identities := make(map[string][]funnelEvent)
+	for _, e := range events {
+		key := funnelIdentityKey(e)
+		identities[key] = append(identities[key], e)
+	}
+
+	fc := newFunnelCounter(groupBy)
+	for _, ee := range identities {
+		fc.add(ee)
+	}
+	return fc.rows()
+}
+
+// funnelIdentityKey returns key of the identity within the funnel of the event.
+func funnelIdentityKey(e funnelEvent) string {
+	return fmt.Sprintf("%s\x00%s", e.Tags["funnel_id"], e.ID)
+}
+
+// add evaluates the funnel of single identity based on all its events within the funnel.
+func (fc *funnelCounter) add(events []funnelEvent) {
+	var ee []funnelEvent
+	for _, e := range events {
+		if _, ok := fc.stepIndex[e.Step]; ok {
+			ee = append(ee, e)
+		}
+	}
+	if len(ee) == 0 {
+		return
+	}
+	sort.SliceStable(ee, func(i, j int) bool {
+		return ee[i].Time.Before(ee[j].Time)
+	})
+
+	var key []string
+	for _, tag := range fc.groupBy {
+		key = append(key, fmt.Sprintf("%s=%s", tag, ee[0].Tags[tag]))
+	}
+	g, ok := fc.groups[strings.Join(key, "\x00")]
+	if !ok {
+		g = &funnelGroup{
+			tags:      make(map[string]string),
+			counts:    make([]int, len(CommerceSteps)),
+			durations: make([][]time.Duration, len(CommerceSteps)),
+		}
+		for _, tag := range fc.groupBy {
+			g.tags[tag] = ee[0].Tags[tag]
+		}
+		fc.groups[strings.Join(key, "\x00")] = g
+	}
+
+	var reached time.Time
+	for i, step := range CommerceSteps {
+		found := false
+		for _, e := range ee {
+			if e.Step == step && (i == 0 || !e.Time.Before(reached)) {
+				if i > 0 {
+					g.durations[i] = append(g.durations[i], e.Time.Sub(reached))
+				}
+				reached = e.Time
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+		g.counts[i]++
+	}
+}
+
+// rows returns funnel rows of all the groups ordered by their tags.
+func (fc *funnelCounter) rows() FunnelRowCollection {
+	groups := fc.groups
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	frc := FunnelRowCollection{}
+	for _, key := range keys {
+		g := groups[key]
+		row := FunnelRow{
+			Tags: g.tags,
+		}
+		for i, step := range CommerceSteps {
+			fs := FunnelStep{
+				Step:  step,
+				Count: g.counts[i],
+			}
+			switch {
+			case i == 0 && g.counts[i] > 0:
+				fs.Rate = 1
+			case i > 0 && g.counts[i-1] > 0:
+				fs.Rate = float64(g.counts[i]) / float64(g.counts[i-1])
+			}
+			if len(g.durations[i]) > 0 {
+				median := medianDuration(g.durations[i])
+				fs.MedianTime = &median
+			}
+			row.Steps = append(row.Steps, fs)
+		}
+		frc = append(frc, row)
+	}
+	return frc
+}
+
+// medianDuration returns median of provided durations. The durations get sorted.
+func medianDuration(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	mid := len(durations) / 2
+	if len(durations)%2 == 1 {
+		return durations[mid]
+	}
+	return (durations[mid-1] + durations[mid]) / 2
+}
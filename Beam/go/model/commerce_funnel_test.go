@@ -0,0 +1,56 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFunnelRows(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	tags := func(funnel, campaign string) map[string]string {
+		return map[string]string{"funnel_id": funnel, "utm_campaign": campaign}
+	}
+	events := []funnelEvent{
+		// complete purchase
+		{ID: "u1", Tags: tags("f1", "spring"), Step: "checkout", Time: now},
+		{ID: "u1", Tags: tags("f1", "spring"), Step: "payment", Time: now.Add(time.Minute)},
+		{ID: "u1", Tags: tags("f1", "spring"), Step: "purchase", Time: now.Add(2 * time.Minute)},
+		// payment failed, grouped by campaign of the checkout
+		{ID: "u2", Tags: tags("f1", "spring"), Step: "checkout", Time: now},
+		{ID: "u2", Tags: tags("f1", "other"), Step: "payment", Time: now.Add(3 * time.Minute)},
+		// purchase before the checkout isn't part of the funnel
+		{ID: "u3", Tags: tags("f1", "spring"), Step: "purchase", Time: now.Add(-time.Hour)},
+		{ID: "u3", Tags: tags("f1", "spring"), Step: "checkout", Time: now},
+		// the same user within another funnel
+		{ID: "u1", Tags: tags("f2", "spring"), Step: "checkout", Time: now},
+	}
+
+	minute := time.Minute
+	twoMinutes := 2 * time.Minute
+	expected := FunnelRowCollection{
+		{
+			Tags: map[string]string{"funnel_id": "f1", "utm_campaign": "spring"},
+			Steps: []FunnelStep{
+				{Step: "checkout", Count: 3, Rate: 1},
+				{Step: "payment", Count: 2, Rate: 2.0 / 3, MedianTime: &twoMinutes},
+				{Step: "purchase", Count: 1, Rate: 0.5, MedianTime: &minute},
+				{Step: "refund", Count: 0, Rate: 0},
+			},
+		},
+		{
+			Tags: map[string]string{"funnel_id": "f2", "utm_campaign": "spring"},
+			Steps: []FunnelStep{
+				{Step: "checkout", Count: 1, Rate: 1},
+				{Step: "payment", Count: 0, Rate: 0},
+				{Step: "purchase", Count: 0, Rate: 0},
+				{Step: "refund", Count: 0, Rate: 0},
+			},
+		},
+	}
+
+	frc := funnelRows(events, []string{"funnel_id", "utm_campaign"})
+	if !reflect.DeepEqual(frc, expected) {
+		t.Errorf("returned funnel %+v, expected %+v", frc, expected)
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"hash/fnv"
 	"regexp"
 	"strings"
 	"time"
@@ -9,9 +10,23 @@ import (
 // ListOptions represent select and filter options for listing-related calls.
 type ListOptions struct {
 	AggregateOptions
+	ListPage
 	SelectFields []string
 }
 
+// ListPage is used to split listed records to pages. All the records are listed if limit is not set.
+type ListPage struct {
+	Limit  int
+	Sort   []ListSort // records are ordered by time if no sort is provided
+	Cursor string     // cursor returned with the previous page, the first page is listed if empty
+}
+
+// ListSort represents field by which the listed records are ordered.
+type ListSort struct {
+	Field string
+	Desc  bool
+}
+
 // AggregateOptions represent filter options for aggregate-related calls.
 type AggregateOptions struct {
 	Category      string
@@ -19,9 +34,58 @@ type AggregateOptions struct {
 	Step          string
 	FilterBy      []*FilterBy
 	GroupBy       []string
+	GroupTop      []GroupTop // limits of grouping by particular tags to the top buckets
 	TimeAfter     time.Time
 	TimeBefore    time.Time
 	TimeHistogram *TimeHistogram
+	Page          *AggregatePage
+
+	UniquePrecision int  // precision threshold of unique counts, default of the storage is used if zero
+	UniqueExact     bool // unique values are counted exactly instead of being approximated
+}
+
+// GroupOther is the tag value of the bucket containing records of the groups not within the top buckets.
+const GroupOther = "_other"
+
+// GroupTop limits grouping by the tag to the top buckets ordered by the metric. Other bucket reports
+// only the number of remaining records, it can't be requested together with metrics or time histogram.
+type GroupTop struct {
+	Tag    string
+	Size   int
+	Metric string // MetricCount (default), MetricSum, MetricAvg or MetricUnique
+	Field  string // field of the metric, not used by MetricCount
+	Asc    bool   // buckets with the lowest values are returned if set
+	Other  bool   // remaining records are reported within GroupOther bucket
+}
+
+// findGroupTop returns GroupTop of the tag or nil if grouping by the tag isn't limited.
+func findGroupTop(tops []GroupTop, tag string) *GroupTop {
+	for i := range tops {
+		if tops[i].Tag == tag {
+			return &tops[i]
+		}
+	}
+	return nil
+}
+
+// AggregatePage is used to split grouped results to pages ordered by values of grouped tags.
+//
+// If Partitions is set, single pseudo-random partition of grouped buckets (selected by hash of the value
+// of the only grouped tag) is returned instead of the page following After. Partitions are used to sample
+// the buckets independently of their order.
+type AggregatePage struct {
+	Size       int
+	After      map[string]string
+	Partition  int
+	Partitions int
+}
+
+// inPartition returns true if the value falls into the partition of AggregatePage. Partitions of in-memory
+// storages are selected by this function.
+func (ap *AggregatePage) inPartition(value string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return int(h.Sum32()%uint32(ap.Partitions)) == ap.Partition
 }
 
 // TimeHistogram is used to split response to buckets
@@ -30,10 +94,39 @@ type TimeHistogram struct {
 	Offset   string
 }
 
+// Operators of FilterBy. Range operators accept single value, exists and missing operators accept no value.
+const (
+	FilterIn       = "in"
+	FilterNotIn    = "not_in"
+	FilterGt       = "gt"
+	FilterGte      = "gte"
+	FilterLt       = "lt"
+	FilterLte      = "lte"
+	FilterPrefix   = "prefix"
+	FilterWildcard = "wildcard"
+	FilterExists   = "exists"
+	FilterMissing  = "missing"
+)
+
 // FilterBy represents tag and values used to filter results of count-related calls.
 type FilterBy struct {
-	Tag    string
-	Values []string
+	Tag      string
+	Values   []string
+	Operator string // one of the Filter* operators, FilterIn is used if empty
+}
+
+// validFilterOperator returns true if the operator is one of the FilterBy operators.
+func validFilterOperator(operator string) bool {
+	switch operator {
+	case FilterIn, FilterNotIn, FilterGt, FilterGte, FilterLt, FilterLte, FilterPrefix, FilterWildcard, FilterExists, FilterMissing:
+		return true
+	}
+	return false
+}
+
+// valueless returns true if the filter operator doesn't use any values.
+func (fb *FilterBy) valueless() bool {
+	return fb.Operator == FilterExists || fb.Operator == FilterMissing
 }
 
 // Webalize replaces all spaces with dash and removes all non-alphanumerical characters.
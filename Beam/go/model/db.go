@@ -27,6 +27,28 @@ func (gt FilterType) column() string {
 	return fts[string(gt)]
 }
 
+// uniqueItemTag returns name of the tag holding identifiers of given unique item.
+func uniqueItemTag(item string) (string, error) {
+	switch item {
+	case UniqueCountBrowsers:
+		return "browser_id", nil
+	case UniqueCountUsers:
+		return "user_id", nil
+	case UniqueCountSessions:
+		return "remp_session_id", nil
+	case UniqueCountPageviews:
+		return TagPageviewID, nil
+	case UniqueCountArticles:
+		return "article_id", nil
+	case UniqueCountAuthors:
+		return "author_id", nil
+	case "":
+		return "", fmt.Errorf("unable to count uniques for item [%s] ", item)
+	}
+	// any other item is considered to be name of the tag
+	return item, nil
+}
+
 // HistogramItem represents one row of histogram results.
 type HistogramItem struct {
 	Time  time.Time
@@ -43,6 +65,16 @@ type CountRow struct {
 // CountRowCollection represents collection of rows of grouped count.
 type CountRowCollection []CountRow
 
+// ActivityRow represents one row of grouped activity (number of events and time of the last one).
+type ActivityRow struct {
+	Tags  map[string]string
+	Count int
+	Last  time.Time
+}
+
+// ActivityRowCollection represents collection of rows of grouped activity.
+type ActivityRowCollection []ActivityRow
+
 // SumRow represents one row of grouped sum.
 type SumRow struct {
 	Tags      map[string]string
@@ -62,3 +94,103 @@ type AvgRow struct {
 
 // AvgRowCollection represents collection of rows of grouped sum.
 type AvgRowCollection []AvgRow
+
+// PercentilesRow represents one row of grouped percentiles. Percentiles are keyed by the percent (e.g. "50").
+type PercentilesRow struct {
+	Tags        map[string]string
+	Percentiles map[string]float64
+	Histogram   []PercentilesHistogramItem
+}
+
+// PercentilesHistogramItem represents percentiles within single time bucket.
+type PercentilesHistogramItem struct {
+	Time        time.Time
+	Percentiles map[string]float64
+}
+
+// PercentilesRowCollection represents collection of rows of grouped percentiles.
+type PercentilesRowCollection []PercentilesRow
+
+// DistributionRow represents one row of grouped distribution of values.
+type DistributionRow struct {
+	Tags      map[string]string
+	Count     int
+	Buckets   []DistributionBucket
+	Histogram []DistributionHistogramItem
+}
+
+// DistributionBucket represents number of values falling into the bucket starting at From (inclusive).
+type DistributionBucket struct {
+	From  float64
+	Count int
+}
+
+// DistributionHistogramItem represents distribution of values within single time bucket.
+type DistributionHistogramItem struct {
+	Time    time.Time
+	Buckets []DistributionBucket
+}
+
+// DistributionRowCollection represents collection of rows of grouped distribution.
+type DistributionRowCollection []DistributionRow
+
+// MaxRow represents one row of grouped maximum.
+type MaxRow struct {
+	Tags map[string]string
+	Max  float64
+}
+
+// MaxRowCollection represents collection of rows of grouped maximum.
+type MaxRowCollection []MaxRow
+
+// CompletionRow represents one row of grouped share of pageviews reaching the progress threshold.
+type CompletionRow struct {
+	Tags      map[string]string
+	Pageviews int
+	Completed int
+	Histogram []CompletionHistogramItem
+}
+
+// CompletionHistogramItem represents share of pageviews reaching the progress threshold within single time bucket.
+type CompletionHistogramItem struct {
+	Time      time.Time
+	Pageviews int
+	Completed int
+}
+
+// CompletionRowCollection represents collection of rows of grouped completion.
+type CompletionRowCollection []CompletionRow
+
+// Rate returns share of pageviews reaching the progress threshold.
+func (cr CompletionRow) Rate() float64 {
+	return completionRate(cr.Pageviews, cr.Completed)
+}
+
+// Rate returns share of pageviews reaching the progress threshold within the time bucket.
+func (chi CompletionHistogramItem) Rate() float64 {
+	return completionRate(chi.Pageviews, chi.Completed)
+}
+
+func completionRate(pageviews, completed int) float64 {
+	if pageviews == 0 {
+		return 0
+	}
+	return float64(completed) / float64(pageviews)
+}
+
+// FunnelRow represents one row of grouped commerce funnel.
+type FunnelRow struct {
+	Tags  map[string]string
+	Steps []FunnelStep
+}
+
+// FunnelStep represents number of identities (users or browsers) which reached the step of commerce funnel.
+type FunnelStep struct {
+	Step       string
+	Count      int
+	Rate       float64        // share of identities of the previous step which reached this step
+	MedianTime *time.Duration // median time between reaching the previous step and this step
+}
+
+// FunnelRowCollection represents collection of rows of grouped commerce funnel.
+type FunnelRowCollection []FunnelRow
@@ -1,23 +1,63 @@
 package model
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/olivere/elastic"
 	"github.com/pkg/errors"
 )
 
+// MaxExactUnique is the maximum number of unique values which can be counted exactly.
+const MaxExactUnique = 10000
+
+// ErrTooManyUniques is returned if exact unique count exceeds MaxExactUnique values.
+var ErrTooManyUniques = fmt.Errorf("unable to count more than %d unique values exactly", MaxExactUnique)
+
+// MaxDistributionBuckets is the maximum number of buckets of the distribution within single group.
+const MaxDistributionBuckets = 1000
+
+// ErrTooManyBuckets is returned if the distribution of values would be split into more than MaxDistributionBuckets buckets.
+var ErrTooManyBuckets = fmt.Errorf("unable to split distribution into more than %d buckets", MaxDistributionBuckets)
+
+// ErrGroupOtherMetrics is returned if other bucket of top groups is requested together with metrics or time histogram,
+// only the number of remaining records is known within the other bucket.
+var ErrGroupOtherMetrics = errors.New("other bucket of top groups can't be combined with metrics or time histogram")
+
+// ErrInvalidCursor is returned if the cursor of the listed page can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// groupTopAgg is the name of aggregation ordering top buckets of grouping, groupOtherMeta is the flag
+// of grouping reporting other bucket.
+const (
+	groupTopAgg    = "group_top"
+	groupOtherMeta = "other"
+)
+
+// activityPageSize is the number of groups of activity loaded at once.
+const activityPageSize = 10000
+
+// listPageSize is the number of records loaded at once if all the records are listed.
+const listPageSize = 1000
+
 // ElasticDB represents data layer based on ElasticSearch.
 type ElasticDB struct {
 	Client  *elastic.Client
 	Debug   bool
 	Context context.Context
 
+	UniquePrecision int // default precision threshold of unique counts, Elastic's default is used if zero
+
+	fieldsMu    sync.RWMutex
 	fieldsCache map[string]map[string]string // fields cache represents list of all index (map key) fields (map values)
 }
 
@@ -48,24 +88,178 @@ func (eDB *ElasticDB) addScrollFilters(scroll *elastic.ScrollService, index stri
 	return scroll.Query(bq), nil
 }
 
-func (eDB *ElasticDB) boolQueryFromOptions(index string, o AggregateOptions) (*elastic.BoolQuery, error) {
-	bq := elastic.NewBoolQuery()
-	for _, f := range o.FilterBy {
-		if len(f.Values) == 0 {
+// list loads records matching the options ordered by the sort fields of the page and passes the hits to the callback.
+// If the page has limit set, only single page is loaded and the cursor of the next page is returned (empty
+// if there are no more records). Otherwise all the records are loaded and passed to the callback page by page.
+func (eDB *ElasticDB) list(index string, o AggregateOptions, page ListPage, selectFields []string, fn func(hits []*elastic.SearchHit) error) (string, error) {
+	bq, err := eDB.boolQueryFromOptions(index, o)
+	if err != nil {
+		return "", err
+	}
+	sorters, err := eDB.listSorters(index, page.Sort)
+	if err != nil {
+		return "", err
+	}
+	searchAfter, err := decodeListCursor(page.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	size := listPageSize
+	if page.Limit > 0 {
+		// one extra record is loaded to find out whether there's next page
+		size = page.Limit + 1
+	}
+	fsc := elastic.NewFetchSourceContext(true).Include(selectFields...)
+
+	for {
+		search := eDB.Client.Search().
+			Index(index).
+			Type("_doc").
+			Query(bq).
+			FetchSourceContext(fsc).
+			SortBy(sorters...).
+			Size(size)
+		if searchAfter != nil {
+			search = search.SearchAfter(searchAfter...)
+		}
+
+		result, err := search.Do(eDB.Context)
+		if err != nil {
+			return "", errors.Wrap(err, "error while reading list data from elastic")
+		}
+		hits := result.Hits.Hits
+
+		if page.Limit > 0 {
+			if len(hits) <= page.Limit {
+				return "", fn(hits)
+			}
+			hits = hits[:page.Limit]
+			if err := fn(hits); err != nil {
+				return "", err
+			}
+			return encodeListCursor(hits[len(hits)-1].Sort)
+		}
+
+		if err := fn(hits); err != nil {
+			return "", err
+		}
+		if len(hits) < size {
+			return "", nil
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+}
+
+// listTiebreakers are the fields identifying the records of listed indices. Sorting by document ID would
+// require loading its fielddata, these fields are sorted using their doc values instead.
+var listTiebreakers = map[string][]string{
+	"pageviews": {TagPageviewID},
+	"events":    {"remp_event_id", "browser_id"},
+	"commerce":  {"remp_commerce_id", "browser_id"},
+}
+
+// listSorters returns sorters ordering listed records by the provided fields. Records are ordered by time
+// if no field is provided; time and the identifying fields of the index are always used as the last sorters
+// so the order is deterministic.
+func (eDB *ElasticDB) listSorters(index string, sorts []ListSort) ([]elastic.Sorter, error) {
+	sorted := make(map[string]bool)
+	var sorters []elastic.Sorter
+	for _, ls := range sorts {
+		field, err := eDB.resolveKeyword(index, ls.Field)
+		if err != nil {
+			return nil, err
+		}
+		sorted[ls.Field] = true
+		sorters = append(sorters, elastic.NewFieldSort(field).Order(!ls.Desc))
+	}
+	for _, tb := range append([]string{"time"}, listTiebreakers[index]...) {
+		if sorted[tb] {
 			continue
 		}
+		field, err := eDB.resolveKeyword(index, tb)
+		if err != nil {
+			return nil, err
+		}
+		// identifiers might not be mapped yet if no record has them
+		sorters = append(sorters, elastic.NewFieldSort(field).Asc().UnmappedType("keyword"))
+	}
+	return sorters, nil
+}
 
-		// cast to interface slice, see https://github.com/golang/go/wiki/InterfaceSlice
-		var interfaceSlice = make([]interface{}, len(f.Values))
-		for idx, val := range f.Values {
-			interfaceSlice[idx] = val
+// encodeListCursor encodes sort values of the last listed record to the opaque cursor of the next page.
+func encodeListCursor(sortValues []interface{}) (string, error) {
+	raw, err := json.Marshal(sortValues)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode list cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeListCursor decodes the cursor of listed page to the sort values of the last record of previous page.
+// Nil is returned for empty cursor.
+func decodeListCursor(cursor string) ([]interface{}, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var sortValues []interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber() // keep the precision of numeric sort values (e.g. timestamps)
+	if err := d.Decode(&sortValues); err != nil || len(sortValues) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return sortValues, nil
+}
+
+// encodeGroupCursor encodes tags of the last returned group to the opaque cursor of the next page of groups.
+func encodeGroupCursor(tags map[string]string, groupBy []string) (string, error) {
+	values := make([]interface{}, 0, len(groupBy))
+	for _, g := range groupBy {
+		values = append(values, tags[g])
+	}
+	return encodeListCursor(values)
+}
+
+// decodeGroupCursor decodes the cursor of page of groups to the tags of the last group of previous page.
+// Nil is returned for empty cursor.
+func decodeGroupCursor(cursor string, groupBy []string) (map[string]string, error) {
+	values, err := decodeListCursor(cursor)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	if len(values) != len(groupBy) {
+		return nil, ErrInvalidCursor
+	}
+	tags := make(map[string]string)
+	for i, g := range groupBy {
+		val, ok := values[i].(string)
+		if !ok {
+			return nil, ErrInvalidCursor
 		}
+		tags[g] = val
+	}
+	return tags, nil
+}
 
-		field, err := eDB.resolveKeyword(index, f.Tag)
+func (eDB *ElasticDB) boolQueryFromOptions(index string, o AggregateOptions) (*elastic.BoolQuery, error) {
+	bq := elastic.NewBoolQuery()
+	for _, f := range o.FilterBy {
+		q, negated, err := eDB.filterQuery(index, f)
 		if err != nil {
 			return nil, err
 		}
-		bq = bq.Must(elastic.NewTermsQuery(field, interfaceSlice...))
+		if q == nil {
+			continue
+		}
+		if negated {
+			bq = bq.MustNot(q)
+		} else {
+			bq = bq.Must(q)
+		}
 	}
 
 	if o.Category != "" {
@@ -102,14 +296,107 @@ func (eDB *ElasticDB) boolQueryFromOptions(index string, o AggregateOptions) (*e
 	return bq, nil
 }
 
+// filterQuery returns query matching records of the filter. Negated queries (not_in, missing) need to be
+// applied as must_not clauses. Nil query is returned for filters without values, which don't filter the data.
+func (eDB *ElasticDB) filterQuery(index string, f *FilterBy) (elastic.Query, bool, error) {
+	if f.valueless() {
+		return elastic.NewExistsQuery(f.Tag), f.Operator == FilterMissing, nil
+	}
+	if len(f.Values) == 0 {
+		return nil, false, nil
+	}
+
+	field, err := eDB.resolveKeyword(index, f.Tag)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch f.Operator {
+	case "", FilterIn, FilterNotIn:
+		values, err := eDB.filterValues(index, field, f.Values)
+		if err != nil {
+			return nil, false, err
+		}
+		return elastic.NewTermsQuery(field, values...), f.Operator == FilterNotIn, nil
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if len(f.Values) > 1 {
+			return nil, false, fmt.Errorf("range filter of %s accepts single value", f.Tag)
+		}
+		rq := elastic.NewRangeQuery(field)
+		switch f.Operator {
+		case FilterGt:
+			rq.Gt(f.Values[0])
+		case FilterGte:
+			rq.Gte(f.Values[0])
+		case FilterLt:
+			rq.Lt(f.Values[0])
+		case FilterLte:
+			rq.Lte(f.Values[0])
+		}
+		return rq, false, nil
+	case FilterPrefix, FilterWildcard:
+		// any of the patterns has to match
+		bq := elastic.NewBoolQuery()
+		for _, val := range f.Values {
+			if f.Operator == FilterPrefix {
+				bq = bq.Should(elastic.NewPrefixQuery(field, val))
+			} else {
+				bq = bq.Should(elastic.NewWildcardQuery(field, val))
+			}
+		}
+		return bq, false, nil
+	}
+	return nil, false, fmt.Errorf("unknown filter operator: %s", f.Operator)
+}
+
+// filterValues casts filter values to the type of the field. Values of boolean fields (e.g. flags) are accepted
+// in any format recognized by strconv.ParseBool ("1", "0", "true", "false", ...).
+func (eDB *ElasticDB) filterValues(index, field string, values []string) ([]interface{}, error) {
+	fields, err := eDB.fieldMapping(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// cast to interface slice, see https://github.com/golang/go/wiki/InterfaceSlice
+	var interfaceSlice = make([]interface{}, len(values))
+	for idx, val := range values {
+		if fields[field] != "boolean" {
+			interfaceSlice[idx] = val
+			continue
+		}
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of boolean filter %s: %s", field, val)
+		}
+		interfaceSlice[idx] = b
+	}
+	return interfaceSlice, nil
+}
+
+// querySource returns source of the filter query applied to the index for provided options.
+func (eDB *ElasticDB) querySource(index string, o AggregateOptions) (interface{}, error) {
+	bq, err := eDB.boolQueryFromOptions(index, o)
+	if err != nil {
+		return nil, err
+	}
+	return bq.Source()
+}
+
 // addGroupBy creates a standard (wrapped) aggregation. The results are fetchable
 // via countRowCollectionFromBuckets or sumRowCollectionFromBuckets.
 func (eDB *ElasticDB) addGroupBy(search *elastic.SearchService, index string, o AggregateOptions,
 	extras map[string]elastic.Aggregation, dateHistogramAgg *elastic.DateHistogramAggregation) (*elastic.SearchService, error) {
 
+	if len(extras) > 0 || dateHistogramAgg != nil {
+		for _, top := range o.GroupTop {
+			if top.Other {
+				return nil, ErrGroupOtherMetrics
+			}
+		}
+	}
 	if len(o.GroupBy) > 0 || len(extras) > 0 || dateHistogramAgg != nil {
 		var err error
-		search, _, err = eDB.WrapAggregation(index, o.GroupBy, search, extras, dateHistogramAgg, nil)
+		search, _, err = eDB.WrapAggregation(index, o.GroupBy, o.GroupTop, search, extras, dateHistogramAgg, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -181,6 +468,10 @@ func (eDB *ElasticDB) sumRowCollectionFromAggregations(result *elastic.SearchRes
 		if options.TimeHistogram != nil {
 			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
 			if !ok {
+				if aggregations == nil {
+					// bucket without aggregated data (e.g. other bucket of top groups)
+					return nil
+				}
 				return errors.New("missing expected histogram aggregation data")
 			}
 
@@ -249,6 +540,10 @@ func (eDB *ElasticDB) avgRowCollectionFromAggregations(result *elastic.SearchRes
 		if options.TimeHistogram != nil {
 			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
 			if !ok {
+				if aggregations == nil {
+					// bucket without aggregated data (e.g. other bucket of top groups)
+					return nil
+				}
 				return errors.New("missing expected histogram aggregation data")
 			}
 
@@ -301,6 +596,124 @@ func (eDB *ElasticDB) avgRowCollectionFromAggregations(result *elastic.SearchRes
 	return src, ok, nil
 }
 
+// percentilesRowCollectionFromAggregations generates PercentilesRowCollection based on query result aggregations.
+func (eDB *ElasticDB) percentilesRowCollectionFromAggregations(result *elastic.SearchResult, options AggregateOptions, targetAgg string, percents []float64) (PercentilesRowCollection, bool, error) {
+	var prc PercentilesRowCollection
+	tags := make(map[string]string)
+
+	err := eDB.UnwrapAggregation(result.Hits.TotalHits, result.Aggregations, options.GroupBy, tags, func(tags map[string]string, count int64, aggregations elastic.Aggregations) error {
+		var histogram []PercentilesHistogramItem
+		if options.TimeHistogram != nil {
+			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
+			if !ok && aggregations != nil {
+				return errors.New("missing expected histogram aggregation data")
+			}
+			if histogramData != nil {
+				for _, histogramItem := range histogramData.Buckets {
+					histogram = append(histogram, PercentilesHistogramItem{
+						Time:        time.Unix(0, int64(histogramItem.Key)*int64(time.Millisecond)).UTC(),
+						Percentiles: percentileValues(histogramItem.Aggregations, targetAgg, percents),
+					})
+				}
+			}
+		}
+
+		prcTags := make(map[string]string)
+		// copy tags to avoid memory sharing
+		for key, val := range tags {
+			prcTags[key] = val
+		}
+
+		prc = append(prc, PercentilesRow{
+			Tags:        prcTags,
+			Percentiles: percentileValues(aggregations, targetAgg, percents),
+			Histogram:   histogram,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok := len(prc) > 0
+	return prc, ok, nil
+}
+
+// percentileValues extracts requested percentiles from the percentiles aggregation. Percentiles are keyed
+// by the percent, missing percentiles (e.g. of no records) are reported as zero.
+func percentileValues(aggregations elastic.Aggregations, targetAgg string, percents []float64) map[string]float64 {
+	agg, ok := aggregations.Percentiles(targetAgg)
+	values := make(map[string]float64)
+	for _, p := range percents {
+		key := strconv.FormatFloat(p, 'f', -1, 64)
+		values[key] = 0
+		if ok {
+			values[key] = agg.Values[percentileKey(p)]
+		}
+	}
+	return values
+}
+
+// distributionRowCollectionFromAggregations generates DistributionRowCollection based on query result aggregations.
+func (eDB *ElasticDB) distributionRowCollectionFromAggregations(result *elastic.SearchResult, options AggregateOptions, targetAgg string) (DistributionRowCollection, bool, error) {
+	var drc DistributionRowCollection
+	tags := make(map[string]string)
+
+	err := eDB.UnwrapAggregation(result.Hits.TotalHits, result.Aggregations, options.GroupBy, tags, func(tags map[string]string, count int64, aggregations elastic.Aggregations) error {
+		var histogram []DistributionHistogramItem
+		if options.TimeHistogram != nil {
+			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
+			if !ok && aggregations != nil {
+				return errors.New("missing expected histogram aggregation data")
+			}
+			if histogramData != nil {
+				for _, histogramItem := range histogramData.Buckets {
+					histogram = append(histogram, DistributionHistogramItem{
+						Time:    time.Unix(0, int64(histogramItem.Key)*int64(time.Millisecond)).UTC(),
+						Buckets: distributionBuckets(histogramItem.Aggregations, targetAgg),
+					})
+				}
+			}
+		}
+
+		drcTags := make(map[string]string)
+		// copy tags to avoid memory sharing
+		for key, val := range tags {
+			drcTags[key] = val
+		}
+
+		drc = append(drc, DistributionRow{
+			Tags:      drcTags,
+			Count:     int(count),
+			Buckets:   distributionBuckets(aggregations, targetAgg),
+			Histogram: histogram,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok := len(drc) > 0
+	return drc, ok, nil
+}
+
+// distributionBuckets extracts buckets of values from the histogram aggregation.
+func distributionBuckets(aggregations elastic.Aggregations, targetAgg string) []DistributionBucket {
+	buckets := []DistributionBucket{}
+	agg, ok := aggregations.Histogram(targetAgg)
+	if !ok {
+		return buckets
+	}
+	for _, b := range agg.Buckets {
+		buckets = append(buckets, DistributionBucket{
+			From:  b.Key,
+			Count: int(b.DocCount),
+		})
+	}
+	return buckets
+}
+
 // uniqueRowCollectionFromAggregations generates CountRowCollection based on query result aggregations.
 func (eDB *ElasticDB) uniqueRowCollectionFromAggregations(result *elastic.SearchResult, options AggregateOptions, targetAgg string, uniqueField string) (CountRowCollection, bool, error) {
 	var src CountRowCollection
@@ -315,13 +728,20 @@ func (eDB *ElasticDB) uniqueRowCollectionFromAggregations(result *elastic.Search
 		if options.TimeHistogram != nil {
 			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
 			if !ok {
+				if aggregations == nil {
+					// bucket without aggregated data (e.g. other bucket of top groups)
+					return nil
+				}
 				return errors.New("missing expected histogram aggregation data")
 			}
 
 			if histogramData != nil {
 				for _, histogramItem := range histogramData.Buckets {
 					uniqueAggLabel := fmt.Sprintf("%s_unique", uniqueField)
-					agg, ok := histogramItem.Aggregations.Cardinality(uniqueAggLabel)
+					value, ok, err := uniqueValue(histogramItem.Aggregations, uniqueAggLabel, options.UniqueExact)
+					if err != nil {
+						return err
+					}
 					if !ok {
 						return errors.New("Unable to retrieve cardinality value from histogram data")
 					}
@@ -329,19 +749,19 @@ func (eDB *ElasticDB) uniqueRowCollectionFromAggregations(result *elastic.Search
 					time := time.Unix(0, int64(histogramItem.Key)*int64(time.Millisecond)).UTC()
 					histogram = append(histogram, HistogramItem{
 						Time:  time,
-						Value: float64(*agg.Value),
+						Value: value,
 					})
 				}
 			}
 		} else {
-			avgAgg, ok := aggregations.Cardinality(targetAgg)
+			value, ok, err := uniqueValue(aggregations, targetAgg, options.UniqueExact)
+			if err != nil {
+				return err
+			}
 			if !ok {
 				return nil
 			}
-
-			if avgAgg.Value != nil {
-				countValue = *avgAgg.Value
-			}
+			countValue = value
 		}
 
 		srcTags := make(map[string]string)
@@ -367,9 +787,170 @@ func (eDB *ElasticDB) uniqueRowCollectionFromAggregations(result *elastic.Search
 	return src, ok, nil
 }
 
+// unique returns unique count of values of provided field within records matching the filter defined by AggregateOptions.
+func (eDB *ElasticDB) unique(index, field string, options AggregateOptions) (CountRowCollection, bool, error) {
+	extras := make(map[string]elastic.Aggregation)
+	targetAgg := fmt.Sprintf("%s_unique", field)
+	extras[targetAgg] = eDB.uniqueAggregation(field, options)
+
+	search := eDB.Client.Search().
+		Index(index).
+		Type("_doc").
+		Size(0) // return no specific results
+
+	search, err := eDB.addSearchFilters(search, index, options)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var dateHistogramAgg *elastic.DateHistogramAggregation
+	if options.TimeHistogram != nil {
+		dateHistogramAgg = elastic.NewDateHistogramAggregation().
+			Field("time").
+			Interval(options.TimeHistogram.Interval).
+			TimeZone("UTC").
+			Offset(options.TimeHistogram.Offset)
+	}
+
+	search, err = eDB.addGroupBy(search, index, options, extras, dateHistogramAgg)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// get results
+	result, err := search.Do(eDB.Context)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return eDB.uniqueRowCollectionFromAggregations(result, options, targetAgg, field)
+}
+
+// uniqueAggregation returns aggregation counting unique values of the field based on the precision options.
+func (eDB *ElasticDB) uniqueAggregation(field string, options AggregateOptions) elastic.Aggregation {
+	if options.UniqueExact {
+		// one more bucket is requested to detect values exceeding the limit
+		return elastic.NewTermsAggregation().Field(field).Size(MaxExactUnique + 1)
+	}
+
+	agg := elastic.NewCardinalityAggregation().Field(field)
+	precision := options.UniquePrecision
+	if precision == 0 {
+		precision = eDB.UniquePrecision
+	}
+	if precision > 0 {
+		agg = agg.PrecisionThreshold(int64(precision))
+	}
+	return agg
+}
+
+// uniqueValue extracts number of unique values from the aggregation created by uniqueAggregation.
+func uniqueValue(aggregations elastic.Aggregations, label string, exact bool) (float64, bool, error) {
+	if exact {
+		agg, ok := aggregations.Terms(label)
+		if !ok {
+			return 0, false, nil
+		}
+		if len(agg.Buckets) > MaxExactUnique || agg.SumOfOtherDocCount > 0 {
+			return 0, false, ErrTooManyUniques
+		}
+		return float64(len(agg.Buckets)), true, nil
+	}
+
+	agg, ok := aggregations.Cardinality(label)
+	if !ok {
+		return 0, false, nil
+	}
+	if agg.Value == nil {
+		return 0, true, nil
+	}
+	return *agg.Value, true, nil
+}
+
+// activity returns number of events and time of the last event for each group of events matching provided options.
+//
+// Groups are loaded page by page via composite aggregation, so the activity can be loaded for any number of groups
+// (e.g. all users) without requesting all the buckets at once.
+func (eDB *ElasticDB) activity(index string, options AggregateOptions) (ActivityRowCollection, bool, error) {
+	targetAgg := "time_last"
+	var arc ActivityRowCollection
+
+	o := options
+	o.Page = &AggregatePage{
+		Size: activityPageSize,
+	}
+	for {
+		search := eDB.Client.Search().
+			Index(index).
+			Type("_doc").
+			Size(0) // return no specific results
+
+		search, err := eDB.addSearchFilters(search, index, o)
+		if err != nil {
+			return nil, false, err
+		}
+
+		lastAgg := elastic.NewMaxAggregation().Field("time")
+		if len(o.GroupBy) == 0 {
+			search = search.Aggregation(targetAgg, lastAgg)
+		} else {
+			search, err = eDB.addCompositeGroupBy(search, index, o, map[string]elastic.Aggregation{targetAgg: lastAgg})
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		// get results
+		result, err := search.Do(eDB.Context)
+		if err != nil {
+			return nil, false, err
+		}
+
+		row := func(tags map[string]string, count int64, aggregations elastic.Aggregations) {
+			maxAgg, ok := aggregations.Max(targetAgg)
+			if !ok || maxAgg.Value == nil {
+				return
+			}
+			arc = append(arc, ActivityRow{
+				Tags:  tags,
+				Count: int(count),
+				Last:  time.Unix(0, int64(*maxAgg.Value)*int64(time.Millisecond)).UTC(),
+			})
+		}
+
+		if len(o.GroupBy) == 0 {
+			row(make(map[string]string), result.Hits.TotalHits, result.Aggregations)
+			break
+		}
+		agg, ok := result.Aggregations.Composite("buckets")
+		if !ok || len(agg.Buckets) == 0 {
+			break
+		}
+		var tags map[string]string
+		for _, bucket := range agg.Buckets {
+			tags, err = compositeBucketTags(bucket)
+			if err != nil {
+				return nil, false, err
+			}
+			row(tags, bucket.DocCount, bucket.Aggregations)
+		}
+		if len(agg.Buckets) < activityPageSize {
+			break
+		}
+		o.Page.After = tags
+	}
+
+	ok := len(arc) > 0
+	return arc, ok, nil
+}
+
 // addCompositeGroupBy creates a composite aggregation. The results are fetchable
 // via countRowCollectionFromCompositeBuckets or sumRowCollectionFromCompositeBuckets.
-func (eDB *ElasticDB) addCompositeGroupBy(search *elastic.SearchService, index string, o AggregateOptions) (*elastic.SearchService, error) {
+//
+// If AggregateOptions.Page is provided, only single page of buckets following the provided key is requested.
+// Extra aggregations are computed within each bucket.
+func (eDB *ElasticDB) addCompositeGroupBy(search *elastic.SearchService, index string, o AggregateOptions,
+	extras map[string]elastic.Aggregation) (*elastic.SearchService, error) {
 	if len(o.GroupBy) > 0 {
 		nestedAgg := elastic.NewCompositeAggregation()
 		for _, g := range o.GroupBy {
@@ -381,59 +962,137 @@ func (eDB *ElasticDB) addCompositeGroupBy(search *elastic.SearchService, index s
 			nestedAgg = nestedAgg.Sources(agg)
 		}
 
+		if o.Page != nil {
+			nestedAgg = nestedAgg.Size(o.Page.Size)
+			if len(o.Page.After) > 0 {
+				after := make(map[string]interface{})
+				for key, val := range o.Page.After {
+					after[key] = val
+				}
+				nestedAgg = nestedAgg.AggregateAfter(after)
+			}
+		}
+		for label, extraAgg := range extras {
+			nestedAgg = nestedAgg.SubAggregation(label, extraAgg)
+		}
+
 		search = search.Aggregation("buckets", nestedAgg)
 	}
 
 	return search, nil
 }
 
+// countPage executes provided search grouped by composite aggregation and returns single page
+// of grouped counts based on AggregateOptions.Page. Next page follows tags of the last returned row.
+// Counts of each row are split into time buckets if TimeHistogram is provided.
+func (eDB *ElasticDB) countPage(search *elastic.SearchService, index string, o AggregateOptions) (CountRowCollection, bool, error) {
+	if len(o.GroupBy) == 0 {
+		return nil, false, errors.New("unable to paginate results without group by tags")
+	}
+	if o.Page.Partitions > 0 {
+		return eDB.countPartition(search, index, o)
+	}
+
+	var extras map[string]elastic.Aggregation
+	if o.TimeHistogram != nil {
+		extras = map[string]elastic.Aggregation{
+			"date_time_histogram": elastic.NewDateHistogramAggregation().
+				Field("time").
+				Interval(o.TimeHistogram.Interval).
+				TimeZone("UTC").
+				Offset(o.TimeHistogram.Offset),
+		}
+	}
+	search, err := eDB.addCompositeGroupBy(search, index, o, extras)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// get results
+	result, err := search.Do(eDB.Context)
+	if err != nil {
+		return nil, false, err
+	}
+
+	agg, ok := result.Aggregations.Composite("buckets")
+	if !ok {
+		return CountRowCollection{}, false, nil
+	}
+	return eDB.countRowCollectionFromCompositeBuckets(agg.Buckets)
+}
+
+// countPartition executes provided search grouped by terms aggregation limited to single partition
+// of the terms based on AggregateOptions.Page. Terms are partitioned by hash of their values.
+func (eDB *ElasticDB) countPartition(search *elastic.SearchService, index string, o AggregateOptions) (CountRowCollection, bool, error) {
+	if len(o.GroupBy) != 1 {
+		return nil, false, errors.New("unable to partition results grouped by multiple tags")
+	}
+	field, err := eDB.resolveKeyword(index, o.GroupBy[0])
+	if err != nil {
+		return nil, false, err
+	}
+	agg := elastic.NewTermsAggregation().
+		Field(field).
+		Size(o.Page.Size).
+		Partition(o.Page.Partition).
+		NumPartitions(o.Page.Partitions).
+		OrderByTermAsc()
+	search = search.Aggregation(o.GroupBy[0], agg)
+
+	result, err := search.Do(eDB.Context)
+	if err != nil {
+		return nil, false, err
+	}
+
+	crc, ok, err := eDB.countRowCollectionFromAggregations(result, o)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	// empty partition is reported as single row without the value of the tag
+	rows := CountRowCollection{}
+	for _, cr := range crc {
+		if cr.Tags[o.GroupBy[0]] != "" {
+			rows = append(rows, cr)
+		}
+	}
+	return rows, true, nil
+}
+
 // countRowCollectionFromCompositeBuckets extracts CountRow data from buckets generated by composite aggregation query.
-func (eDB *ElasticDB) countRowCollectionFromCompositeBuckets(buckets []*elastic.AggregationBucketKeyItem) (CountRowCollection, bool, error) {
+func (eDB *ElasticDB) countRowCollectionFromCompositeBuckets(buckets []*elastic.AggregationBucketCompositeItem) (CountRowCollection, bool, error) {
 	crc := CountRowCollection{}
 
 	for _, bucket := range buckets {
-		keys, ok := bucket.Key.(map[string]interface{}) // composite aggregation has map[string]interface{} bucket keys
-		if !ok {
-			return nil, false, fmt.Errorf("unexpected type of bucket key: %T", bucket.Key)
+		tags, err := compositeBucketTags(bucket)
+		if err != nil {
+			return nil, false, err
 		}
-		tags := make(map[string]string)
-		for key, val := range keys {
-			switch tag := val.(type) {
-			case float64:
-				tags[key] = strconv.FormatFloat(tag, 'f', 0, 64)
-			case string:
-				tags[key] = tag
-			default:
-				return nil, false, fmt.Errorf("unexpected type of aggregation bucket count: %T", val)
+		var histogram []HistogramItem
+		if histogramData, ok := bucket.Aggregations.DateHistogram("date_time_histogram"); ok {
+			for _, hi := range histogramData.Buckets {
+				histogram = append(histogram, HistogramItem{
+					Time:  time.Unix(0, int64(hi.Key)*int64(time.Millisecond)).UTC(),
+					Value: float64(hi.DocCount),
+				})
 			}
 		}
 		crc = append(crc, CountRow{
-			Tags:  tags,
-			Count: int(bucket.DocCount),
+			Tags:      tags,
+			Count:     int(bucket.DocCount),
+			Histogram: histogram,
 		})
 	}
 	return crc, true, nil
 }
 
 // sumRowCollectionFromBuckets extracts SumRow data from buckets generated by composite aggregation query.
-func (eDB *ElasticDB) sumRowCollectionFromCompositeBuckets(buckets []*elastic.AggregationBucketKeyItem) (SumRowCollection, bool, error) {
+func (eDB *ElasticDB) sumRowCollectionFromCompositeBuckets(buckets []*elastic.AggregationBucketCompositeItem) (SumRowCollection, bool, error) {
 	src := SumRowCollection{}
 
 	for _, bucket := range buckets {
-		keys, ok := bucket.Key.(map[string]interface{}) // composite aggregation has map[string]interface{} bucket keys
-		if !ok {
-			return nil, false, fmt.Errorf("unexpected type of bucket key: %T", bucket.Key)
-		}
-		tags := make(map[string]string)
-		for key, val := range keys {
-			switch tag := val.(type) {
-			case float64:
-				tags[key] = strconv.FormatFloat(tag, 'f', 0, 64)
-			case string:
-				tags[key] = tag
-			default:
-				return nil, false, fmt.Errorf("unexpected type of aggregation bucket count: %T", val)
-			}
+		tags, err := compositeBucketTags(bucket)
+		if err != nil {
+			return nil, false, err
 		}
 		src = append(src, SumRow{
 			Tags: tags,
@@ -443,23 +1102,32 @@ func (eDB *ElasticDB) sumRowCollectionFromCompositeBuckets(buckets []*elastic.Ag
 	return src, true, nil
 }
 
+// compositeBucketTags converts key of composite aggregation bucket to string tags.
+func compositeBucketTags(bucket *elastic.AggregationBucketCompositeItem) (map[string]string, error) {
+	tags := make(map[string]string)
+	for key, val := range bucket.Key {
+		switch tag := val.(type) {
+		case float64:
+			tags[key] = strconv.FormatFloat(tag, 'f', 0, 64)
+		case string:
+			tags[key] = tag
+		default:
+			return nil, fmt.Errorf("unexpected type of aggregation bucket count: %T", val)
+		}
+	}
+	return tags, nil
+}
+
 // resolveKeyword checks, whether the index contains ".keyword" field (for exact indexed search) and uses that if possible.
 func (eDB *ElasticDB) resolveKeyword(index, field string) (string, error) {
-	fields, ok := eDB.fieldsCache[index]
-	var err error
-
-	// populate cache for given index if it's empty
-	if !ok {
-		fields, err = eDB.cacheFieldMapping(index)
-		if err != nil {
-			return "", err
-		}
+	fields, err := eDB.fieldMapping(index)
+	if err != nil {
+		return "", err
 	}
 
 	// check if keyword is present among fields
 	keyword := fmt.Sprintf("%s.keyword", field)
-	_, ok = fields[keyword]
-	if !ok {
+	if _, ok := fields[keyword]; !ok {
 		return field, nil
 	}
 	return keyword, nil
@@ -467,15 +1135,9 @@ func (eDB *ElasticDB) resolveKeyword(index, field string) (string, error) {
 
 // resolveKeyword checks, whether the index contains ".keyword" field (for exact indexed search) and uses that if possible.
 func (eDB *ElasticDB) resolveZeroValue(index, field string) (interface{}, error) {
-	fields, ok := eDB.fieldsCache[index]
-	var err error
-
-	// populate cache for given index if it's empty
-	if !ok {
-		fields, err = eDB.cacheFieldMapping(index)
-		if err != nil {
-			return "", err
-		}
+	fields, err := eDB.fieldMapping(index)
+	if err != nil {
+		return "", err
 	}
 
 	// check fields data type
@@ -497,6 +1159,52 @@ func (eDB *ElasticDB) resolveZeroValue(index, field string) (interface{}, error)
 	return nil, nil
 }
 
+// fieldNames returns sorted names of fields of the index. Keyword subfields are not included.
+func (eDB *ElasticDB) fieldNames(index string) ([]string, error) {
+	fields, err := eDB.fieldMapping(index)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for field := range fields {
+		if !strings.HasSuffix(field, ".keyword") {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// fieldMapping returns types of fields of the index keyed by field names, mapping is downloaded on the first use.
+func (eDB *ElasticDB) fieldMapping(index string) (map[string]string, error) {
+	eDB.fieldsMu.RLock()
+	fields, ok := eDB.fieldsCache[index]
+	eDB.fieldsMu.RUnlock()
+	if ok {
+		return fields, nil
+	}
+	return eDB.cacheFieldMapping(index)
+}
+
+// fieldType returns mapping type of the field within the index. Mapping is downloaded again if the field
+// is not cached yet, so fields added to the index since the mapping was cached are found too.
+func (eDB *ElasticDB) fieldType(index, field string) (string, bool, error) {
+	fields, err := eDB.fieldMapping(index)
+	if err != nil {
+		return "", false, err
+	}
+	if typ, ok := fields[field]; ok {
+		return typ, true, nil
+	}
+	fields, err = eDB.cacheFieldMapping(index)
+	if err != nil {
+		return "", false, err
+	}
+	typ, ok := fields[field]
+	return typ, ok, nil
+}
+
 // cacheFieldMapping downloads and caches field mappings for specified index
 func (eDB *ElasticDB) cacheFieldMapping(index string) (map[string]string, error) {
 	result, err := eDB.Client.GetMapping().Index(index).Type("_doc").Do(eDB.Context)
@@ -557,7 +1265,12 @@ func (eDB *ElasticDB) cacheFieldMapping(index string) (map[string]string, error)
 			}
 		}
 	}
+	eDB.fieldsMu.Lock()
+	if eDB.fieldsCache == nil {
+		eDB.fieldsCache = make(map[string]map[string]string)
+	}
 	eDB.fieldsCache[index] = fields
+	eDB.fieldsMu.Unlock()
 	return fields, nil
 }
 
@@ -569,7 +1282,9 @@ func (eDB *ElasticDB) cacheFieldMapping(index string) (map[string]string, error)
 // and elastic didn't allow us to link sum aggregation to the results.
 //
 // Following is a standard wrapping via SubAggregation() endorsed by official docs.
-func (eDB *ElasticDB) WrapAggregation(index string, groupBy []string, search *elastic.SearchService,
+//
+// Grouping by tags with GroupTop provided is limited to the top buckets.
+func (eDB *ElasticDB) WrapAggregation(index string, groupBy []string, tops []GroupTop, search *elastic.SearchService,
 	extras map[string]elastic.Aggregation, dateHistogramAgg *elastic.DateHistogramAggregation, agg *elastic.TermsAggregation) (*elastic.SearchService, *elastic.TermsAggregation, error) {
 
 	// if there is no group by - add only extras aggs
@@ -600,9 +1315,15 @@ func (eDB *ElasticDB) WrapAggregation(index string, groupBy []string, search *el
 		}
 
 		termsAgg := elastic.NewTermsAggregation().Field(keyword).Size(math.MaxInt32).Missing(zeroVal)
+		if top := findGroupTop(tops, field); top != nil {
+			termsAgg, err = eDB.limitTermsAggregation(index, termsAgg, top)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
 
 		if len(groupBy) > 1 {
-			search, termsAgg, err = eDB.WrapAggregation(index, groupBy[1:], search, extras, dateHistogramAgg, termsAgg)
+			search, termsAgg, err = eDB.WrapAggregation(index, groupBy[1:], tops, search, extras, dateHistogramAgg, termsAgg)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -631,6 +1352,39 @@ func (eDB *ElasticDB) WrapAggregation(index string, groupBy []string, search *el
 	return search, nil, nil
 }
 
+// limitTermsAggregation limits the terms aggregation to the top buckets ordered by the metric of GroupTop.
+func (eDB *ElasticDB) limitTermsAggregation(index string, agg *elastic.TermsAggregation, top *GroupTop) (*elastic.TermsAggregation, error) {
+	if top.Size > 0 {
+		agg = agg.Size(top.Size)
+	}
+	if top.Other {
+		agg = agg.Meta(map[string]interface{}{groupOtherMeta: true})
+	}
+
+	var orderAgg elastic.Aggregation
+	switch top.Metric {
+	case "", MetricCount:
+		return agg.OrderByCount(top.Asc), nil
+	case MetricSum:
+		orderAgg = elastic.NewSumAggregation().Field(top.Field)
+	case MetricAvg:
+		orderAgg = elastic.NewAvgAggregation().Field(top.Field)
+	case MetricUnique:
+		// buckets can be ordered only by approximated unique count
+		field, err := eDB.resolveKeyword(index, top.Field)
+		if err != nil {
+			return nil, err
+		}
+		orderAgg = elastic.NewCardinalityAggregation().Field(field)
+	default:
+		return nil, fmt.Errorf("unknown metric ordering top buckets of %s: %s", top.Tag, top.Metric)
+	}
+	if top.Field == "" {
+		return nil, fmt.Errorf("metric %s ordering top buckets of %s requires field", top.Metric, top.Tag)
+	}
+	return agg.SubAggregation(groupTopAgg, orderAgg).OrderByAggregation(groupTopAgg, top.Asc), nil
+}
+
 // UnwrapCallback represents final callback that should be called when all aggregations are unwrapped
 // and the final set of tags and count can be provided
 type UnwrapCallback func(tags map[string]string, docCount int64, aggregations elastic.Aggregations) error
@@ -690,6 +1444,16 @@ func (eDB *ElasticDB) UnwrapAggregation(docCount int64, aggregations elastic.Agg
 				return err
 			}
 		}
+
+		// records not within the top buckets are reported as single bucket without any aggregations
+		if other, _ := agg.Meta[groupOtherMeta].(bool); other && agg.SumOfOtherDocCount > 0 {
+			for _, f := range groupBy {
+				tags[f] = GroupOther
+			}
+			if err := cb(tags, agg.SumOfOtherDocCount, nil); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,233 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+func TestPercentileValues(t *testing.T) {
+	var aggs elastic.Aggregations
+	raw := `{"timespent_percentiles": {"values": {"50.0": 42, "99.9": 610.5}}}`
+	if err := json.Unmarshal([]byte(raw), &aggs); err != nil {
+		t.Fatal(err)
+	}
+
+	values := percentileValues(aggs, "timespent_percentiles", []float64{50, 90, 99.9})
+	expected := map[string]float64{"50": 42, "90": 0, "99.9": 610.5}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("returned percentiles %v, expected %v", values, expected)
+	}
+}
+
+func TestDistributionBuckets(t *testing.T) {
+	var aggs elastic.Aggregations
+	raw := `{"article_progress_distribution": {"buckets": [{"key": 0, "doc_count": 12}, {"key": 0.5, "doc_count": 3}]}}`
+	if err := json.Unmarshal([]byte(raw), &aggs); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets := distributionBuckets(aggs, "article_progress_distribution")
+	expected := []DistributionBucket{{From: 0, Count: 12}, {From: 0.5, Count: 3}}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Errorf("returned buckets %v, expected %v", buckets, expected)
+	}
+	if buckets := distributionBuckets(nil, "article_progress_distribution"); len(buckets) != 0 {
+		t.Errorf("returned buckets %v of missing aggregation", buckets)
+	}
+}
+
+func TestHistogramBucketCount(t *testing.T) {
+	var countTests = []struct {
+		Min, Max, Interval float64
+		Count              float64
+	}{
+		{0, 1, 0.1, 11},
+		{0.25, 0.75, 0.5, 2},
+		{3, 3, 10, 1},
+		{0, 86400, 0.001, 86400001},
+	}
+
+	for _, ct := range countTests {
+		if count := histogramBucketCount(ct.Min, ct.Max, ct.Interval); count != ct.Count {
+			t.Errorf("returned %v buckets between %v and %v by %v, expected %v", count, ct.Min, ct.Max, ct.Interval, ct.Count)
+		}
+	}
+}
+
+func TestCompletionCounts(t *testing.T) {
+	var aggs elastic.Aggregations
+	raw := `{"pageviews": {"value": 40}, "completed": {"doc_count": 55, "pageviews": {"value": 10}}}`
+	if err := json.Unmarshal([]byte(raw), &aggs); err != nil {
+		t.Fatal(err)
+	}
+
+	pageviews, completed := completionCounts(aggs)
+	if pageviews != 40 || completed != 10 {
+		t.Errorf("returned counts %d/%d, expected 40/10", pageviews, completed)
+	}
+	if rate := (CompletionRow{Pageviews: pageviews, Completed: completed}).Rate(); rate != 0.25 {
+		t.Errorf("returned rate %v, expected 0.25", rate)
+	}
+	if rate := (CompletionRow{}).Rate(); rate != 0 {
+		t.Errorf("returned rate %v of no pageviews, expected 0", rate)
+	}
+}
+
+func TestUniqueValue(t *testing.T) {
+	var aggs elastic.Aggregations
+	raw := `{
+		"approx": {"value": 1523},
+		"exact": {"sum_other_doc_count": 0, "buckets": [{"key": "a", "doc_count": 3}, {"key": "b", "doc_count": 1}]},
+		"overflow": {"sum_other_doc_count": 12, "buckets": [{"key": "a", "doc_count": 3}]}
+	}`
+	if err := json.Unmarshal([]byte(raw), &aggs); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok, err := uniqueValue(aggs, "approx", false); err != nil || !ok || v != 1523 {
+		t.Errorf("returned approximated count %v (%t, %v), expected 1523", v, ok, err)
+	}
+	if v, ok, err := uniqueValue(aggs, "exact", true); err != nil || !ok || v != 2 {
+		t.Errorf("returned exact count %v (%t, %v), expected 2", v, ok, err)
+	}
+	if _, _, err := uniqueValue(aggs, "overflow", true); err != ErrTooManyUniques {
+		t.Errorf("returned error %v, expected %v", err, ErrTooManyUniques)
+	}
+	if _, ok, _ := uniqueValue(aggs, "missing", false); ok {
+		t.Errorf("returned count of missing aggregation")
+	}
+}
+
+func TestListCursor(t *testing.T) {
+	cursor, err := encodeListCursor([]interface{}{float64(1546300800123), "article-1", "AWgX2hVb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortValues, err := decodeListCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{json.Number("1546300800123"), "article-1", "AWgX2hVb"}
+	if !reflect.DeepEqual(sortValues, expected) {
+		t.Errorf("decoded sort values %v, expected %v", sortValues, expected)
+	}
+
+	if sortValues, err := decodeListCursor(""); err != nil || sortValues != nil {
+		t.Errorf("decoded empty cursor to %v (%v), expected nil", sortValues, err)
+	}
+	for _, cursor := range []string{"not a cursor!", "e30", "W10"} {
+		if _, err := decodeListCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("decoded cursor %q with error %v, expected %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestAddGroupBy_Other(t *testing.T) {
+	eDB := &ElasticDB{}
+	o := AggregateOptions{
+		GroupBy:  []string{"article_id"},
+		GroupTop: []GroupTop{{Tag: "article_id", Size: 10, Other: true}},
+	}
+
+	if _, err := eDB.addGroupBy(nil, "pageviews", o, map[string]elastic.Aggregation{
+		"timespent_sum": elastic.NewSumAggregation().Field("timespent"),
+	}, nil); err != ErrGroupOtherMetrics {
+		t.Errorf("other bucket with metric returned error %v, expected %v", err, ErrGroupOtherMetrics)
+	}
+	if _, err := eDB.addGroupBy(nil, "pageviews", o, nil, elastic.NewDateHistogramAggregation().Field("time")); err != ErrGroupOtherMetrics {
+		t.Errorf("other bucket with time histogram returned error %v, expected %v", err, ErrGroupOtherMetrics)
+	}
+}
+
+func TestListSorters(t *testing.T) {
+	eDB := &ElasticDB{fieldsCache: map[string]map[string]string{
+		"pageviews": {"time": "date", "article_id": "text", "article_id.keyword": "keyword", TagPageviewID: "keyword"},
+	}}
+
+	var sortTests = []struct {
+		Sorts  []ListSort
+		Fields []string
+	}{
+		{nil, []string{"time", TagPageviewID}},
+		{[]ListSort{{Field: "article_id", Desc: true}}, []string{"article_id.keyword", "time", TagPageviewID}},
+		{[]ListSort{{Field: "time", Desc: true}}, []string{"time", TagPageviewID}},
+	}
+
+	for _, st := range sortTests {
+		sorters, err := eDB.listSorters("pageviews", st.Sorts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, s := range sorters {
+			src, err := s.Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for field := range src.(map[string]interface{}) {
+				fields = append(fields, field)
+			}
+		}
+		if !reflect.DeepEqual(fields, st.Fields) {
+			t.Errorf("sorts %v: sorted by %v, expected %v", st.Sorts, fields, st.Fields)
+		}
+	}
+}
+
+func TestGroupCursor(t *testing.T) {
+	groupBy := []string{"article_id", TagPageviewID}
+	tags := map[string]string{"article_id": "article-1", TagPageviewID: "c4ad1b3e"}
+	cursor, err := encodeGroupCursor(tags, groupBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := decodeGroupCursor(cursor, groupBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, tags) {
+		t.Errorf("decoded tags %v, expected %v", after, tags)
+	}
+
+	if after, err := decodeGroupCursor("", groupBy); err != nil || after != nil {
+		t.Errorf("decoded empty cursor to %v (%v), expected nil", after, err)
+	}
+	if _, err := decodeGroupCursor(cursor, []string{TagPageviewID}); err != ErrInvalidCursor {
+		t.Errorf("decoded cursor of other grouping with error %v, expected %v", err, ErrInvalidCursor)
+	}
+	numeric, _ := encodeListCursor([]interface{}{float64(1), "c4ad1b3e"})
+	if _, err := decodeGroupCursor(numeric, groupBy); err != ErrInvalidCursor {
+		t.Errorf("decoded cursor of non-string tags with error %v, expected %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestUnwrapAggregation_Other(t *testing.T) {
+	var aggs elastic.Aggregations
+	raw := `{
+		"article_id": {
+			"sum_other_doc_count": 42,
+			"meta": {"other": true},
+			"buckets": [{"key": "a", "doc_count": 30}, {"key": "b", "doc_count": 20}]
+		}
+	}`
+	if err := json.Unmarshal([]byte(raw), &aggs); err != nil {
+		t.Fatal(err)
+	}
+
+	eDB := &ElasticDB{}
+	counts := make(map[string]int64)
+	err := eDB.UnwrapAggregation(92, aggs, []string{"article_id"}, make(map[string]string), func(tags map[string]string, count int64, aggregations elastic.Aggregations) error {
+		counts[tags["article_id"]] = count
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"a": 30, "b": 20, GroupOther: 42}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("unwrapped counts %v, expected %v", counts, expected)
+	}
+}
@@ -28,6 +28,8 @@ type Event struct {
 	Host      string    `json:"host"`
 	IP        string    `json:"ip"`
 	UserID    string    `json:"user_id"`
+	BrowserID string    `json:"browser_id"`
+	SessionID string    `json:"remp_session_id"`
 	URL       string    `json:"url"`
 	UserAgent string    `json:"user_agent"`
 
@@ -50,14 +52,19 @@ type EventRowCollection []*EventRow
 type EventStorage interface {
 	// Count returns number of events matching the filter defined by EventOptions.
 	Count(o AggregateOptions) (CountRowCollection, bool, error)
-	// List returns list of all events based on given EventOptions.
-	List(o ListOptions) (EventRowCollection, error)
+	// Unique returns unique count of given item based on the provided filter options.
+	Unique(o AggregateOptions, item string) (CountRowCollection, bool, error)
+	// List returns list of events based on given EventOptions and the cursor of the next page
+	// (empty if there are no more events).
+	List(o ListOptions) (EventRowCollection, string, error)
 	// Categories lists all tracked categories.
 	Categories() ([]string, error)
 	// Flags lists all available flags.
 	Flags() []string
 	// Actions lists all tracked actions under the given category.
 	Actions(category string) ([]string, error)
+	// Fields lists all fields of records of the given category.
+	Fields(category string) ([]string, error)
 	// Users lists all tracked users.
 	Users() ([]string, error)
 	// Cache creates internal cache of available categories and actions so they're not polled repeatedly.
@@ -3,7 +3,6 @@ package model
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"reflect"
 	"strconv"
@@ -33,6 +32,10 @@ func (eDB *EventElastic) Count(options AggregateOptions) (CountRowCollection, bo
 		return nil, false, err
 	}
 
+	if options.Page != nil {
+		return eDB.DB.countPage(search, "events", options)
+	}
+
 	var dateHistogramAgg *elastic.DateHistogramAggregation
 
 	if options.TimeHistogram != nil {
@@ -69,47 +72,40 @@ func (eDB *EventElastic) Count(options AggregateOptions) (CountRowCollection, bo
 	return eDB.DB.countRowCollectionFromAggregations(result, options)
 }
 
-// List returns list of all events based on given EventOptions.
-func (eDB *EventElastic) List(options ListOptions) (EventRowCollection, error) {
-	var erc EventRowCollection
-
-	fsc := elastic.NewFetchSourceContext(true).Include(options.SelectFields...)
-	scroll := eDB.DB.Client.Scroll("events").
-		Type("_doc").
-		Size(1000).
-		FetchSourceContext(fsc)
-
-	scroll, err := eDB.DB.addScrollFilters(scroll, "events", options.AggregateOptions)
+// Unique returns unique count of given item within events matching the filter defined by AggregateOptions.
+func (eDB *EventElastic) Unique(options AggregateOptions, item string) (CountRowCollection, bool, error) {
+	tag, err := uniqueItemTag(item)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	field, err := eDB.DB.resolveKeyword("events", tag)
+	if err != nil {
+		return nil, false, err
 	}
+	return eDB.DB.unique("events", field, options)
+}
+
+// List returns list of events based on given EventOptions and the cursor of the next page. All the events
+// are listed if the limit is not set, otherwise events are grouped within the listed page.
+func (eDB *EventElastic) List(options ListOptions) (EventRowCollection, string, error) {
+	var erc EventRowCollection
 
 	// prepare EventRow buckets
 	erBuckets := make(map[string]*EventRow)
 
-	// get results
-	for {
-		results, err := scroll.Do(eDB.DB.Context)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, errors.Wrap(err, "error while reading list data from elastic")
-		}
-
-		// Send the hits to the hits channel
-		for _, hit := range results.Hits.Hits {
+	next, err := eDB.DB.list("events", options.AggregateOptions, options.ListPage, options.SelectFields, func(hits []*elastic.SearchHit) error {
+		for _, hit := range hits {
 			// populate event for collection
 			event := &Event{}
 			if err := json.Unmarshal(*hit.Source, event); err != nil {
-				return nil, errors.Wrap(err, "error reading pageview record from elastic")
+				return errors.Wrap(err, "error reading pageview record from elastic")
 			}
 			event.ID = hit.Id
 
 			// extract raw event data to build tags map
 			rawEvent := make(map[string]interface{})
 			if err := json.Unmarshal(*hit.Source, &rawEvent); err != nil {
-				return nil, errors.Wrap(err, "error reading pageview record from elastic")
+				return errors.Wrap(err, "error reading pageview record from elastic")
 			}
 
 			// we need to get string value for tags by type casting
@@ -133,7 +129,7 @@ func (eDB *EventElastic) List(options ListOptions) (EventRowCollection, error) {
 				case int64:
 					tagVal = strconv.FormatInt(val, 10)
 				default:
-					return nil, fmt.Errorf("unhandled tag type in pageview listing: %T", rawEvent[field])
+					return fmt.Errorf("unhandled tag type in pageview listing: %T", rawEvent[field])
 				}
 
 				tags[field] = fmt.Sprintf("%s", tagVal)
@@ -147,16 +143,22 @@ func (eDB *EventElastic) List(options ListOptions) (EventRowCollection, error) {
 					Tags: tags,
 				}
 				erBuckets[key] = er
+				erc = append(erc, er)
 			}
 			er.Events = append(er.Events, event)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
-	for _, er := range erBuckets {
-		erc = append(erc, er)
-	}
+	return erc, next, nil
+}
 
-	return erc, nil
+// Fields lists all fields of records of the given category.
+func (eDB *EventElastic) Fields(category string) ([]string, error) {
+	return eDB.DB.fieldNames("events")
 }
 
 // Categories lists all tracked categories.
@@ -288,3 +290,8 @@ func (eDB *EventElastic) Cache() error {
 
 	return nil
 }
+
+// QuerySource returns source of the filter query used to count events matching provided options.
+func (eDB *EventElastic) QuerySource(options AggregateOptions) (interface{}, error) {
+	return eDB.DB.querySource("events", options)
+}
@@ -2,8 +2,16 @@ package model
 
 import (
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// ErrPerPageviewOptions is returned if the options can't be applied to the values of single pageviews.
+var ErrPerPageviewOptions = errors.New("time histogram and group top can't be applied to values of single pageviews")
+
+// ErrPerPageviewLimit is returned if the values of single pageviews are requested without the page limit.
+var ErrPerPageviewLimit = errors.New("values of single pageviews can be listed only page by page, limit is required")
+
 // Exported constants for services writing to EventStorage indirectly (e.g. Kafka) and reading from enumerated values.
 const (
 	CategoryPageview        = "pageview"
@@ -12,10 +20,15 @@ const (
 	ActionPageviewProgress  = "progress"
 	UniqueCountBrowsers     = "browsers"
 	UniqueCountUsers        = "users"
+	UniqueCountSessions     = "sessions"
+	UniqueCountPageviews    = "pageviews"
+	UniqueCountArticles     = "articles"
+	UniqueCountAuthors      = "authors"
 	TablePageviews          = "pageviews"
 	TableTimespent          = "pageviews_time_spent"
 	TableProgress           = "pageviews_progress"
 	FlagArticle             = "_article"
+	TagPageviewID           = "remp_pageview_id"
 )
 
 // PageviewOptions represent filter options for pageview-related calls.
@@ -70,6 +83,7 @@ type PageviewRowCollection []*PageviewRow
 // ListPageviewsOptions represents select and filter options for listing of pageviews
 type ListPageviewsOptions struct {
 	AggregateOptions
+	ListPage
 	SelectFields  []string
 	LoadTimespent bool
 }
@@ -82,14 +96,30 @@ type PageviewStorage interface {
 	Sum(o AggregateOptions) (SumRowCollection, bool, error)
 	// Avg returns average of pageviews based on the provided filter options.
 	Avg(o AggregateOptions) (AvgRowCollection, bool, error)
+	// Percentiles returns percentiles of pageview values (time spent, progress) based on the provided filter options.
+	Percentiles(o AggregateOptions, percents []float64) (PercentilesRowCollection, bool, error)
+	// MaxPerPageview returns final (maximal) progress of each pageview based on the provided filter options.
+	// Single page of pageviews is returned with the cursor of the next page, the page limit is required.
+	MaxPerPageview(o AggregateOptions, page ListPage) (MaxRowCollection, string, error)
+	// Completion returns number of pageviews and number of pageviews which reached the progress threshold
+	// based on the provided filter options.
+	Completion(o AggregateOptions, threshold float64) (CompletionRowCollection, bool, error)
+	// Distribution returns distribution of pageview values (time spent, progress) split into buckets
+	// of provided width based on the provided filter options.
+	Distribution(o AggregateOptions, interval float64) (DistributionRowCollection, bool, error)
 	// Unique returns unique count of given item based on the provided filter options.
 	Unique(o AggregateOptions, item string) (CountRowCollection, bool, error)
-	// List returns list of all pageviews based on given PageviewOptions.
-	List(o ListPageviewsOptions) (PageviewRowCollection, error)
+	// Activity returns number of pageviews and time of the last pageview based on the provided filter options.
+	Activity(o AggregateOptions) (ActivityRowCollection, bool, error)
+	// List returns list of pageviews based on given PageviewOptions and the cursor of the next page
+	// (empty if there are no more pageviews).
+	List(o ListPageviewsOptions) (PageviewRowCollection, string, error)
 	// Categories lists all tracked categories.
 	Categories() ([]string, error)
 	// Flags lists all available flags.
 	Flags() []string
 	// Actions lists all tracked actions under the given category.
 	Actions(category string) ([]string, error)
+	// Fields lists all fields of records of the given category.
+	Fields(category string) ([]string, error)
 }
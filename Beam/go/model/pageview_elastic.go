@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"time"
 
 	"github.com/olivere/elastic"
 	"github.com/pkg/errors"
@@ -46,6 +48,10 @@ func (pDB *PageviewElastic) Count(options AggregateOptions) (CountRowCollection,
 		return nil, false, err
 	}
 
+	if options.Page != nil {
+		return pDB.DB.countPage(search, binding.Index, options)
+	}
+
 	var dateHistogramAgg *elastic.DateHistogramAggregation
 	if options.TimeHistogram != nil {
 		dateHistogramAgg = elastic.NewDateHistogramAggregation().
@@ -177,108 +183,348 @@ func (pDB *PageviewElastic) Avg(options AggregateOptions) (AvgRowCollection, boo
 	return pDB.DB.avgRowCollectionFromAggregations(result, options, targetAgg, binding.Field)
 }
 
-// Unique returns unique count of Pageviews records matching the filter defined by AggregateOptions.
-func (pDB *PageviewElastic) Unique(options AggregateOptions, item string) (CountRowCollection, bool, error) {
-	var binding elasticQueryBinding
-	switch item {
-	case UniqueCountBrowsers:
-		binding = elasticQueryBinding{
-			Index: TablePageviews,
-			Field: "browser_id.keyword",
-		}
-	case UniqueCountUsers:
-		binding = elasticQueryBinding{
-			Index: TablePageviews,
-			Field: "user_id.keyword",
+// Percentiles returns percentiles of Timespent/Progress records matching the filter defined by AggregateOptions.
+func (pDB *PageviewElastic) Percentiles(options AggregateOptions, percents []float64) (PercentilesRowCollection, bool, error) {
+	if len(percents) == 0 {
+		percents = DefaultPercents
+	}
+	binding, err := pDB.resolveValueBindings(options.Action)
+	if err != nil {
+		return nil, false, err
+	}
+
+	targetAgg := fmt.Sprintf("%s_percentiles", binding.Field)
+	agg := elastic.NewPercentilesAggregation().Field(binding.Field).Percentiles(percents...)
+	result, err := pDB.aggregateValues(binding.Index, options, map[string]elastic.Aggregation{targetAgg: agg})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pDB.DB.percentilesRowCollectionFromAggregations(result, options, targetAgg, percents)
+}
+
+// Distribution returns number of Timespent/Progress records matching the filter defined by AggregateOptions
+// split into buckets of values of provided width.
+func (pDB *PageviewElastic) Distribution(options AggregateOptions, interval float64) (DistributionRowCollection, bool, error) {
+	if interval <= 0 {
+		return nil, false, fmt.Errorf("invalid interval of distribution buckets: %v", interval)
+	}
+	binding, err := pDB.resolveValueBindings(options.Action)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// histogram returns also empty buckets between the lowest and the highest value, the range of values
+	// is checked beforehand so the number of buckets stays bounded
+	bounds := options
+	bounds.GroupBy = nil
+	bounds.TimeHistogram = nil
+	minAgg := fmt.Sprintf("%s_min", binding.Field)
+	maxAgg := fmt.Sprintf("%s_max", binding.Field)
+	result, err := pDB.aggregateValues(binding.Index, bounds, map[string]elastic.Aggregation{
+		minAgg: elastic.NewMinAggregation().Field(binding.Field),
+		maxAgg: elastic.NewMaxAggregation().Field(binding.Field),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	lowest, okMin := result.Aggregations.Min(minAgg)
+	highest, okMax := result.Aggregations.Max(maxAgg)
+	if okMin && okMax && lowest.Value != nil && highest.Value != nil {
+		if histogramBucketCount(*lowest.Value, *highest.Value, interval) > MaxDistributionBuckets {
+			return nil, false, ErrTooManyBuckets
 		}
-	default:
-		return nil, false, fmt.Errorf("unable to count uniques for item [%s] ", item)
 	}
 
+	targetAgg := fmt.Sprintf("%s_distribution", binding.Field)
+	agg := elastic.NewHistogramAggregation().Field(binding.Field).Interval(interval).MinDocCount(0)
+	result, err = pDB.aggregateValues(binding.Index, options, map[string]elastic.Aggregation{targetAgg: agg})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pDB.DB.distributionRowCollectionFromAggregations(result, options, targetAgg)
+}
+
+// histogramBucketCount returns number of histogram buckets of provided width covering values between min and max.
+func histogramBucketCount(min, max, interval float64) float64 {
+	return math.Floor(max/interval) - math.Floor(min/interval) + 1
+}
+
+// MaxPerPageview returns final progress of each pageview matching the filter defined by AggregateOptions.
+// Pageviews are identified by remp_pageview_id tag which is always appended to the grouping.
+//
+// Pageviews are paged through by composite aggregation ordered by the grouping tags. Single page limited
+// by the required page limit is returned together with the cursor of the next page (empty if there are no
+// more pageviews).
+func (pDB *PageviewElastic) MaxPerPageview(options AggregateOptions, page ListPage) (MaxRowCollection, string, error) {
+	if options.TimeHistogram != nil || len(options.GroupTop) > 0 {
+		return nil, "", ErrPerPageviewOptions
+	}
+	if page.Limit <= 0 {
+		return nil, "", ErrPerPageviewLimit
+	}
+	binding, err := pDB.resolveQueryBindings(ActionPageviewProgress)
+	if err != nil {
+		return nil, "", err
+	}
+
+	groupBy := make([]string, 0, len(options.GroupBy)+1)
+	for _, g := range options.GroupBy {
+		if g != TagPageviewID {
+			groupBy = append(groupBy, g)
+		}
+	}
+	options.GroupBy = append(groupBy, TagPageviewID)
 	// action is not being tracked within separate measurements and we would get no records back
-	// removing it before applying filter
 	options.Action = ""
 
-	extras := make(map[string]elastic.Aggregation)
-	targetAgg := fmt.Sprintf("%s_unique", binding.Field)
-	extras[targetAgg] = elastic.NewCardinalityAggregation().Field(binding.Field)
+	after, err := decodeGroupCursor(page.Cursor, options.GroupBy)
+	if err != nil {
+		return nil, "", err
+	}
+	options.Page = &AggregatePage{
+		Size:  page.Limit,
+		After: after,
+	}
+
+	targetAgg := fmt.Sprintf("%s_max", binding.Field)
+	extras := map[string]elastic.Aggregation{
+		targetAgg: elastic.NewMaxAggregation().Field(binding.Field),
+	}
 
 	search := pDB.DB.Client.Search().
 		Index(binding.Index).
 		Type("_doc").
 		Size(0) // return no specific results
 
-	search, err := pDB.DB.addSearchFilters(search, binding.Index, options)
+	search, err = pDB.DB.addSearchFilters(search, binding.Index, options)
+	if err != nil {
+		return nil, "", err
+	}
+	search, err = pDB.DB.addCompositeGroupBy(search, binding.Index, options, extras)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := search.Do(pDB.DB.Context)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mrc := MaxRowCollection{}
+	agg, ok := result.Aggregations.Composite("buckets")
+	if !ok || len(agg.Buckets) == 0 {
+		return mrc, "", nil
+	}
+	var tags map[string]string
+	for _, bucket := range agg.Buckets {
+		tags, err = compositeBucketTags(bucket)
+		if err != nil {
+			return nil, "", err
+		}
+		progress, ok := bucket.Aggregations.Max(targetAgg)
+		if !ok || progress.Value == nil {
+			continue
+		}
+		mrc = append(mrc, MaxRow{
+			Tags: tags,
+			Max:  *progress.Value,
+		})
+	}
+
+	if len(agg.Buckets) < options.Page.Size {
+		return mrc, "", nil
+	}
+	next, err := encodeGroupCursor(tags, options.GroupBy)
+	return mrc, next, err
+}
+
+// Completion returns number of pageviews matching the filter defined by AggregateOptions and number of those
+// which reached the progress threshold. Pageview reached the threshold if any of its progress records did.
+// Pageviews are counted by cardinality aggregation of remp_pageview_id tag, therefore the counts are approximate.
+func (pDB *PageviewElastic) Completion(options AggregateOptions, threshold float64) (CompletionRowCollection, bool, error) {
+	binding, err := pDB.resolveQueryBindings(ActionPageviewProgress)
+	if err != nil {
+		return nil, false, err
+	}
+	field, err := pDB.DB.resolveKeyword(binding.Index, TagPageviewID)
 	if err != nil {
 		return nil, false, err
 	}
 
-	var dateHistogramAgg *elastic.DateHistogramAggregation
+	aggs := map[string]elastic.Aggregation{
+		"pageviews": elastic.NewCardinalityAggregation().Field(field),
+		"completed": elastic.NewFilterAggregation().
+			Filter(elastic.NewRangeQuery(binding.Field).Gte(threshold)).
+			SubAggregation("pageviews", elastic.NewCardinalityAggregation().Field(field)),
+	}
+	result, err := pDB.aggregateValues(binding.Index, options, aggs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var crc CompletionRowCollection
+	err = pDB.DB.UnwrapAggregation(result.Hits.TotalHits, result.Aggregations, options.GroupBy, make(map[string]string), func(tags map[string]string, count int64, aggregations elastic.Aggregations) error {
+		cr := CompletionRow{
+			Tags: make(map[string]string),
+		}
+		// copy tags to avoid memory sharing
+		for key, val := range tags {
+			cr.Tags[key] = val
+		}
+		cr.Pageviews, cr.Completed = completionCounts(aggregations)
+
+		if options.TimeHistogram != nil {
+			histogramData, ok := aggregations.DateHistogram("date_time_histogram")
+			if !ok && aggregations != nil {
+				return errors.New("missing expected histogram aggregation data")
+			}
+			if histogramData != nil {
+				for _, histogramItem := range histogramData.Buckets {
+					chi := CompletionHistogramItem{
+						Time: time.Unix(0, int64(histogramItem.Key)*int64(time.Millisecond)).UTC(),
+					}
+					chi.Pageviews, chi.Completed = completionCounts(histogramItem.Aggregations)
+					cr.Histogram = append(cr.Histogram, chi)
+				}
+			}
+		}
+
+		crc = append(crc, cr)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok := len(crc) > 0
+	return crc, ok, nil
+}
+
+// completionCounts extracts number of all and completed pageviews from the aggregations.
+func completionCounts(aggregations elastic.Aggregations) (int, int) {
+	var pageviews, completed int
+	if agg, ok := aggregations.Cardinality("pageviews"); ok && agg.Value != nil {
+		pageviews = int(*agg.Value)
+	}
+	if filter, ok := aggregations.Filter("completed"); ok {
+		if agg, ok := filter.Aggregations.Cardinality("pageviews"); ok && agg.Value != nil {
+			completed = int(*agg.Value)
+		}
+	}
+	return pageviews, completed
+}
+
+// resolveValueBindings returns bindings of the action tracking numeric values of pageviews.
+func (pDB *PageviewElastic) resolveValueBindings(action string) (elasticQueryBinding, error) {
+	switch action {
+	case ActionPageviewTimespent, ActionPageviewProgress:
+		return pDB.resolveQueryBindings(action)
+	}
+	return elasticQueryBinding{}, fmt.Errorf("unable to resolve value bindings: action [%s] doesn't track values", action)
+}
+
+// aggregateValues runs provided aggregations over the filtered and grouped records of the index. If time histogram
+// is requested, aggregations are run both for the whole group and for each of the time buckets.
+func (pDB *PageviewElastic) aggregateValues(index string, options AggregateOptions, aggs map[string]elastic.Aggregation) (*elastic.SearchResult, error) {
+	// action is not being tracked within separate measurements and we would get no records back
+	// removing it before applying filter
+	options.Action = ""
+
+	extras := make(map[string]elastic.Aggregation)
+	for label, agg := range aggs {
+		extras[label] = agg
+	}
 	if options.TimeHistogram != nil {
-		dateHistogramAgg = elastic.NewDateHistogramAggregation().
+		dateHistogramAgg := elastic.NewDateHistogramAggregation().
 			Field("time").
 			Interval(options.TimeHistogram.Interval).
 			TimeZone("UTC").
 			Offset(options.TimeHistogram.Offset)
+		for label, agg := range aggs {
+			dateHistogramAgg = dateHistogramAgg.SubAggregation(label, agg)
+		}
+		extras["date_time_histogram"] = dateHistogramAgg
 	}
 
-	search, err = pDB.DB.addGroupBy(search, binding.Index, options, extras, dateHistogramAgg)
+	search := pDB.DB.Client.Search().
+		Index(index).
+		Type("_doc").
+		Size(0) // return no specific results
+
+	search, err := pDB.DB.addSearchFilters(search, index, options)
 	if err != nil {
-		return nil, false, err
+		return nil, err
+	}
+	search, err = pDB.DB.addGroupBy(search, index, options, extras, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	// get results
-	result, err := search.Do(pDB.DB.Context)
+	return search.Do(pDB.DB.Context)
+}
+
+// Unique returns unique count of Pageviews records matching the filter defined by AggregateOptions.
+// If the action is provided, unique items are counted within the records of the action.
+func (pDB *PageviewElastic) Unique(options AggregateOptions, item string) (CountRowCollection, bool, error) {
+	index := TablePageviews
+	if options.Action != "" {
+		binding, err := pDB.resolveQueryBindings(options.Action)
+		if err != nil {
+			return nil, false, err
+		}
+		index = binding.Index
+	}
+
+	tag, err := uniqueItemTag(item)
+	if err != nil {
+		return nil, false, err
+	}
+	field, err := pDB.DB.resolveKeyword(index, tag)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return pDB.DB.uniqueRowCollectionFromAggregations(result, options, targetAgg, binding.Field)
+	// action is not being tracked within separate measurements and we would get no records back
+	// removing it before applying filter
+	options.Action = ""
+
+	return pDB.DB.unique(index, field, options)
 }
 
-// List returns list of all Pageviews based on given PageviewOptions.
-func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowCollection, error) {
-	var prc PageviewRowCollection
+// Activity returns number of pageviews and time of the last pageview based on the provided filter options.
+func (pDB *PageviewElastic) Activity(options AggregateOptions) (ActivityRowCollection, bool, error) {
+	// action is not being tracked within separate measurements and we would get no records back
+	// removing it before applying filter
+	options.Action = ""
 
-	fsc := elastic.NewFetchSourceContext(true).Include(options.SelectFields...)
-	scroll := pDB.DB.Client.Scroll("pageviews").
-		Type("_doc").
-		Size(1000).
-		FetchSourceContext(fsc)
+	return pDB.DB.activity(TablePageviews, options)
+}
 
-	scroll, err := pDB.DB.addScrollFilters(scroll, "pageviews", options.AggregateOptions)
-	if err != nil {
-		return nil, err
-	}
+// List returns list of Pageviews based on given PageviewOptions and the cursor of the next page. All the pageviews
+// are listed if the limit is not set, otherwise pageviews are grouped within the listed page.
+func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowCollection, string, error) {
+	var prc PageviewRowCollection
 
 	// prepare PageviewRow buckets
 	prBuckets := make(map[string]*PageviewRow)
 
 	pageviewIDs := []string{}
 
-	// get results
-	for {
-		results, err := scroll.Do(pDB.DB.Context)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, errors.Wrap(err, "error while reading list data from elastic")
-		}
-
-		// Send the hits to the hits channel
-		for _, hit := range results.Hits.Hits {
+	next, err := pDB.DB.list("pageviews", options.AggregateOptions, options.ListPage, options.SelectFields, func(hits []*elastic.SearchHit) error {
+		for _, hit := range hits {
 			// populate pageview for collection
 			pv := &Pageview{}
 			if err := json.Unmarshal(*hit.Source, pv); err != nil {
-				return nil, errors.Wrap(err, "error reading pageview record from elastic")
+				return errors.Wrap(err, "error reading pageview record from elastic")
 			}
 			pv.ID = hit.Id
 
 			// extract raw pageview data to build tags map
 			rawPv := make(map[string]interface{})
 			if err := json.Unmarshal(*hit.Source, &rawPv); err != nil {
-				return nil, errors.Wrap(err, "error reading pageview record from elastic")
+				return errors.Wrap(err, "error reading pageview record from elastic")
 			}
 
 			// we need to get string value for tags by type casting
@@ -302,7 +548,7 @@ func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowColle
 				case int64:
 					tagVal = strconv.FormatInt(val, 10)
 				default:
-					return nil, fmt.Errorf("unhandled tag type in pageview listing: %T", rawPv[field])
+					return fmt.Errorf("unhandled tag type in pageview listing: %T", rawPv[field])
 				}
 
 				tags[field] = fmt.Sprintf("%s", tagVal)
@@ -316,6 +562,7 @@ func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowColle
 					Tags: tags,
 				}
 				prBuckets[key] = pr
+				prc = append(prc, pr)
 			}
 
 			if pv.ID != "" {
@@ -324,6 +571,10 @@ func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowColle
 
 			pr.Pageviews = append(pr.Pageviews, pv)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Load timespent
@@ -331,21 +582,19 @@ func (pDB *PageviewElastic) List(options ListPageviewsOptions) (PageviewRowColle
 	if len(pageviewIDs) > 0 && options.LoadTimespent {
 		timespentForPageviews, err = loadTimespent(pDB, pageviewIDs)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	}
 
-	for _, pr := range prBuckets {
+	for _, pr := range prc {
 		for _, pv := range pr.Pageviews {
 			if timespent, ok := timespentForPageviews[pv.ID]; ok {
 				pv.Timespent = timespent
 			}
 		}
-
-		prc = append(prc, pr)
 	}
 
-	return prc, nil
+	return prc, next, nil
 }
 
 func loadTimespent(pDB *PageviewElastic, pageviewIDs []string) (map[string]int, error) {
@@ -416,6 +665,15 @@ func (pDB *PageviewElastic) Actions(category string) ([]string, error) {
 	return nil, fmt.Errorf("unknown pageview category: %s", category)
 }
 
+// Fields lists all fields of records of the given category.
+func (pDB *PageviewElastic) Fields(category string) ([]string, error) {
+	switch category {
+	case CategoryPageview:
+		return pDB.DB.fieldNames(TablePageviews)
+	}
+	return nil, fmt.Errorf("unknown pageview category: %s", category)
+}
+
 // Users lists all tracked users.
 func (pDB *PageviewElastic) Users() ([]string, error) {
 	// prepare aggregation
@@ -442,6 +700,16 @@ func (pDB *PageviewElastic) Users() ([]string, error) {
 	return users, nil
 }
 
+// QuerySource returns source of the filter query used to count pageviews matching provided options.
+func (pDB *PageviewElastic) QuerySource(options AggregateOptions) (interface{}, error) {
+	binding, err := pDB.resolveQueryBindings(options.Action)
+	if err != nil {
+		return nil, err
+	}
+	options.Action = ""
+	return pDB.DB.querySource(binding.Index, options)
+}
+
 // resolveQueryBindings returns name of the table and field used within the aggregate function
 // based on the provided action.
 func (pDB *PageviewElastic) resolveQueryBindings(action string) (elasticQueryBinding, error) {
@@ -456,6 +724,11 @@ func (pDB *PageviewElastic) resolveQueryBindings(action string) (elasticQueryBin
 			Index: TableTimespent,
 			Field: "timespent",
 		}, nil
+	case ActionPageviewProgress:
+		return elasticQueryBinding{
+			Index: TableProgress,
+			Field: "article_progress",
+		}, nil
 	}
 	return elasticQueryBinding{}, fmt.Errorf("unable to resolve query bindings: action [%s] unknown", action)
 }
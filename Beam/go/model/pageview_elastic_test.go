@@ -0,0 +1,24 @@
+package model
+
+import "testing"
+
+func TestPageviewElastic_MaxPerPageviewOptions(t *testing.T) {
+	pDB := &PageviewElastic{}
+
+	var optionsTests = []struct {
+		Name    string
+		Options AggregateOptions
+		Page    ListPage
+		Err     error
+	}{
+		{"time histogram", AggregateOptions{TimeHistogram: &TimeHistogram{}}, ListPage{Limit: 10}, ErrPerPageviewOptions},
+		{"group top", AggregateOptions{GroupTop: []GroupTop{{Tag: "article_id", Size: 5}}}, ListPage{Limit: 10}, ErrPerPageviewOptions},
+		{"missing limit", AggregateOptions{}, ListPage{}, ErrPerPageviewLimit},
+	}
+
+	for _, ot := range optionsTests {
+		if _, _, err := pDB.MaxPerPageview(ot.Options, ot.Page); err != ot.Err {
+			t.Errorf("%s: returned error %v, expected %v", ot.Name, err, ot.Err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Data sources and metrics of the unified query.
+const (
+	QuerySourceEvents      = "events"
+	QuerySourceCommerce    = "commerce"
+	QuerySourcePageviews   = "pageviews"
+	QuerySourceTimespent   = "timespent"
+	QuerySourceProgress    = "progress"
+	QuerySourceConcurrents = "concurrents"
+
+	MetricCount       = "count"
+	MetricSum         = "sum"
+	MetricAvg         = "avg"
+	MetricMin         = "min"
+	MetricMax         = "max"
+	MetricUnique      = "unique"
+	MetricPercentiles = "percentiles"
+)
+
+// querySourceIndexes maps data sources to the indexes holding their data.
+var querySourceIndexes = map[string]string{
+	QuerySourceEvents:      TableEvents,
+	QuerySourceCommerce:    TableCommerce,
+	QuerySourcePageviews:   TablePageviews,
+	QuerySourceTimespent:   TableTimespent,
+	QuerySourceProgress:    TableProgress,
+	QuerySourceConcurrents: TableConcurrents,
+}
+
+// DefaultPercents are percentiles computed by MetricPercentiles if none are requested.
+var DefaultPercents = []float64{50, 75, 90, 95, 99}
+
+// queryHistogramAgg is the name of aggregation splitting the metrics to time buckets.
+const queryHistogramAgg = "date_time_histogram"
+
+var metricNamePattern = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+// Errors returned when field of the query can't be used by the data source.
+var (
+	ErrUnknownField     = errors.New("unknown field")
+	ErrInvalidFieldType = errors.New("field of this type can't be used by the metric")
+)
+
+// numericFieldTypes lists mapping types of fields numeric metrics (sum, avg, ...) can be computed of.
+var numericFieldTypes = map[string]bool{
+	"long":         true,
+	"integer":      true,
+	"short":        true,
+	"byte":         true,
+	"double":       true,
+	"float":        true,
+	"half_float":   true,
+	"scaled_float": true,
+}
+
+// QueryStorage is an interface to run analytics queries over any of the journals.
+type QueryStorage interface {
+	// Query returns rows of metrics computed over the data source based on the provided query.
+	Query(q Query) (QueryRowCollection, error)
+}
+
+// Query represents metrics requested over single data source. Embedded AggregateOptions provide filters,
+// grouping and time histogram of the query.
+type Query struct {
+	AggregateOptions
+
+	Source  string
+	Metrics []QueryMetric
+}
+
+// QueryMetric represents single metric computed over the field of the data source.
+type QueryMetric struct {
+	Name     string // name of the metric within the rows, derived from type and field if empty
+	Type     string
+	Field    string    // counted field, count without field counts all the records
+	Percents []float64 // percentiles computed by MetricPercentiles
+}
+
+// QueryRow represents one row of grouped query results. Values are keyed by the metric names, percentiles
+// are keyed by metric name suffixed by the percent (e.g. timespent_p95).
+type QueryRow struct {
+	Tags      map[string]string
+	Count     int
+	Values    map[string]float64
+	Histogram []QueryHistogramItem
+}
+
+// QueryHistogramItem represents values of the metrics within single time bucket.
+type QueryHistogramItem struct {
+	Time   time.Time
+	Count  int
+	Values map[string]float64
+}
+
+// QueryRowCollection represents collection of rows of grouped query results.
+type QueryRowCollection []QueryRow
+
+// Validate checks whether the query can be run.
+func (q Query) Validate() error {
+	if _, ok := querySourceIndexes[q.Source]; !ok {
+		return fmt.Errorf("unknown data source: %s", q.Source)
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("at least one metric has to be requested")
+	}
+
+	names := make(map[string]bool)
+	for _, m := range q.Metrics {
+		switch m.Type {
+		case MetricCount:
+		case MetricSum, MetricAvg, MetricMin, MetricMax, MetricUnique, MetricPercentiles:
+			if m.Field == "" {
+				return fmt.Errorf("metric %s requires field", m.Type)
+			}
+		default:
+			return fmt.Errorf("unknown metric: %s", m.Type)
+		}
+		for _, p := range m.Percents {
+			if p < 0 || p > 100 {
+				return fmt.Errorf("invalid percentile of metric %s: %v", m.name(), p)
+			}
+		}
+
+		name := m.name()
+		if !metricNamePattern.MatchString(name) || name == queryHistogramAgg || name == groupTopAgg {
+			return fmt.Errorf("invalid metric name: %s", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate metric name: %s", name)
+		}
+		names[name] = true
+	}
+
+	for _, top := range q.GroupTop {
+		if top.Metric != "" && top.Metric != MetricCount && top.Field == "" {
+			return fmt.Errorf("metric %s ordering top buckets of %s requires field", top.Metric, top.Tag)
+		}
+	}
+	return nil
+}
+
+// index returns name of the index holding data of the query source.
+func (q Query) index() string {
+	return querySourceIndexes[q.Source]
+}
+
+// name returns name of the metric within the rows.
+func (m QueryMetric) name() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	if m.Field == "" {
+		return m.Type
+	}
+	return fmt.Sprintf("%s_%s", m.Type, strings.Replace(m.Field, ".", "_", -1))
+}
+
+// percents returns percentiles computed by MetricPercentiles metric.
+func (m QueryMetric) percents() []float64 {
+	if len(m.Percents) == 0 {
+		return DefaultPercents
+	}
+	return m.Percents
+}
+
+// percentileName returns name of the single percentile within the rows.
+func (m QueryMetric) percentileName(p float64) string {
+	return fmt.Sprintf("%s_p%s", m.name(), strconv.FormatFloat(p, 'f', -1, 64))
+}
+
+// percentileKey returns key of the percentile within the Elastic response (formatted as Java double).
+func percentileKey(p float64) string {
+	k := strconv.FormatFloat(p, 'f', -1, 64)
+	if !strings.Contains(k, ".") {
+		k += ".0"
+	}
+	return k
+}
@@ -0,0 +1,238 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/pkg/errors"
+)
+
+// QueryElastic is ElasticDB implementation of QueryStorage.
+type QueryElastic struct {
+	DB *ElasticDB
+}
+
+// Query returns rows of metrics computed over the data source based on the provided query.
+//
+// All the metrics are computed within single Elastic request. If time histogram is requested, metrics are
+// computed both for the whole row and for each of the time buckets.
+func (qDB *QueryElastic) Query(q Query) (QueryRowCollection, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	index := q.index()
+
+	extras := make(map[string]elastic.Aggregation)
+	for _, top := range q.GroupTop {
+		if top.Field == "" {
+			continue
+		}
+		if err := qDB.checkMetricField(q.Source, top.Metric, top.Field); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range q.Metrics {
+		if m.Field != "" {
+			if err := qDB.checkMetricField(q.Source, m.Type, m.Field); err != nil {
+				return nil, err
+			}
+		}
+		agg, err := qDB.metricAggregation(index, m, q.AggregateOptions)
+		if err != nil {
+			return nil, err
+		}
+		if agg != nil {
+			extras[m.name()] = agg
+		}
+	}
+
+	// histogram is included as an extra aggregation next to the metrics so the row totals are computed too
+	if q.TimeHistogram != nil {
+		dateHistogramAgg := elastic.NewDateHistogramAggregation().
+			Field("time").
+			Interval(q.TimeHistogram.Interval).
+			TimeZone("UTC").
+			Offset(q.TimeHistogram.Offset)
+		for label, agg := range extras {
+			dateHistogramAgg = dateHistogramAgg.SubAggregation(label, agg)
+		}
+		extras[queryHistogramAgg] = dateHistogramAgg
+	}
+
+	search := qDB.DB.Client.Search().
+		Index(index).
+		Type("_doc").
+		Size(0) // return no specific results
+
+	search, err := qDB.DB.addSearchFilters(search, index, q.AggregateOptions)
+	if err != nil {
+		return nil, err
+	}
+	search, err = qDB.DB.addGroupBy(search, index, q.AggregateOptions, extras, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// get results
+	result, err := search.Do(qDB.DB.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	qrc := QueryRowCollection{}
+	err = qDB.DB.UnwrapAggregation(result.Hits.TotalHits, result.Aggregations, q.GroupBy, make(map[string]string), func(tags map[string]string, count int64, aggregations elastic.Aggregations) error {
+		values, err := q.values(aggregations, count)
+		if err != nil {
+			return err
+		}
+		row := QueryRow{
+			Tags:   make(map[string]string),
+			Count:  int(count),
+			Values: values,
+		}
+		// copy tags to avoid memory sharing
+		for key, val := range tags {
+			row.Tags[key] = val
+		}
+
+		if q.TimeHistogram != nil {
+			histogramData, ok := aggregations.DateHistogram(queryHistogramAgg)
+			if !ok && aggregations != nil {
+				return errors.New("missing expected histogram aggregation data")
+			}
+			if histogramData != nil {
+				for _, bucket := range histogramData.Buckets {
+					values, err := q.values(bucket.Aggregations, bucket.DocCount)
+					if err != nil {
+						return err
+					}
+					row.Histogram = append(row.Histogram, QueryHistogramItem{
+						Time:   time.Unix(0, int64(bucket.Key)*int64(time.Millisecond)).UTC(),
+						Count:  int(bucket.DocCount),
+						Values: values,
+					})
+				}
+			}
+		}
+
+		qrc = append(qrc, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return qrc, nil
+}
+
+// checkMetricField returns ErrUnknownField if the field is not present within the data source and
+// ErrInvalidFieldType if the metric can't be computed over the field of its type (e.g. sum of keyword).
+func (qDB *QueryElastic) checkMetricField(source, metric, field string) error {
+	index := querySourceIndexes[source]
+	typ, ok, err := qDB.DB.fieldType(index, field)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrap(ErrUnknownField, fmt.Sprintf("field [%s] of [%s]", field, source))
+	}
+
+	switch metric {
+	case MetricSum, MetricAvg, MetricMin, MetricMax, MetricPercentiles:
+		if !numericFieldTypes[typ] {
+			return errors.Wrap(ErrInvalidFieldType, fmt.Sprintf("%s of %s field [%s]", metric, typ, field))
+		}
+	case MetricCount, MetricUnique, "":
+		// text fields without keyword subfield can't be aggregated
+		if typ != "text" {
+			return nil
+		}
+		if _, ok, err := qDB.DB.fieldType(index, field+".keyword"); err != nil || ok {
+			return err
+		}
+		return errors.Wrap(ErrInvalidFieldType, fmt.Sprintf("%s of %s field [%s]", metric, typ, field))
+	}
+	return nil
+}
+
+// metricAggregation returns aggregation computing the metric. Count of all the records doesn't need any.
+func (qDB *QueryElastic) metricAggregation(index string, m QueryMetric, options AggregateOptions) (elastic.Aggregation, error) {
+	switch m.Type {
+	case MetricCount:
+		if m.Field == "" {
+			return nil, nil
+		}
+		field, err := qDB.DB.resolveKeyword(index, m.Field)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.NewValueCountAggregation().Field(field), nil
+	case MetricSum:
+		return elastic.NewSumAggregation().Field(m.Field), nil
+	case MetricAvg:
+		return elastic.NewAvgAggregation().Field(m.Field), nil
+	case MetricMin:
+		return elastic.NewMinAggregation().Field(m.Field), nil
+	case MetricMax:
+		return elastic.NewMaxAggregation().Field(m.Field), nil
+	case MetricUnique:
+		field, err := qDB.DB.resolveKeyword(index, m.Field)
+		if err != nil {
+			return nil, err
+		}
+		return qDB.DB.uniqueAggregation(field, options), nil
+	case MetricPercentiles:
+		return elastic.NewPercentilesAggregation().Field(m.Field).Percentiles(m.percents()...), nil
+	}
+	return nil, errors.Errorf("unknown metric: %s", m.Type)
+}
+
+// values extracts values of the query metrics from the aggregations. Metrics without any value
+// (e.g. average of no records) are reported as zero.
+func (q Query) values(aggregations elastic.Aggregations, count int64) (map[string]float64, error) {
+	values := make(map[string]float64)
+	for _, m := range q.Metrics {
+		name := m.name()
+
+		var agg *elastic.AggregationValueMetric
+		var ok bool
+		switch m.Type {
+		case MetricCount:
+			if m.Field == "" {
+				values[name] = float64(count)
+				continue
+			}
+			agg, ok = aggregations.ValueCount(name)
+		case MetricSum:
+			agg, ok = aggregations.Sum(name)
+		case MetricAvg:
+			agg, ok = aggregations.Avg(name)
+		case MetricMin:
+			agg, ok = aggregations.Min(name)
+		case MetricMax:
+			agg, ok = aggregations.Max(name)
+		case MetricUnique:
+			value, _, err := uniqueValue(aggregations, name, q.UniqueExact)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = value
+			continue
+		case MetricPercentiles:
+			pagg, ok := aggregations.Percentiles(name)
+			for _, p := range m.percents() {
+				values[m.percentileName(p)] = 0
+				if ok {
+					values[m.percentileName(p)] = pagg.Values[percentileKey(p)]
+				}
+			}
+			continue
+		}
+
+		values[name] = 0
+		if ok && agg.Value != nil {
+			values[name] = *agg.Value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/olivere/elastic"
+	"github.com/pkg/errors"
+)
+
+func TestQuery_Validate(t *testing.T) {
+	cases := []struct {
+		name      string
+		query     Query
+		expectErr bool
+	}{
+		{
+			name: "valid metrics",
+			query: Query{Source: QuerySourceTimespent, Metrics: []QueryMetric{
+				{Type: MetricCount},
+				{Type: MetricAvg, Field: "timespent"},
+				{Type: MetricPercentiles, Field: "timespent", Percents: []float64{50, 99.9}},
+			}},
+		},
+		{
+			name:      "unknown source",
+			query:     Query{Source: "clicks", Metrics: []QueryMetric{{Type: MetricCount}}},
+			expectErr: true,
+		},
+		{
+			name:      "no metrics",
+			query:     Query{Source: QuerySourcePageviews},
+			expectErr: true,
+		},
+		{
+			name:      "metric without field",
+			query:     Query{Source: QuerySourcePageviews, Metrics: []QueryMetric{{Type: MetricSum}}},
+			expectErr: true,
+		},
+		{
+			name: "duplicate names",
+			query: Query{Source: QuerySourceCommerce, Metrics: []QueryMetric{
+				{Type: MetricSum, Field: "revenue"},
+				{Name: "sum_revenue", Type: MetricMax, Field: "revenue"},
+			}},
+			expectErr: true,
+		},
+		{
+			name:      "invalid percentile",
+			query:     Query{Source: QuerySourceProgress, Metrics: []QueryMetric{{Type: MetricPercentiles, Field: "page_progress", Percents: []float64{120}}}},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		err := c.query.Validate()
+		if c.expectErr && err == nil {
+			t.Errorf("%s: expected error, none returned", c.name)
+		}
+		if !c.expectErr && err != nil {
+			t.Errorf("%s: returned error %s even when none was expected", c.name, err)
+		}
+	}
+}
+
+func TestQueryMetric_Names(t *testing.T) {
+	m := QueryMetric{Type: MetricPercentiles, Field: "article.progress"}
+	if n := m.name(); n != "percentiles_article_progress" {
+		t.Errorf("returned name %q, expected %q", n, "percentiles_article_progress")
+	}
+	if n := m.percentileName(99.9); n != "percentiles_article_progress_p99.9" {
+		t.Errorf("returned percentile name %q, expected %q", n, "percentiles_article_progress_p99.9")
+	}
+	for p, k := range map[float64]string{50: "50.0", 99.9: "99.9", 99.95: "99.95"} {
+		if pk := percentileKey(p); pk != k {
+			t.Errorf("returned percentile key %q, expected %q", pk, k)
+		}
+	}
+}
+
+func TestQueryElastic_CheckMetricField(t *testing.T) {
+	mappingRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mappingRequests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"%s": {"mappings": {"_doc": {"properties": {
+			"value": {"type": "double"},
+			"category": {"type": "keyword"},
+			"title": {"type": "text"},
+			"author": {"type": "text", "fields": {"keyword": {"type": "keyword"}}}
+		}}}}}`, TableEvents)
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qDB := &QueryElastic{DB: NewElasticDB(context.Background(), client, false)}
+
+	cases := []struct {
+		metric   string
+		field    string
+		err      error
+		requests int // total number of mapping requests after the check
+	}{
+		{MetricSum, "value", nil, 1},
+		{MetricCount, "category", nil, 1},
+		{MetricUnique, "author", nil, 1},
+		{MetricAvg, "category", ErrInvalidFieldType, 1},
+		{MetricUnique, "title", ErrInvalidFieldType, 2},
+		{MetricMax, "unknown", ErrUnknownField, 3},
+	}
+	for _, c := range cases {
+		err := qDB.checkMetricField(QuerySourceEvents, c.metric, c.field)
+		if errors.Cause(err) != c.err {
+			t.Errorf("%s of %s: returned error %v, expected %v", c.metric, c.field, err, c.err)
+		}
+		if mappingRequests != c.requests {
+			t.Errorf("%s of %s: mapping requested %d times, expected %d", c.metric, c.field, mappingRequests, c.requests)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Retention periods and cohort definitions.
+const (
+	RetentionPeriodDay   = "day"
+	RetentionPeriodWeek  = "week"
+	RetentionPeriodMonth = "month"
+
+	CohortFirstSeen     = "first_seen"
+	CohortFirstPurchase = "first_purchase"
+)
+
+// retentionPageSize is the number of identities loaded at once.
+const retentionPageSize = 10000
+
+// RetentionStorage is an interface to measure retention of readers.
+type RetentionStorage interface {
+	// Retention returns cohort matrix of users or browsers active within periods following the period
+	// they joined the cohort.
+	Retention(o RetentionOptions) (RetentionRowCollection, error)
+}
+
+// RetentionOptions represent options of retention analysis. Embedded AggregateOptions filter pageviews
+// determining the activity; time range of the options bounds both the cohorts and the activity.
+type RetentionOptions struct {
+	AggregateOptions
+
+	Item    string // UniqueCountUsers or UniqueCountBrowsers
+	Period  string // one of the RetentionPeriod* periods
+	Cohort  string // CohortFirstSeen or CohortFirstPurchase
+	Periods int    // number of periods following the cohort period reported within each row
+}
+
+// RetentionRow represents single cohort of the retention matrix.
+type RetentionRow struct {
+	Cohort  time.Time // start of the period the cohort members were first seen (or first purchased) in
+	Size    int       // number of cohort members
+	Periods []RetentionPeriod
+}
+
+// RetentionPeriod represents activity of the cohort members within single period following the cohort period.
+type RetentionPeriod struct {
+	Period int // number of periods since the cohort period, zero is the cohort period itself
+	Active int
+	Share  float64
+}
+
+// RetentionRowCollection represents retention matrix ordered by cohort periods.
+type RetentionRowCollection []RetentionRow
+
+// Validate checks whether the retention can be computed.
+func (o RetentionOptions) Validate() error {
+	switch o.Item {
+	case UniqueCountUsers, UniqueCountBrowsers:
+	default:
+		return fmt.Errorf("unable to compute retention of %s", o.Item)
+	}
+	switch o.Period {
+	case RetentionPeriodDay, RetentionPeriodWeek, RetentionPeriodMonth:
+	default:
+		return fmt.Errorf("unknown retention period: %s", o.Period)
+	}
+	switch o.Cohort {
+	case CohortFirstSeen, CohortFirstPurchase:
+	default:
+		return fmt.Errorf("unknown cohort: %s", o.Cohort)
+	}
+	if o.Periods < 1 {
+		return errors.New("at least one period has to be reported")
+	}
+	return nil
+}
+
+// RetentionDB represents retention computed from the data of pageview and commerce storages.
+type RetentionDB struct {
+	PageviewStorage PageviewStorage
+	CommerceStorage CommerceStorage
+}
+
+// Retention returns cohort matrix of users or browsers active within periods following the period
+// they joined the cohort.
+//
+// Activity is considered only within the time range of the options. Identities seen (or purchasing)
+// before the range are dropped, so the cohorts contain only identities which joined within the range.
+func (rDB *RetentionDB) Retention(o RetentionOptions) (RetentionRowCollection, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	idTag, _ := uniqueItemTag(o.Item)
+
+	ao := o.AggregateOptions
+	ao.Category = ""
+	ao.Action = ActionPageviewLoad
+	ao.Step = ""
+	ao.GroupBy = []string{idTag}
+	ao.GroupTop = nil
+	ao.Page = nil
+	ao.TimeHistogram = &TimeHistogram{
+		Interval: o.Period,
+	}
+	activity, err := rDB.periods(rDB.PageviewStorage.Count, ao, idTag)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to count pageviews of retention periods")
+	}
+
+	cohorts := make(map[string]time.Time)
+	var seen map[string]bool
+	switch o.Cohort {
+	case CohortFirstSeen:
+		for id, active := range activity {
+			cohorts[id] = active[0]
+		}
+		seen, err = seenBefore(rDB.PageviewStorage.Count, ao, idTag, cohorts)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to count pageviews preceding retention periods")
+		}
+	case CohortFirstPurchase:
+		co := AggregateOptions{
+			Step:          "purchase",
+			TimeAfter:     o.TimeAfter,
+			TimeBefore:    o.TimeBefore,
+			TimeHistogram: ao.TimeHistogram,
+		}
+		purchases, err := rDB.periods(rDB.CommerceStorage.Count, co, idTag)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to count purchases of retention periods")
+		}
+		for id, pp := range purchases {
+			cohorts[id] = pp[0]
+		}
+		seen, err = seenBefore(rDB.CommerceStorage.Count, co, idTag, cohorts)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to count purchases preceding retention periods")
+		}
+	}
+	for id := range seen {
+		delete(cohorts, id)
+	}
+
+	return retentionMatrix(cohorts, activity, o.Period, o.Periods), nil
+}
+
+// periods returns starts of the periods each identity had any record within, ordered by time.
+//
+// Identities are paged through by composite aggregation and the periods are accumulated page by page,
+// so the number of identities isn't limited by the size of single aggregation.
+func (rDB *RetentionDB) periods(count func(AggregateOptions) (CountRowCollection, bool, error), o AggregateOptions, idTag string) (map[string][]time.Time, error) {
+	o.GroupBy = []string{idTag}
+	o.Page = &AggregatePage{
+		Size: retentionPageSize,
+	}
+
+	periods := make(map[string][]time.Time)
+	for {
+		crc, ok, err := count(o)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || len(crc) == 0 {
+			return periods, nil
+		}
+		for _, cr := range crc {
+			id := cr.Tags[idTag]
+			if id == "" {
+				continue
+			}
+			for _, hi := range cr.Histogram {
+				if hi.Value > 0 {
+					periods[id] = append(periods[id], hi.Time.UTC())
+				}
+			}
+			sort.Slice(periods[id], func(i, j int) bool {
+				return periods[id][i].Before(periods[id][j])
+			})
+			if len(periods[id]) == 0 {
+				delete(periods, id)
+			}
+		}
+		if len(crc) < o.Page.Size {
+			return periods, nil
+		}
+		o.Page.After = crc[len(crc)-1].Tags
+	}
+}
+
+// seenBefore returns identities of the cohorts having any record before the time range of the options.
+// Records are searched without any lower bound, page by page of the identities. Nothing is returned
+// if the time range isn't bounded from below.
+func seenBefore(count func(AggregateOptions) (CountRowCollection, bool, error), o AggregateOptions, idTag string, cohorts map[string]time.Time) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	if o.TimeAfter.IsZero() {
+		return seen, nil
+	}
+
+	ids := make([]string, 0, len(cohorts))
+	for id := range cohorts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	po := o
+	po.TimeBefore = o.TimeAfter
+	po.TimeAfter = time.Time{}
+	po.TimeHistogram = nil
+	po.GroupBy = []string{idTag}
+	for len(ids) > 0 {
+		page := ids
+		if len(page) > retentionPageSize {
+			page = ids[:retentionPageSize]
+		}
+		ids = ids[len(page):]
+
+		po.FilterBy = append(append([]*FilterBy{}, o.FilterBy...), &FilterBy{Tag: idTag, Values: page})
+		po.Page = &AggregatePage{
+			Size: len(page),
+		}
+		crc, _, err := count(po)
+		if err != nil {
+			return nil, err
+		}
+		for _, cr := range crc {
+			if cr.Count > 0 && cr.Tags[idTag] != "" {
+				seen[cr.Tags[idTag]] = true
+			}
+		}
+	}
+	return seen, nil
+}
+
+// retentionMatrix counts cohort members active within each of the following periods. Cohorts are keyed by
+// identity, activity contains starts of the periods identity was active within.
+func retentionMatrix(cohorts map[string]time.Time, activity map[string][]time.Time, period string, periods int) RetentionRowCollection {
+	rows := make(map[time.Time]*RetentionRow)
+	for id, cohort := range cohorts {
+		row, ok := rows[cohort]
+		if !ok {
+			row = &RetentionRow{
+				Cohort:  cohort,
+				Periods: make([]RetentionPeriod, periods+1),
+			}
+			for i := range row.Periods {
+				row.Periods[i].Period = i
+			}
+			rows[cohort] = row
+		}
+		row.Size++
+
+		counted := make(map[int]bool)
+		for _, t := range activity[id] {
+			i := periodsBetween(period, cohort, t)
+			if i < 0 || i > periods || counted[i] {
+				continue
+			}
+			counted[i] = true
+			row.Periods[i].Active++
+		}
+	}
+
+	rrc := RetentionRowCollection{}
+	for _, row := range rows {
+		for i := range row.Periods {
+			row.Periods[i].Share = float64(row.Periods[i].Active) / float64(row.Size)
+		}
+		rrc = append(rrc, *row)
+	}
+	sort.Slice(rrc, func(i, j int) bool {
+		return rrc[i].Cohort.Before(rrc[j].Cohort)
+	})
+	return rrc
+}
+
+// periodsBetween returns number of whole periods between starts of two periods.
+func periodsBetween(period string, from, to time.Time) int {
+	switch period {
+	case RetentionPeriodDay:
+		return int(to.Sub(from).Hours() / 24)
+	case RetentionPeriodWeek:
+		return int(to.Sub(from).Hours() / (24 * 7))
+	case RetentionPeriodMonth:
+		return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	}
+	return -1
+}
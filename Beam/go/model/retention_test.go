@@ -0,0 +1,152 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionMatrix(t *testing.T) {
+	jan := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+	apr := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	cohorts := map[string]time.Time{
+		"u1": jan,
+		"u2": jan,
+		"u3": feb,
+	}
+	activity := map[string][]time.Time{
+		"u1": {jan, feb, apr},
+		"u2": {jan, mar},
+		"u3": {feb, mar},
+	}
+
+	expected := RetentionRowCollection{
+		{
+			Cohort: jan,
+			Size:   2,
+			Periods: []RetentionPeriod{
+				{Period: 0, Active: 2, Share: 1},
+				{Period: 1, Active: 1, Share: 0.5},
+				{Period: 2, Active: 1, Share: 0.5},
+			},
+		},
+		{
+			Cohort: feb,
+			Size:   1,
+			Periods: []RetentionPeriod{
+				{Period: 0, Active: 1, Share: 1},
+				{Period: 1, Active: 1, Share: 1},
+				{Period: 2, Active: 0, Share: 0},
+			},
+		},
+	}
+
+	rrc := retentionMatrix(cohorts, activity, RetentionPeriodMonth, 2)
+	if !reflect.DeepEqual(rrc, expected) {
+		t.Errorf("returned matrix %+v, expected %+v", rrc, expected)
+	}
+}
+
+func TestPeriodsBetween(t *testing.T) {
+	from := time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		period   string
+		to       time.Time
+		expected int
+	}{
+		{RetentionPeriodDay, from, 0},
+		{RetentionPeriodDay, from.AddDate(0, 0, 3), 3},
+		{RetentionPeriodWeek, from.AddDate(0, 0, 14), 2},
+		{RetentionPeriodMonth, time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC), 2},
+	}
+	for _, c := range cases {
+		if n := periodsBetween(c.period, from, c.to); n != c.expected {
+			t.Errorf("returned %d %s periods between %s and %s, expected %d", n, c.period, from, c.to, c.expected)
+		}
+	}
+}
+
+func TestRetentionDB_Periods(t *testing.T) {
+	jan := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	// identities are served in pages following the composite aggregation paging contract
+	ids := make([]string, retentionPageSize+2)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("u%06d", i)
+	}
+	var pages int
+	count := func(o AggregateOptions) (CountRowCollection, bool, error) {
+		pages++
+		if o.Page == nil || o.TimeHistogram == nil {
+			t.Fatalf("counted identities without page or histogram: %+v", o)
+		}
+		crc := CountRowCollection{}
+		for _, id := range ids {
+			if id <= o.Page.After["user_id"] {
+				continue
+			}
+			if len(crc) == o.Page.Size {
+				break
+			}
+			crc = append(crc, CountRow{
+				Tags:      map[string]string{"user_id": id},
+				Histogram: []HistogramItem{{Time: feb, Value: 1}, {Time: jan, Value: 2}},
+			})
+		}
+		return crc, true, nil
+	}
+
+	rDB := &RetentionDB{}
+	periods, err := rDB.periods(count, AggregateOptions{TimeHistogram: &TimeHistogram{Interval: RetentionPeriodMonth}}, "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("counted %d pages, expected 2", pages)
+	}
+	if len(periods) != len(ids) {
+		t.Errorf("returned periods of %d identities, expected %d", len(periods), len(ids))
+	}
+	if p := periods[ids[len(ids)-1]]; !reflect.DeepEqual(p, []time.Time{jan, feb}) {
+		t.Errorf("returned periods %v of the last identity, expected ordered %v", p, []time.Time{jan, feb})
+	}
+}
+
+func TestSeenBefore(t *testing.T) {
+	after := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	cohorts := map[string]time.Time{"u1": after, "u2": after, "u3": after}
+	earlier := map[string]bool{"u2": true, "u4": true}
+
+	count := func(o AggregateOptions) (CountRowCollection, bool, error) {
+		if !o.TimeAfter.IsZero() || !o.TimeBefore.Equal(after) {
+			t.Errorf("counted records between %s and %s, expected all before %s", o.TimeAfter, o.TimeBefore, after)
+		}
+		crc := CountRowCollection{}
+		filter := o.FilterBy[len(o.FilterBy)-1]
+		for _, id := range filter.Values {
+			if earlier[id] {
+				crc = append(crc, CountRow{Tags: map[string]string{"user_id": id}, Count: 1})
+			}
+		}
+		return crc, true, nil
+	}
+
+	o := AggregateOptions{TimeAfter: after, TimeBefore: after.AddDate(0, 3, 0)}
+	seen, err := seenBefore(count, o, "user_id", cohorts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seen, map[string]bool{"u2": true}) {
+		t.Errorf("returned identities seen before %v, expected only u2", seen)
+	}
+
+	seen, err = seenBefore(count, AggregateOptions{}, "user_id", cohorts)
+	if err != nil || len(seen) > 0 {
+		t.Errorf("returned identities seen before unbounded range: %v (%v)", seen, err)
+	}
+}
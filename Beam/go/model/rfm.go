@@ -0,0 +1,216 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Enumerated RFM segments based on combination of RFM scores.
+const (
+	RFMChampions     = "champions"
+	RFMLoyal         = "loyal"
+	RFMPotential     = "potential"
+	RFMNew           = "new"
+	RFMNeedAttention = "need_attention"
+	RFMAtRisk        = "at_risk"
+	RFMHibernating   = "hibernating"
+)
+
+// RFMSegments lists all the RFM segments users can be assigned to.
+var RFMSegments = []string{RFMChampions, RFMLoyal, RFMPotential, RFMNew, RFMNeedAttention, RFMAtRisk, RFMHibernating}
+
+// rfmQuantiles is the number of buckets the RFM values are split into; scores are within [1, rfmQuantiles].
+const rfmQuantiles = 5
+
+// RFM represents recency, frequency and monetary scores of single user. Each score is a quintile
+// (1-5) of the user among all active users, higher score always represents better value.
+type RFM struct {
+	UserID     string
+	Recency    int
+	Frequency  int
+	Monetary   int
+	Segment    string
+	LastVisit  time.Time
+	Visits     int
+	Revenue    float64
+	ComputedAt time.Time
+}
+
+// RFMStorage is an interface to get RFM scores of users.
+type RFMStorage interface {
+	// Get returns RFM scores of the user. Scores are computed on the first use if they weren't cached yet.
+	Get(userID string) (*RFM, bool, error)
+	// Users returns sorted identifiers of all scored users. Scores are computed on the first use if they weren't cached yet.
+	Users() ([]string, error)
+	// Cache recomputes RFM scores of all users active within the scoring period.
+	Cache() error
+}
+
+// RFMDB represents RFMStorage implementation computing scores from pageviews and commerce purchases.
+type RFMDB struct {
+	PageviewStorage PageviewStorage
+	CommerceStorage CommerceStorage
+	Period          time.Duration // period of activity (pageviews and purchases) the scores are computed from
+
+	cacheMu sync.Mutex // serializes computations of scores
+	mu      sync.RWMutex
+	scores  map[string]*RFM
+	users   []string
+}
+
+// Get returns RFM scores of the user. Scores are computed on the first use if they weren't cached yet.
+func (rDB *RFMDB) Get(userID string) (*RFM, bool, error) {
+	if err := rDB.computed(); err != nil {
+		return nil, false, err
+	}
+	rDB.mu.RLock()
+	defer rDB.mu.RUnlock()
+
+	rfm, ok := rDB.scores[userID]
+	return rfm, ok, nil
+}
+
+// Users returns sorted identifiers of all scored users. Scores are computed on the first use if they weren't cached yet.
+func (rDB *RFMDB) Users() ([]string, error) {
+	if err := rDB.computed(); err != nil {
+		return nil, err
+	}
+	rDB.mu.RLock()
+	defer rDB.mu.RUnlock()
+	return rDB.users, nil
+}
+
+// computed makes sure the scores were computed. Concurrent callers wait for single computation.
+func (rDB *RFMDB) computed() error {
+	rDB.mu.RLock()
+	ok := rDB.scores != nil
+	rDB.mu.RUnlock()
+	if ok {
+		return nil
+	}
+
+	rDB.cacheMu.Lock()
+	defer rDB.cacheMu.Unlock()
+	rDB.mu.RLock()
+	ok = rDB.scores != nil
+	rDB.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	return rDB.cache()
+}
+
+// Cache recomputes RFM scores of all users active within the scoring period.
+func (rDB *RFMDB) Cache() error {
+	rDB.cacheMu.Lock()
+	defer rDB.cacheMu.Unlock()
+	return rDB.cache()
+}
+
+func (rDB *RFMDB) cache() error {
+	now := time.Now()
+	o := AggregateOptions{
+		Action:    ActionPageviewLoad,
+		GroupBy:   []string{"user_id"},
+		TimeAfter: now.Add(-rDB.Period),
+	}
+
+	arc, _, err := rDB.PageviewStorage.Activity(o)
+	if err != nil {
+		return errors.Wrap(err, "unable to load activity of users for RFM")
+	}
+
+	o.Action = ""
+	o.Step = "purchase"
+	src, _, err := rDB.CommerceStorage.Sum(o)
+	if err != nil {
+		return errors.Wrap(err, "unable to load revenue of users for RFM")
+	}
+	revenues := make(map[string]float64)
+	for _, sr := range src {
+		revenues[sr.Tags["user_id"]] = sr.Sum
+	}
+
+	scores := make(map[string]*RFM)
+	for _, ar := range arc {
+		userID := ar.Tags["user_id"]
+		if userID == "" {
+			continue
+		}
+		scores[userID] = &RFM{
+			UserID:     userID,
+			LastVisit:  ar.Last,
+			Visits:     ar.Count,
+			Revenue:    revenues[userID],
+			ComputedAt: now,
+		}
+	}
+	scoreRFM(scores)
+
+	users := make([]string, 0, len(scores))
+	for userID := range scores {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+
+	rDB.mu.Lock()
+	rDB.scores = scores
+	rDB.users = users
+	rDB.mu.Unlock()
+	return nil
+}
+
+// scoreRFM assigns quintile-based scores and RFM segment to each of provided users.
+func scoreRFM(scores map[string]*RFM) {
+	rfms := make([]*RFM, 0, len(scores))
+	for _, rfm := range scores {
+		rfms = append(rfms, rfm)
+	}
+
+	quantiles(rfms, func(rfm *RFM) float64 { return float64(rfm.LastVisit.Unix()) }, func(rfm *RFM, score int) { rfm.Recency = score })
+	quantiles(rfms, func(rfm *RFM) float64 { return float64(rfm.Visits) }, func(rfm *RFM, score int) { rfm.Frequency = score })
+	quantiles(rfms, func(rfm *RFM) float64 { return rfm.Revenue }, func(rfm *RFM, score int) { rfm.Monetary = score })
+
+	for _, rfm := range rfms {
+		rfm.Segment = rfmSegment(rfm.Recency, rfm.Frequency, rfm.Monetary)
+	}
+}
+
+// quantiles sorts provided items by the value and assigns score based on the quantile the value belongs to.
+// Items with equal values always get the same (lower) score.
+func quantiles(rfms []*RFM, value func(*RFM) float64, assign func(*RFM, int)) {
+	sort.SliceStable(rfms, func(i, j int) bool {
+		return value(rfms[i]) < value(rfms[j])
+	})
+
+	var score int
+	for i, rfm := range rfms {
+		if i == 0 || value(rfm) != value(rfms[i-1]) {
+			score = i*rfmQuantiles/len(rfms) + 1
+		}
+		assign(rfm, score)
+	}
+}
+
+// rfmSegment returns name of RFM segment based on provided scores.
+func rfmSegment(r, f, m int) string {
+	switch {
+	case r >= 4 && f >= 4 && m >= 4:
+		return RFMChampions
+	case r >= 3 && f >= 4:
+		return RFMLoyal
+	case r >= 4 && f <= 1:
+		return RFMNew
+	case r >= 3:
+		return RFMPotential
+	case r <= 2 && f >= 3:
+		return RFMAtRisk
+	case r <= 1 && f <= 2:
+		return RFMHibernating
+	default:
+		return RFMNeedAttention
+	}
+}
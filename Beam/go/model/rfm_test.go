@@ -0,0 +1,105 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScoreRFM(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	scores := make(map[string]*RFM)
+	for i := 0; i < 10; i++ {
+		id := string(rune('a' + i))
+		scores[id] = &RFM{
+			UserID:    id,
+			LastVisit: now.AddDate(0, 0, -i),
+			Visits:    10 - i,
+		}
+	}
+	scores["a"].Revenue = 20
+	scores["b"].Revenue = 10
+
+	scoreRFM(scores)
+
+	var rfmTests = []struct {
+		UserID    string
+		Recency   int
+		Frequency int
+		Monetary  int
+		Segment   string
+	}{
+		{"a", 5, 5, 5, RFMChampions},
+		{"b", 5, 5, 5, RFMChampions},
+		{"c", 4, 4, 1, RFMLoyal},
+		{"f", 3, 3, 1, RFMPotential},
+		{"j", 1, 1, 1, RFMHibernating},
+	}
+
+	for _, rt := range rfmTests {
+		rfm := scores[rt.UserID]
+		if rfm.Recency != rt.Recency || rfm.Frequency != rt.Frequency || rfm.Monetary != rt.Monetary {
+			t.Errorf("returned scores %d%d%d, expected %d%d%d: %v", rfm.Recency, rfm.Frequency, rfm.Monetary,
+				rt.Recency, rt.Frequency, rt.Monetary, rt)
+		}
+		if rfm.Segment != rt.Segment {
+			t.Errorf("returned segment %s, expected %s: %v", rfm.Segment, rt.Segment, rt)
+		}
+	}
+}
+
+// activityPageviewStorage returns activity of two users and counts the calls.
+type activityPageviewStorage struct {
+	PageviewStorage
+	calls int32
+}
+
+func (s *activityPageviewStorage) Activity(o AggregateOptions) (ActivityRowCollection, bool, error) {
+	atomic.AddInt32(&s.calls, 1)
+	now := time.Now()
+	return ActivityRowCollection{
+		{Tags: map[string]string{"user_id": "u1"}, Count: 10, Last: now},
+		{Tags: map[string]string{"user_id": "u2"}, Count: 1, Last: now.AddDate(0, 0, -20)},
+	}, true, nil
+}
+
+// revenueCommerceStorage returns no revenue.
+type revenueCommerceStorage struct {
+	CommerceStorage
+}
+
+func (s *revenueCommerceStorage) Sum(o AggregateOptions) (SumRowCollection, bool, error) {
+	return SumRowCollection{}, false, nil
+}
+
+func TestRFMDB_GetBeforeCache(t *testing.T) {
+	pageviews := &activityPageviewStorage{}
+	rDB := &RFMDB{
+		PageviewStorage: pageviews,
+		CommerceStorage: &revenueCommerceStorage{},
+		Period:          30 * 24 * time.Hour,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rfm, ok, err := rDB.Get("u1")
+			if err != nil || !ok || rfm.UserID != "u1" {
+				t.Errorf("returned scores %v %t %v, expected scores of u1", rfm, ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	users, err := rDB.Users()
+	if err != nil || len(users) != 2 {
+		t.Errorf("returned users %v %v, expected u1 and u2", users, err)
+	}
+	// concurrent first uses share single computation
+	if pageviews.calls != 1 {
+		t.Errorf("scores were computed %d times, expected 1", pageviews.calls)
+	}
+}
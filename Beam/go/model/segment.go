@@ -4,25 +4,51 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	cache "github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 )
 
 const (
+	ruleSegmentType     = "rule"
 	explicitSegmentType = "explicit"
 )
 
+// mysqlDuplicateEntry is the number of MySQL error returned when unique index is violated.
+const mysqlDuplicateEntry = 1062
+
+// Errors returned when segment (or segment group) can't be stored due to the current state of storage.
+var (
+	ErrSegmentCodeConflict  = errors.New("segment code is already used")
+	ErrSegmentModified      = errors.New("segment was modified since provided version")
+	ErrSegmentGroupNotFound = errors.New("segment group doesn't exist")
+)
+
 // SegmentStorage represents interface to get segment related data.
 type SegmentStorage interface {
-	// Create creates new Segment from provided data and returns it.
-	Create(sd SegmentData) (*Segment, error)
-	// Update updates existing Segment from provided data and returns it.
-	Update(id int, sd SegmentData) (*Segment, bool, error)
+	// Create creates new Segment from provided data, stores its first revision and returns it.
+	Create(sd SegmentData, author string) (*Segment, error)
+	// Update updates existing Segment from provided data, stores its new revision and returns it. If version
+	// is provided, the segment is updated only if it wasn't modified since.
+	Update(id int, sd SegmentData, author string, version *int) (*Segment, bool, error)
+	// SetActive activates or deactivates existing segment.
+	SetActive(id int, active bool, version *int) (*Segment, bool, error)
+	// Delete soft-deletes existing segment.
+	Delete(id int, version *int) (bool, error)
+	// Revisions returns all revisions of segment ordered from the oldest one.
+	Revisions(id int) (SegmentRevisionCollection, bool, error)
+	// Revision returns single revision of segment.
+	Revision(id, revision int) (*SegmentRevision, bool, error)
+	// Rollback restores segment to provided revision, stores it as a new revision and returns the segment.
+	Rollback(id, revision int, author string) (*Segment, bool, error)
 	// Get returns instance of Segment based on the given code.
 	Get(code string) (*Segment, bool, error)
 	// GetByID returns instance of Segment based on the given segment ID.
@@ -31,12 +57,50 @@ type SegmentStorage interface {
 	List() (SegmentCollection, error)
 	// Groups returns all available segment groups.
 	Groups() (SegmentGroupCollection, error)
+	// GetGroup returns segment group based on the given ID.
+	GetGroup(id int) (*SegmentGroup, bool, error)
+	// CreateGroup creates new segment group from provided data and returns it.
+	CreateGroup(sg SegmentGroup) (*SegmentGroup, error)
+	// UpdateGroup updates existing segment group from provided data and returns it.
+	UpdateGroup(id int, sg SegmentGroup, version *int) (*SegmentGroup, bool, error)
+	// DeleteGroup deletes existing segment group without any segments.
+	DeleteGroup(id int) (bool, error)
+	// SortGroups sets sorting of segment groups based on the order of provided IDs.
+	SortGroups(ids []int) (SegmentGroupCollection, error)
+	// Members returns page of identifiers of members of explicit segment and cursor of the next page.
+	Members(id int, mt MemberType, cursor string, limit int) ([]string, string, bool, error)
+	// AddMembers adds provided identifiers to members of explicit segment and returns number of added members.
+	AddMembers(id int, mt MemberType, members []string) (int, bool, error)
+	// RemoveMembers removes provided identifiers from members of explicit segment and returns number of removed members.
+	RemoveMembers(id int, mt MemberType, members []string) (int, bool, error)
+	// ReplaceMembers atomically replaces all members of explicit segment and returns number of stored members.
+	ReplaceMembers(id int, mt MemberType, members []string) (int, bool, error)
+	// ImportMembers starts background import of members of explicit segment read from provided input.
+	ImportMembers(id int, mt MemberType, mode, format string, r io.Reader) (*SegmentMemberImport, bool, error)
+	// MemberImport returns current state of member import.
+	MemberImport(importID string) (*SegmentMemberImport, bool)
+	// InvalidateRuleCache removes cached rule counts affected by provided tracked events.
+	InvalidateRuleCache(events []TrackedEvent)
 	// CheckUser verifies presence of user within provided segment.
 	CheckUser(segment *Segment, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, bool, error)
 	// CheckBrowser verifies presence of browser within provided segment.
 	CheckBrowser(segment *Segment, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, bool, error)
+	// ExplainUser verifies presence of user within provided segment and traces evaluation of each rule.
+	ExplainUser(segment *Segment, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, *SegmentExplanation, error)
+	// ExplainBrowser verifies presence of browser within provided segment and traces evaluation of each rule.
+	ExplainBrowser(segment *Segment, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, *SegmentExplanation, error)
+	// CheckUserSegments verifies presence of user within all provided segments.
+	CheckUserSegments(segments SegmentCollection, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, SegmentChecks, error)
+	// CheckBrowserSegments verifies presence of browser within all provided segments.
+	CheckBrowserSegments(segments SegmentCollection, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, SegmentChecks, error)
 	// Users return list of all users within segment.
 	Users(segment *Segment, now time.Time, ro RuleOverrides) ([]string, error)
+	// UsersPage returns single page of users within segment following provided cursor and the cursor of the next page.
+	UsersPage(segment *Segment, now time.Time, ro RuleOverrides, cursor string, limit int) ([]string, string, error)
+	// EstimateUsers returns approximate number of users within segment computed within provided latency budget.
+	EstimateUsers(segment *Segment, now time.Time, ro RuleOverrides, budget time.Duration) (*SegmentEstimate, error)
+	// BrowsersPage returns single page of browsers within segment following provided cursor and the cursor of the next page.
+	BrowsersPage(segment *Segment, now time.Time, ro RuleOverrides, cursor string, limit int) ([]string, string, error)
 	// CountAll returns count of unique tracked users.
 	CountAll() (int, error)
 	// EventRules returns map of rules assigned to given "category/event" key.
@@ -63,18 +127,33 @@ type Flags map[int]map[string]string
 // SegmentCache represents event count information for SegmentRules indexed by SegmentRule ID.
 type SegmentCache map[int]*SegmentRuleCache
 
+// SegmentChecks represents results of segment membership checks indexed by segment code.
+type SegmentChecks map[string]bool
+
 // Segment structure.
 type Segment struct {
 	ID int
 	SegmentData
 
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	Version   int        // incremented on every modification, used for optimistic concurrency
 
 	Group SegmentGroup  `db:"segment_group"`
 	Rules []SegmentRule `db:"segment_rules"`
 }
 
+// withOverrides returns copy of segment with provided RuleOverrides applied to all its rules.
+func (s *Segment) withOverrides(ro RuleOverrides) *Segment {
+	os := *s
+	os.Rules = make([]SegmentRule, 0, len(s.Rules))
+	for _, sr := range s.Rules {
+		os.Rules = append(os.Rules, *sr.applyOverrides(ro))
+	}
+	return &os
+}
+
 // SegmentData contains data of segment
 type SegmentData struct {
 	Name           string
@@ -106,6 +185,7 @@ type SegmentGroup struct {
 	Sorting   int
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
+	Version   int       // incremented on every modification, used for optimistic concurrency
 }
 
 // SegmentGroupCollection is list of SegmentGroups.
@@ -133,18 +213,37 @@ type Intersector func(userID string) bool
 type SegmentDB struct {
 	MySQL                    *sqlx.DB
 	CountCache               *cache.Cache
+	RuleCache                *RuleCountCache // optional cache of rule counts of single users and browsers
 	EventStorage             EventStorage
 	PageviewStorage          PageviewStorage
 	CommerceStorage          CommerceStorage
+	RFMStorage               RFMStorage
 	Segments                 map[string]*Segment
 	ExplicitSegmentsUsers    map[string]UserSet
 	ExplicitSegmentsBrowsers map[string]BrowserSet
+
+	explicitMu       sync.RWMutex // guards ExplicitSegmentsUsers and ExplicitSegmentsBrowsers
+	explicitReloadMu sync.Mutex   // serializes reloads of explicit segments members
+	memberImports    memberImports
 }
 
-// Create creates new Segment from provided data and returns it.
-func (sDB *SegmentDB) Create(sd SegmentData) (*Segment, error) {
-	// TODO: insert & get should be in transaction
-	_, err := sDB.MySQL.NamedExec(`
+// Create creates new Segment from provided data, stores its first revision and returns it.
+func (sDB *SegmentDB) Create(sd SegmentData, author string) (*Segment, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, ok, err := getSegmentGroup(tx, sd.SegmentGroupID, false); err != nil || !ok {
+		if err == nil {
+			err = ErrSegmentGroupNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	res, err := tx.NamedExec(`
 		INSERT INTO segments (name, code, active, segment_group_id, criteria, created_at, updated_at)
 		VALUES (:name, :code, :active, :segment_group_id, :criteria, :created_at, :updated_at)`,
 		map[string]interface{}{
@@ -153,14 +252,27 @@ func (sDB *SegmentDB) Create(sd SegmentData) (*Segment, error) {
 			"active":           sd.Active,
 			"segment_group_id": sd.SegmentGroupID,
 			"criteria":         sd.Criteria,
-			"created_at":       time.Now(),
-			"updated_at":       time.Now(),
+			"created_at":       now,
+			"updated_at":       now,
 		})
 	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, errors.Wrap(ErrSegmentCodeConflict, fmt.Sprintf("code [%s]", sd.Code))
+		}
+		return nil, errors.Wrap(err, "unable to insert segment")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get ID of inserted segment")
+	}
+	if err := storeRevision(tx, int(id), author, nil); err != nil {
 		return nil, err
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "unable to commit transaction")
+	}
 
-	s, ok, err := sDB.Get(sd.Code)
+	s, ok, err := sDB.GetByID(int(id))
 	if err != nil {
 		return nil, err
 	}
@@ -170,32 +282,57 @@ func (sDB *SegmentDB) Create(sd SegmentData) (*Segment, error) {
 	return s, nil
 }
 
-// Update updates existing Segment from provided data and returns it.
-func (sDB *SegmentDB) Update(id int, sd SegmentData) (*Segment, bool, error) {
-	// TODO: get & update & get should be in transaction
+// Update updates existing Segment from provided data, stores its new revision and returns it. Code of segment
+// is changed only if provided. If version is provided, the segment is updated only if it wasn't modified since.
+func (sDB *SegmentDB) Update(id int, sd SegmentData, author string, version *int) (*Segment, bool, error) {
+	return sDB.update(id, sd, author, nil, version)
+}
 
-	// find out if provided ID belongs to some segment
-	_, ok, err := sDB.GetByID(id)
+// update updates existing Segment from provided data and stores its new revision. Revision the segment
+// was rolled back to is recorded within the new revision, if provided.
+func (sDB *SegmentDB) update(id int, sd SegmentData, author string, rollbackOf *int, version *int) (*Segment, bool, error) {
+	tx, err := sDB.MySQL.Beginx()
 	if err != nil {
-		return nil, false, err
+		return nil, false, errors.Wrap(err, "unable to start transaction")
 	}
-	if !ok {
-		return nil, false, nil
+	defer tx.Rollback()
+
+	current, ok, err := lockSegment(tx, id, version)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if sd.Code == "" {
+		sd.Code = current.Code
+	}
+	if sd.SegmentGroupID != current.SegmentGroupID {
+		if _, ok, err := getSegmentGroup(tx, sd.SegmentGroupID, false); err != nil || !ok {
+			if err == nil {
+				err = ErrSegmentGroupNotFound
+			}
+			return nil, false, err
+		}
+	}
+
+	// segments created outside of the API (e.g. Beam admin) have no revision yet
+	if err := storeInitialRevision(tx, current); err != nil {
+		return nil, false, err
 	}
 
-	// update segment
-	_, err = sDB.MySQL.NamedExec(`
+	_, err = tx.NamedExec(`
 		UPDATE segments
 		SET
 			name = :name,
+			code = :code,
 			active = :active,
 			segment_group_id = :segment_group_id,
 			criteria = :criteria,
-			updated_at = :updated_at
+			updated_at = :updated_at,
+			version = version + 1
 		WHERE id = :id
 		`,
 		map[string]interface{}{
 			"name":             sd.Name,
+			"code":             sd.Code,
 			"active":           sd.Active,
 			"segment_group_id": sd.SegmentGroupID,
 			"criteria":         sd.Criteria,
@@ -203,8 +340,17 @@ func (sDB *SegmentDB) Update(id int, sd SegmentData) (*Segment, bool, error) {
 			"id":               id,
 		})
 	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, false, errors.Wrap(ErrSegmentCodeConflict, fmt.Sprintf("code [%s]", sd.Code))
+		}
+		return nil, false, errors.Wrap(err, "unable to update segment")
+	}
+	if err := storeRevision(tx, id, author, rollbackOf); err != nil {
 		return nil, false, err
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, errors.Wrap(err, "unable to commit transaction")
+	}
 
 	// reload segment
 	s, ok, err := sDB.GetByID(id)
@@ -214,9 +360,105 @@ func (sDB *SegmentDB) Update(id int, sd SegmentData) (*Segment, bool, error) {
 	if !ok {
 		return nil, false, errors.New("transaction error: unable to load updated segment")
 	}
+	sDB.refreshCached(id, s)
+	return s, true, nil
+}
+
+// SetActive activates or deactivates existing segment. If version is provided, the segment is updated
+// only if it wasn't modified since.
+func (sDB *SegmentDB) SetActive(id int, active bool, version *int) (*Segment, bool, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, ok, err := lockSegment(tx, id, version); err != nil || !ok {
+		return nil, ok, err
+	}
+	_, err = tx.Exec("UPDATE segments SET active = ?, updated_at = ?, version = version + 1 WHERE id = ?", active, time.Now(), id)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to update segment")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, errors.Wrap(err, "unable to commit transaction")
+	}
+
+	s, ok, err := sDB.GetByID(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, errors.New("transaction error: unable to load updated segment")
+	}
+	sDB.refreshCached(id, s)
+	return s, true, nil
+}
+
+// Delete soft-deletes existing segment. Deleted segment is deactivated and its code stays reserved.
+// If version is provided, the segment is deleted only if it wasn't modified since.
+func (sDB *SegmentDB) Delete(id int, version *int) (bool, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, ok, err := lockSegment(tx, id, version); err != nil || !ok {
+		return ok, err
+	}
+	now := time.Now()
+	_, err = tx.Exec("UPDATE segments SET active = 0, deleted_at = ?, updated_at = ?, version = version + 1 WHERE id = ?", now, now, id)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to delete segment")
+	}
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "unable to commit transaction")
+	}
+	sDB.refreshCached(id, nil)
+	return true, nil
+}
+
+// refreshCached replaces cached version of segment with provided ID (stored under any code) by the provided one,
+// so the change is visible before the next reload of the cache. Only active segments are kept in the cache.
+// The cache is replaced as a whole, the same way Cache does it.
+func (sDB *SegmentDB) refreshCached(id int, s *Segment) {
+	sm := make(map[string]*Segment, len(sDB.Segments))
+	for code, cached := range sDB.Segments {
+		if cached.ID != id {
+			sm[code] = cached
+		}
+	}
+	if s != nil && s.Active {
+		sm[s.Code] = s
+	}
+	sDB.Segments = sm
+}
+
+// lockSegment locks row of existing (not deleted) segment for update within the transaction and returns its
+// current state. If version is provided, ErrSegmentModified is returned if the segment was modified since.
+func lockSegment(tx *sqlx.Tx, id int, version *int) (*Segment, bool, error) {
+	s := &Segment{}
+	err := tx.Get(s, "SELECT * FROM segments WHERE id = ? AND deleted_at IS NULL FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrap(err, fmt.Sprintf("unable to lock segment [%d]", id))
+	}
+	if version != nil && s.Version != *version {
+		return nil, false, errors.Wrap(ErrSegmentModified, fmt.Sprintf("segment [%d] is at version %d", id, s.Version))
+	}
 	return s, true, nil
 }
 
+// isDuplicateEntry returns true if provided error was caused by violation of unique index, e.g. the unique code
+// of segments. Codes of deleted segments stay reserved by the index.
+func isDuplicateEntry(err error) bool {
+	me, ok := errors.Cause(err).(*mysql.MySQLError)
+	return ok && me.Number == mysqlDuplicateEntry
+}
+
 // Get returns instance of Segment based on the given code.
 func (sDB *SegmentDB) Get(code string) (*Segment, bool, error) {
 	p, ok := sDB.Segments[code]
@@ -235,7 +477,7 @@ func (sDB *SegmentDB) Get(code string) (*Segment, bool, error) {
 		segment_groups.sorting AS 'segment_group.sorting'
 	FROM segments
 	JOIN segment_groups ON segments.segment_group_id = segment_groups.id
-	WHERE segments.code = ?
+	WHERE segments.code = ? AND segments.deleted_at IS NULL
 	`, code)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -267,7 +509,7 @@ func (sDB *SegmentDB) GetByID(id int) (*Segment, bool, error) {
 		segment_groups.sorting AS 'segment_group.sorting'
 	FROM segments
 	JOIN segment_groups ON segments.segment_group_id = segment_groups.id
-	WHERE segments.id = ?
+	WHERE segments.id = ? AND segments.deleted_at IS NULL
 	`, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -294,7 +536,8 @@ func (sDB *SegmentDB) List() (SegmentCollection, error) {
 		"segment_groups.code AS 'segment_group.code', "+
 		"segment_groups.type AS 'segment_group.type', "+
 		"segment_groups.sorting AS 'segment_group.sorting' "+
-		"FROM segments JOIN segment_groups ON segments.segment_group_id = segment_groups.id")
+		"FROM segments JOIN segment_groups ON segments.segment_group_id = segment_groups.id "+
+		"WHERE segments.deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -315,39 +558,109 @@ func (sDB *SegmentDB) Groups() (SegmentGroupCollection, error) {
 // CheckUser verifies presence of user within provided segment.
 func (sDB *SegmentDB) CheckUser(segment *Segment, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, bool, error) {
 	if segment.Group.Type == explicitSegmentType {
-		if _, ok := sDB.ExplicitSegmentsUsers[segment.Code]; !ok {
+		if _, ok := sDB.explicitUsers(segment.Code); !ok {
 			// if segment is not present in the cache, reload
 			sDB.CacheExplicitSegments()
 		}
-		segmentUsers, ok := sDB.ExplicitSegmentsUsers[segment.Code]
+		segmentUsers, ok := sDB.explicitUsers(segment.Code)
 		if !ok {
 			return cache, false, nil
 		}
 		_, ok = segmentUsers[userID]
 		return cache, ok, nil
 	}
-	return sDB.check(segment, "user_id", userID, now, cache, ro)
+	return sDB.check(segment, "user_id", userID, now, cache, ro, nil, nil)
 }
 
 // CheckBrowser verifies presence of browser within provided segment.
 func (sDB *SegmentDB) CheckBrowser(segment *Segment, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, bool, error) {
 	if segment.Group.Type == explicitSegmentType {
-		if _, ok := sDB.ExplicitSegmentsBrowsers[segment.Code]; !ok {
+		if _, ok := sDB.explicitBrowsers(segment.Code); !ok {
 			// if segment is not present in the cache, reload
 			sDB.CacheExplicitSegments()
 		}
-		segmentBrowsers, ok := sDB.ExplicitSegmentsBrowsers[segment.Code]
+		segmentBrowsers, ok := sDB.explicitBrowsers(segment.Code)
 		if !ok {
 			return cache, false, nil
 		}
 		_, ok = segmentBrowsers[browserID]
 		return cache, ok, nil
 	}
-	return sDB.check(segment, "browser_id", browserID, now, cache, ro)
+	return sDB.check(segment, "browser_id", browserID, now, cache, ro, nil, nil)
+}
+
+// CheckUserSegments verifies presence of user within all provided segments.
+func (sDB *SegmentDB) CheckUserSegments(segments SegmentCollection, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, SegmentChecks, error) {
+	rc := newRuleCounter()
+	return sDB.checkSegments(segments, cache, func(s *Segment) (SegmentCache, bool, error) {
+		if s.Group.Type == explicitSegmentType {
+			return sDB.CheckUser(s, userID, now, cache, ro)
+		}
+		return sDB.check(s, "user_id", userID, now, cache, ro, rc, nil)
+	})
+}
+
+// CheckBrowserSegments verifies presence of browser within all provided segments.
+func (sDB *SegmentDB) CheckBrowserSegments(segments SegmentCollection, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, SegmentChecks, error) {
+	rc := newRuleCounter()
+	return sDB.checkSegments(segments, cache, func(s *Segment) (SegmentCache, bool, error) {
+		if s.Group.Type == explicitSegmentType {
+			return sDB.CheckBrowser(s, browserID, now, cache, ro)
+		}
+		return sDB.check(s, "browser_id", browserID, now, cache, ro, rc, nil)
+	})
+}
+
+// checkSegments concurrently runs provided checker for each segment and merges the resulting caches.
+func (sDB *SegmentDB) checkSegments(segments SegmentCollection, cache SegmentCache, checker func(s *Segment) (SegmentCache, bool, error)) (SegmentCache, SegmentChecks, error) {
+	type checkResult struct {
+		code  string
+		cache SegmentCache
+		ok    bool
+		err   error
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan checkResult, len(segments))
+	for _, s := range segments {
+		wg.Add(1)
+		go func(s *Segment) {
+			defer wg.Done()
+			c, ok, err := checker(s)
+			results <- checkResult{
+				code:  s.Code,
+				cache: c,
+				ok:    ok,
+				err:   err,
+			}
+		}(s)
+	}
+	wg.Wait()
+	close(results)
+
+	c := make(SegmentCache)
+	for key, val := range cache {
+		c[key] = val
+	}
+	checks := make(SegmentChecks)
+	for r := range results {
+		if r.err != nil {
+			return nil, nil, errors.Wrap(r.err, fmt.Sprintf("unable to check segment [%s]", r.code))
+		}
+		for key, val := range r.cache {
+			c[key] = val
+		}
+		checks[r.code] = r.ok
+	}
+
+	return c, checks, nil
 }
 
 // Check verifies presence of provided tag within segment by its value.
-func (sDB *SegmentDB) check(segment *Segment, tagName, tagValue string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, bool, error) {
+//
+// Counts of rules are memoized by provided ruleCounter, if any, so they can be shared between multiple checks.
+// Evaluation of each rule is traced into provided SegmentExplanation, if any.
+func (sDB *SegmentDB) check(segment *Segment, tagName, tagValue string, now time.Time, cache SegmentCache, ro RuleOverrides, rc *ruleCounter, se *SegmentExplanation) (SegmentCache, bool, error) {
 	c := make(SegmentCache)
 
 	// copy cache to new instance to prevent mutability of original cache
@@ -358,42 +671,57 @@ func (sDB *SegmentDB) check(segment *Segment, tagName, tagValue string, now time
 		}
 	}
 
-	for _, sr := range segment.Rules {
+	for i, sr := range segment.Rules {
 		osr := sr.applyOverrides(ro)
 
 		cacheKey := sr.getCacheKey(ro)
 		src, ok := cache[cacheKey]
+		cached := sr.cacheable() && ok
 
-		// get count
-		var count int
+		// get count (or aggregated value)
+		var value float64
 		var err error
-		if sr.cacheable() && ok {
-			count = src.Count
+		if cached {
+			value = float64(src.Count)
 			// update cache
 			c[cacheKey] = &SegmentRuleCache{
-				Count:    count,
+				Count:    src.Count,
 				SyncedAt: cache[cacheKey].SyncedAt,
 			}
 		} else {
-			count, err = sDB.getRuleEventCount(osr, tagName, tagValue, now, ro)
+			value, err = rc.value(osr.definitionKey(), func() (float64, error) {
+				v, serverCached, err := sDB.cachedRuleValue(osr, tagName, tagValue, now, ro)
+				cached = serverCached
+				return v, err
+			})
 			if err != nil {
 				return nil, false, errors.Wrap(err, "unable to get SegmentRule event count")
 			}
-			// set synced cache
-			c[cacheKey] = &SegmentRuleCache{
-				Count:    count,
-				SyncedAt: now,
+			// set synced cache, aggregated values are not cached as the frontend can't keep track of them
+			if !osr.isValueAggregate() {
+				c[cacheKey] = &SegmentRuleCache{
+					Count:    int(value),
+					SyncedAt: now,
+				}
 			}
 		}
 
 		// evaluate
-		ok, err = osr.Evaluate(count)
+		ok, err = osr.EvaluateValue(value)
 		if err != nil {
 			return nil, false, errors.Wrap(err, "unable to evaluate SegmentRule")
 		}
+		if se != nil {
+			if err := sDB.traceRule(se, osr, tagName, tagValue, now, ro, value, cached, ok); err != nil {
+				return nil, false, err
+			}
+		}
 
 		// if user doesn't match current rule, no need to evaluate further
 		if !ok {
+			if se != nil {
+				se.traceSkipped(segment.Rules[i+1:], ro)
+			}
 			return c, ok, nil
 		}
 	}
@@ -401,37 +729,52 @@ func (sDB *SegmentDB) check(segment *Segment, tagName, tagValue string, now time
 	return c, true, nil
 }
 
-// getRuleEventCount returns real db-based number of events occurred based on provided SegmentRule.
-func (sDB *SegmentDB) getRuleEventCount(sr *SegmentRule, tagName, tagValue string, now time.Time, ro RuleOverrides) (int, error) {
-	options := sr.options(now, ro)
-	options.FilterBy = append(options.FilterBy, &FilterBy{tagName, []string{tagValue}})
-
-	var crc CountRowCollection
-	var ok bool
-	var err error
+// cachedRuleValue returns value of SegmentRule served by RuleCache, if configured, and caches values queried
+// from the storage. Checks evaluated for other than current time bypass the cache.
+func (sDB *SegmentDB) cachedRuleValue(sr *SegmentRule, tagName, tagValue string, now time.Time, ro RuleOverrides) (float64, bool, error) {
+	if sDB.RuleCache == nil || time.Since(now) > time.Minute || time.Until(now) > time.Minute {
+		value, err := sDB.getRuleValue(sr, tagName, tagValue, now, ro)
+		return value, false, err
+	}
+	if value, ok := sDB.RuleCache.Get(sr, tagName, tagValue, now); ok {
+		return value, true, nil
+	}
+	queriedAt := time.Now()
+	value, err := sDB.getRuleValue(sr, tagName, tagValue, now, ro)
+	if err != nil {
+		return 0, false, err
+	}
+	sDB.RuleCache.Set(sr, tagName, tagValue, value, now, queriedAt)
+	return value, false, nil
+}
 
-	switch sr.EventCategory {
-	case CategoryPageview:
-		crc, ok, err = sDB.PageviewStorage.Count(options)
-	case CategoryCommerce:
-		crc, ok, err = sDB.CommerceStorage.Count(options)
-	default:
-		crc, ok, err = sDB.EventStorage.Count(options)
+// InvalidateRuleCache removes cached rule counts affected by provided tracked events.
+func (sDB *SegmentDB) InvalidateRuleCache(events []TrackedEvent) {
+	if sDB.RuleCache == nil {
+		return
 	}
+	sDB.RuleCache.Invalidate(events)
+}
 
+// getRuleValue returns real db-based number of events occurred (or their aggregated value) based on provided SegmentRule.
+func (sDB *SegmentDB) getRuleValue(sr *SegmentRule, tagName, tagValue string, now time.Time, ro RuleOverrides) (float64, error) {
+	options := sr.options(now, ro)
+	options.FilterBy = append(options.FilterBy, &FilterBy{Tag: tagName, Values: []string{tagValue}})
+
+	rvc, ok, err := sDB.storageValues(sr, options)
 	if err != nil {
 		return 0, errors.Wrap(err, "unable to get rule event count")
 	}
-	if !ok {
+	if !ok || len(rvc) == 0 {
 		return 0, nil
 	}
 
 	// result read
-	if len(crc) > 1 {
-		return 0, fmt.Errorf("unexpected result of CountRows returned: %d", len(crc))
+	if len(rvc) > 1 {
+		return 0, fmt.Errorf("unexpected result of CountRows returned: %d", len(rvc))
 	}
 
-	return crc[0].Count, nil
+	return rvc[0].Value, nil
 }
 
 // CountAll returns count of unique tracked users.
@@ -464,11 +807,13 @@ func (sDB *SegmentDB) CountAll() (int, error) {
 func (sDB *SegmentDB) Users(segment *Segment, now time.Time, ro RuleOverrides) ([]string, error) {
 	if segment.Group.Type == explicitSegmentType {
 		uc := []string{}
-		for userID := range sDB.ExplicitSegmentsUsers[segment.Code] {
+		users, _ := sDB.explicitUsers(segment.Code)
+		for userID := range users {
 			uc = append(uc, userID)
 		}
 		return uc, nil
 	}
+	segment = segment.withOverrides(ro)
 
 	users := make(UserSet)
 
@@ -503,19 +848,7 @@ func (sDB *SegmentDB) ruleUsers(sr SegmentRule, now time.Time, ro RuleOverrides,
 
 	um := make(UserSet)
 
-	var crc CountRowCollection
-	var ok bool
-	var err error
-
-	switch sr.EventCategory {
-	case CategoryPageview:
-		crc, ok, err = sDB.PageviewStorage.Count(options)
-	case CategoryCommerce:
-		crc, ok, err = sDB.CommerceStorage.Count(options)
-	default:
-		crc, ok, err = sDB.EventStorage.Count(options)
-	}
-
+	rvc, ok, err := sDB.storageValues(&sr, options)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get rule event count")
 	}
@@ -523,15 +856,15 @@ func (sDB *SegmentDB) ruleUsers(sr SegmentRule, now time.Time, ro RuleOverrides,
 		return um, nil
 	}
 
-	for _, cr := range crc {
-		evalResult, err := sr.Evaluate(cr.Count)
+	for _, rv := range rvc {
+		evalResult, err := sr.EvaluateValue(rv.Value)
 		if err != nil {
 			return nil, err
 		}
 		if !evalResult {
 			continue
 		}
-		userID := cr.Tags["user_id"]
+		userID := rv.Tags["user_id"]
 		if intersect(userID) {
 			um[userID] = true
 		}
@@ -540,12 +873,270 @@ func (sDB *SegmentDB) ruleUsers(sr SegmentRule, now time.Time, ro RuleOverrides,
 	return um, nil
 }
 
+// UsersPage returns single page of users within segment following provided cursor and the cursor of the next page.
+// Empty next cursor indicates there are no more users within the segment.
+func (sDB *SegmentDB) UsersPage(segment *Segment, now time.Time, ro RuleOverrides, cursor string, limit int) ([]string, string, error) {
+	if segment.Group.Type == explicitSegmentType {
+		users, _ := sDB.explicitUsers(segment.Code)
+		return explicitMembersPage(users, cursor, limit)
+	}
+	return sDB.membersPage(segment, "user_id", now, ro, cursor, limit)
+}
+
+// BrowsersPage returns single page of browsers within segment following provided cursor and the cursor of the next page.
+// Empty next cursor indicates there are no more browsers within the segment.
+func (sDB *SegmentDB) BrowsersPage(segment *Segment, now time.Time, ro RuleOverrides, cursor string, limit int) ([]string, string, error) {
+	if segment.Group.Type == explicitSegmentType {
+		browsers, _ := sDB.explicitBrowsers(segment.Code)
+		return explicitMembersPage(browsers, cursor, limit)
+	}
+	return sDB.membersPage(segment, "browser_id", now, ro, cursor, limit)
+}
+
+// membersPage lists members of segment identified by provided tag ordered by their identifier.
+//
+// Candidates are paginated by the first rule of the segment; the rest of the rules are evaluated only
+// against candidates of the current page so the memory footprint doesn't depend on the size of segment.
+func (sDB *SegmentDB) membersPage(segment *Segment, tagName string, now time.Time, ro RuleOverrides, cursor string, limit int) ([]string, string, error) {
+	members := []string{}
+	if len(segment.Rules) == 0 {
+		return members, "", nil
+	}
+	segment = segment.withOverrides(ro)
+
+	for {
+		page := AggregatePage{
+			Size: limit,
+		}
+		if cursor != "" {
+			page.After = map[string]string{
+				tagName: cursor,
+			}
+		}
+		pageMembers, _, next, err := sDB.evaluatePage(segment, 0, tagName, now, ro, page, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		members = append(members, pageMembers...)
+		if len(members) > limit {
+			// the rest of the members is listed by the next page starting after the last returned one
+			members = members[:limit]
+			next = members[limit-1]
+		}
+		if next == "" || len(members) == limit {
+			return members, next, nil
+		}
+		cursor = next
+	}
+}
+
+// evaluatePage loads single page (or partition) of candidates matching the driving rule (identified by its index)
+// and evaluates the rest of the rules against them. It returns members of segment found within the page,
+// number of evaluated candidates and the cursor of the next page (empty if the last page was reached).
+//
+// Candidates without any event matching the other rules are evaluated with zero count, the same
+// way as single member check does. If expired is provided, it's checked before each storage call
+// and ErrEstimateBudget is returned once it reports the budget of evaluation was exhausted.
+func (sDB *SegmentDB) evaluatePage(segment *Segment, driver int, tagName string, now time.Time, ro RuleOverrides, page AggregatePage, expired func() bool) ([]string, int, string, error) {
+	if expired != nil && expired() {
+		return nil, 0, "", ErrEstimateBudget
+	}
+	dsr := segment.Rules[driver]
+	options := dsr.options(now, ro)
+	options.GroupBy = []string{tagName}
+	options.Page = &page
+
+	crc, ok, err := sDB.storageCount(&dsr, options)
+	if err != nil {
+		return nil, 0, "", errors.Wrap(err, "unable to get page of segment candidates")
+	}
+	if !ok || len(crc) == 0 {
+		return []string{}, 0, "", nil
+	}
+
+	candidates := []string{}
+	if dsr.isValueAggregate() {
+		// page lists candidates having any event, their aggregated values need to be loaded separately
+		for _, cr := range crc {
+			candidates = append(candidates, cr.Tags[tagName])
+		}
+		candidates, err = sDB.filterCandidates(dsr, tagName, candidates, now, ro)
+		if err != nil {
+			return nil, 0, "", err
+		}
+	} else {
+		for _, cr := range crc {
+			ok, err := dsr.Evaluate(cr.Count)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			if ok {
+				candidates = append(candidates, cr.Tags[tagName])
+			}
+		}
+	}
+
+	for i, sr := range segment.Rules {
+		if i == driver {
+			continue
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		if expired != nil && expired() {
+			return nil, 0, "", ErrEstimateBudget
+		}
+		candidates, err = sDB.filterCandidates(sr, tagName, candidates, now, ro)
+		if err != nil {
+			return nil, 0, "", err
+		}
+	}
+
+	next := crc[len(crc)-1].Tags[tagName]
+	if len(crc) < page.Size {
+		// last page of candidates reached
+		next = ""
+	}
+	return candidates, len(crc), next, nil
+}
+
+// filterCandidates returns only those of provided candidates which match the given SegmentRule.
+func (sDB *SegmentDB) filterCandidates(sr SegmentRule, tagName string, candidates []string, now time.Time, ro RuleOverrides) ([]string, error) {
+	options := sr.options(now, ro)
+	options.FilterBy = append(options.FilterBy, &FilterBy{Tag: tagName, Values: candidates})
+	options.GroupBy = []string{tagName}
+
+	rvc, _, err := sDB.storageValues(&sr, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get rule event count of segment candidates")
+	}
+	values := make(map[string]float64)
+	for _, rv := range rvc {
+		values[rv.Tags[tagName]] = rv.Value
+	}
+
+	filtered := []string{}
+	for _, c := range candidates {
+		ok, err := sr.EvaluateValue(values[c])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered, nil
+}
+
+// explicitMembersPage returns single page of sorted members of explicit segment following provided cursor.
+func explicitMembersPage(set map[string]bool, cursor string, limit int) ([]string, string, error) {
+	ids := []string{}
+	for id := range set {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) <= limit {
+		return ids, "", nil
+	}
+	return ids[:limit], ids[limit-1], nil
+}
+
+// storageCount returns counts of events from the storage holding events of provided SegmentRule.
+func (sDB *SegmentDB) storageCount(sr *SegmentRule, options AggregateOptions) (CountRowCollection, bool, error) {
+	switch sr.EventCategory {
+	case CategorySequence:
+		// sequence candidates are counted by events of its first step
+		step := sr.Sequence.Steps[0]
+		return sDB.storageCount(step.rule(), step.options(options))
+	case CategoryRFM:
+		return sDB.rfmCount(options)
+	case CategoryPageview:
+		return sDB.PageviewStorage.Count(options)
+	case CategoryCommerce:
+		return sDB.CommerceStorage.Count(options)
+	default:
+		return sDB.EventStorage.Count(options)
+	}
+}
+
+// ruleValueRow represents count of events (or their aggregated value) of single group evaluated by SegmentRule.
+type ruleValueRow struct {
+	Tags  map[string]string
+	Value float64
+}
+
+// storageValues returns counts of events, or their aggregated values in case of value-aggregate rule,
+// from the storage holding events of provided SegmentRule.
+func (sDB *SegmentDB) storageValues(sr *SegmentRule, options AggregateOptions) ([]ruleValueRow, bool, error) {
+	rvc := []ruleValueRow{}
+
+	switch sr.EventCategory {
+	case CategorySequence:
+		return sDB.sequenceValues(sr, options)
+	case CategoryRFM:
+		return sDB.rfmValues(sr, options)
+	}
+
+	switch sr.Aggregate {
+	case AggregateSum:
+		var src SumRowCollection
+		var ok bool
+		var err error
+		switch sr.EventCategory {
+		case CategoryPageview:
+			src, ok, err = sDB.PageviewStorage.Sum(options)
+		case CategoryCommerce:
+			src, ok, err = sDB.CommerceStorage.Sum(options)
+		default:
+			return nil, false, fmt.Errorf("unable to sum values of %s events", sr.EventCategory)
+		}
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		for _, row := range src {
+			rvc = append(rvc, ruleValueRow{Tags: row.Tags, Value: row.Sum})
+		}
+	case AggregateAvg:
+		if sr.EventCategory != CategoryPageview {
+			return nil, false, fmt.Errorf("unable to average values of %s events", sr.EventCategory)
+		}
+		arc, ok, err := sDB.PageviewStorage.Avg(options)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		for _, row := range arc {
+			rvc = append(rvc, ruleValueRow{Tags: row.Tags, Value: row.Avg})
+		}
+	case AggregateDistinct:
+		crc, ok, err := sDB.storageUnique(sr, options, sr.AggregateField)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		for _, row := range crc {
+			rvc = append(rvc, ruleValueRow{Tags: row.Tags, Value: float64(row.Count)})
+		}
+	default:
+		crc, ok, err := sDB.storageCount(sr, options)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		for _, row := range crc {
+			rvc = append(rvc, ruleValueRow{Tags: row.Tags, Value: float64(row.Count)})
+		}
+	}
+
+	return rvc, true, nil
+}
+
 // Cache stores the segments in memory.
 func (sDB *SegmentDB) Cache() error {
 	sm := make(map[string]*Segment)
 	sc := SegmentCollection{}
 
-	err := sDB.MySQL.Select(&sc, "SELECT * FROM segments WHERE active = 1")
+	err := sDB.MySQL.Select(&sc, "SELECT * FROM segments WHERE active = 1 AND deleted_at IS NULL")
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil
@@ -553,15 +1144,27 @@ func (sDB *SegmentDB) Cache() error {
 		return errors.Wrap(err, "unable to cache segments from MySQL")
 	}
 
+	// segment with broken rules can't block caching of the others, previously cached version is kept instead
+	loaded := SegmentCollection{}
 	for _, s := range sc {
 		src, err := sDB.loadSegmentRules(s)
 		if err != nil {
-			return err
+			log.Println(errors.Wrap(err, fmt.Sprintf("unable to load rules of segment [%d], skipping", s.ID)))
+			if cached, ok := sDB.Segments[s.Code]; ok && cached.ID == s.ID {
+				loaded = append(loaded, cached)
+			}
+			continue
 		}
 		s.Rules = src
+		loaded = append(loaded, s)
 	}
+	sc = loaded
 
 	for _, s := range sc {
+		if s.Group.ID != 0 {
+			// group of previously cached segment is already loaded
+			continue
+		}
 		src := SegmentGroup{}
 		err = sDB.MySQL.Get(&src, "SELECT * FROM segment_groups WHERE id = ?", s.SegmentGroupID)
 		if err != nil {
@@ -584,6 +1187,9 @@ func (sDB *SegmentDB) Cache() error {
 
 // CacheExplicitSegments caches segments data in memory
 func (sDB *SegmentDB) CacheExplicitSegments() error {
+	sDB.explicitReloadMu.Lock()
+	defer sDB.explicitReloadMu.Unlock()
+
 	usersSet := make(map[string]UserSet)
 	browsersSet := make(map[string]BrowserSet)
 
@@ -628,11 +1234,29 @@ func (sDB *SegmentDB) CacheExplicitSegments() error {
 			browsersSet[code] = browsers
 		}
 	}
+	sDB.explicitMu.Lock()
 	sDB.ExplicitSegmentsUsers = usersSet
 	sDB.ExplicitSegmentsBrowsers = browsersSet
+	sDB.explicitMu.Unlock()
 	return nil
 }
 
+// explicitUsers returns cached users of explicit segment.
+func (sDB *SegmentDB) explicitUsers(code string) (UserSet, bool) {
+	sDB.explicitMu.RLock()
+	defer sDB.explicitMu.RUnlock()
+	us, ok := sDB.ExplicitSegmentsUsers[code]
+	return us, ok
+}
+
+// explicitBrowsers returns cached browsers of explicit segment.
+func (sDB *SegmentDB) explicitBrowsers(code string) (BrowserSet, bool) {
+	sDB.explicitMu.RLock()
+	defer sDB.explicitMu.RUnlock()
+	bs, ok := sDB.ExplicitSegmentsBrowsers[code]
+	return bs, ok
+}
+
 // EventRules returns map of rules assigned to given "category/event" key
 func (sDB *SegmentDB) EventRules() EventRules {
 	er := make(EventRules)
@@ -673,7 +1297,7 @@ func (sDB *SegmentDB) Flags() Flags {
 // Related compares provided criteria to existing segments and returns segments with same criteria.
 func (sDB *SegmentDB) Related(criteria SegmentCriteria) (SegmentCollection, error) {
 	sc := SegmentCollection{}
-	err := sDB.MySQL.Select(&sc, "SELECT * FROM segments WHERE criteria IS NOT NULL")
+	err := sDB.MySQL.Select(&sc, "SELECT * FROM segments WHERE criteria IS NOT NULL AND deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -9,6 +9,8 @@ import (
 type SegmentBlueprintStorage interface {
 	// Get returns SegmentBlueprintTableCollection.
 	Get() (SegmentBlueprintTableCollection, error)
+	// Validate checks segment criteria against the blueprint and returns all the problems found.
+	Validate(criteria SegmentCriteria) (SegmentCriteriaProblems, error)
 }
 
 // SegmentBlueprintDB represents SegmentBlueprintStorage implementation.
@@ -51,12 +53,14 @@ type SegmentBlueprintTableCriterionParam struct {
 }
 
 // PCEStorage is interface which ensures provided storage (Pageview/Commerce/Event)
-// can return categories and actions of given category.
+// can return categories and actions and fields of given category.
 type PCEStorage interface {
 	// Categories lists all tracked categories.
 	Categories() ([]string, error)
 	// Actions lists all tracked actions under the given category.
 	Actions(category string) ([]string, error)
+	// Fields lists all fields of records of the given category.
+	Fields(category string) ([]string, error)
 }
 
 // Get returns all criteria / blueprint for creating new or editing segment.
@@ -92,6 +96,22 @@ func (sbdb *SegmentBlueprintDB) generateBlueprintData() (SegmentBlueprintTableCo
 		return nil, err
 	}
 
+	// append RFM scores
+	sbt.Fields = append(sbt.Fields, CategoryRFM)
+	sbt.Criteria = append(sbt.Criteria, &SegmentBlueprintTableCriterion{
+		Key:    CategoryRFM,
+		Label:  "RFM",
+		Params: sbdb.rfmParams(),
+	})
+
+	// append sequence of events
+	sbt.Fields = append(sbt.Fields, CategorySequence)
+	sbt.Criteria = append(sbt.Criteria, &SegmentBlueprintTableCriterion{
+		Key:    CategorySequence,
+		Label:  "Sequence",
+		Params: sbdb.sequenceParams(),
+	})
+
 	return blueprint, nil
 }
 
@@ -116,6 +136,11 @@ func (sbdb *SegmentBlueprintDB) fillCriterionFromStorage(storage PCEStorage, sbt
 			return err
 		}
 
+		sbtc.Fields, err = storage.Fields(c)
+		if err != nil {
+			return err
+		}
+
 		sbtc.Params["action"] = SegmentBlueprintTableCriterionParam{
 			Type:      "string",
 			Required:  true,
@@ -134,6 +159,7 @@ func (sbdb *SegmentBlueprintDB) fillCriterionFromStorage(storage PCEStorage, sbt
 func (sbdb *SegmentBlueprintDB) buildParams(category string) map[string]SegmentBlueprintTableCriterionParam {
 	params := sbdb.commonParams()
 
+	aggregates := []string{"count", AggregateDistinct}
 	switch category {
 	case CategoryPageview:
 		params["is_article"] = SegmentBlueprintTableCriterionParam{
@@ -142,6 +168,82 @@ func (sbdb *SegmentBlueprintDB) buildParams(category string) map[string]SegmentB
 			Help:     "Should segment match only article pageviews (true), not-article pageviews (false) or all (parameter not provided)",
 			Label:    "Match article pageviews",
 		}
+		aggregates = append(aggregates, AggregateSum, AggregateAvg)
+	case CategoryCommerce:
+		aggregates = append(aggregates, AggregateSum)
+	}
+
+	defaultAggregate := "count"
+	params["aggregate"] = SegmentBlueprintTableCriterionParam{
+		Type:      "string",
+		Required:  false,
+		Default:   &defaultAggregate,
+		Help:      "Aggregate function compared against count param. Sum and avg aggregate timespent/progress of pageviews and revenue of commerce, distinct counts unique values of aggregated field.",
+		Label:     "Aggregate",
+		Available: aggregates,
+	}
+	params["aggregate_field"] = SegmentBlueprintTableCriterionParam{
+		Type:     "string",
+		Required: false,
+		Help:     "Field aggregated by distinct aggregate function. E.g. `author_id`",
+		Label:    "Aggregated field",
+	}
+	return params
+}
+
+// rfmParams returns map of Params of RFM criterion.
+func (sbdb *SegmentBlueprintDB) rfmParams() map[string]SegmentBlueprintTableCriterionParam {
+	params := make(map[string]SegmentBlueprintTableCriterionParam)
+	params["segments"] = SegmentBlueprintTableCriterionParam{
+		Type:      "string_array",
+		Required:  false,
+		Help:      "Match users within any of selected RFM segments",
+		Label:     "RFM segments",
+		Available: RFMSegments,
+	}
+	for _, score := range []string{"recency", "frequency", "monetary"} {
+		params[score] = SegmentBlueprintTableCriterionParam{
+			Type:     "number",
+			Required: false,
+			Help:     fmt.Sprintf("Minimal %s score (1-%d, higher is better)", score, rfmQuantiles),
+			Label:    strings.Title(score),
+		}
+	}
+	return params
+}
+
+// sequenceParams returns map of Params of sequence criterion.
+func (sbdb *SegmentBlueprintDB) sequenceParams() map[string]SegmentBlueprintTableCriterionParam {
+	params := sbdb.commonParams()
+	params["count"] = SegmentBlueprintTableCriterionParam{
+		Type:     "number",
+		Required: false,
+		Help:     "Use 1 to match completed sequences (default) or 0 to match incomplete sequences",
+		Label:    "Count",
+	}
+	params["steps"] = SegmentBlueprintTableCriterionParam{
+		Type:     "event_steps",
+		Required: true,
+		Help:     "Ordered list of events (category, action and optional fields). E.g. `[{\"category\": \"commerce\", \"action\": \"checkout\"}]`",
+		Label:    "Steps",
+	}
+	params["without"] = SegmentBlueprintTableCriterionParam{
+		Type:     "event_steps",
+		Required: false,
+		Help:     "Events which must not occur between the first step and the end of sequence window",
+		Label:    "Without",
+	}
+	params["within"] = SegmentBlueprintTableCriterionParam{
+		Type:     "interval",
+		Required: false,
+		Help:     "Maximal duration between the first and the last step",
+		Label:    "Within",
+	}
+	params["session"] = SegmentBlueprintTableCriterionParam{
+		Type:     "boolean",
+		Required: false,
+		Help:     "Should all the steps occur within the same session",
+		Label:    "Same session",
 	}
 	return params
 }
@@ -153,7 +255,7 @@ func (sbdb *SegmentBlueprintDB) commonParams() map[string]SegmentBlueprintTableC
 	commonParams["count"] = SegmentBlueprintTableCriterionParam{
 		Type:     "number",
 		Required: true,
-		Help:     "Value of count against which is action of segment rule checked. E.g. 5",
+		Help:     "Value of count (or aggregated value) against which is action of segment rule checked. E.g. 5",
 		Label:    "Count",
 	}
 
@@ -178,5 +280,13 @@ func (sbdb *SegmentBlueprintDB) commonParams() map[string]SegmentBlueprintTableC
 		Label:    "Fields",
 	}
 
+	commonParams["overridable"] = SegmentBlueprintTableCriterionParam{
+		Type:      "string_array",
+		Required:  false,
+		Help:      "Params which can be overridden by the client checking the segment. E.g. `[\"count\", \"timespan\"]`",
+		Label:     "Overridable params",
+		Available: []string{OverridableCount, OverridableOperator, OverridableTimespan},
+	}
+
 	return commonParams
 }
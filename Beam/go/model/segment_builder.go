@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
@@ -89,17 +90,14 @@ func (sDB *SegmentDB) BuildRules(s *Segment) ([]SegmentRule, bool, error) {
 	}
 
 	for _, n := range sc.Nodes {
-		// TODO: move to validation when creating/updating segment criteria
 		if n.Type != "operator" {
 			return rules, false, errors.New("incorrect type of node - only `operator` is allowed on this level")
 		}
-		// TODO: move to validation when creating/updating segment criteria
 		if n.Operator != "AND" {
 			return rules, false, errors.New("incorrect operator - only `AND` operator is allowed")
 		}
 
 		for _, nn := range n.Nodes {
-			// TODO: move to validation when creating/updating segment criteria
 			if nn.Type != "criteria" {
 				return rules, false, errors.New("incorrect type of node - only `criteria` is allowed on this level")
 			}
@@ -114,6 +112,13 @@ func (sDB *SegmentDB) BuildRules(s *Segment) ([]SegmentRule, bool, error) {
 			sr.Fields = make(JSONMap, 0)
 			sr.Flags = make(JSONMap, 0)
 
+			switch sr.EventCategory {
+			case CategorySequence:
+				sr.Sequence = &SegmentRuleSequence{}
+			case CategoryRFM:
+				sr.RFM = &SegmentRuleRFM{}
+			}
+
 			for k, v := range nn.Values {
 				switch k {
 				case "action":
@@ -121,7 +126,10 @@ func (sDB *SegmentDB) BuildRules(s *Segment) ([]SegmentRule, bool, error) {
 						sr.EventAction = action
 					}
 				case "count":
-					mf := v.(map[string]interface{})
+					mf, ok := v.(map[string]interface{})
+					if !ok {
+						return nil, false, errors.New("unable to scan count from segment criteria values")
+					}
 					for fk, fv := range mf {
 						var operator string
 						switch fk {
@@ -140,27 +148,122 @@ func (sDB *SegmentDB) BuildRules(s *Segment) ([]SegmentRule, bool, error) {
 						}
 
 						var count int
+						var value float64
 						if c, ok := fv.(float64); ok {
 							count = int(c)
+							value = c
 						}
 
 						if sr.Operator == "" && sr.Count == 0 {
 							sr.Operator = operator
 							sr.Count = count
+							sr.Value = value
 
 						} else if sr.Operator2 == nil && sr.Count2 == nil {
 							sr.Operator2 = &operator
 							sr.Count2 = &count
+							sr.Value2 = &value
 						}
 					}
+				case "steps", "without":
+					if sr.Sequence == nil {
+						return nil, false, fmt.Errorf("%s can be used only within sequence criterion", k)
+					}
+					var steps []SegmentRuleSequenceStep
+					if err := scanCriteriaValue(v, &steps); err != nil {
+						return nil, false, errors.Wrapf(err, "unable to scan sequence %s", k)
+					}
+					if k == "steps" {
+						sr.Sequence.Steps = steps
+					} else {
+						sr.Sequence.Without = steps
+					}
+				case "within":
+					if sr.Sequence == nil {
+						return nil, false, errors.New("within can be used only within sequence criterion")
+					}
+					var within struct {
+						Value int
+						Unit  string
+					}
+					if err := scanCriteriaValue(v, &within); err != nil {
+						return nil, false, errors.Wrap(err, "unable to scan sequence window")
+					}
+					sr.Sequence.Within = intervalMinutes(within.Value, within.Unit)
+				case "session":
+					if sr.Sequence == nil {
+						return nil, false, errors.New("session can be used only within sequence criterion")
+					}
+					if session, ok := v.(bool); ok {
+						sr.Sequence.Session = session
+					}
+				case "segments":
+					if sr.RFM == nil {
+						return nil, false, errors.New("segments can be used only within rfm criterion")
+					}
+					if err := scanCriteriaValue(v, &sr.RFM.Segments); err != nil {
+						return nil, false, errors.Wrap(err, "unable to scan RFM segments")
+					}
+				case "recency", "frequency", "monetary":
+					if sr.RFM == nil {
+						return nil, false, fmt.Errorf("%s can be used only within rfm criterion", k)
+					}
+					score, ok := v.(float64)
+					if !ok {
+						return nil, false, fmt.Errorf("unable to scan RFM %s score", k)
+					}
+					switch k {
+					case "recency":
+						sr.RFM.Recency = int(score)
+					case "frequency":
+						sr.RFM.Frequency = int(score)
+					case "monetary":
+						sr.RFM.Monetary = int(score)
+					}
+				case "overridable":
+					var params OverridableParams
+					if err := scanCriteriaValue(v, &params); err != nil {
+						return nil, false, errors.Wrap(err, "unable to scan overridable params")
+					}
+					sort.Strings(params)
+					sr.Overridable = params
+				case "aggregate":
+					if aggregate, ok := v.(string); ok && aggregate != "count" {
+						sr.Aggregate = aggregate
+					}
+				case "aggregate_field":
+					if field, ok := v.(string); ok {
+						sr.AggregateField = field
+					}
 				case "fields":
-					mf := v.(map[string]interface{})
+					mf, ok := v.(map[string]interface{})
+					if !ok {
+						return nil, false, errors.New("unable to scan fields from segment criteria values")
+					}
 					var fields JSONMap
 					for fk, fv := range mf {
-						fields = append(fields, map[string]string{
-							"key":   fk,
-							"value": fmt.Sprintf("%v", fv),
-						})
+						// field is either matched exactly by value or by filter {"operator": "prefix", "value": "..."}
+						of, ok := fv.(map[string]interface{})
+						if !ok {
+							fields = append(fields, map[string]string{
+								"key":   fk,
+								"value": fmt.Sprintf("%v", fv),
+							})
+							continue
+						}
+						operator, _ := of["operator"].(string)
+						if !validFilterOperator(operator) {
+							return nil, false, fmt.Errorf("invalid operator of field %s: %v", fk, of["operator"])
+						}
+						def := map[string]string{
+							"key":      fk,
+							"value":    "",
+							"operator": operator,
+						}
+						if fv, ok := of["value"]; ok && fv != nil {
+							def["value"] = fmt.Sprintf("%v", fv)
+						}
+						fields = append(fields, def)
 					}
 					sr.Fields = fields
 				case "is_article":
@@ -201,44 +304,94 @@ func (sDB *SegmentDB) BuildRules(s *Segment) ([]SegmentRule, bool, error) {
 							return nil, false, errors.New("absolute timespan missing values")
 						}
 						if val, ok := (*scvd.Absolute)["gte"]; ok {
-							layout := "2006-01-02T15:04:05.000Z"
-							t, err := time.Parse(layout, val)
+							t, err := parseCriteriaTime(val)
 							if err != nil {
-								return nil, false, errors.New(fmt.Sprintf("unable to parse timespan [%s]", val))
+								return nil, false, err
 							}
-							duration := time.Since(t)
-							sr.Timespan = sql.NullInt64{
-								Int64: int64(duration.Minutes()),
-								Valid: true,
+							sr.TimeAfter = &t
+						}
+						if val, ok := (*scvd.Absolute)["lte"]; ok {
+							t, err := parseCriteriaTime(val)
+							if err != nil {
+								return nil, false, err
 							}
+							sr.TimeBefore = &t
 						}
 					case "interval":
 						if scvd.Interval == nil {
 							return nil, false, errors.New("interval timespan missing values")
 						}
 						if val, ok := (*scvd.Interval)["gte"]; ok {
-							var timespan int
-							var multiplier int
-							switch val.Unit {
-							case "hour":
-								multiplier = 60
-							case "day":
-								multiplier = 1440
-							case "month":
-								multiplier = 43800
-							}
-							timespan = int(math.Abs(float64(val.Value))) * multiplier
 							sr.Timespan = sql.NullInt64{
-								Int64: int64(timespan),
+								Int64: int64(intervalMinutes(val.Value, val.Unit)),
 								Valid: true,
 							}
 						}
+						if val, ok := (*scvd.Interval)["lte"]; ok {
+							sr.TimespanEnd = sql.NullInt64{
+								Int64: int64(intervalMinutes(val.Value, val.Unit)),
+								Valid: true,
+							}
+						}
+					}
+					if sr.Timespan.Valid && sr.TimespanEnd.Valid && sr.TimespanEnd.Int64 > sr.Timespan.Int64 {
+						return nil, false, errors.New("interval timespan ends before it starts")
+					}
+					if sr.TimeAfter != nil && sr.TimeBefore != nil && sr.TimeBefore.Before(*sr.TimeAfter) {
+						return nil, false, errors.New("absolute timespan ends before it starts")
 					}
 				}
 			}
+			if (sr.Sequence != nil || sr.RFM != nil) && sr.Operator == "" {
+				// without count condition, the sequence needs to be completed (RFM conditions matched)
+				sr.Operator = ">="
+				sr.Count = 1
+				sr.Value = 1
+			}
+			if err := sr.validateAggregate(); err != nil {
+				return nil, false, err
+			}
 			rules = append(rules, sr)
 		}
 	}
 
 	return rules, true, nil
 }
+
+// parseCriteriaTime parses absolute datetime used within segment criteria.
+func parseCriteriaTime(val string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02T15:04:05.000Z", val)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("unable to parse timespan [%s]", val))
+	}
+	return t, nil
+}
+
+// intervalMinutes converts interval value of segment criteria to number of minutes ago.
+func intervalMinutes(value int, unit string) int {
+	var multiplier int
+	switch unit {
+	case "minute":
+		multiplier = 1
+	case "hour":
+		multiplier = 60
+	case "day":
+		multiplier = 1440
+	case "month":
+		multiplier = 43800
+	}
+	return int(math.Abs(float64(value))) * multiplier
+}
+
+// scanCriteriaValue scans raw value of criteria node into provided target.
+func scanCriteriaValue(v interface{}, target interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
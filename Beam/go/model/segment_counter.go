@@ -0,0 +1,48 @@
+package model
+
+import (
+	"sync"
+)
+
+// ruleCounter memoizes counts (or aggregated values) of SegmentRules evaluated for single identifier. Segments sharing
+// rules with the same definition (see SegmentRule.definitionKey) hit the storage only once
+// even if they're being evaluated concurrently.
+type ruleCounter struct {
+	mu     sync.Mutex
+	counts map[string]*ruleCount
+}
+
+// ruleCount represents single memoized result of rule count.
+type ruleCount struct {
+	once  sync.Once
+	value float64
+	err   error
+}
+
+// newRuleCounter returns new instance of ruleCounter.
+func newRuleCounter() *ruleCounter {
+	return &ruleCounter{
+		counts: make(map[string]*ruleCount),
+	}
+}
+
+// value returns memoized value for provided key. If there's no value stored yet, it's
+// computed by provided callback. Nil ruleCounter always calls the callback.
+func (rc *ruleCounter) value(key string, fn func() (float64, error)) (float64, error) {
+	if rc == nil {
+		return fn()
+	}
+
+	rc.mu.Lock()
+	c, ok := rc.counts[key]
+	if !ok {
+		c = &ruleCount{}
+		rc.counts[key] = c
+	}
+	rc.mu.Unlock()
+
+	c.once.Do(func() {
+		c.value, c.err = fn()
+	})
+	return c.value, c.err
+}
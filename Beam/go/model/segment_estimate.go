@@ -0,0 +1,218 @@
+package model
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// estimatePageSize is the expected number of candidates evaluated within single sampling step.
+	estimatePageSize = 500
+	// estimatePartitionSize is the maximum number of candidates listed within single sampling step. It leaves enough
+	// headroom for partitions larger than expected, as the partitions are selected by hash of the identifiers.
+	estimatePartitionSize = 4 * estimatePageSize
+	// estimateSampleSize is the number of candidates after which the sampling stops even if latency budget wasn't reached.
+	estimateSampleSize = 5000
+	// estimateZ is the z-score of ~95% confidence interval.
+	estimateZ = 1.96
+	// cardinalityRelativeError is expected relative error of cardinality aggregation used to get the population size.
+	cardinalityRelativeError = 0.01
+)
+
+// SegmentEstimate represents approximate number of segment members.
+type SegmentEstimate struct {
+	Count      int
+	ErrorBound int  // half-width of ~95% confidence interval of Count
+	Exact      bool // flag whether all the candidates were evaluated
+}
+
+// ErrEstimateBudget is returned if the latency budget of estimate was exhausted before any candidate was sampled.
+var ErrEstimateBudget = errors.New("latency budget was exhausted before any candidate was sampled")
+
+// EstimateUsers returns approximate number of users within segment computed within provided latency budget.
+//
+// Population of candidates is taken from the most selective rule (the lowest cardinality of users with
+// matching events). Candidates are split into partitions by hash of their identifiers, so each partition is
+// a random sample independent of the order of identifiers (which follows the signup order of users).
+// Partitions are fully evaluated one by one until the sample size or the budget is reached. If all
+// the partitions fit into the budget, the returned count is exact.
+//
+// Budget is checked before each storage call; ErrEstimateBudget is returned if it's exhausted before
+// the first partition is evaluated.
+func (sDB *SegmentDB) EstimateUsers(segment *Segment, now time.Time, ro RuleOverrides, budget time.Duration) (*SegmentEstimate, error) {
+	deadline := time.Now().Add(budget)
+	return sDB.estimateUsers(segment, now, ro, func() bool {
+		return !time.Now().Before(deadline)
+	})
+}
+
+// estimateUsers estimates number of users within segment until expired reports the budget was exhausted.
+func (sDB *SegmentDB) estimateUsers(segment *Segment, now time.Time, ro RuleOverrides, expired func() bool) (*SegmentEstimate, error) {
+	if segment.Group.Type == explicitSegmentType {
+		users, _ := sDB.explicitUsers(segment.Code)
+		return &SegmentEstimate{
+			Count: len(users),
+			Exact: true,
+		}, nil
+	}
+	if len(segment.Rules) == 0 {
+		return &SegmentEstimate{Exact: true}, nil
+	}
+	segment = segment.withOverrides(ro)
+
+	driver, population, err := sDB.drivingRule(segment, UniqueCountUsers, now, ro, expired)
+	if err != nil {
+		return nil, err
+	}
+	if population == 0 {
+		return &SegmentEstimate{Exact: true}, nil
+	}
+
+	partitions := int(math.Ceil(float64(population) / estimatePageSize))
+	var evaluated, sampled, matched int
+	truncated := false
+	for p := 0; p < partitions && sampled < estimateSampleSize; p++ {
+		members, candidates, _, err := sDB.evaluatePage(segment, driver, "user_id", now, ro, AggregatePage{
+			Size:       estimatePartitionSize,
+			Partition:  p,
+			Partitions: partitions,
+		}, expired)
+		if err == ErrEstimateBudget {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		evaluated++
+		if candidates >= estimatePartitionSize {
+			// partition might not have been listed completely; only whole partitions are sampled,
+			// so the sample isn't biased towards the candidates listed first
+			truncated = true
+			continue
+		}
+		sampled += candidates
+		matched += len(members)
+	}
+
+	if evaluated == 0 {
+		return nil, ErrEstimateBudget
+	}
+	if evaluated == partitions && !truncated {
+		return &SegmentEstimate{
+			Count: matched,
+			Exact: true,
+		}, nil
+	}
+	count, bound := estimateMembers(matched, sampled, population)
+	return &SegmentEstimate{
+		Count:      count,
+		ErrorBound: bound,
+	}, nil
+}
+
+// drivingRule returns index of the rule with the lowest number of unique items having matching events
+// and the number of these items. Rules satisfied even by zero count can't drive the evaluation, as items
+// without events wouldn't be listed; the first rule is used if there's no other option.
+//
+// If the budget expires, the most selective of already counted rules is used; ErrEstimateBudget is returned
+// if no rule was counted yet.
+func (sDB *SegmentDB) drivingRule(segment *Segment, item string, now time.Time, ro RuleOverrides, expired func() bool) (int, int, error) {
+	driver := -1
+	var population int
+	for i, sr := range segment.Rules {
+		zeroMatch, err := sr.Evaluate(0)
+		if err != nil {
+			return 0, 0, err
+		}
+		if zeroMatch {
+			continue
+		}
+		if expired() {
+			break
+		}
+		n, err := sDB.ruleUnique(&sr, now, ro, item)
+		if err != nil {
+			return 0, 0, err
+		}
+		if driver == -1 || n < population {
+			driver = i
+			population = n
+		}
+	}
+	if driver != -1 {
+		return driver, population, nil
+	}
+
+	if expired() {
+		return 0, 0, ErrEstimateBudget
+	}
+	n, err := sDB.ruleUnique(&segment.Rules[0], now, ro, item)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, n, nil
+}
+
+// ruleUnique returns number of unique items having events matching provided SegmentRule.
+func (sDB *SegmentDB) ruleUnique(sr *SegmentRule, now time.Time, ro RuleOverrides, item string) (int, error) {
+	crc, ok, err := sDB.storageUnique(sr, sr.options(now, ro), item)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get unique count of rule candidates")
+	}
+	if !ok || len(crc) == 0 {
+		return 0, nil
+	}
+	return crc[0].Count, nil
+}
+
+// storageUnique returns unique count of items from the storage holding events of provided SegmentRule.
+func (sDB *SegmentDB) storageUnique(sr *SegmentRule, options AggregateOptions, item string) (CountRowCollection, bool, error) {
+	switch sr.EventCategory {
+	case CategorySequence:
+		// sequence candidates are counted by events of its first step
+		step := sr.Sequence.Steps[0]
+		return sDB.storageUnique(step.rule(), step.options(options), item)
+	case CategoryRFM:
+		if item != UniqueCountUsers {
+			return CountRowCollection{}, false, nil
+		}
+		crc, ok, err := sDB.rfmCount(options)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		return CountRowCollection{{Tags: map[string]string{}, Count: len(crc)}}, true, nil
+	case CategoryPageview:
+		return sDB.PageviewStorage.Unique(options, item)
+	case CategoryCommerce:
+		return sDB.CommerceStorage.Unique(options, item)
+	default:
+		return sDB.EventStorage.Unique(options, item)
+	}
+}
+
+// estimateMembers extrapolates number of members within the population based on the evaluated sample.
+// It returns the estimate and half-width of its ~95% confidence interval. Agresti-Coull adjusted proportion
+// with finite population correction is used, so the bound is reasonable even if no sampled candidate matched.
+func estimateMembers(matched, sampled, population int) (int, int) {
+	if sampled == 0 || population == 0 {
+		return 0, 0
+	}
+	if sampled > population {
+		population = sampled
+	}
+
+	p := float64(matched) / float64(sampled)
+	estimate := p * float64(population)
+
+	n := float64(sampled) + estimateZ*estimateZ
+	pa := (float64(matched) + estimateZ*estimateZ/2) / n
+	se := math.Sqrt(pa * (1 - pa) / n)
+	if population > 1 {
+		se *= math.Sqrt(float64(population-sampled) / float64(population-1))
+	}
+	bound := estimateZ*se*float64(population) + cardinalityRelativeError*estimate
+
+	return int(math.Round(estimate)), int(math.Ceil(bound))
+}
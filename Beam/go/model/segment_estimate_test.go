@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEstimateMembers(t *testing.T) {
+	var estimateTests = []struct {
+		Matched    int
+		Sampled    int
+		Population int
+		Count      int
+		MinBound   int
+		MaxBound   int
+	}{
+		{0, 0, 1000, 0, 0, 0},
+		{10, 100, 0, 0, 0, 0},
+		{50, 100, 1000, 500, 80, 120},
+		{0, 1000, 100000, 0, 1, 1000},
+		{1000, 1000, 100000, 100000, 1000, 2000},
+		{250, 1000, 1000, 250, 0, 3}, // whole population sampled
+	}
+
+	for _, et := range estimateTests {
+		count, bound := estimateMembers(et.Matched, et.Sampled, et.Population)
+		if count != et.Count {
+			t.Errorf("returned count %d, expected %d: %v", count, et.Count, et)
+		}
+		if bound < et.MinBound || bound > et.MaxBound {
+			t.Errorf("returned bound %d, expected within [%d, %d]: %v", bound, et.MinBound, et.MaxBound, et)
+		}
+	}
+}
+
+func TestSegmentDB_EstimateUsersBudget(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[fmt.Sprintf("u%04d", i)] = 1 + i%3
+	}
+	segment := &Segment{
+		Rules: []SegmentRule{
+			{ID: 1, EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: ">=", Count: 2},
+			{ID: 2, EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: "<=", Count: 2},
+		},
+	}
+	// expiresAfter returns budget check expiring after provided number of checks
+	expiresAfter := func(n int) func() bool {
+		checks := 0
+		return func() bool {
+			checks++
+			return checks > n
+		}
+	}
+
+	var budgetTests = []struct {
+		Name   string
+		Checks int // number of budget checks passed before the budget expires
+		Calls  int // expected number of storage calls
+		Err    error
+	}{
+		// budget exhausted before any storage call
+		{"expired", 0, 0, ErrEstimateBudget},
+		// driving rule counted (the other rule is matched by zero count and can't drive the evaluation)
+		{"driving rule", 1, 1, ErrEstimateBudget},
+		// first partition listed, but its candidates aren't evaluated against the other rule
+		{"partial partition", 2, 2, ErrEstimateBudget},
+		// first partition evaluated, second one isn't listed
+		{"single partition", 3, 3, nil},
+	}
+
+	for _, bt := range budgetTests {
+		storage := &pagedPageviewStorage{counts: counts}
+		sDB := &SegmentDB{PageviewStorage: storage}
+		se, err := sDB.estimateUsers(segment, time.Now(), RuleOverrides{}, expiresAfter(bt.Checks))
+		if err != bt.Err {
+			t.Errorf("%s: returned error %v, expected %v", bt.Name, err, bt.Err)
+			continue
+		}
+		if storage.calls != bt.Calls {
+			t.Errorf("%s: called storage %d times, expected %d", bt.Name, storage.calls, bt.Calls)
+		}
+		if err == nil && se.Exact {
+			t.Errorf("%s: returned exact estimate %+v of single partition", bt.Name, se)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuerySourcer is implemented by storages able to describe the query used to filter events for provided options.
+type QuerySourcer interface {
+	// QuerySource returns source of the filter query used for provided options.
+	QuerySource(o AggregateOptions) (interface{}, error)
+}
+
+// SegmentExplanation represents trace of segment membership check.
+type SegmentExplanation struct {
+	Segment  string
+	Explicit bool // membership of explicit segments is not evaluated by rules
+	Result   bool
+	// ShortCircuit is the index of rule which stopped the evaluation, nil if all the rules were evaluated.
+	ShortCircuit *int
+	Rules        []*SegmentRuleTrace
+}
+
+// SegmentRuleTrace represents evaluation of single SegmentRule within segment membership check.
+type SegmentRuleTrace struct {
+	Rule       *SegmentRule // effective rule after overrides
+	TimeAfter  time.Time    // resolved start of the time window, zero for unbounded
+	TimeBefore time.Time    // resolved end of the time window, zero for unbounded
+	Query      interface{}  // filter query used by the storage, nil if the storage doesn't provide it
+	Evaluated  bool         // false if the evaluation short-circuited before the rule
+	Cached     bool         // whether the count was provided by client or server cache
+	Value      float64
+	Comparison string
+	Matched    bool
+}
+
+// ExplainUser verifies presence of user within provided segment and traces evaluation of each rule.
+func (sDB *SegmentDB) ExplainUser(segment *Segment, userID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, *SegmentExplanation, error) {
+	return sDB.explain(segment, "user_id", userID, now, cache, ro, func() (SegmentCache, bool, error) {
+		return sDB.CheckUser(segment, userID, now, cache, ro)
+	})
+}
+
+// ExplainBrowser verifies presence of browser within provided segment and traces evaluation of each rule.
+func (sDB *SegmentDB) ExplainBrowser(segment *Segment, browserID string, now time.Time, cache SegmentCache, ro RuleOverrides) (SegmentCache, *SegmentExplanation, error) {
+	return sDB.explain(segment, "browser_id", browserID, now, cache, ro, func() (SegmentCache, bool, error) {
+		return sDB.CheckBrowser(segment, browserID, now, cache, ro)
+	})
+}
+
+// explain runs traced check of provided tag within segment. Explicit segments are checked by provided checker.
+func (sDB *SegmentDB) explain(segment *Segment, tagName, tagValue string, now time.Time, cache SegmentCache, ro RuleOverrides,
+	explicitChecker func() (SegmentCache, bool, error)) (SegmentCache, *SegmentExplanation, error) {
+	se := &SegmentExplanation{
+		Segment: segment.Code,
+		Rules:   []*SegmentRuleTrace{},
+	}
+
+	var c SegmentCache
+	var err error
+	if segment.Group.Type == explicitSegmentType {
+		se.Explicit = true
+		c, se.Result, err = explicitChecker()
+	} else {
+		c, se.Result, err = sDB.check(segment, tagName, tagValue, now, cache, ro, nil, se)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, se, nil
+}
+
+// traceRule records evaluation of provided (already overridden) rule.
+func (sDB *SegmentDB) traceRule(se *SegmentExplanation, osr *SegmentRule, tagName, tagValue string, now time.Time, ro RuleOverrides,
+	value float64, cached, matched bool) error {
+	options := osr.options(now, ro)
+	options.FilterBy = append(options.FilterBy, &FilterBy{Tag: tagName, Values: []string{tagValue}})
+
+	query, err := sDB.querySource(osr, options)
+	if err != nil {
+		return errors.Wrap(err, "unable to get query of SegmentRule")
+	}
+
+	if !matched {
+		index := len(se.Rules)
+		se.ShortCircuit = &index
+	}
+	se.Rules = append(se.Rules, &SegmentRuleTrace{
+		Rule:       osr,
+		TimeAfter:  options.TimeAfter,
+		TimeBefore: options.TimeBefore,
+		Query:      query,
+		Evaluated:  true,
+		Cached:     cached,
+		Value:      value,
+		Comparison: osr.comparison(value),
+		Matched:    matched,
+	})
+	return nil
+}
+
+// traceSkipped records rules which weren't evaluated because the evaluation short-circuited.
+func (se *SegmentExplanation) traceSkipped(rules []SegmentRule, ro RuleOverrides) {
+	for _, sr := range rules {
+		se.Rules = append(se.Rules, &SegmentRuleTrace{
+			Rule: sr.applyOverrides(ro),
+		})
+	}
+}
+
+// querySource returns filter query used by the storage holding events of provided SegmentRule.
+func (sDB *SegmentDB) querySource(sr *SegmentRule, options AggregateOptions) (interface{}, error) {
+	var storage interface{}
+	switch sr.EventCategory {
+	case CategorySequence:
+		// sequence candidates are filtered by events of its first step
+		step := sr.Sequence.Steps[0]
+		return sDB.querySource(step.rule(), step.options(options))
+	case CategoryRFM:
+		// RFM scores are not queried from the storage
+		return nil, nil
+	case CategoryPageview:
+		storage = sDB.PageviewStorage
+	case CategoryCommerce:
+		storage = sDB.CommerceStorage
+	default:
+		storage = sDB.EventStorage
+	}
+
+	qs, ok := storage.(QuerySourcer)
+	if !ok {
+		return nil, nil
+	}
+	return qs.QuerySource(options)
+}
+
+// comparison returns human-readable comparison of provided value against thresholds of the rule.
+// E.g. "12 >= 5 AND 12 < 20".
+func (sr *SegmentRule) comparison(value float64) string {
+	v := strconv.FormatFloat(value, 'f', -1, 64)
+	against, against2 := sr.thresholds()
+
+	c := fmt.Sprintf("%s %s %s", v, sr.Operator, strconv.FormatFloat(against, 'f', -1, 64))
+	if sr.Operator2 != nil && against2 != nil {
+		c += fmt.Sprintf(" AND %s %s %s", v, *sr.Operator2, strconv.FormatFloat(*against2, 'f', -1, 64))
+	}
+	return c
+}
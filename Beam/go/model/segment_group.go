@@ -0,0 +1,232 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Errors returned when segment group can't be stored due to the current state of storage.
+var (
+	ErrSegmentGroupCodeConflict = errors.New("segment group code is already used")
+	ErrSegmentGroupModified     = errors.New("segment group was modified since provided version")
+	ErrSegmentGroupNotEmpty     = errors.New("segment group contains segments")
+	ErrSegmentGroupProtected    = errors.New("segment group is required by Beam and can't be deleted or recoded")
+)
+
+// protectedGroupCodes lists codes of segment groups Beam relies on.
+var protectedGroupCodes = map[string]bool{
+	"remp-segments":    true,
+	"authors-segments": true,
+}
+
+// GetGroup returns segment group based on the given ID.
+func (sDB *SegmentDB) GetGroup(id int) (*SegmentGroup, bool, error) {
+	return getSegmentGroup(sDB.MySQL, id, false)
+}
+
+// CreateGroup creates new segment group from provided data and returns it. Group is placed after all the
+// existing groups if its sorting is not provided.
+func (sDB *SegmentDB) CreateGroup(sg SegmentGroup) (*SegmentGroup, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if sg.Code == "" {
+		return nil, errors.New("segment group code is required")
+	}
+	if sg.Type == "" {
+		sg.Type = ruleSegmentType
+	}
+	if sg.Sorting == 0 {
+		if err := tx.Get(&sg.Sorting, "SELECT COALESCE(MAX(sorting), 0) + 100 FROM segment_groups"); err != nil {
+			return nil, errors.Wrap(err, "unable to get sorting of segment group")
+		}
+	}
+
+	now := time.Now()
+	res, err := tx.Exec("INSERT INTO segment_groups (name, code, type, sorting, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		sg.Name, sg.Code, sg.Type, sg.Sorting, now, now)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, errors.Wrap(ErrSegmentGroupCodeConflict, fmt.Sprintf("code [%s]", sg.Code))
+		}
+		return nil, errors.Wrap(err, "unable to insert segment group")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get ID of inserted segment group")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "unable to commit transaction")
+	}
+
+	g, ok, err := sDB.GetGroup(int(id))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("transaction error: unable to load created segment group")
+	}
+	return g, nil
+}
+
+// UpdateGroup updates existing segment group from provided data and returns it. Code, type and sorting are changed
+// only if provided. If version is provided, the group is updated only if it wasn't modified since.
+func (sDB *SegmentDB) UpdateGroup(id int, sg SegmentGroup, version *int) (*SegmentGroup, bool, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	current, ok, err := getSegmentGroup(tx, id, true)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if version != nil && current.Version != *version {
+		return nil, false, errors.Wrap(ErrSegmentGroupModified, fmt.Sprintf("segment group [%d] is at version %d", id, current.Version))
+	}
+	if sg.Code == "" {
+		sg.Code = current.Code
+	} else if sg.Code != current.Code {
+		if protectedGroupCodes[current.Code] {
+			return nil, false, errors.Wrap(ErrSegmentGroupProtected, fmt.Sprintf("code [%s]", current.Code))
+		}
+	}
+	if sg.Type == "" {
+		sg.Type = current.Type
+	}
+	if sg.Sorting == 0 {
+		sg.Sorting = current.Sorting
+	}
+
+	_, err = tx.Exec("UPDATE segment_groups SET name = ?, code = ?, type = ?, sorting = ?, updated_at = ?, version = version + 1 WHERE id = ?",
+		sg.Name, sg.Code, sg.Type, sg.Sorting, time.Now(), id)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return nil, false, errors.Wrap(ErrSegmentGroupCodeConflict, fmt.Sprintf("code [%s]", sg.Code))
+		}
+		return nil, false, errors.Wrap(err, "unable to update segment group")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, errors.Wrap(err, "unable to commit transaction")
+	}
+
+	g, ok, err := sDB.GetGroup(id)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return nil, false, errors.New("transaction error: unable to load updated segment group")
+	}
+	return g, true, nil
+}
+
+// DeleteGroup deletes existing segment group. Groups containing any segments (including the deleted ones)
+// and groups Beam relies on can't be deleted.
+func (sDB *SegmentDB) DeleteGroup(id int) (bool, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	current, ok, err := getSegmentGroup(tx, id, true)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if protectedGroupCodes[current.Code] {
+		return false, errors.Wrap(ErrSegmentGroupProtected, fmt.Sprintf("code [%s]", current.Code))
+	}
+
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM segments WHERE segment_group_id = ?", id); err != nil {
+		return false, errors.Wrap(err, "unable to count segments of segment group")
+	}
+	if count > 0 {
+		return false, errors.Wrap(ErrSegmentGroupNotEmpty, fmt.Sprintf("segment group [%d] contains %d segments", id, count))
+	}
+
+	if _, err := tx.Exec("DELETE FROM segment_groups WHERE id = ?", id); err != nil {
+		return false, errors.Wrap(err, "unable to delete segment group")
+	}
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "unable to commit transaction")
+	}
+	return true, nil
+}
+
+// SortGroups sets sorting of segment groups based on the order of provided IDs and returns all the groups.
+// Groups not listed keep their sorting and are placed after the listed ones.
+func (sDB *SegmentDB) SortGroups(ids []int) (SegmentGroupCollection, error) {
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	sgc := SegmentGroupCollection{}
+	if err := tx.Select(&sgc, "SELECT * FROM segment_groups ORDER BY sorting FOR UPDATE"); err != nil {
+		return nil, errors.Wrap(err, "unable to lock segment groups")
+	}
+	existing := make(map[int]bool)
+	for _, sg := range sgc {
+		existing[sg.ID] = true
+	}
+
+	listed := make(map[int]bool)
+	sorting := 0
+	now := time.Now()
+	sort := func(id int) error {
+		sorting += 100
+		_, err := tx.Exec("UPDATE segment_groups SET sorting = ?, updated_at = ?, version = version + 1 WHERE id = ?", sorting, now, id)
+		return err
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			return nil, errors.Wrap(ErrSegmentGroupNotFound, fmt.Sprintf("segment group [%d]", id))
+		}
+		if listed[id] {
+			continue
+		}
+		listed[id] = true
+		if err := sort(id); err != nil {
+			return nil, errors.Wrap(err, "unable to sort segment groups")
+		}
+	}
+	for _, sg := range sgc {
+		if listed[sg.ID] {
+			continue
+		}
+		if err := sort(sg.ID); err != nil {
+			return nil, errors.Wrap(err, "unable to sort segment groups")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "unable to commit transaction")
+	}
+
+	return sDB.Groups()
+}
+
+// getSegmentGroup returns segment group based on the given ID, optionally locking it for update.
+func getSegmentGroup(q sqlx.Queryer, id int, forUpdate bool) (*SegmentGroup, bool, error) {
+	query := "SELECT * FROM segment_groups WHERE id = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	sg := &SegmentGroup{}
+	if err := sqlx.Get(q, sg, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrap(err, fmt.Sprintf("unable to get segment group [%d]", id))
+	}
+	return sg, true, nil
+}
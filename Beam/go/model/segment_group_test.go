@@ -0,0 +1,250 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// scriptedStatement is single statement expected by scriptedConn together with the response to it.
+type scriptedStatement struct {
+	Query   string // beginning of the statement, whitespace is normalized before comparison
+	Columns []string
+	Rows    [][]driver.Value
+	Err     error
+}
+
+// executedStatement is statement executed via scriptedConn.
+type executedStatement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// scriptedConn is database connection responding to the statements in the order of its script.
+type scriptedConn struct {
+	script    []scriptedStatement
+	executed  []executedStatement
+	committed bool
+}
+
+func newScriptedDB(script ...scriptedStatement) (*sqlx.DB, *scriptedConn) {
+	conn := &scriptedConn{script: script}
+	db := sql.OpenDB(conn)
+	db.SetMaxOpenConns(1)
+	return sqlx.NewDb(db, "mysql"), conn
+}
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+func (c *scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return &scriptedStmt{conn: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+func (c *scriptedConn) Close() error              { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *scriptedConn) Commit() error             { c.committed = true; return nil }
+func (c *scriptedConn) Rollback() error           { return nil }
+
+// next records executed statement and returns its scripted response.
+func (c *scriptedConn) next(query string, args []driver.Value) (scriptedStatement, error) {
+	c.executed = append(c.executed, executedStatement{Query: query, Args: args})
+	if len(c.script) == 0 {
+		return scriptedStatement{}, fmt.Errorf("unexpected statement: %s", query)
+	}
+	ss := c.script[0]
+	c.script = c.script[1:]
+	if !strings.HasPrefix(query, ss.Query) {
+		return scriptedStatement{}, fmt.Errorf("unexpected statement: %s, expected: %s", query, ss.Query)
+	}
+	return ss, ss.Err
+}
+
+type scriptedStmt struct {
+	conn  *scriptedConn
+	query string
+}
+
+func (s *scriptedStmt) Close() error  { return nil }
+func (s *scriptedStmt) NumInput() int { return -1 }
+func (s *scriptedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.conn.next(s.query, args); err != nil {
+		return nil, err
+	}
+	return scriptedResult{}, nil
+}
+func (s *scriptedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	ss, err := s.conn.next(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptedRows{columns: ss.Columns, rows: ss.Rows}, nil
+}
+
+// scriptedResult is result of single affected row with ID 1.
+type scriptedResult struct{}
+
+func (scriptedResult) LastInsertId() (int64, error) { return 1, nil }
+func (scriptedResult) RowsAffected() (int64, error) { return 1, nil }
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var segmentGroupColumns = []string{"id", "name", "code", "type", "sorting", "created_at", "updated_at", "version"}
+
+func segmentGroupRow(id int64, code, typ string, sorting, version int64) []driver.Value {
+	now := time.Now()
+	return []driver.Value{id, "Group", code, typ, sorting, now, now, version}
+}
+
+func TestSegmentDB_CreateGroup(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'news' for key 'segment_groups_code_unique'"}
+
+	var createTests = []struct {
+		Name   string
+		Group  SegmentGroup
+		Script []scriptedStatement
+		Err    error
+		Args   []driver.Value // arguments of the insert
+	}{
+		{
+			Name:  "default type and sorting",
+			Group: SegmentGroup{Name: "News", Code: "news"},
+			Script: []scriptedStatement{
+				{Query: "SELECT COALESCE(MAX(sorting), 0) + 100", Columns: []string{"sorting"}, Rows: [][]driver.Value{{int64(300)}}},
+				{Query: "INSERT INTO segment_groups"},
+				{Query: "SELECT * FROM segment_groups", Columns: segmentGroupColumns, Rows: [][]driver.Value{segmentGroupRow(3, "news", "rule", 300, 1)}},
+			},
+			Args: []driver.Value{"News", "news", "rule", int64(300)},
+		},
+		{
+			Name:  "code conflict",
+			Group: SegmentGroup{Name: "News", Code: "news", Type: "explicit", Sorting: 50},
+			Script: []scriptedStatement{
+				{Query: "INSERT INTO segment_groups", Err: duplicate},
+			},
+			Err:  ErrSegmentGroupCodeConflict,
+			Args: []driver.Value{"News", "news", "explicit", int64(50)},
+		},
+		{
+			Name:  "missing code",
+			Group: SegmentGroup{Name: "News"},
+		},
+	}
+
+	for _, ct := range createTests {
+		db, conn := newScriptedDB(ct.Script...)
+		sDB := &SegmentDB{MySQL: db}
+
+		_, err := sDB.CreateGroup(ct.Group)
+		if ct.Args == nil {
+			if err == nil || len(conn.executed) > 0 {
+				t.Errorf("%s: stored group without code", ct.Name)
+			}
+			continue
+		}
+		if errors.Cause(err) != ct.Err {
+			t.Errorf("%s: returned error %v, expected %v", ct.Name, err, ct.Err)
+		}
+		for _, es := range conn.executed {
+			if !strings.HasPrefix(es.Query, "INSERT") {
+				continue
+			}
+			if !reflect.DeepEqual(es.Args[:4], ct.Args) {
+				t.Errorf("%s: inserted %v, expected %v", ct.Name, es.Args[:4], ct.Args)
+			}
+		}
+		if conn.committed != (ct.Err == nil) {
+			t.Errorf("%s: committed %t, expected %t", ct.Name, conn.committed, ct.Err == nil)
+		}
+	}
+}
+
+func TestSegmentDB_UpdateGroup(t *testing.T) {
+	version := func(v int) *int { return &v }
+	current := []scriptedStatement{
+		{Query: "SELECT * FROM segment_groups WHERE id = ? FOR UPDATE", Columns: segmentGroupColumns, Rows: [][]driver.Value{segmentGroupRow(3, "news", "explicit", 300, 2)}},
+	}
+	updated := []scriptedStatement{
+		{Query: "UPDATE segment_groups"},
+		{Query: "SELECT * FROM segment_groups", Columns: segmentGroupColumns, Rows: [][]driver.Value{segmentGroupRow(3, "news", "explicit", 300, 3)}},
+	}
+
+	var updateTests = []struct {
+		Name    string
+		Group   SegmentGroup
+		Version *int
+		Err     error
+		Args    []driver.Value // arguments of the update, nil if group shouldn't be updated
+	}{
+		{
+			Name:  "omitted code, type and sorting are kept",
+			Group: SegmentGroup{Name: "Renamed"},
+			Args:  []driver.Value{"Renamed", "news", "explicit", int64(300)},
+		},
+		{
+			Name:    "provided values at current version",
+			Group:   SegmentGroup{Name: "Renamed", Code: "daily", Type: "rule", Sorting: 100},
+			Version: version(2),
+			Args:    []driver.Value{"Renamed", "daily", "rule", int64(100)},
+		},
+		{
+			Name:    "modified since provided version",
+			Group:   SegmentGroup{Name: "Renamed"},
+			Version: version(1),
+			Err:     ErrSegmentGroupModified,
+		},
+	}
+
+	for _, ut := range updateTests {
+		script := append([]scriptedStatement{}, current...)
+		if ut.Args != nil {
+			script = append(script, updated...)
+		}
+		db, conn := newScriptedDB(script...)
+		sDB := &SegmentDB{MySQL: db}
+
+		_, ok, err := sDB.UpdateGroup(3, ut.Group, ut.Version)
+		if errors.Cause(err) != ut.Err {
+			t.Errorf("%s: returned error %v, expected %v", ut.Name, err, ut.Err)
+			continue
+		}
+		if ut.Args == nil {
+			if len(conn.executed) != 1 || conn.committed {
+				t.Errorf("%s: updated group modified since provided version", ut.Name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: group not found", ut.Name)
+			continue
+		}
+		if args := conn.executed[1].Args[:4]; !reflect.DeepEqual(args, ut.Args) {
+			t.Errorf("%s: updated group to %v, expected %v", ut.Name, args, ut.Args)
+		}
+		if !strings.Contains(conn.executed[1].Query, "version = version + 1") {
+			t.Errorf("%s: version of group not incremented: %s", ut.Name, conn.executed[1].Query)
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MemberType represents type of explicit segment members.
+type MemberType string
+
+// Enum of available member types.
+const (
+	MemberUsers    MemberType = "users"
+	MemberBrowsers MemberType = "browsers"
+)
+
+// Enum of member import modes.
+const (
+	ImportModeAdd     = "add"     // members are added to the existing ones
+	ImportModeReplace = "replace" // existing members are replaced by the imported ones
+)
+
+// Enum of member import formats.
+const (
+	ImportFormatCSV    = "csv"    // identifier is read from the first column, optional header is skipped
+	ImportFormatNDJSON = "ndjson" // each line is JSON string or object with the identifier
+)
+
+// Enum of member import statuses.
+const (
+	ImportStatusPending  = "pending"
+	ImportStatusRunning  = "running"
+	ImportStatusFinished = "finished"
+	ImportStatusFailed   = "failed"
+)
+
+// memberBatchSize is the number of members inserted or removed by single query.
+const memberBatchSize = 1000
+
+// memberImportTTL is the duration for which state of finished member import is kept.
+const memberImportTTL = 24 * time.Hour
+
+// MaxImportSize is the highest number of bytes of input accepted by single member import.
+const MaxImportSize = 256 << 20
+
+// importStagingTable is the table members of running imports are staged in before they're moved to the segment.
+const importStagingTable = "segment_import_members"
+
+// ErrSegmentNotExplicit is returned when members of segment evaluated by rules are being changed.
+var ErrSegmentNotExplicit = errors.New("members can be changed only within explicit segments")
+
+// ErrImportTooLarge is returned when input of member import exceeds MaxImportSize.
+var ErrImportTooLarge = errors.New("imported data are too large")
+
+// table returns name of the table storing members of the type.
+func (mt MemberType) table() string {
+	if mt == MemberBrowsers {
+		return "segment_browsers"
+	}
+	return "segment_users"
+}
+
+// column returns name of the column storing identifier of member of the type.
+func (mt MemberType) column() string {
+	if mt == MemberBrowsers {
+		return "browser_id"
+	}
+	return "user_id"
+}
+
+// SegmentMemberImport represents background import of explicit segment members.
+type SegmentMemberImport struct {
+	ID         string
+	SegmentID  int
+	MemberType MemberType
+	Mode       string
+	Format     string
+	Status     string
+	Processed  int     // number of identifiers read so far
+	Changed    int     // number of members added, known once the read identifiers are moved to the segment
+	Progress   float64 // ratio of already processed input, within [0, 1]
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// memberImports holds state of member imports run by the storage. Finished imports are evicted after memberImportTTL.
+type memberImports struct {
+	mu      sync.RWMutex
+	imports map[string]*SegmentMemberImport
+}
+
+// evict removes imports finished before memberImportTTL. Caller is required to hold the write lock.
+func (mi *memberImports) evict(now time.Time) {
+	for id, smi := range mi.imports {
+		if smi.FinishedAt != nil && now.Sub(*smi.FinishedAt) > memberImportTTL {
+			delete(mi.imports, id)
+		}
+	}
+}
+
+// memberRow represents single stored member of explicit segment.
+type memberRow struct {
+	ID     int64  `db:"id"`
+	Member string `db:"member"`
+}
+
+// Members returns page of identifiers of members of explicit segment ordered by the time they were added.
+// Page starts after the provided cursor (the first page is returned if empty) and contains at most limit members.
+// Cursor of the next page is returned too, empty if there are no more members.
+func (sDB *SegmentDB) Members(id int, mt MemberType, cursor string, limit int) ([]string, string, bool, error) {
+	var after int64
+	if cursor != "" {
+		var err error
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil || after < 0 {
+			return nil, "", false, ErrInvalidCursor
+		}
+	}
+	s, ok, err := sDB.explicitSegment(id)
+	if err != nil || !ok {
+		return nil, "", ok, err
+	}
+
+	rows := []memberRow{}
+	query := fmt.Sprintf("SELECT id, %s AS member FROM %s WHERE segment_id = ? AND id > ? ORDER BY id LIMIT ?", mt.column(), mt.table())
+	// one more row is loaded to find out whether there's the next page
+	if err := sDB.MySQL.Select(&rows, query, s.ID, after, limit+1); err != nil {
+		return nil, "", false, errors.Wrap(err, fmt.Sprintf("unable to get %s of segment [%d]", mt, id))
+	}
+
+	var next string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		next = strconv.FormatInt(rows[len(rows)-1].ID, 10)
+	}
+	members := make([]string, 0, len(rows))
+	for _, r := range rows {
+		members = append(members, r.Member)
+	}
+	return members, next, true, nil
+}
+
+// AddMembers adds provided identifiers to members of explicit segment and returns number of added members.
+// Identifiers already present within the segment are skipped.
+func (sDB *SegmentDB) AddMembers(id int, mt MemberType, members []string) (int, bool, error) {
+	return sDB.changeMembers(id, mt, func(tx *sqlx.Tx) (int, error) {
+		return insertMembers(tx, id, mt, members)
+	})
+}
+
+// RemoveMembers removes provided identifiers from members of explicit segment and returns number of removed members.
+func (sDB *SegmentDB) RemoveMembers(id int, mt MemberType, members []string) (int, bool, error) {
+	return sDB.changeMembers(id, mt, func(tx *sqlx.Tx) (int, error) {
+		return deleteMembers(tx, id, mt, members)
+	})
+}
+
+// ReplaceMembers atomically replaces all members of explicit segment by provided identifiers and returns
+// number of stored members.
+func (sDB *SegmentDB) ReplaceMembers(id int, mt MemberType, members []string) (int, bool, error) {
+	return sDB.changeMembers(id, mt, func(tx *sqlx.Tx) (int, error) {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE segment_id = ?", mt.table()), id); err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to remove %s of segment [%d]", mt, id))
+		}
+		return insertMembers(tx, id, mt, members)
+	})
+}
+
+// ImportMembers starts background import of members of explicit segment read from provided input in given
+// format. Input is stored to temporary file before the import starts, so it doesn't have to outlive the call.
+// ErrImportTooLarge is returned if the input exceeds MaxImportSize.
+func (sDB *SegmentDB) ImportMembers(id int, mt MemberType, mode, format string, r io.Reader) (*SegmentMemberImport, bool, error) {
+	if _, ok, err := sDB.explicitSegment(id); err != nil || !ok {
+		return nil, ok, err
+	}
+
+	f, err := ioutil.TempFile("", "beam-segment-import-")
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to create temporary file for import")
+	}
+	size, err := io.Copy(f, io.LimitReader(r, MaxImportSize+1))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil || size > MaxImportSize {
+		f.Close()
+		os.Remove(f.Name())
+		if err != nil {
+			return nil, false, errors.Wrap(err, "unable to store imported data")
+		}
+		return nil, false, errors.Wrap(ErrImportTooLarge, fmt.Sprintf("limit is %d bytes", MaxImportSize))
+	}
+
+	smi := &SegmentMemberImport{
+		ID:         uuid.NewV4().String(),
+		SegmentID:  id,
+		MemberType: mt,
+		Mode:       mode,
+		Format:     format,
+		Status:     ImportStatusPending,
+		CreatedAt:  time.Now(),
+	}
+	sDB.memberImports.mu.Lock()
+	if sDB.memberImports.imports == nil {
+		sDB.memberImports.imports = make(map[string]*SegmentMemberImport)
+	}
+	sDB.memberImports.evict(smi.CreatedAt)
+	sDB.memberImports.imports[smi.ID] = smi
+	sDB.memberImports.mu.Unlock()
+
+	go func() {
+		defer os.Remove(f.Name())
+		defer f.Close()
+		err := sDB.runImport(smi, f, size)
+
+		sDB.memberImports.mu.Lock()
+		defer sDB.memberImports.mu.Unlock()
+		now := time.Now()
+		smi.FinishedAt = &now
+		if err != nil {
+			smi.Status = ImportStatusFailed
+			smi.Error = err.Error()
+			return
+		}
+		smi.Status = ImportStatusFinished
+		smi.Progress = 1
+	}()
+
+	return smi.snapshot(&sDB.memberImports.mu), true, nil
+}
+
+// MemberImport returns current state of member import.
+func (sDB *SegmentDB) MemberImport(importID string) (*SegmentMemberImport, bool) {
+	sDB.memberImports.mu.Lock()
+	sDB.memberImports.evict(time.Now())
+	smi, ok := sDB.memberImports.imports[importID]
+	sDB.memberImports.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return smi.snapshot(&sDB.memberImports.mu), true
+}
+
+// snapshot returns copy of the import safe to be read while the import is still running.
+func (smi *SegmentMemberImport) snapshot(mu *sync.RWMutex) *SegmentMemberImport {
+	mu.RLock()
+	defer mu.RUnlock()
+	s := *smi
+	return &s
+}
+
+// runImport stages members read from provided file in batches committed one by one, so the segment isn't locked
+// while the input is being read. Staged members are moved to the segment within single transaction afterwards,
+// so the import either succeeds as a whole or leaves the segment untouched.
+func (sDB *SegmentDB) runImport(smi *SegmentMemberImport, r io.Reader, size int64) error {
+	sDB.memberImports.mu.Lock()
+	smi.Status = ImportStatusRunning
+	sDB.memberImports.mu.Unlock()
+
+	defer func() {
+		if _, err := sDB.MySQL.Exec(fmt.Sprintf("DELETE FROM %s WHERE import_id = ?", importStagingTable), smi.ID); err != nil {
+			log.Println(errors.Wrap(err, fmt.Sprintf("unable to remove staged members of import [%s]", smi.ID)))
+		}
+	}()
+
+	cr := &countingReader{r: r}
+	err := readMembers(cr, smi.Format, smi.MemberType, memberBatchSize, func(members []string) error {
+		if err := stageMembers(sDB.MySQL, smi.ID, members); err != nil {
+			return err
+		}
+
+		sDB.memberImports.mu.Lock()
+		smi.Processed += len(members)
+		if size > 0 {
+			smi.Progress = float64(cr.n) / float64(size)
+		}
+		sDB.memberImports.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = sDB.changeMembers(smi.SegmentID, smi.MemberType, func(tx *sqlx.Tx) (int, error) {
+		if smi.Mode == ImportModeReplace {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE segment_id = ?", smi.MemberType.table()), smi.SegmentID); err != nil {
+				return 0, errors.Wrap(err, fmt.Sprintf("unable to remove %s of segment [%d]", smi.MemberType, smi.SegmentID))
+			}
+		}
+		changed, err := moveStagedMembers(tx, smi.ID, smi.SegmentID, smi.MemberType)
+		if err != nil {
+			return 0, err
+		}
+
+		sDB.memberImports.mu.Lock()
+		smi.Changed = changed
+		sDB.memberImports.mu.Unlock()
+		return changed, nil
+	})
+	return err
+}
+
+// stageMembers stores provided identifiers read by the import to the staging table.
+func stageMembers(e sqlx.Execer, importID string, members []string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(members))
+	values := make([]interface{}, 0, 2*len(members))
+	for _, m := range members {
+		placeholders = append(placeholders, "(?, ?)")
+		values = append(values, importID, m)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (import_id, member) VALUES %s", importStagingTable, strings.Join(placeholders, ", "))
+	if _, err := e.Exec(query, values...); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to stage members of import [%s]", importID))
+	}
+	return nil
+}
+
+// moveStagedMembers inserts members staged by the import not yet present within the segment and returns number
+// of inserted ones.
+func moveStagedMembers(tx *sqlx.Tx, importID string, id int, mt MemberType) (int, error) {
+	now := time.Now()
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (segment_id, %[2]s, created_at, updated_at)
+		SELECT ?, staged.member, ?, ?
+		FROM (SELECT DISTINCT member FROM %[3]s WHERE import_id = ?) AS staged
+		LEFT JOIN %[1]s AS existing ON existing.segment_id = ? AND existing.%[2]s = staged.member
+		WHERE existing.id IS NULL`, mt.table(), mt.column(), importStagingTable)
+	res, err := tx.Exec(query, id, now, now, importID, id)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("unable to insert imported %s of segment [%d]", mt, id))
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get number of imported members")
+	}
+	return int(n), nil
+}
+
+// changeMembers runs provided change of members of explicit segment within transaction and refreshes
+// cached members of the segment afterwards.
+func (sDB *SegmentDB) changeMembers(id int, mt MemberType, change func(tx *sqlx.Tx) (int, error)) (int, bool, error) {
+	s, ok, err := sDB.explicitSegment(id)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+
+	tx, err := sDB.MySQL.Beginx()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	// lock the segment so concurrent changes of its members are serialized
+	if _, ok, err := lockSegment(tx, id, nil); err != nil || !ok {
+		return 0, ok, err
+	}
+	n, err := change(tx)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, errors.Wrap(err, "unable to commit transaction")
+	}
+
+	if err := sDB.cacheExplicitSegment(s, mt); err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// explicitSegment returns segment based on the given ID or ErrSegmentNotExplicit if the segment is not explicit.
+func (sDB *SegmentDB) explicitSegment(id int) (*Segment, bool, error) {
+	s, ok, err := sDB.GetByID(id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if s.Group.Type != explicitSegmentType {
+		return nil, false, errors.Wrap(ErrSegmentNotExplicit, fmt.Sprintf("segment [%s]", s.Code))
+	}
+	return s, true, nil
+}
+
+// cacheExplicitSegment reloads cached members of single explicit segment.
+func (sDB *SegmentDB) cacheExplicitSegment(s *Segment, mt MemberType) error {
+	// reloads are serialized, so the members loaded here can't be overwritten by older state of all the segments
+	sDB.explicitReloadMu.Lock()
+	defer sDB.explicitReloadMu.Unlock()
+
+	members := []string{}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE segment_id = ?", mt.column(), mt.table())
+	if err := sDB.MySQL.Select(&members, query, s.ID); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to get %s of segment [%d]", mt, s.ID))
+	}
+
+	sDB.setExplicitMembers(s.Code, mt, members)
+	return nil
+}
+
+// setExplicitMembers replaces cached members of single explicit segment. Caches are replaced as a whole,
+// so the sets returned by explicitUsers and explicitBrowsers can be read without locking.
+func (sDB *SegmentDB) setExplicitMembers(code string, mt MemberType, members []string) {
+	sDB.explicitMu.Lock()
+	defer sDB.explicitMu.Unlock()
+
+	if mt == MemberBrowsers {
+		browsers := make(BrowserSet)
+		for _, m := range members {
+			browsers[m] = true
+		}
+		browsersSet := make(map[string]BrowserSet)
+		for c, bs := range sDB.ExplicitSegmentsBrowsers {
+			browsersSet[c] = bs
+		}
+		browsersSet[code] = browsers
+		sDB.ExplicitSegmentsBrowsers = browsersSet
+		return
+	}
+
+	users := make(UserSet)
+	for _, m := range members {
+		users[m] = true
+	}
+	usersSet := make(map[string]UserSet)
+	for c, us := range sDB.ExplicitSegmentsUsers {
+		usersSet[c] = us
+	}
+	usersSet[code] = users
+	sDB.ExplicitSegmentsUsers = usersSet
+}
+
+// insertMembers inserts provided identifiers not yet present within the segment and returns number of inserted ones.
+func insertMembers(tx *sqlx.Tx, id int, mt MemberType, members []string) (int, error) {
+	inserted := 0
+	for start := 0; start < len(members); start += memberBatchSize {
+		end := start + memberBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+		batch := members[start:end]
+
+		query, args, err := sqlx.In(fmt.Sprintf("SELECT %s FROM %s WHERE segment_id = ? AND %s IN (?)", mt.column(), mt.table(), mt.column()), id, batch)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to build query of existing members")
+		}
+		existing := []string{}
+		if err := tx.Select(&existing, query, args...); err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to get existing %s of segment [%d]", mt, id))
+		}
+		skip := make(map[string]bool)
+		for _, e := range existing {
+			skip[e] = true
+		}
+
+		now := time.Now()
+		var placeholders []string
+		var values []interface{}
+		for _, m := range batch {
+			if skip[m] || m == "" {
+				continue
+			}
+			skip[m] = true
+			placeholders = append(placeholders, "(?, ?, ?, ?)")
+			values = append(values, id, m, now, now)
+		}
+		if len(placeholders) == 0 {
+			continue
+		}
+		query = fmt.Sprintf("INSERT INTO %s (segment_id, %s, created_at, updated_at) VALUES %s", mt.table(), mt.column(), strings.Join(placeholders, ", "))
+		if _, err := tx.Exec(query, values...); err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to insert %s of segment [%d]", mt, id))
+		}
+		inserted += len(placeholders)
+	}
+	return inserted, nil
+}
+
+// deleteMembers deletes provided identifiers from the segment and returns number of deleted ones.
+func deleteMembers(tx *sqlx.Tx, id int, mt MemberType, members []string) (int, error) {
+	deleted := 0
+	for start := 0; start < len(members); start += memberBatchSize {
+		end := start + memberBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+		query, args, err := sqlx.In(fmt.Sprintf("DELETE FROM %s WHERE segment_id = ? AND %s IN (?)", mt.table(), mt.column()), id, members[start:end])
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to build query of removed members")
+		}
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return 0, errors.Wrap(err, fmt.Sprintf("unable to remove %s of segment [%d]", mt, id))
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to get number of removed members")
+		}
+		deleted += int(n)
+	}
+	return deleted, nil
+}
+
+// readMembers reads member identifiers from input in provided format and passes them to fn in batches.
+func readMembers(r io.Reader, format string, mt MemberType, batchSize int, fn func([]string) error) error {
+	batch := make([]string, 0, batchSize)
+	add := func(member string) error {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			return nil
+		}
+		batch = append(batch, member)
+		if len(batch) < batchSize {
+			return nil
+		}
+		err := fn(batch)
+		batch = make([]string, 0, batchSize)
+		return err
+	}
+
+	switch format {
+	case ImportFormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		for line := 1; ; line++ {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrap(err, "unable to read CSV")
+			}
+			// header is allowed only on the first line
+			if line == 1 && isMemberHeader(record[0], mt) {
+				continue
+			}
+			if err := add(record[0]); err != nil {
+				return err
+			}
+		}
+	case ImportFormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			raw := strings.TrimSpace(scanner.Text())
+			if raw == "" {
+				continue
+			}
+			member, err := ndjsonMember([]byte(raw), mt)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to read NDJSON line %d", line))
+			}
+			if err := add(member); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return errors.Wrap(err, "unable to read NDJSON")
+		}
+	default:
+		return fmt.Errorf("unknown import format [%s]", format)
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// isMemberHeader returns true if provided value is the name of CSV column with identifier of member.
+func isMemberHeader(value string, mt MemberType) bool {
+	value = strings.TrimSpace(value)
+	return value == mt.column() || value == "id"
+}
+
+// ndjsonMember reads identifier of member from single NDJSON line. Line is either JSON string or object
+// with the identifier stored under column name of the member type (e.g. user_id) or "id".
+func ndjsonMember(raw []byte, mt MemberType) (string, error) {
+	var member string
+	if err := json.Unmarshal(raw, &member); err == nil {
+		return member, nil
+	}
+	// numeric identifiers are kept as they are, float64 would lose precision of the large ones
+	var obj map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		return "", errors.New("JSON string or object expected")
+	}
+	for _, key := range []string{mt.column(), "id"} {
+		switch v := obj[key].(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		}
+	}
+	return "", fmt.Errorf("object without [%s] key", mt.column())
+}
+
+// countingReader counts bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader and counts the bytes read.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
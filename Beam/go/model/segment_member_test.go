@@ -0,0 +1,218 @@
+package model
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadMembers(t *testing.T) {
+	cases := []struct {
+		name      string
+		input     string
+		format    string
+		mt        MemberType
+		expected  [][]string
+		expectErr bool
+	}{
+		{
+			name:     "csv with header",
+			input:    "user_id,email\n1,a@example.com\n2,b@example.com\n3\n",
+			format:   ImportFormatCSV,
+			mt:       MemberUsers,
+			expected: [][]string{{"1", "2"}, {"3"}},
+		},
+		{
+			name:     "csv without header",
+			input:    "abc\n\ndef\n",
+			format:   ImportFormatCSV,
+			mt:       MemberBrowsers,
+			expected: [][]string{{"abc", "def"}},
+		},
+		{
+			name:     "ndjson strings and objects",
+			input:    "\"abc\"\n{\"browser_id\": \"def\"}\n\n{\"id\": 15}\n",
+			format:   ImportFormatNDJSON,
+			mt:       MemberBrowsers,
+			expected: [][]string{{"abc", "def"}, {"15"}},
+		},
+		{
+			name:     "ndjson large numeric identifier",
+			input:    "{\"user_id\": 9007199254740993}\n",
+			format:   ImportFormatNDJSON,
+			mt:       MemberUsers,
+			expected: [][]string{{"9007199254740993"}},
+		},
+		{
+			name:      "ndjson object without identifier",
+			input:     "{\"email\": \"a@example.com\"}\n",
+			format:    ImportFormatNDJSON,
+			mt:        MemberUsers,
+			expectErr: true,
+		},
+		{
+			name:      "unknown format",
+			input:     "1\n",
+			format:    "xml",
+			mt:        MemberUsers,
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		var batches [][]string
+		err := readMembers(strings.NewReader(c.input), c.format, c.mt, 2, func(members []string) error {
+			batches = append(batches, members)
+			return nil
+		})
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, none returned", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error %s even when none was expected", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(batches, c.expected) {
+			t.Errorf("%s: returned batches %v, expected %v", c.name, batches, c.expected)
+		}
+	}
+}
+
+func TestSegmentDB_RunImport(t *testing.T) {
+	segment := append(segmentRow(7, "newsletter", 1), "explicit")
+	columns := append(append([]string{}, segmentColumns...), "segment_group.type")
+	segment[5] = nil // explicit segment without criteria
+	db, conn := newScriptedDB(
+		scriptedStatement{Query: "INSERT INTO segment_import_members (import_id, member) VALUES (?, ?), (?, ?), (?, ?)"},
+		scriptedStatement{Query: "SELECT segments.*", Columns: columns, Rows: [][]driver.Value{segment}},
+		scriptedStatement{Query: "SELECT * FROM segment_rules"},
+		scriptedStatement{Query: "SELECT * FROM segments WHERE id = ? AND deleted_at IS NULL FOR UPDATE", Columns: segmentColumns, Rows: [][]driver.Value{segmentRow(7, "newsletter", 1)}},
+		scriptedStatement{Query: "DELETE FROM segment_users WHERE segment_id = ?"},
+		scriptedStatement{Query: "INSERT INTO segment_users (segment_id, user_id, created_at, updated_at) SELECT"},
+		scriptedStatement{Query: "SELECT user_id FROM segment_users", Columns: []string{"user_id"}, Rows: [][]driver.Value{{"1"}, {"2"}, {"3"}}},
+		scriptedStatement{Query: "DELETE FROM segment_import_members WHERE import_id = ?"},
+	)
+	sDB := &SegmentDB{MySQL: db}
+
+	input := strings.NewReader("user_id\n1\n2\n3\n")
+	smi := &SegmentMemberImport{ID: "import", SegmentID: 7, MemberType: MemberUsers, Mode: ImportModeReplace, Format: ImportFormatCSV}
+
+	if err := sDB.runImport(smi, input, 0); err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+	if len(conn.script) > 0 {
+		t.Errorf("statements not executed: %v", conn.script)
+	}
+	if !conn.committed {
+		t.Errorf("import not committed")
+	}
+	if smi.Processed != 3 {
+		t.Errorf("processed %d members, expected 3", smi.Processed)
+	}
+	if us, _ := sDB.explicitUsers("newsletter"); len(us) != 3 {
+		t.Errorf("cached %d members, expected 3", len(us))
+	}
+}
+
+func TestSegmentDB_Members(t *testing.T) {
+	segment := append(segmentRow(7, "newsletter", 1), "explicit")
+	columns := append(append([]string{}, segmentColumns...), "segment_group.type")
+	segment[5] = nil
+	script := func(rows ...[]driver.Value) []scriptedStatement {
+		return []scriptedStatement{
+			{Query: "SELECT segments.*", Columns: columns, Rows: [][]driver.Value{segment}},
+			{Query: "SELECT * FROM segment_rules"},
+			{Query: "SELECT id, user_id AS member FROM segment_users", Columns: []string{"id", "member"}, Rows: rows},
+		}
+	}
+
+	var membersTests = []struct {
+		Name    string
+		Cursor  string
+		Rows    [][]driver.Value
+		Members []string
+		Next    string
+		Err     error
+	}{
+		{"first page", "", [][]driver.Value{{int64(3), "a"}, {int64(5), "b"}, {int64(9), "c"}}, []string{"a", "b"}, "5", nil},
+		{"last page", "5", [][]driver.Value{{int64(9), "c"}}, []string{"c"}, "", nil},
+		{"invalid cursor", "abc", nil, nil, "", ErrInvalidCursor},
+	}
+
+	for _, mt := range membersTests {
+		var statements []scriptedStatement
+		if mt.Err == nil {
+			statements = script(mt.Rows...)
+		}
+		db, conn := newScriptedDB(statements...)
+		sDB := &SegmentDB{MySQL: db}
+
+		members, next, _, err := sDB.Members(7, MemberUsers, mt.Cursor, 2)
+		if err != mt.Err {
+			t.Errorf("%s: returned error %v, expected %v", mt.Name, err, mt.Err)
+			continue
+		}
+		if !reflect.DeepEqual(members, mt.Members) || next != mt.Next {
+			t.Errorf("%s: returned %v with cursor %q, expected %v with cursor %q", mt.Name, members, next, mt.Members, mt.Next)
+		}
+		if len(conn.script) > 0 {
+			t.Errorf("%s: statements not executed: %v", mt.Name, conn.script)
+		}
+	}
+}
+
+func TestMemberImports_Evict(t *testing.T) {
+	now := time.Now()
+	finished := now.Add(-memberImportTTL - time.Minute)
+	recent := now.Add(-time.Minute)
+	mi := memberImports{imports: map[string]*SegmentMemberImport{
+		"running":  {ID: "running", CreatedAt: finished},
+		"expired":  {ID: "expired", FinishedAt: &finished},
+		"finished": {ID: "finished", FinishedAt: &recent},
+	}}
+
+	mi.evict(now)
+
+	var kept []string
+	for id := range mi.imports {
+		kept = append(kept, id)
+	}
+	sort.Strings(kept)
+	if !reflect.DeepEqual(kept, []string{"finished", "running"}) {
+		t.Errorf("kept imports %v, expected [finished running]", kept)
+	}
+}
+
+func TestSegmentDB_ExplicitUsersConcurrentReload(t *testing.T) {
+	sDB := &SegmentDB{
+		ExplicitSegmentsUsers: map[string]UserSet{"a": {"1": true}},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if us, ok := sDB.explicitUsers("a"); !ok || !us["1"] {
+					t.Errorf("cached users of segment lost during reload")
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		sDB.setExplicitMembers("b", MemberUsers, []string{"2"})
+	}
+	wg.Wait()
+
+	if us, _ := sDB.explicitUsers("b"); !us["2"] {
+		t.Errorf("reloaded users of segment not cached")
+	}
+}
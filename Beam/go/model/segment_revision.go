@@ -0,0 +1,208 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SegmentRevision represents immutable snapshot of segment stored with each of its changes.
+type SegmentRevision struct {
+	ID             int
+	SegmentID      int `db:"segment_id"`
+	Revision       int // sequence number of revision within the segment, starting with 1
+	Name           string
+	SegmentGroupID int `db:"segment_group_id"`
+	Criteria       sql.NullString
+	Author         sql.NullString
+	RollbackOf     sql.NullInt64 `db:"rollback_of"` // revision the segment was rolled back to
+	CreatedAt      time.Time     `db:"created_at"`
+}
+
+// SegmentRevisionCollection is list of SegmentRevisions.
+type SegmentRevisionCollection []*SegmentRevision
+
+// SegmentRevisionChange represents single value changed between two revisions. Old or New value
+// is nil if the value was added or removed.
+type SegmentRevisionChange struct {
+	Path string // path to the changed value, e.g. criteria.nodes[0].nodes[1].values.count.gte
+	Old  interface{}
+	New  interface{}
+}
+
+// SegmentRevisionDiff represents all the changes between two revisions of segment.
+type SegmentRevisionDiff struct {
+	From    int
+	To      int
+	Changes []SegmentRevisionChange
+}
+
+// Revisions returns all revisions of segment ordered from the oldest one.
+func (sDB *SegmentDB) Revisions(id int) (SegmentRevisionCollection, bool, error) {
+	_, ok, err := sDB.GetByID(id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	src := SegmentRevisionCollection{}
+	err = sDB.MySQL.Select(&src, "SELECT * FROM segment_revisions WHERE segment_id = ? ORDER BY revision", id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, errors.Wrap(err, fmt.Sprintf("unable to get revisions of segment [%d]", id))
+	}
+	return src, true, nil
+}
+
+// Revision returns single revision of segment.
+func (sDB *SegmentDB) Revision(id, revision int) (*SegmentRevision, bool, error) {
+	sr := &SegmentRevision{}
+	err := sDB.MySQL.Get(sr, "SELECT * FROM segment_revisions WHERE segment_id = ? AND revision = ?", id, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrap(err, fmt.Sprintf("unable to get revision [%d] of segment [%d]", revision, id))
+	}
+	return sr, true, nil
+}
+
+// Rollback restores segment to provided revision, stores it as a new revision and returns the segment.
+func (sDB *SegmentDB) Rollback(id, revision int, author string) (*Segment, bool, error) {
+	s, ok, err := sDB.GetByID(id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	sr, ok, err := sDB.Revision(id, revision)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	sd := SegmentData{
+		Name:           sr.Name,
+		Active:         s.Active,
+		SegmentGroupID: sr.SegmentGroupID,
+		Criteria:       sr.Criteria,
+	}
+	return sDB.update(id, sd, author, &revision, nil)
+}
+
+// storeRevision stores current state of the segment as its new revision.
+func storeRevision(e sqlx.Execer, id int, author string, rollbackOf *int) error {
+	_, err := e.Exec(`
+		INSERT INTO segment_revisions (segment_id, revision, name, segment_group_id, criteria, author, rollback_of, created_at)
+		SELECT
+			id,
+			(SELECT COALESCE(MAX(revision), 0) + 1 FROM segment_revisions WHERE segment_id = ?),
+			name, segment_group_id, criteria, ?, ?, ?
+		FROM segments
+		WHERE id = ?
+		`,
+		id,
+		sql.NullString{String: author, Valid: author != ""},
+		rollbackOf,
+		time.Now(),
+		id,
+	)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to store revision of segment [%d]", id))
+	}
+	return nil
+}
+
+// storeInitialRevision stores current state of the segment as its first revision if the segment has no revisions.
+func storeInitialRevision(e sqlx.Execer, s *Segment) error {
+	_, err := e.Exec(`
+		INSERT INTO segment_revisions (segment_id, revision, name, segment_group_id, criteria, author, rollback_of, created_at)
+		SELECT id, 1, name, segment_group_id, criteria, NULL, NULL, updated_at
+		FROM segments
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM segment_revisions WHERE segment_id = ?)
+		`,
+		s.ID,
+		s.ID,
+	)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to store initial revision of segment [%d]", s.ID))
+	}
+	return nil
+}
+
+// DiffRevisions returns all the values changed between provided revisions of segment.
+func DiffRevisions(from, to *SegmentRevision) (*SegmentRevisionDiff, error) {
+	fromValues, err := from.values()
+	if err != nil {
+		return nil, err
+	}
+	toValues, err := to.values()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for path := range fromValues {
+		paths[path] = true
+	}
+	for path := range toValues {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	srd := &SegmentRevisionDiff{
+		From:    from.Revision,
+		To:      to.Revision,
+		Changes: []SegmentRevisionChange{},
+	}
+	for _, path := range sorted {
+		if reflect.DeepEqual(fromValues[path], toValues[path]) {
+			continue
+		}
+		srd.Changes = append(srd.Changes, SegmentRevisionChange{
+			Path: path,
+			Old:  fromValues[path],
+			New:  toValues[path],
+		})
+	}
+	return srd, nil
+}
+
+// values returns all the values of revision indexed by their path.
+func (sr *SegmentRevision) values() (map[string]interface{}, error) {
+	values := map[string]interface{}{
+		"name":             sr.Name,
+		"segment_group_id": float64(sr.SegmentGroupID),
+	}
+	if !sr.Criteria.Valid {
+		return values, nil
+	}
+
+	var criteria interface{}
+	if err := json.Unmarshal([]byte(sr.Criteria.String), &criteria); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to unmarshal criteria of revision [%d]", sr.Revision))
+	}
+	flattenValues(values, "criteria", criteria)
+	return values, nil
+}
+
+// flattenValues stores all the scalar values nested within provided value indexed by their path.
+func flattenValues(values map[string]interface{}, path string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			flattenValues(values, fmt.Sprintf("%s.%s", path, key), val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			flattenValues(values, fmt.Sprintf("%s[%d]", path, i), val)
+		}
+	default:
+		values[path] = v
+	}
+}
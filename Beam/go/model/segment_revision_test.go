@@ -0,0 +1,53 @@
+package model
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	from := &SegmentRevision{
+		Revision:       1,
+		Name:           "Readers",
+		SegmentGroupID: 1,
+		Criteria: sql.NullString{
+			String: `{"version": "1", "nodes": [{"type": "operator", "operator": "AND", "nodes": [{"type": "criteria", "key": "pageview", "values": {"count": {"gte": 5}, "action": "load"}}]}]}`,
+			Valid:  true,
+		},
+	}
+	to := &SegmentRevision{
+		Revision:       3,
+		Name:           "Loyal readers",
+		SegmentGroupID: 1,
+		Criteria: sql.NullString{
+			String: `{"version": "1", "nodes": [{"type": "operator", "operator": "AND", "nodes": [{"type": "criteria", "key": "pageview", "values": {"count": {"gte": 10}, "action": "load", "is_article": true}}]}]}`,
+			Valid:  true,
+		},
+	}
+
+	srd, err := DiffRevisions(from, to)
+	if err != nil {
+		t.Fatalf("returned error %s even when none was expected", err)
+	}
+	if srd.From != 1 || srd.To != 3 {
+		t.Errorf("returned diff of revisions %d..%d, expected 1..3", srd.From, srd.To)
+	}
+
+	expected := []SegmentRevisionChange{
+		{"criteria.nodes[0].nodes[0].values.count.gte", float64(5), float64(10)},
+		{"criteria.nodes[0].nodes[0].values.is_article", nil, true},
+		{"name", "Readers", "Loyal readers"},
+	}
+	if !reflect.DeepEqual(srd.Changes, expected) {
+		t.Errorf("returned changes %v, expected %v", srd.Changes, expected)
+	}
+
+	srd, err = DiffRevisions(from, from)
+	if err != nil {
+		t.Fatalf("returned error %s even when none was expected", err)
+	}
+	if len(srd.Changes) != 0 {
+		t.Errorf("returned changes %v of the same revision, expected none", srd.Changes)
+	}
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// CategoryRFM identifies SegmentRules evaluating RFM scores of users instead of tracked events.
+const CategoryRFM = "rfm"
+
+// SegmentRuleRFM represents conditions of RFM scores evaluated by SegmentRule.
+type SegmentRuleRFM struct {
+	Segments  []string // RFM segments user needs to belong to (any of them), empty for any segment
+	Recency   int      // minimal recency score, zero for any
+	Frequency int      // minimal frequency score, zero for any
+	Monetary  int      // minimal monetary score, zero for any
+}
+
+// validate checks whether the RFM conditions can be evaluated.
+func (sr *SegmentRuleRFM) validate() error {
+	for _, s := range sr.Segments {
+		known := false
+		for _, rs := range RFMSegments {
+			if s == rs {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown RFM segment [%s]", s)
+		}
+	}
+	for _, score := range []int{sr.Recency, sr.Frequency, sr.Monetary} {
+		if score < 0 || score > rfmQuantiles {
+			return fmt.Errorf("RFM score needs to be within [0, %d]", rfmQuantiles)
+		}
+	}
+	return nil
+}
+
+// match returns true if provided RFM scores match the conditions.
+func (sr *SegmentRuleRFM) match(rfm *RFM) bool {
+	if rfm == nil {
+		return false
+	}
+	if rfm.Recency < sr.Recency || rfm.Frequency < sr.Frequency || rfm.Monetary < sr.Monetary {
+		return false
+	}
+	if len(sr.Segments) == 0 {
+		return true
+	}
+	for _, s := range sr.Segments {
+		if s == rfm.Segment {
+			return true
+		}
+	}
+	return false
+}
+
+// definitionKey returns key identifying the RFM conditions.
+func (sr *SegmentRuleRFM) definitionKey() string {
+	if sr == nil {
+		return ""
+	}
+	segments := append([]string{}, sr.Segments...)
+	sort.Strings(segments)
+	return fmt.Sprintf("%v:%d:%d:%d", segments, sr.Recency, sr.Frequency, sr.Monetary)
+}
+
+// rfmUsers returns identifiers of users RFM rule should be evaluated for based on the first tag of options.GroupBy
+// and user filter of options. RFM scores are available only for users, other identifiers never match.
+func (sDB *SegmentDB) rfmUsers(options AggregateOptions) ([]string, bool, error) {
+	if sDB.RFMStorage == nil {
+		return nil, false, errors.New("RFM storage is not configured")
+	}
+	if len(options.GroupBy) > 1 || len(options.GroupBy) == 1 && options.GroupBy[0] != "user_id" {
+		return nil, false, nil
+	}
+
+	var users []string
+	filtered := false
+	excluded := make(map[string]bool)
+	for _, fb := range options.FilterBy {
+		switch fb.Tag {
+		case "user_id":
+			switch fb.Operator {
+			case "", FilterIn:
+				users = append(users, fb.Values...)
+				filtered = true
+			case FilterNotIn:
+				for _, val := range fb.Values {
+					excluded[val] = true
+				}
+			default:
+				return nil, false, fmt.Errorf("unsupported filter of RFM users: %s", fb.Operator)
+			}
+		case "browser_id":
+			return nil, false, nil
+		}
+	}
+	if !filtered {
+		var err error
+		users, err = sDB.RFMStorage.Users()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(excluded) == 0 {
+		return users, true, nil
+	}
+
+	var included []string
+	for _, userID := range users {
+		if !excluded[userID] {
+			included = append(included, userID)
+		}
+	}
+	return included, true, nil
+}
+
+// rfmValues evaluates RFM rule for each user; value of the row is 1 if the user matches the conditions and 0 otherwise.
+func (sDB *SegmentDB) rfmValues(sr *SegmentRule, options AggregateOptions) ([]ruleValueRow, bool, error) {
+	if sr.RFM == nil {
+		return nil, false, errors.New("RFM rule is missing RFM conditions")
+	}
+	users, ok, err := sDB.rfmUsers(options)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	rvc := []ruleValueRow{}
+	for _, userID := range users {
+		rfm, ok, err := sDB.RFMStorage.Get(userID)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			continue
+		}
+		var value float64
+		if sr.RFM.match(rfm) {
+			value = 1
+		}
+		tags := make(map[string]string)
+		if len(options.GroupBy) > 0 {
+			tags["user_id"] = userID
+		}
+		rvc = append(rvc, ruleValueRow{Tags: tags, Value: value})
+	}
+	return rvc, true, nil
+}
+
+// rfmCount returns scored users as rows with count of one, following options.Page (or its partition) if provided.
+// RFM rule candidates are paginated this way.
+func (sDB *SegmentDB) rfmCount(options AggregateOptions) (CountRowCollection, bool, error) {
+	users, ok, err := sDB.rfmUsers(options)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	sort.Strings(users)
+
+	if options.Page != nil && options.Page.Partitions > 0 {
+		var partition []string
+		for _, userID := range users {
+			if options.Page.inPartition(userID) {
+				partition = append(partition, userID)
+			}
+		}
+		users = partition
+	} else if options.Page != nil {
+		after := options.Page.After["user_id"]
+		start := sort.SearchStrings(users, after)
+		if start < len(users) && users[start] == after {
+			start++
+		}
+		users = users[start:]
+	}
+	if options.Page != nil && len(users) > options.Page.Size {
+		users = users[:options.Page.Size]
+	}
+
+	crc := CountRowCollection{}
+	for _, userID := range users {
+		tags := make(map[string]string)
+		if len(options.GroupBy) > 0 {
+			tags["user_id"] = userID
+		}
+		crc = append(crc, CountRow{Tags: tags, Count: 1})
+	}
+	return crc, true, nil
+}
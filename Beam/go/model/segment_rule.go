@@ -8,37 +8,103 @@ import (
 	"hash/fnv"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 )
 
+// Enumerated aggregate functions of value-aggregate SegmentRules. Rules without aggregate function compare number of events.
+const (
+	AggregateSum      = "sum"
+	AggregateAvg      = "avg"
+	AggregateDistinct = "distinct"
+)
+
 // SegmentRule represent single rule of a Segment
 type SegmentRule struct {
-	ID            int
-	ParentID      sql.NullInt64 `db:"parent_id"`
-	SegmentID     int           `db:"segment_id"`
-	EventCategory string        `db:"event_category"`
-	EventAction   string        `db:"event_action"`
-	Timespan      sql.NullInt64
-	Operator      string
-	Operator2     *string
-	Count         int
-	Count2        *int
-	CreatedAt     time.Time `db:"created_at"`
-	UpdatedAt     time.Time `db:"updated_at"`
-	Fields        JSONMap
-	Flags         JSONMap
+	ID             int
+	ParentID       sql.NullInt64 `db:"parent_id"`
+	SegmentID      int           `db:"segment_id"`
+	EventCategory  string        `db:"event_category"`
+	EventAction    string        `db:"event_action"`
+	Timespan       sql.NullInt64 // minutes ago the window starts
+	TimespanEnd    sql.NullInt64 `db:"timespan_end"` // minutes ago the window ends
+	TimeAfter      *time.Time    `db:"time_after"`   // absolute start of the window
+	TimeBefore     *time.Time    `db:"time_before"`  // absolute end of the window
+	Operator       string
+	Operator2      *string
+	Count          int
+	Count2         *int
+	Aggregate      string               // aggregate function of value-aggregate rule, empty for rules comparing event counts
+	AggregateField string               `db:"aggregate_field"` // field aggregated by distinct value-aggregate rule
+	Value          float64              // threshold of value-aggregate rule
+	Value2         *float64             // second threshold of value-aggregate rule
+	Sequence       *SegmentRuleSequence `db:"-"` // sequence of events evaluated by rule, sequence rules are built only from criteria
+	RFM            *SegmentRuleRFM      `db:"-"` // RFM scores conditions evaluated by rule, RFM rules are built only from criteria
+	CreatedAt      time.Time            `db:"created_at"`
+	UpdatedAt      time.Time            `db:"updated_at"`
+	Fields         JSONMap
+	Flags          JSONMap
+	Overridable    OverridableParams // params of rule which can be overridden via RuleOverrides
 
 	Segment *Segment `db:"segment"`
-
-	cacheKey int
 }
 
-// RuleOverrides represent key-value string pairs for overriding stored tags in segment rules.
+// Enumerated params of SegmentRule which can be marked as overridable.
+const (
+	OverridableCount    = "count"
+	OverridableOperator = "operator"
+	OverridableTimespan = "timespan"
+)
+
+// RuleOverrides represent key-value string pairs for overriding stored tags in segment rules and optional
+// overrides of thresholds and timespan of rules marking these params as overridable.
 type RuleOverrides struct {
-	Fields map[string]string
+	Fields   map[string]string
+	Count    *int     // overrides count (or value threshold) of rule
+	Value    *float64 // overrides value threshold of value-aggregate rule, takes precedence over Count
+	Operator *string  // overrides operator of rule
+	Timespan *int     // overrides minutes ago the window starts
+}
+
+// OverridableParams represents list of overridable params stored as string JSON ["count", "timespan"].
+type OverridableParams []string
+
+// Value returns JSON-encoded value of OverridableParams.
+func (op OverridableParams) Value() (driver.Value, error) {
+	if op == nil {
+		return nil, nil
+	}
+	return json.Marshal(op)
+}
+
+// Scan populates OverridableParams based on scanned value.
+func (op *OverridableParams) Scan(src interface{}) error {
+	if src == nil {
+		*op = nil
+		return nil
+	}
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("unable to scan OverridableParams: type assertion .([]byte) failed")
+	}
+	err := json.Unmarshal(source, op)
+	if err != nil {
+		return errors.Wrap(err, "unable to unmarshal OverridableParams")
+	}
+	return nil
+}
+
+// Has returns true if provided param is overridable.
+func (op OverridableParams) Has(param string) bool {
+	for _, p := range op {
+		if p == param {
+			return true
+		}
+	}
+	return false
 }
 
 // JSONMap represents key-value string pairs stored as string JSON [{"key": "foo", "value": "bar"}].
@@ -70,6 +136,10 @@ func (jm *JSONMap) Scan(src interface{}) error {
 
 // CacheDuration returns duration to cache the item for and whether the item should be cached at all.
 func (sr *SegmentRule) CacheDuration(count int) (time.Duration, bool) {
+	if sr.isValueAggregate() {
+		// cached counts can't be used to evaluate aggregated values
+		return 0, false
+	}
 	var d time.Duration
 	switch sr.Operator {
 	case "<=", ">=":
@@ -95,19 +165,27 @@ func (sr *SegmentRule) CacheDuration(count int) (time.Duration, bool) {
 
 // Evaluate evaluates segment rule condition against provided count.
 func (sr *SegmentRule) Evaluate(count int) (bool, error) {
+	return sr.EvaluateValue(float64(count))
+}
+
+// EvaluateValue evaluates segment rule condition against provided value. Event count rules compare
+// the value against their counts, value-aggregate rules against their value thresholds.
+func (sr *SegmentRule) EvaluateValue(value float64) (bool, error) {
+	against, against2 := sr.thresholds()
+
 	// only one count provided
-	if sr.Operator2 == nil && sr.Count2 == nil {
-		return evaluate(sr.Operator, count, sr.Count)
+	if sr.Operator2 == nil && against2 == nil {
+		return evaluate(sr.Operator, value, against)
 	}
 
 	// two counts & operators provided
-	if sr.Operator2 != nil && sr.Count2 != nil {
-		first, err := evaluate(sr.Operator, count, sr.Count)
+	if sr.Operator2 != nil && against2 != nil {
+		first, err := evaluate(sr.Operator, value, against)
 		if err != nil {
 			return false, err
 		}
 
-		second, err := evaluate(*sr.Operator2, count, *sr.Count2)
+		second, err := evaluate(*sr.Operator2, value, *against2)
 		if err != nil {
 			return false, err
 		}
@@ -119,10 +197,23 @@ func (sr *SegmentRule) Evaluate(count int) (bool, error) {
 	return false, fmt.Errorf("unable to evaluate multiple operators and counts: missing second operator or count")
 }
 
+// thresholds returns values the rule compares counted (or aggregated) value against.
+func (sr *SegmentRule) thresholds() (float64, *float64) {
+	if sr.isValueAggregate() {
+		return sr.Value, sr.Value2
+	}
+	var against2 *float64
+	if sr.Count2 != nil {
+		c2 := float64(*sr.Count2)
+		against2 = &c2
+	}
+	return float64(sr.Count), against2
+}
+
 // evaluate returns result of comparision.
 // Formula is {checkCount} {operator} {against}.
 // Eg. evaluate("<=", 10, 20) will return true as result of (10 <= 20).
-func evaluate(operator string, checkCount, checkAgainst int) (bool, error) {
+func evaluate(operator string, checkCount, checkAgainst float64) (bool, error) {
 	switch operator {
 	case "<=":
 		return checkCount <= checkAgainst, nil
@@ -139,7 +230,8 @@ func evaluate(operator string, checkCount, checkAgainst int) (bool, error) {
 	}
 }
 
-// applyOverrides overrides field values based on provided RuleOverrides.
+// applyOverrides overrides field values based on provided RuleOverrides. Count (or value), operator and timespan
+// are overridden only if the rule marks them as overridable.
 func (sr SegmentRule) applyOverrides(o RuleOverrides) *SegmentRule {
 	overridable := make(map[string]bool)
 	for _, f := range sr.overridableFields() {
@@ -154,12 +246,47 @@ func (sr SegmentRule) applyOverrides(o RuleOverrides) *SegmentRule {
 		if _, ok := overridable[def["key"]]; ok {
 			v = o.Fields[def["key"]]
 		}
-		newFields = append(newFields, map[string]string{
+		nd := map[string]string{
 			"key":   k,
 			"value": v,
-		})
+		}
+		if op, ok := def["operator"]; ok {
+			nd["operator"] = op
+		}
+		newFields = append(newFields, nd)
 	}
 	sr.Fields = newFields
+
+	// flags without value (e.g. utm_campaign of match_campaign) are bound to the field values provided at check time
+	newFlags := make(JSONMap, 0, len(sr.Flags))
+	for _, def := range sr.Flags {
+		v := def["value"]
+		if v == "" {
+			v = o.Fields[def["key"]]
+		}
+		newFlags = append(newFlags, map[string]string{
+			"key":   def["key"],
+			"value": v,
+		})
+	}
+	sr.Flags = newFlags
+
+	if o.Count != nil && sr.Overridable.Has(OverridableCount) {
+		sr.Count = *o.Count
+		sr.Value = float64(*o.Count)
+	}
+	if o.Value != nil && sr.Overridable.Has(OverridableCount) && sr.isValueAggregate() {
+		sr.Value = *o.Value
+	}
+	if o.Operator != nil && sr.Overridable.Has(OverridableOperator) {
+		sr.Operator = *o.Operator
+	}
+	if o.Timespan != nil && sr.Overridable.Has(OverridableTimespan) {
+		sr.Timespan = sql.NullInt64{
+			Int64: int64(*o.Timespan),
+			Valid: true,
+		}
+	}
 	return &sr
 }
 
@@ -178,19 +305,65 @@ func (sr *SegmentRule) options(now time.Time, o RuleOverrides) AggregateOptions
 	}
 
 	for _, def := range sr.Fields {
-		if def["key"] == "" || def["value"] == "" {
-			continue
+		if fb := fieldFilter(def); fb != nil {
+			options.FilterBy = append(options.FilterBy, fb)
 		}
-		options.FilterBy = append(options.FilterBy, &FilterBy{Tag: def["key"], Values: []string{def["value"]}})
 	}
 
-	if sr.Timespan.Valid {
-		t := now.Add(time.Minute * time.Duration(int(sr.Timespan.Int64)*-1))
-		options.TimeAfter = t
+	// flags are filtered the same way as fields, flags without (bound) value don't filter the data
+	flags := sr.flags()
+	keys := make([]string, 0, len(flags))
+	for key := range flags {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		options.FilterBy = append(options.FilterBy, &FilterBy{Tag: key, Values: []string{flags[key]}})
+	}
+
+	options.TimeAfter, options.TimeBefore = sr.window(now)
 	return options
 }
 
+// fieldFilter returns filter of the rule field definition. Field can optionally define filter operator,
+// values are matched exactly otherwise. Nil is returned for fields without (bound) value.
+func fieldFilter(def map[string]string) *FilterBy {
+	fb := &FilterBy{
+		Tag:      def["key"],
+		Operator: def["operator"],
+	}
+	if def["value"] != "" {
+		fb.Values = []string{def["value"]}
+	}
+	if fb.Tag == "" || len(fb.Values) == 0 && !fb.valueless() {
+		return nil
+	}
+	return fb
+}
+
+// window returns bounds of time window the events are counted within. Relative bounds are resolved against
+// provided time; if both relative and absolute bound is set, the more restrictive one is used. Zero time
+// represents unbounded side of the window.
+func (sr *SegmentRule) window(now time.Time) (time.Time, time.Time) {
+	var after, before time.Time
+
+	if sr.Timespan.Valid {
+		after = now.Add(time.Minute * time.Duration(int(sr.Timespan.Int64)*-1))
+	}
+	if sr.TimeAfter != nil && sr.TimeAfter.After(after) {
+		after = *sr.TimeAfter
+	}
+
+	if sr.TimespanEnd.Valid {
+		before = now.Add(time.Minute * time.Duration(int(sr.TimespanEnd.Int64)*-1))
+	}
+	if sr.TimeBefore != nil && (before.IsZero() || sr.TimeBefore.Before(before)) {
+		before = *sr.TimeBefore
+	}
+
+	return after, before
+}
+
 func (sr *SegmentRule) groups() []string {
 	var conds []string
 	for _, def := range sr.Flags {
@@ -218,7 +391,7 @@ func (sr *SegmentRule) tableName() string {
 func (sr *SegmentRule) overridableFields() []string {
 	fields := []string{}
 	for _, def := range sr.Fields {
-		if def["value"] != "" {
+		if def["value"] != "" || (&FilterBy{Operator: def["operator"]}).valueless() {
 			continue
 		}
 		fields = append(fields, def["key"])
@@ -239,11 +412,8 @@ func (sr *SegmentRule) flags() map[string]string {
 	return flags
 }
 
-// getCacheKey generates unique int-based key based on SegmentRule definition.
+// getCacheKey generates unique int-based key based on SegmentRule definition and overrides applicable to it.
 func (sr *SegmentRule) getCacheKey(ro RuleOverrides) int {
-	if sr.cacheKey != 0 {
-		return sr.cacheKey
-	}
 	k := strconv.Itoa(sr.ID)
 
 	// use overridable fields
@@ -251,12 +421,125 @@ func (sr *SegmentRule) getCacheKey(ro RuleOverrides) int {
 		k = fmt.Sprintf("%s_%s", k, ro.Fields[f])
 	}
 
-	// generate and store hash
+	// use values bound to flags without value
+	for _, def := range sr.Flags {
+		if def["value"] == "" {
+			k = fmt.Sprintf("%s_%s=%s", k, def["key"], ro.Fields[def["key"]])
+		}
+	}
+
+	// use overridden params, each variant of the rule needs its own key
+	osr := sr.applyOverrides(ro)
+	if ro.Count != nil && sr.Overridable.Has(OverridableCount) {
+		k = fmt.Sprintf("%s_c%d", k, osr.Count)
+	}
+	if ro.Value != nil && sr.Overridable.Has(OverridableCount) && sr.isValueAggregate() {
+		k = fmt.Sprintf("%s_v%g", k, osr.Value)
+	}
+	if ro.Operator != nil && sr.Overridable.Has(OverridableOperator) {
+		k = fmt.Sprintf("%s_o%s", k, osr.Operator)
+	}
+	if ro.Timespan != nil && sr.Overridable.Has(OverridableTimespan) {
+		k = fmt.Sprintf("%s_t%d", k, osr.Timespan.Int64)
+	}
+
 	h := fnv.New32a()
 	h.Write([]byte(k))
-	sr.cacheKey = int(h.Sum32())
+	return int(h.Sum32())
+}
 
-	return sr.cacheKey
+// definitionKey returns key identifying the data queried by SegmentRule. Threshold (operator & count)
+// is not part of the key, rules with the same key always yield the same count for the same identifier.
+func (sr *SegmentRule) definitionKey() string {
+	var fields []string
+	for _, def := range sr.Fields {
+		if def["key"] == "" {
+			continue
+		}
+		key := def["key"]
+		if op := def["operator"]; op != "" && op != FilterIn {
+			key = fmt.Sprintf("%s:%s", key, op)
+		}
+		fields = append(fields, fmt.Sprintf("%s=%s", key, def["value"]))
+	}
+	sort.Strings(fields)
+
+	var flags []string
+	for key, val := range sr.flags() {
+		flags = append(flags, fmt.Sprintf("%s=%s", key, val))
+	}
+	sort.Strings(flags)
+
+	var timespan string
+	if sr.Timespan.Valid {
+		timespan = strconv.FormatInt(sr.Timespan.Int64, 10)
+	}
+	timespan += "-"
+	if sr.TimespanEnd.Valid {
+		timespan += strconv.FormatInt(sr.TimespanEnd.Int64, 10)
+	}
+	if sr.TimeAfter != nil {
+		timespan += "@" + sr.TimeAfter.UTC().Format(time.RFC3339)
+	}
+	timespan += "-"
+	if sr.TimeBefore != nil {
+		timespan += "@" + sr.TimeBefore.UTC().Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("%s/%s|%s(%s)|%s|%s|%s|%s|%s", sr.EventCategory, sr.EventAction, sr.Aggregate, sr.AggregateField,
+		timespan, strings.Join(fields, ","), strings.Join(flags, ","), sr.Sequence.definitionKey(), sr.RFM.definitionKey())
+}
+
+// isValueAggregate returns true if the rule compares aggregated value of events instead of their count.
+// Sequence and RFM rules are value-aggregate too, their value is 1 if the sequence was completed
+// or if the RFM scores matched.
+func (sr *SegmentRule) isValueAggregate() bool {
+	return sr.Aggregate != "" || sr.EventCategory == CategorySequence || sr.EventCategory == CategoryRFM
+}
+
+// validateAggregate checks whether the aggregate function of rule can be computed by the storage holding its events.
+func (sr *SegmentRule) validateAggregate() error {
+	if sr.EventCategory == CategorySequence {
+		if sr.Aggregate != "" {
+			return errors.New("aggregate function can't be used with sequence")
+		}
+		if sr.Sequence == nil {
+			return errors.New("sequence rule is missing sequence definition")
+		}
+		return sr.Sequence.validate()
+	}
+	if sr.EventCategory == CategoryRFM {
+		if sr.Aggregate != "" {
+			return errors.New("aggregate function can't be used with RFM")
+		}
+		if sr.RFM == nil {
+			return errors.New("RFM rule is missing RFM conditions")
+		}
+		return sr.RFM.validate()
+	}
+	switch sr.Aggregate {
+	case "":
+		return nil
+	case AggregateSum:
+		if sr.EventCategory == CategoryCommerce {
+			return nil
+		}
+		if sr.EventCategory == CategoryPageview && (sr.EventAction == ActionPageviewTimespent || sr.EventAction == ActionPageviewProgress) {
+			return nil
+		}
+	case AggregateAvg:
+		if sr.EventCategory == CategoryPageview && (sr.EventAction == ActionPageviewTimespent || sr.EventAction == ActionPageviewProgress) {
+			return nil
+		}
+	case AggregateDistinct:
+		if sr.AggregateField == "" {
+			return errors.New("distinct aggregate requires field to be provided")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown aggregate function [%s]", sr.Aggregate)
+	}
+	return fmt.Errorf("aggregate function [%s] is not available for %s/%s events", sr.Aggregate, sr.EventCategory, sr.EventAction)
 }
 
 // cacheable indicates whether the rule is cacheable or not. Only events trackable
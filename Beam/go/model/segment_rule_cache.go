@@ -0,0 +1,195 @@
+package model
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxCachedQueryDuration is the longest duration of storage query its count is still cached for. Invalidations
+// are kept only for this long after the ingest lag passes, so counts of longer queries can't be verified.
+const maxCachedQueryDuration = time.Minute
+
+// RuleCountCache is bounded LRU cache of rule counts of single users or browsers. Cached counts are
+// invalidated whenever new event of the rule's category and action is tracked for the same identifier.
+//
+// Tracker reports events before they're ingested to the storage, so counts queried within the ingest lag
+// after the invalidation might not include the reported event yet. Such counts are not cached.
+type RuleCountCache struct {
+	capacity  int
+	ttl       time.Duration // TTL of counts the rule doesn't provide its own cache duration for
+	ingestLag time.Duration // max delay between reporting of tracked event and its availability in storage
+
+	mu          sync.Mutex
+	ll          *list.List
+	items       map[ruleCountKey]*list.Element
+	index       map[ruleEventKey]map[ruleCountKey]bool
+	invalidated map[ruleEventKey]time.Time // time of the last invalidation of counts of the events
+	prunedAt    time.Time
+}
+
+// TrackedEvent identifies event tracked for user or browser, used for invalidation of cached rule counts.
+type TrackedEvent struct {
+	Category  string
+	Action    string
+	UserID    string
+	BrowserID string
+}
+
+// ruleCountKey identifies cached count of rule for single identifier.
+type ruleCountKey struct {
+	rule     string // definition key of the rule with overrides applied
+	tagName  string
+	tagValue string
+}
+
+// ruleEventKey identifies events invalidating cached counts.
+type ruleEventKey struct {
+	category string
+	action   string
+	tagName  string
+	tagValue string
+}
+
+// ruleCountEntry represents single cached count.
+type ruleCountEntry struct {
+	key       ruleCountKey
+	event     ruleEventKey
+	count     float64
+	expiresAt time.Time
+}
+
+// NewRuleCountCache creates cache holding at most capacity counts. Provided TTL is used for counts of rules
+// which don't specify their own cache duration. Counts queried sooner than ingestLag after the invalidation
+// of the identifier's events are not cached.
+func NewRuleCountCache(capacity int, ttl, ingestLag time.Duration) *RuleCountCache {
+	return &RuleCountCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		ingestLag:   ingestLag,
+		ll:          list.New(),
+		items:       make(map[ruleCountKey]*list.Element),
+		index:       make(map[ruleEventKey]map[ruleCountKey]bool),
+		invalidated: make(map[ruleEventKey]time.Time),
+	}
+}
+
+// Get returns cached count of rule for provided identifier.
+func (rcc *RuleCountCache) Get(sr *SegmentRule, tagName, tagValue string, now time.Time) (float64, bool) {
+	rcc.mu.Lock()
+	defer rcc.mu.Unlock()
+
+	el, ok := rcc.items[ruleCountKey{sr.definitionKey(), tagName, tagValue}]
+	if !ok {
+		return 0, false
+	}
+	entry := el.Value.(*ruleCountEntry)
+	if !now.Before(entry.expiresAt) {
+		rcc.remove(el)
+		return 0, false
+	}
+	rcc.ll.MoveToFront(el)
+	return entry.count, true
+}
+
+// Set stores count of rule for provided identifier if the rule allows caching of the count. Count is not stored
+// if its query started (at queriedAt) before the ingest lag of the last invalidation of its events passed, as
+// the invalidating event might have been missing in the storage.
+func (rcc *RuleCountCache) Set(sr *SegmentRule, tagName, tagValue string, count float64, now, queriedAt time.Time) {
+	d, ok := sr.CacheDuration(int(count))
+	if !ok {
+		return
+	}
+	if d <= 0 {
+		d = rcc.ttl
+	}
+	if now.Sub(queriedAt) > maxCachedQueryDuration {
+		return
+	}
+
+	rcc.mu.Lock()
+	defer rcc.mu.Unlock()
+
+	event := ruleEventKey{sr.EventCategory, sr.EventAction, tagName, tagValue}
+	if invalidatedAt, ok := rcc.invalidated[event]; ok && queriedAt.Before(invalidatedAt.Add(rcc.ingestLag)) {
+		return
+	}
+
+	key := ruleCountKey{sr.definitionKey(), tagName, tagValue}
+	if el, ok := rcc.items[key]; ok {
+		rcc.remove(el)
+	}
+	entry := &ruleCountEntry{
+		key:       key,
+		event:     event,
+		count:     count,
+		expiresAt: now.Add(d),
+	}
+	rcc.items[key] = rcc.ll.PushFront(entry)
+	if rcc.index[entry.event] == nil {
+		rcc.index[entry.event] = make(map[ruleCountKey]bool)
+	}
+	rcc.index[entry.event][key] = true
+
+	for rcc.capacity > 0 && rcc.ll.Len() > rcc.capacity {
+		rcc.remove(rcc.ll.Back())
+	}
+}
+
+// Invalidate removes cached counts of rules affected by provided tracked events and records the time
+// of invalidation, so counts queried before the events are ingested are not cached.
+func (rcc *RuleCountCache) Invalidate(events []TrackedEvent) {
+	now := time.Now()
+	rcc.mu.Lock()
+	defer rcc.mu.Unlock()
+
+	for _, e := range events {
+		for tagName, tagValue := range map[string]string{"user_id": e.UserID, "browser_id": e.BrowserID} {
+			if tagValue == "" {
+				continue
+			}
+			// rules without action count events of all the actions within the category
+			for _, action := range []string{e.Action, ""} {
+				event := ruleEventKey{e.Category, action, tagName, tagValue}
+				for key := range rcc.index[event] {
+					rcc.remove(rcc.items[key])
+				}
+				rcc.invalidated[event] = now
+			}
+		}
+	}
+	rcc.pruneInvalidations(now)
+}
+
+// pruneInvalidations removes invalidations which can't prevent caching of any count anymore. Invalidations are
+// pruned at most once per ingest lag. Caller is required to hold the lock.
+func (rcc *RuleCountCache) pruneInvalidations(now time.Time) {
+	if now.Sub(rcc.prunedAt) < rcc.ingestLag {
+		return
+	}
+	rcc.prunedAt = now
+	for event, invalidatedAt := range rcc.invalidated {
+		if now.Sub(invalidatedAt) > rcc.ingestLag+maxCachedQueryDuration {
+			delete(rcc.invalidated, event)
+		}
+	}
+}
+
+// Len returns number of cached counts.
+func (rcc *RuleCountCache) Len() int {
+	rcc.mu.Lock()
+	defer rcc.mu.Unlock()
+	return rcc.ll.Len()
+}
+
+// remove removes cached count from the list and all the indexes.
+func (rcc *RuleCountCache) remove(el *list.Element) {
+	entry := rcc.ll.Remove(el).(*ruleCountEntry)
+	delete(rcc.items, entry.key)
+	if keys, ok := rcc.index[entry.event]; ok {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(rcc.index, entry.event)
+		}
+	}
+}
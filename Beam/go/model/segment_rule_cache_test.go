@@ -0,0 +1,114 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleCountCache(t *testing.T) {
+	now := time.Now()
+	load := &SegmentRule{EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: ">=", Count: 5}
+	anyPageview := &SegmentRule{EventCategory: CategoryPageview, Operator: ">=", Count: 1}
+	purchase := &SegmentRule{EventCategory: CategoryCommerce, EventAction: "purchase", Operator: ">=", Count: 1}
+	exact := &SegmentRule{EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: "=", Count: 3}
+
+	rcc := NewRuleCountCache(3, time.Minute, 0)
+
+	// counts below the threshold of rule are not cached
+	rcc.Set(load, "user_id", "1", 4, now, now)
+	if _, ok := rcc.Get(load, "user_id", "1", now); ok {
+		t.Errorf("count below threshold was cached")
+	}
+
+	rcc.Set(load, "user_id", "1", 7, now, now)
+	rcc.Set(anyPageview, "user_id", "1", 12, now, now)
+	rcc.Set(purchase, "user_id", "1", 2, now, now)
+	if c, ok := rcc.Get(load, "user_id", "1", now); !ok || c != 7 {
+		t.Errorf("returned cached count %v (%t), expected 7", c, ok)
+	}
+	if _, ok := rcc.Get(load, "user_id", "2", now); ok {
+		t.Errorf("returned cached count of other user")
+	}
+
+	// TTL is provided by rule if available
+	rcc.Set(exact, "browser_id", "abc", 3, now, now)
+	if _, ok := rcc.Get(exact, "browser_id", "abc", now.Add(90*time.Second)); !ok {
+		t.Errorf("count expired before TTL of rule")
+	}
+	if _, ok := rcc.Get(exact, "browser_id", "abc", now.Add(3*time.Minute)); ok {
+		t.Errorf("count didn't expire after TTL of rule")
+	}
+
+	// pageview of user invalidates pageview rules, commerce rules stay cached
+	rcc.Invalidate([]TrackedEvent{{Category: CategoryPageview, Action: ActionPageviewLoad, UserID: "1"}})
+	if _, ok := rcc.Get(load, "user_id", "1", now); ok {
+		t.Errorf("count of rule with invalidated action stayed cached")
+	}
+	if _, ok := rcc.Get(anyPageview, "user_id", "1", now); ok {
+		t.Errorf("count of rule without action stayed cached")
+	}
+	if _, ok := rcc.Get(purchase, "user_id", "1", now); !ok {
+		t.Errorf("count of rule with other category was invalidated")
+	}
+
+	// least recently used counts are evicted
+	for i, id := range []string{"2", "3", "4"} {
+		rcc.Set(load, "user_id", id, float64(10+i), now, now)
+	}
+	if rcc.Len() != 3 {
+		t.Errorf("cache holds %d counts, expected 3", rcc.Len())
+	}
+	if _, ok := rcc.Get(purchase, "user_id", "1", now); ok {
+		t.Errorf("least recently used count was not evicted")
+	}
+}
+
+func TestRuleCountCache_IngestLag(t *testing.T) {
+	lag := 10 * time.Second
+	load := &SegmentRule{EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: ">=", Count: 1}
+	anyPageview := &SegmentRule{EventCategory: CategoryPageview, Operator: ">=", Count: 1}
+
+	rcc := NewRuleCountCache(10, time.Minute, lag)
+	before := time.Now()
+	rcc.Invalidate([]TrackedEvent{{Category: CategoryPageview, Action: ActionPageviewLoad, UserID: "1"}})
+	after := time.Now()
+
+	var lagTests = []struct {
+		Name      string
+		Rule      *SegmentRule
+		UserID    string
+		QueriedAt time.Time
+		Cached    bool
+	}{
+		{"queried before invalidation", load, "1", before, false},
+		{"queried within ingest lag", load, "1", after, false},
+		{"rule without action queried within ingest lag", anyPageview, "1", after, false},
+		{"queried after ingest lag", load, "1", after.Add(lag + time.Second), true},
+		{"other user", load, "2", before, true},
+		{"query running too long", load, "2", before.Add(-2 * maxCachedQueryDuration), false},
+	}
+
+	for _, lt := range lagTests {
+		now := time.Now()
+		rcc.Set(lt.Rule, "user_id", lt.UserID, 3, now, lt.QueriedAt)
+		if _, ok := rcc.Get(lt.Rule, "user_id", lt.UserID, now); ok != lt.Cached {
+			t.Errorf("%s: cached %t, expected %t", lt.Name, ok, lt.Cached)
+		}
+		if el, ok := rcc.items[ruleCountKey{lt.Rule.definitionKey(), "user_id", lt.UserID}]; ok {
+			rcc.remove(el)
+		}
+	}
+
+	// duration of the query is measured against the provided time
+	past := before.Add(-time.Hour)
+	rcc.Set(load, "user_id", "3", 3, past, past.Add(-time.Second))
+	if _, ok := rcc.Get(load, "user_id", "3", past); !ok {
+		t.Errorf("count queried shortly before provided time was not cached")
+	}
+
+	// invalidations are pruned once they can't prevent caching of any count
+	rcc.pruneInvalidations(after.Add(lag + maxCachedQueryDuration + time.Second))
+	for event := range rcc.invalidated {
+		t.Errorf("invalidation of %v was not pruned", event)
+	}
+}
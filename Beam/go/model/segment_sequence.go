@@ -0,0 +1,395 @@
+package model
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CategorySequence identifies SegmentRules evaluating ordered sequence of events instead of events of single category.
+const CategorySequence = "sequence"
+
+// maxSequenceCandidates is the highest number of identifiers evaluated at once. Identifiers which did the first step
+// are paginated by this number and events of the sequence are listed only for the identifiers of the current page.
+const maxSequenceCandidates = 10000
+
+// SegmentRuleSequence represents ordered sequence of events evaluated by SegmentRule.
+type SegmentRuleSequence struct {
+	Steps   []SegmentRuleSequenceStep `json:"steps"`
+	Without []SegmentRuleSequenceStep `json:"without"` // events that must not occur within the sequence window
+	Within  int                       `json:"within"`  // max number of minutes between first and last step, zero for no limit
+	Session bool                      `json:"session"` // whether all the steps need to occur within the same remp_session_id
+}
+
+// SegmentRuleSequenceStep represents single step (type of event) of the sequence.
+type SegmentRuleSequenceStep struct {
+	Category string            `json:"category"`
+	Action   string            `json:"action"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// sequenceEvent represents single occurrence of the sequence step.
+type sequenceEvent struct {
+	ID        string
+	Time      time.Time
+	SessionID string
+}
+
+// options returns AggregateOptions filtering events of the step based on options of whole sequence rule.
+func (step SegmentRuleSequenceStep) options(o AggregateOptions) AggregateOptions {
+	options := o
+	options.Category = ""
+	options.Action = ""
+	options.Step = ""
+
+	switch step.Category {
+	case CategoryPageview:
+		options.Action = step.Action
+	case CategoryCommerce:
+		options.Step = step.Action
+	default:
+		options.Category = step.Category
+		options.Action = step.Action
+	}
+
+	options.FilterBy = append([]*FilterBy{}, o.FilterBy...)
+	keys := []string{}
+	for key := range step.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if step.Fields[key] == "" {
+			continue
+		}
+		options.FilterBy = append(options.FilterBy, &FilterBy{Tag: key, Values: []string{step.Fields[key]}})
+	}
+	return options
+}
+
+// rule returns SegmentRule representing events of the step.
+func (step SegmentRuleSequenceStep) rule() *SegmentRule {
+	return &SegmentRule{
+		EventCategory: step.Category,
+		EventAction:   step.Action,
+	}
+}
+
+// validate checks whether the sequence can be evaluated.
+func (seq *SegmentRuleSequence) validate() error {
+	if len(seq.Steps) == 0 {
+		return errors.New("sequence requires at least one step")
+	}
+	for _, step := range append(append([]SegmentRuleSequenceStep{}, seq.Steps...), seq.Without...) {
+		if step.Category == "" || step.Category == CategorySequence {
+			return errors.New("sequence step requires category of tracked events")
+		}
+	}
+	if seq.Within < 0 {
+		return errors.New("sequence window can't be negative")
+	}
+	return nil
+}
+
+// definitionKey returns key identifying the sequence definition.
+func (seq *SegmentRuleSequence) definitionKey() string {
+	if seq == nil {
+		return ""
+	}
+	key, _ := json.Marshal(seq)
+	return string(key)
+}
+
+// matchSequence checks whether provided events (grouped by steps and ordered by time) contain all the steps
+// of the sequence in the correct order. Every occurrence of the first step is tried as a beginning of sequence;
+// the sequence fails if any of excluded events occur between its beginning and the end of its window.
+//
+// Each step needs to be matched by an event occurring after the event of the previous step; events occurring
+// at the same time are accepted only if they weren't matched by any of the previous steps already.
+func matchSequence(seq *SegmentRuleSequence, steps [][]sequenceEvent, without []sequenceEvent) bool {
+	if len(steps) == 0 {
+		return false
+	}
+
+	for _, first := range steps[0] {
+		var deadline time.Time
+		if seq.Within > 0 {
+			deadline = first.Time.Add(time.Duration(seq.Within) * time.Minute)
+		}
+		inWindow := func(e sequenceEvent, from time.Time) bool {
+			if e.Time.Before(from) {
+				return false
+			}
+			if !deadline.IsZero() && e.Time.After(deadline) {
+				return false
+			}
+			if seq.Session && e.SessionID != first.SessionID {
+				return false
+			}
+			return true
+		}
+
+		last := first
+		used := map[string]bool{first.ID: true}
+		matched := true
+		for _, step := range steps[1:] {
+			found := false
+			for _, e := range step {
+				if !inWindow(e, last.Time) {
+					continue
+				}
+				if e.Time.Equal(last.Time) && (e.ID == "" || used[e.ID]) {
+					continue
+				}
+				last = e
+				used[e.ID] = true
+				found = true
+				break
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		excluded := false
+		for _, e := range without {
+			if inWindow(e, first.Time) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// sequenceValues evaluates sequence rule for each identifier based on the first tag of options.GroupBy. Value
+// of the row is 1 if the identifier completed the sequence and 0 otherwise.
+//
+// Without grouping the identifiers are taken from user_id or browser_id filter of the options and single row
+// is returned, with value 1 if any of the filtered identifiers completed the sequence.
+//
+// Identifiers are evaluated page by page, so only events of single page of identifiers are held in memory.
+func (sDB *SegmentDB) sequenceValues(sr *SegmentRule, options AggregateOptions) ([]ruleValueRow, bool, error) {
+	if sr.Sequence == nil {
+		return nil, false, errors.New("sequence rule is missing sequence definition")
+	}
+	options.Page = nil
+	if len(options.GroupBy) == 0 {
+		tagName := sequenceIdentityTag(options)
+		if tagName == "" {
+			return nil, false, errors.New("sequence rule without grouping requires user_id or browser_id filter")
+		}
+		grouped := options
+		grouped.GroupBy = []string{tagName}
+		rvc, ok, err := sDB.sequenceValues(sr, grouped)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		row := ruleValueRow{Tags: map[string]string{}}
+		for _, rv := range rvc {
+			if rv.Value > row.Value {
+				row.Value = rv.Value
+			}
+		}
+		return []ruleValueRow{row}, true, nil
+	}
+	tagName := options.GroupBy[0]
+
+	rvc := []ruleValueRow{}
+	err := sDB.sequenceCandidates(sr, options, tagName, func(candidates []string) error {
+		narrowed := options
+		narrowed.FilterBy = append(append([]*FilterBy{}, options.FilterBy...), &FilterBy{Tag: tagName, Values: candidates})
+		rows, err := sDB.sequenceRows(sr, narrowed, tagName)
+		if err != nil {
+			return err
+		}
+		rvc = append(rvc, rows...)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return rvc, true, nil
+}
+
+// sequenceIdentityTag returns the identity tag (user_id or browser_id) the options are filtered by.
+func sequenceIdentityTag(options AggregateOptions) string {
+	for i := len(options.FilterBy) - 1; i >= 0; i-- {
+		switch options.FilterBy[i].Tag {
+		case "user_id", "browser_id":
+			return options.FilterBy[i].Tag
+		}
+	}
+	return ""
+}
+
+// sequenceCandidates pages through identifiers (values of provided tag) having events of the first step
+// of the sequence and calls provided callback with each page.
+func (sDB *SegmentDB) sequenceCandidates(sr *SegmentRule, options AggregateOptions, tagName string, fn func(candidates []string) error) error {
+	step := sr.Sequence.Steps[0]
+	po := step.options(options)
+	po.GroupBy = []string{tagName}
+	po.Page = &AggregatePage{
+		Size: maxSequenceCandidates,
+	}
+
+	for {
+		crc, ok, err := sDB.storageCount(step.rule(), po)
+		if err != nil {
+			return errors.Wrap(err, "unable to get page of sequence candidates")
+		}
+		if !ok || len(crc) == 0 {
+			return nil
+		}
+		candidates := []string{}
+		for _, cr := range crc {
+			if cr.Tags[tagName] != "" {
+				candidates = append(candidates, cr.Tags[tagName])
+			}
+		}
+		if len(candidates) > 0 {
+			if err := fn(candidates); err != nil {
+				return err
+			}
+		}
+		if len(crc) < po.Page.Size {
+			return nil
+		}
+		po.Page.After = map[string]string{
+			tagName: crc[len(crc)-1].Tags[tagName],
+		}
+	}
+}
+
+// sequenceRows lists events of all the steps matching provided options and evaluates the sequence for each
+// identifier (value of provided tag) which did the first step.
+func (sDB *SegmentDB) sequenceRows(sr *SegmentRule, options AggregateOptions, tagName string) ([]ruleValueRow, error) {
+	steps := make([]map[string][]sequenceEvent, len(sr.Sequence.Steps))
+	for i, step := range sr.Sequence.Steps {
+		events, err := sDB.stepEvents(step, options, tagName)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && len(events) == 0 {
+			return []ruleValueRow{}, nil
+		}
+		steps[i] = events
+	}
+
+	without := make(map[string][]sequenceEvent)
+	for _, step := range sr.Sequence.Without {
+		events, err := sDB.stepEvents(step, options, tagName)
+		if err != nil {
+			return nil, err
+		}
+		for id, ee := range events {
+			without[id] = append(without[id], ee...)
+		}
+	}
+
+	rvc := []ruleValueRow{}
+	for id := range steps[0] {
+		idSteps := make([][]sequenceEvent, len(steps))
+		for i := range steps {
+			idSteps[i] = steps[i][id]
+		}
+
+		var value float64
+		if matchSequence(sr.Sequence, idSteps, without[id]) {
+			value = 1
+		}
+		tags := make(map[string]string)
+		if tagName != "" {
+			tags[tagName] = id
+		}
+		rvc = append(rvc, ruleValueRow{Tags: tags, Value: value})
+	}
+	return rvc, nil
+}
+
+// stepEvents lists occurrences of the sequence step ordered by time and grouped by value of provided tag.
+func (sDB *SegmentDB) stepEvents(step SegmentRuleSequenceStep, o AggregateOptions, tagName string) (map[string][]sequenceEvent, error) {
+	options := step.options(o)
+	options.GroupBy = nil
+	options.Page = nil
+	selectFields := []string{"time", "user_id", "browser_id", "remp_session_id"}
+
+	events := make(map[string][]sequenceEvent)
+	add := func(eventID, userID, browserID, sessionID string, t time.Time) {
+		var id string
+		switch tagName {
+		case "user_id":
+			id = userID
+		case "browser_id":
+			id = browserID
+		}
+		if tagName != "" && id == "" {
+			return
+		}
+		events[id] = append(events[id], sequenceEvent{
+			ID:        eventID,
+			Time:      t,
+			SessionID: sessionID,
+		})
+	}
+
+	switch step.Category {
+	case CategoryPageview:
+		// pageviews are listed from single index, action is not being tracked there
+		options.Action = ""
+		prc, _, err := sDB.PageviewStorage.List(ListPageviewsOptions{
+			AggregateOptions: options,
+			SelectFields:     selectFields,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list pageviews of sequence step")
+		}
+		for _, pr := range prc {
+			for _, pv := range pr.Pageviews {
+				add(pv.ID, pv.UserID, pv.BrowserID, pv.SessionID, pv.Time)
+			}
+		}
+	case CategoryCommerce:
+		crc, _, err := sDB.CommerceStorage.List(ListOptions{
+			AggregateOptions: options,
+			SelectFields:     append(selectFields, "step"),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list commerce events of sequence step")
+		}
+		for _, cr := range crc {
+			for _, c := range cr.Commerces {
+				add(c.ID, c.UserID, c.BrowserID, c.SessionID, c.Time)
+			}
+		}
+	default:
+		erc, _, err := sDB.EventStorage.List(ListOptions{
+			AggregateOptions: options,
+			SelectFields:     append(selectFields, "category", "action"),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list events of sequence step")
+		}
+		for _, er := range erc {
+			for _, e := range er.Events {
+				add(e.ID, e.UserID, e.BrowserID, e.SessionID, e.Time)
+			}
+		}
+	}
+
+	for id := range events {
+		sort.Slice(events[id], func(i, j int) bool {
+			return events[id][i].Time.Before(events[id][j].Time)
+		})
+	}
+	return events, nil
+}
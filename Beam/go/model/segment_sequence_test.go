@@ -0,0 +1,200 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMatchSequence(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int, session string) sequenceEvent {
+		return sequenceEvent{Time: now.Add(time.Duration(minutes) * time.Minute), SessionID: session}
+	}
+	id := func(id string, minutes int) sequenceEvent {
+		return sequenceEvent{ID: id, Time: now.Add(time.Duration(minutes) * time.Minute)}
+	}
+
+	var sequenceTests = []struct {
+		Name     string
+		Sequence SegmentRuleSequence
+		Steps    [][]sequenceEvent
+		Without  []sequenceEvent
+		Result   bool
+	}{
+		{"no events", SegmentRuleSequence{}, [][]sequenceEvent{{}, {at(1, "a")}}, nil, false},
+		{"ordered", SegmentRuleSequence{}, [][]sequenceEvent{{at(0, "a")}, {at(10, "b")}}, nil, true},
+		{"reversed", SegmentRuleSequence{}, [][]sequenceEvent{{at(10, "a")}, {at(0, "a")}}, nil, false},
+		{"within window", SegmentRuleSequence{Within: 60}, [][]sequenceEvent{{at(0, "a")}, {at(60, "a")}}, nil, true},
+		{"out of window", SegmentRuleSequence{Within: 60}, [][]sequenceEvent{{at(0, "a")}, {at(61, "a")}}, nil, false},
+		{"later anchor", SegmentRuleSequence{Within: 60}, [][]sequenceEvent{{at(0, "a"), at(30, "a")}, {at(80, "a")}}, nil, true},
+		{"other session", SegmentRuleSequence{Session: true}, [][]sequenceEvent{{at(0, "a")}, {at(10, "b")}}, nil, false},
+		{"same session", SegmentRuleSequence{Session: true}, [][]sequenceEvent{{at(0, "a")}, {at(5, "b"), at(10, "a")}}, nil, true},
+		{"three steps", SegmentRuleSequence{}, [][]sequenceEvent{{at(0, "a")}, {at(10, "a")}, {at(5, "a")}}, nil, false},
+		{"excluded", SegmentRuleSequence{Within: 60}, [][]sequenceEvent{{at(0, "a")}, {at(10, "a")}}, []sequenceEvent{at(20, "a")}, false},
+		{"excluded out of window", SegmentRuleSequence{Within: 60}, [][]sequenceEvent{{at(0, "a")}, {at(10, "a")}}, []sequenceEvent{at(90, "a")}, true},
+		{"identical steps single event", SegmentRuleSequence{}, [][]sequenceEvent{{id("e1", 0)}, {id("e1", 0)}}, nil, false},
+		{"identical steps two events", SegmentRuleSequence{}, [][]sequenceEvent{{id("e1", 0), id("e2", 10)}, {id("e1", 0), id("e2", 10)}}, nil, true},
+		{"identical steps same time", SegmentRuleSequence{}, [][]sequenceEvent{{id("e1", 0), id("e2", 0)}, {id("e1", 0), id("e2", 0)}}, nil, true},
+		{"three identical steps two events", SegmentRuleSequence{}, [][]sequenceEvent{{id("e1", 0), id("e2", 0)}, {id("e1", 0), id("e2", 0)}, {id("e1", 0), id("e2", 0)}}, nil, false},
+	}
+
+	for _, st := range sequenceTests {
+		result := matchSequence(&st.Sequence, st.Steps, st.Without)
+		if result != st.Result {
+			t.Errorf("%s: returned %t, expected %t", st.Name, result, st.Result)
+		}
+	}
+}
+
+// sequencePageviewStorage serves pageviews of articles, counts them per user honoring pagination and
+// records the number of users each listing was narrowed to.
+type sequencePageviewStorage struct {
+	PageviewStorage
+	pageviews []*Pageview
+	listed    []int
+}
+
+// filter returns pageviews matching article_id and user_id filters of the options.
+func (s *sequencePageviewStorage) filter(o AggregateOptions) ([]*Pageview, int) {
+	users := make(map[string]bool)
+	var article string
+	for _, fb := range o.FilterBy {
+		switch fb.Tag {
+		case "user_id":
+			for _, v := range fb.Values {
+				users[v] = true
+			}
+		case "article_id":
+			article = fb.Values[0]
+		}
+	}
+	var pvs []*Pageview
+	for _, pv := range s.pageviews {
+		if (len(users) == 0 || users[pv.UserID]) && (article == "" || pv.ArticleID == article) {
+			pvs = append(pvs, pv)
+		}
+	}
+	return pvs, len(users)
+}
+
+func (s *sequencePageviewStorage) Count(o AggregateOptions) (CountRowCollection, bool, error) {
+	pvs, _ := s.filter(o)
+	counts := make(map[string]int)
+	for _, pv := range pvs {
+		if pv.UserID > o.Page.After["user_id"] {
+			counts[pv.UserID]++
+		}
+	}
+	var ids []string
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if len(ids) > o.Page.Size {
+		ids = ids[:o.Page.Size]
+	}
+	crc := CountRowCollection{}
+	for _, id := range ids {
+		crc = append(crc, CountRow{Tags: map[string]string{"user_id": id}, Count: counts[id]})
+	}
+	return crc, true, nil
+}
+
+func (s *sequencePageviewStorage) List(o ListPageviewsOptions) (PageviewRowCollection, string, error) {
+	pvs, users := s.filter(o.AggregateOptions)
+	s.listed = append(s.listed, users)
+	return PageviewRowCollection{{Pageviews: pvs}}, "", nil
+}
+
+func TestSegmentDB_SequenceValues(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	storage := &sequencePageviewStorage{}
+	users := maxSequenceCandidates + 5
+	for i := 0; i < users; i++ {
+		userID := fmt.Sprintf("u%05d", i)
+		storage.pageviews = append(storage.pageviews, &Pageview{UserID: userID, ArticleID: "a", Time: now})
+		if i%1000 == 0 {
+			storage.pageviews = append(storage.pageviews, &Pageview{UserID: userID, ArticleID: "b", Time: now.Add(time.Minute)})
+		}
+	}
+
+	sr := &SegmentRule{
+		EventCategory: CategorySequence,
+		Sequence: &SegmentRuleSequence{
+			Steps: []SegmentRuleSequenceStep{
+				{Category: CategoryPageview, Action: ActionPageviewLoad, Fields: map[string]string{"article_id": "a"}},
+				{Category: CategoryPageview, Action: ActionPageviewLoad, Fields: map[string]string{"article_id": "b"}},
+			},
+		},
+	}
+	sDB := &SegmentDB{PageviewStorage: storage}
+	rvc, _, err := sDB.sequenceValues(sr, AggregateOptions{GroupBy: []string{"user_id"}})
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+
+	if len(rvc) != users {
+		t.Errorf("returned %d rows, expected %d", len(rvc), users)
+	}
+	var completed int
+	for _, rv := range rvc {
+		completed += int(rv.Value)
+	}
+	if completed != 11 {
+		t.Errorf("returned %d completed sequences, expected 11", completed)
+	}
+	// each page of candidates lists events of both steps
+	if len(storage.listed) != 4 {
+		t.Errorf("listed events %d times, expected 4", len(storage.listed))
+	}
+	for _, n := range storage.listed {
+		if n == 0 || n > maxSequenceCandidates {
+			t.Errorf("listing was narrowed to %d users, expected at most %d", n, maxSequenceCandidates)
+		}
+	}
+}
+
+func TestSegmentDB_SequenceValuesUngrouped(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	storage := &sequencePageviewStorage{
+		pageviews: []*Pageview{
+			{ID: "p1", UserID: "u1", ArticleID: "a", Time: now},
+			{ID: "p2", UserID: "u1", ArticleID: "b", Time: now.Add(time.Minute)},
+			{ID: "p3", UserID: "u2", ArticleID: "a", Time: now},
+		},
+	}
+	sr := &SegmentRule{
+		EventCategory: CategorySequence,
+		Sequence: &SegmentRuleSequence{
+			Steps: []SegmentRuleSequenceStep{
+				{Category: CategoryPageview, Action: ActionPageviewLoad, Fields: map[string]string{"article_id": "a"}},
+				{Category: CategoryPageview, Action: ActionPageviewLoad, Fields: map[string]string{"article_id": "b"}},
+			},
+		},
+	}
+	sDB := &SegmentDB{PageviewStorage: storage}
+
+	for userID, value := range map[string]float64{"u1": 1, "u2": 0} {
+		storage.listed = nil
+		rvc, _, err := sDB.sequenceValues(sr, AggregateOptions{
+			FilterBy: []*FilterBy{{Tag: "user_id", Values: []string{userID}}},
+		})
+		if err != nil {
+			t.Fatalf("%s: returned error: %v", userID, err)
+		}
+		if len(rvc) != 1 || rvc[0].Value != value {
+			t.Errorf("%s: returned %v, expected single row with value %v", userID, rvc, value)
+		}
+		for _, n := range storage.listed {
+			if n != 1 {
+				t.Errorf("%s: listing was narrowed to %d users, expected 1", userID, n)
+			}
+		}
+	}
+
+	if _, _, err := sDB.sequenceValues(sr, AggregateOptions{}); err == nil {
+		t.Error("returned no error without grouping and identity filter")
+	}
+}
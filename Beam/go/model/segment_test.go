@@ -1,10 +1,19 @@
 package model
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	cache "github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
 )
 
 func TestSegmentRule_CacheDuration(t *testing.T) {
@@ -96,3 +105,686 @@ func TestSegmentRule_Evaluate(t *testing.T) {
 		}
 	}
 }
+
+func TestSegmentRule_Window(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := int64(1440)
+	abs := func(t time.Time) *time.Time { return &t }
+
+	var windowTests = []struct {
+		Timespan    sql.NullInt64
+		TimespanEnd sql.NullInt64
+		TimeAfter   *time.Time
+		TimeBefore  *time.Time
+		After       time.Time
+		Before      time.Time
+	}{
+		{sql.NullInt64{}, sql.NullInt64{}, nil, nil, time.Time{}, time.Time{}},
+		{sql.NullInt64{Int64: 30 * day, Valid: true}, sql.NullInt64{}, nil, nil, now.AddDate(0, 0, -30), time.Time{}},
+		{sql.NullInt64{Int64: 30 * day, Valid: true}, sql.NullInt64{Int64: 7 * day, Valid: true}, nil, nil, now.AddDate(0, 0, -30), now.AddDate(0, 0, -7)},
+		{sql.NullInt64{}, sql.NullInt64{}, abs(now.AddDate(0, -1, 0)), abs(now.AddDate(0, 0, -1)), now.AddDate(0, -1, 0), now.AddDate(0, 0, -1)},
+		{sql.NullInt64{Int64: 30 * day, Valid: true}, sql.NullInt64{Int64: 7 * day, Valid: true}, abs(now.AddDate(0, 0, -10)), abs(now.AddDate(0, 0, -1)), now.AddDate(0, 0, -10), now.AddDate(0, 0, -7)},
+	}
+
+	for _, wt := range windowTests {
+		sr := &SegmentRule{
+			Timespan:    wt.Timespan,
+			TimespanEnd: wt.TimespanEnd,
+			TimeAfter:   wt.TimeAfter,
+			TimeBefore:  wt.TimeBefore,
+		}
+		after, before := sr.window(now)
+		if !after.Equal(wt.After) {
+			t.Errorf("returned start %s, expected %s: %v", after, wt.After, wt)
+		}
+		if !before.Equal(wt.Before) {
+			t.Errorf("returned end %s, expected %s: %v", before, wt.Before, wt)
+		}
+	}
+}
+
+func TestSegmentRule_EvaluateValue(t *testing.T) {
+	lt := "<"
+	upper := 0.9
+
+	var evalTests = []struct {
+		TestedValue float64
+		Aggregate   string
+		Value       float64
+		Operator2   *string
+		Value2      *float64
+		Result      bool
+	}{
+		{0.7, AggregateAvg, 0.7, nil, nil, false},
+		{0.71, AggregateAvg, 0.7, nil, nil, true},
+		{0.95, AggregateAvg, 0.7, &lt, &upper, false},
+		{0.8, AggregateAvg, 0.7, &lt, &upper, true},
+		{601, AggregateSum, 600, nil, nil, true},
+		{3, AggregateDistinct, 3, nil, nil, false},
+	}
+
+	for _, et := range evalTests {
+		sr := &SegmentRule{
+			Aggregate: et.Aggregate,
+			Operator:  ">",
+			Operator2: et.Operator2,
+			Value:     et.Value,
+			Value2:    et.Value2,
+		}
+		ok, err := sr.EvaluateValue(et.TestedValue)
+		if err != nil {
+			t.Errorf("returned error %s even when none was expected: %v", err, et)
+		}
+		if ok != et.Result {
+			t.Errorf("returned result %t, expected %t: %v", ok, et.Result, et)
+		}
+	}
+}
+
+func TestSegmentRule_Comparison(t *testing.T) {
+	lt := "<"
+	count2 := 20
+	upper := 0.9
+
+	var comparisonTests = []struct {
+		Rule       SegmentRule
+		Value      float64
+		Comparison string
+	}{
+		{SegmentRule{Operator: ">=", Count: 5}, 12, "12 >= 5"},
+		{SegmentRule{Operator: ">=", Count: 5, Operator2: &lt, Count2: &count2}, 12, "12 >= 5 AND 12 < 20"},
+		{SegmentRule{Aggregate: AggregateAvg, Operator: ">", Value: 0.7, Operator2: &lt, Value2: &upper}, 0.75, "0.75 > 0.7 AND 0.75 < 0.9"},
+	}
+
+	for _, ct := range comparisonTests {
+		if c := ct.Rule.comparison(ct.Value); c != ct.Comparison {
+			t.Errorf("returned comparison %q, expected %q", c, ct.Comparison)
+		}
+	}
+}
+
+func TestSegmentRule_Overrides(t *testing.T) {
+	count := 10
+	gt := ">"
+	timespan := 60
+
+	sr := SegmentRule{
+		ID:          1,
+		Operator:    ">=",
+		Count:       5,
+		Timespan:    sql.NullInt64{Int64: 1440, Valid: true},
+		Overridable: OverridableParams{OverridableCount, OverridableTimespan},
+	}
+
+	var overrideTests = []struct {
+		Overrides RuleOverrides
+		Count     int
+		Operator  string
+		Timespan  int64
+	}{
+		{RuleOverrides{}, 5, ">=", 1440},
+		{RuleOverrides{Count: &count}, 10, ">=", 1440},
+		{RuleOverrides{Operator: &gt}, 5, ">=", 1440},
+		{RuleOverrides{Count: &count, Operator: &gt, Timespan: &timespan}, 10, ">=", 60},
+	}
+
+	keys := make(map[int]bool)
+	for _, ot := range overrideTests {
+		osr := sr.applyOverrides(ot.Overrides)
+		if osr.Count != ot.Count || osr.Operator != ot.Operator || osr.Timespan.Int64 != ot.Timespan {
+			t.Errorf("returned rule %d %s %d, expected %d %s %d", osr.Count, osr.Operator, osr.Timespan.Int64, ot.Count, ot.Operator, ot.Timespan)
+		}
+		keys[sr.getCacheKey(ot.Overrides)] = true
+	}
+	// ignored operator override shares the key of variant without overrides
+	if len(keys) != 3 {
+		t.Errorf("returned %d distinct cache keys, expected 3", len(keys))
+	}
+	if sr.Count != 5 || sr.Timespan.Int64 != 1440 {
+		t.Errorf("overrides modified the original rule")
+	}
+}
+
+func TestSegmentRule_ValueOverrides(t *testing.T) {
+	count := 10
+	value := 7.25
+
+	sr := SegmentRule{
+		ID:          1,
+		Operator:    ">=",
+		Aggregate:   AggregateAvg,
+		Value:       2.5,
+		Overridable: OverridableParams{OverridableCount},
+	}
+
+	var overrideTests = []struct {
+		Overrides RuleOverrides
+		Value     float64
+	}{
+		{RuleOverrides{}, 2.5},
+		{RuleOverrides{Count: &count}, 10},
+		{RuleOverrides{Value: &value}, 7.25},
+		{RuleOverrides{Count: &count, Value: &value}, 7.25},
+	}
+
+	keys := make(map[int]bool)
+	for _, ot := range overrideTests {
+		if osr := sr.applyOverrides(ot.Overrides); osr.Value != ot.Value {
+			t.Errorf("returned value threshold %g, expected %g", osr.Value, ot.Value)
+		}
+		keys[sr.getCacheKey(ot.Overrides)] = true
+	}
+	if len(keys) != len(overrideTests) {
+		t.Errorf("returned %d distinct cache keys, expected %d", len(keys), len(overrideTests))
+	}
+
+	// value is ignored by rules comparing event counts
+	counting := SegmentRule{ID: 2, Operator: ">=", Count: 5, Overridable: OverridableParams{OverridableCount}}
+	if osr := counting.applyOverrides(RuleOverrides{Value: &value}); osr.Count != 5 || osr.Value != 0 {
+		t.Errorf("value override changed threshold of event count rule")
+	}
+}
+
+func TestSegmentRule_Flags(t *testing.T) {
+	sr := SegmentRule{
+		ID:            1,
+		EventCategory: CategoryPageview,
+		EventAction:   ActionPageviewLoad,
+		Flags: JSONMap{
+			{"key": "utm_campaign", "value": ""},
+			{"key": FlagArticle, "value": "1"},
+		},
+	}
+
+	var flagTests = []struct {
+		Overrides RuleOverrides
+		FilterBy  []FilterBy
+	}{
+		{RuleOverrides{}, []FilterBy{{Tag: FlagArticle, Values: []string{"1"}}}},
+		{RuleOverrides{Fields: map[string]string{"utm_campaign": "abc"}}, []FilterBy{{Tag: FlagArticle, Values: []string{"1"}}, {Tag: "utm_campaign", Values: []string{"abc"}}}},
+	}
+
+	keys := make(map[int]bool)
+	for _, ft := range flagTests {
+		options := sr.applyOverrides(ft.Overrides).options(time.Now(), ft.Overrides)
+		var filterBy []FilterBy
+		for _, fb := range options.FilterBy {
+			filterBy = append(filterBy, *fb)
+		}
+		if !reflect.DeepEqual(filterBy, ft.FilterBy) {
+			t.Errorf("returned filters %v, expected %v", filterBy, ft.FilterBy)
+		}
+		keys[sr.getCacheKey(ft.Overrides)] = true
+	}
+	if len(keys) != len(flagTests) {
+		t.Errorf("returned %d distinct cache keys, expected %d", len(keys), len(flagTests))
+	}
+}
+
+func TestSegmentRule_FieldOperators(t *testing.T) {
+	sr := SegmentRule{
+		ID:            1,
+		EventCategory: CategoryPageview,
+		EventAction:   ActionPageviewLoad,
+		Fields: JSONMap{
+			{"key": "url", "value": "https://example.com/", "operator": FilterPrefix},
+			{"key": "author_id", "value": "", "operator": FilterMissing},
+			{"key": "article_id", "value": "", "operator": FilterNotIn},
+		},
+	}
+
+	var operatorTests = []struct {
+		Overrides RuleOverrides
+		FilterBy  []FilterBy
+	}{
+		{
+			RuleOverrides{},
+			[]FilterBy{
+				{Tag: "url", Values: []string{"https://example.com/"}, Operator: FilterPrefix},
+				{Tag: "author_id", Operator: FilterMissing},
+			},
+		},
+		{
+			RuleOverrides{Fields: map[string]string{"article_id": "123"}},
+			[]FilterBy{
+				{Tag: "url", Values: []string{"https://example.com/"}, Operator: FilterPrefix},
+				{Tag: "author_id", Operator: FilterMissing},
+				{Tag: "article_id", Values: []string{"123"}, Operator: FilterNotIn},
+			},
+		},
+	}
+
+	if fields := sr.overridableFields(); !reflect.DeepEqual(fields, []string{"article_id"}) {
+		t.Errorf("returned overridable fields %v, expected [article_id]", fields)
+	}
+	for _, ot := range operatorTests {
+		options := sr.applyOverrides(ot.Overrides).options(time.Now(), ot.Overrides)
+		var filterBy []FilterBy
+		for _, fb := range options.FilterBy {
+			filterBy = append(filterBy, *fb)
+		}
+		if !reflect.DeepEqual(filterBy, ot.FilterBy) {
+			t.Errorf("returned filters %v, expected %v", filterBy, ot.FilterBy)
+		}
+	}
+}
+
+// countingPageviewStorage counts calls of Count and returns single row with the count of calls.
+type countingPageviewStorage struct {
+	PageviewStorage
+	calls int32
+}
+
+func (s *countingPageviewStorage) Count(o AggregateOptions) (CountRowCollection, bool, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return CountRowCollection{{Count: 3}}, true, nil
+}
+
+func TestSegmentDB_CheckSegmentsSharedCounts(t *testing.T) {
+	rule := func(segmentID, count int, fields JSONMap) SegmentRule {
+		return SegmentRule{
+			ID:            segmentID*10 + count,
+			SegmentID:     segmentID,
+			EventCategory: CategoryPageview,
+			EventAction:   ActionPageviewLoad,
+			Timespan:      sql.NullInt64{Int64: 1440, Valid: true},
+			Operator:      ">=",
+			Count:         count,
+			Fields:        fields,
+		}
+	}
+	article := JSONMap{{"key": "article_id", "value": "123"}}
+	segments := SegmentCollection{
+		{ID: 1, SegmentData: SegmentData{Code: "s1"}, Rules: []SegmentRule{rule(1, 1, nil)}},
+		{ID: 2, SegmentData: SegmentData{Code: "s2"}, Rules: []SegmentRule{rule(2, 2, nil)}},
+		{ID: 3, SegmentData: SegmentData{Code: "s3"}, Rules: []SegmentRule{rule(3, 5, nil)}},
+		{ID: 4, SegmentData: SegmentData{Code: "s4"}, Rules: []SegmentRule{rule(4, 1, article)}},
+	}
+
+	storage := &countingPageviewStorage{}
+	sDB := &SegmentDB{PageviewStorage: storage}
+	_, checks, err := sDB.CheckUserSegments(segments, "u1", time.Now(), nil, RuleOverrides{})
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+
+	expected := SegmentChecks{"s1": true, "s2": true, "s3": false, "s4": true}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Errorf("returned checks %v, expected %v", checks, expected)
+	}
+	// rules of s1, s2 and s3 differ only by threshold and share single count
+	if storage.calls != 2 {
+		t.Errorf("storage was called %d times, expected 2", storage.calls)
+	}
+}
+
+func TestSegmentRule_DefinitionKey(t *testing.T) {
+	day := sql.NullInt64{Int64: 1440, Valid: true}
+	week := sql.NullInt64{Int64: 7 * 1440, Valid: true}
+	after := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	base := SegmentRule{
+		EventCategory: CategoryPageview,
+		EventAction:   ActionPageviewLoad,
+		Timespan:      day,
+		Operator:      ">=",
+		Count:         1,
+		Fields:        JSONMap{{"key": "article_id", "value": "123"}},
+	}
+
+	same := base
+	same.ID = 2
+	same.Operator = "<"
+	same.Count = 10
+	if base.definitionKey() != same.definitionKey() {
+		t.Errorf("rules differing only by threshold returned different keys")
+	}
+
+	var variants []SegmentRule
+	variant := func(fn func(sr *SegmentRule)) {
+		sr := base
+		fn(&sr)
+		variants = append(variants, sr)
+	}
+	variant(func(sr *SegmentRule) {})
+	variant(func(sr *SegmentRule) { sr.EventAction = ActionPageviewTimespent })
+	variant(func(sr *SegmentRule) { sr.Fields = JSONMap{{"key": "article_id", "value": "456"}} })
+	variant(func(sr *SegmentRule) { sr.Fields = JSONMap{{"key": "author_id", "value": "123"}} })
+	variant(func(sr *SegmentRule) {
+		sr.Fields = JSONMap{{"key": "article_id", "value": "123", "operator": FilterNotIn}}
+	})
+	variant(func(sr *SegmentRule) { sr.Fields = nil })
+	variant(func(sr *SegmentRule) { sr.Flags = JSONMap{{"key": FlagArticle, "value": "1"}} })
+	variant(func(sr *SegmentRule) { sr.Flags = JSONMap{{"key": FlagArticle, "value": "0"}} })
+	variant(func(sr *SegmentRule) { sr.Timespan = week })
+	variant(func(sr *SegmentRule) { sr.TimespanEnd = day })
+	variant(func(sr *SegmentRule) { sr.TimeAfter = &after })
+	variant(func(sr *SegmentRule) { sr.TimeBefore = &after })
+	variant(func(sr *SegmentRule) { sr.Aggregate = AggregateSum })
+
+	keys := make(map[string]int)
+	for i, sr := range variants {
+		key := sr.definitionKey()
+		if j, ok := keys[key]; ok {
+			t.Errorf("variants %d and %d share the key %s", j, i, key)
+		}
+		keys[key] = i
+	}
+}
+
+func TestRuleCounter_Concurrent(t *testing.T) {
+	rc := newRuleCounter()
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "a"
+			if i%2 == 1 {
+				key = "b"
+			}
+			rc.value(key, func() (float64, error) {
+				atomic.AddInt32(&calls, 1)
+				return 1, nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Errorf("callback was called %d times, expected 2", calls)
+	}
+}
+
+// pagedPageviewStorage returns counts of pageviews of identifiers grouped by single tag honoring
+// pagination, partitions and filters of identifiers.
+type pagedPageviewStorage struct {
+	PageviewStorage
+	counts map[string]int
+	calls  int
+}
+
+func (s *pagedPageviewStorage) Count(o AggregateOptions) (CountRowCollection, bool, error) {
+	s.calls++
+	tagName := o.GroupBy[0]
+	allowed := make(map[string]bool)
+	for _, fb := range o.FilterBy {
+		if fb.Tag == tagName {
+			for _, v := range fb.Values {
+				allowed[v] = true
+			}
+		}
+	}
+
+	var ids []string
+	for id := range s.counts {
+		if len(allowed) > 0 && !allowed[id] {
+			continue
+		}
+		if o.Page != nil && o.Page.Partitions > 0 && !o.Page.inPartition(id) {
+			continue
+		}
+		if o.Page != nil && o.Page.Partitions == 0 && id <= o.Page.After[tagName] {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if o.Page != nil && len(ids) > o.Page.Size {
+		ids = ids[:o.Page.Size]
+	}
+
+	crc := CountRowCollection{}
+	for _, id := range ids {
+		crc = append(crc, CountRow{Tags: map[string]string{tagName: id}, Count: s.counts[id]})
+	}
+	return crc, true, nil
+}
+
+func (s *pagedPageviewStorage) Unique(o AggregateOptions, item string) (CountRowCollection, bool, error) {
+	s.calls++
+	return CountRowCollection{{Count: len(s.counts)}}, true, nil
+}
+
+func TestSegmentDB_EstimateUsers(t *testing.T) {
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[fmt.Sprintf("u%04d", i)] = 1 + i%3
+	}
+	sDB := &SegmentDB{PageviewStorage: &pagedPageviewStorage{counts: counts}}
+	segment := &Segment{
+		Rules: []SegmentRule{
+			{ID: 1, EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: ">=", Count: 2},
+		},
+	}
+
+	// all the partitions fit into the sample
+	se, err := sDB.EstimateUsers(segment, time.Now(), RuleOverrides{}, time.Minute)
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+	if !se.Exact || se.Count != 2000 {
+		t.Errorf("returned estimate %+v, expected exact count 2000", se)
+	}
+
+	// single partition is sampled, it's not biased towards the lowest identifiers
+	for i := 0; i < 3000; i++ {
+		if i < 1000 {
+			counts[fmt.Sprintf("u%04d", i)] = 2
+		} else {
+			counts[fmt.Sprintf("u%04d", i)] = 1
+		}
+	}
+	// budget expires after counting the driving rule and evaluating the first partition
+	checks := 0
+	se, err = sDB.estimateUsers(segment, time.Now(), RuleOverrides{}, func() bool {
+		checks++
+		return checks > 2
+	})
+	if err != nil {
+		t.Fatalf("returned error: %v", err)
+	}
+	if se.Exact || se.Count < 1000-se.ErrorBound || se.Count > 1000+se.ErrorBound {
+		t.Errorf("returned estimate %+v, expected approximate count 1000", se)
+	}
+}
+
+func TestSegmentDB_UsersPage(t *testing.T) {
+	counts := make(map[string]int)
+	var expected []string
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("u%02d", i)
+		// every third user doesn't match the rule
+		counts[id] = 2
+		if i%3 == 0 {
+			counts[id] = 1
+		} else {
+			expected = append(expected, id)
+		}
+	}
+
+	sDB := &SegmentDB{PageviewStorage: &pagedPageviewStorage{counts: counts}}
+	segment := &Segment{
+		Rules: []SegmentRule{
+			{ID: 1, EventCategory: CategoryPageview, EventAction: ActionPageviewLoad, Operator: ">=", Count: 2},
+		},
+	}
+
+	for _, limit := range []int{1, 4, 5, 16, 100} {
+		var members []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(counts) {
+				t.Fatalf("limit %d: pagination doesn't end", limit)
+			}
+			page, next, err := sDB.UsersPage(segment, time.Now(), RuleOverrides{}, cursor, limit)
+			if err != nil {
+				t.Fatalf("limit %d: returned error: %v", limit, err)
+			}
+			if len(page) > limit {
+				t.Errorf("limit %d: returned page of %d members", limit, len(page))
+			}
+			if next != "" && len(page) < limit {
+				t.Errorf("limit %d: returned incomplete page of %d members with next cursor", limit, len(page))
+			}
+			members = append(members, page...)
+			if next == "" {
+				break
+			}
+			if next != page[len(page)-1] {
+				t.Errorf("limit %d: returned cursor %s, expected the last member %s", limit, next, page[len(page)-1])
+			}
+			cursor = next
+		}
+		if !reflect.DeepEqual(members, expected) {
+			t.Errorf("limit %d: returned members %v, expected %v", limit, members, expected)
+		}
+	}
+}
+
+var segmentColumns = []string{"id", "name", "code", "active", "segment_group_id", "criteria", "created_at", "updated_at", "deleted_at", "version"}
+
+func segmentRow(id int64, code string, version int64) []driver.Value {
+	now := time.Now()
+	return []driver.Value{id, "Segment", code, int64(1), int64(1), `{"nodes":[]}`, now, now, nil, version}
+}
+
+func TestSegmentDB_Create(t *testing.T) {
+	db, conn := newScriptedDB(
+		scriptedStatement{Query: "SELECT * FROM segment_groups WHERE id = ?", Columns: segmentGroupColumns, Rows: [][]driver.Value{segmentGroupRow(1, "news", "rule", 100, 1)}},
+		scriptedStatement{Query: "INSERT INTO segments", Err: &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'sport' for key 'segments_code_unique'"}},
+	)
+	sDB := &SegmentDB{MySQL: db}
+
+	_, err := sDB.Create(SegmentData{Name: "Sport", Code: "sport", SegmentGroupID: 1}, "")
+	if errors.Cause(err) != ErrSegmentCodeConflict {
+		t.Errorf("returned error %v, expected %v", err, ErrSegmentCodeConflict)
+	}
+	if conn.committed {
+		t.Errorf("committed segment with conflicting code")
+	}
+}
+
+func TestSegmentDB_Update(t *testing.T) {
+	version := func(v int) *int { return &v }
+	locked := scriptedStatement{Query: "SELECT * FROM segments WHERE id = ? AND deleted_at IS NULL FOR UPDATE", Columns: segmentColumns, Rows: [][]driver.Value{segmentRow(7, "sport", 4)}}
+
+	var updateTests = []struct {
+		Name    string
+		Script  []scriptedStatement
+		Version *int
+		Err     error
+	}{
+		{
+			Name:    "modified since provided version",
+			Script:  []scriptedStatement{locked},
+			Version: version(3),
+			Err:     ErrSegmentModified,
+		},
+		{
+			Name: "code conflict",
+			Script: []scriptedStatement{
+				locked,
+				{Query: "INSERT INTO segment_revisions"},
+				{Query: "UPDATE segments", Err: &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry 'news' for key 'segments_code_unique'"}},
+			},
+			Version: version(4),
+			Err:     ErrSegmentCodeConflict,
+		},
+	}
+
+	for _, ut := range updateTests {
+		db, conn := newScriptedDB(ut.Script...)
+		sDB := &SegmentDB{MySQL: db}
+
+		_, _, err := sDB.Update(7, SegmentData{Name: "News", Code: "news", SegmentGroupID: 1}, "", ut.Version)
+		if errors.Cause(err) != ut.Err {
+			t.Errorf("%s: returned error %v, expected %v", ut.Name, err, ut.Err)
+		}
+		if len(conn.executed) != len(ut.Script) {
+			t.Errorf("%s: executed %d statements, expected %d", ut.Name, len(conn.executed), len(ut.Script))
+		}
+		if conn.committed {
+			t.Errorf("%s: committed failed update", ut.Name)
+		}
+	}
+}
+
+func TestSegmentDB_Delete(t *testing.T) {
+	version := func(v int) *int { return &v }
+	lockQuery := "SELECT * FROM segments WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
+
+	var deleteTests = []struct {
+		Name    string
+		Script  []scriptedStatement
+		Version *int
+		Deleted bool
+		Err     error
+	}{
+		{
+			Name: "soft delete",
+			Script: []scriptedStatement{
+				{Query: lockQuery, Columns: segmentColumns, Rows: [][]driver.Value{segmentRow(7, "sport", 4)}},
+				{Query: "UPDATE segments SET active = 0, deleted_at = ?"},
+			},
+			Version: version(4),
+			Deleted: true,
+		},
+		{
+			Name: "modified since provided version",
+			Script: []scriptedStatement{
+				{Query: lockQuery, Columns: segmentColumns, Rows: [][]driver.Value{segmentRow(7, "sport", 5)}},
+			},
+			Version: version(4),
+			Err:     ErrSegmentModified,
+		},
+		{
+			Name: "already deleted",
+			Script: []scriptedStatement{
+				{Query: lockQuery, Columns: segmentColumns},
+			},
+		},
+	}
+
+	for _, dt := range deleteTests {
+		db, conn := newScriptedDB(dt.Script...)
+		sDB := &SegmentDB{MySQL: db}
+
+		ok, err := sDB.Delete(7, dt.Version)
+		if errors.Cause(err) != dt.Err {
+			t.Errorf("%s: returned error %v, expected %v", dt.Name, err, dt.Err)
+		}
+		if ok != dt.Deleted || conn.committed != dt.Deleted {
+			t.Errorf("%s: returned deleted %t (committed %t), expected %t", dt.Name, ok, conn.committed, dt.Deleted)
+		}
+		if len(conn.executed) != len(dt.Script) {
+			t.Errorf("%s: executed %d statements, expected %d", dt.Name, len(conn.executed), len(dt.Script))
+		}
+	}
+}
+
+func TestSegmentDB_GetAfterDelete(t *testing.T) {
+	db, _ := newScriptedDB(
+		scriptedStatement{Query: "SELECT * FROM segments WHERE id = ? AND deleted_at IS NULL FOR UPDATE", Columns: segmentColumns, Rows: [][]driver.Value{segmentRow(7, "sport", 4)}},
+		scriptedStatement{Query: "UPDATE segments SET active = 0, deleted_at = ?"},
+		scriptedStatement{Query: "SELECT segments.*", Columns: segmentColumns},
+	)
+	news := &Segment{ID: 8, Code: "news", Active: true}
+	sDB := &SegmentDB{
+		MySQL: db,
+		Segments: map[string]*Segment{
+			"sport": {ID: 7, Code: "sport", Active: true},
+			"news":  news,
+		},
+	}
+
+	if ok, err := sDB.Delete(7, nil); err != nil || !ok {
+		t.Fatalf("returned deleted %t, error %v", ok, err)
+	}
+	if _, ok, err := sDB.Get("sport"); err != nil || ok {
+		t.Errorf("returned deleted segment from cache (found %t, error %v)", ok, err)
+	}
+	if s, ok, _ := sDB.Get("news"); !ok || s != news {
+		t.Errorf("evicted other segment from cache")
+	}
+}
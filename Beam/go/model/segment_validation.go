@@ -0,0 +1,344 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fieldNamePattern is the pattern of valid names of fields (tags) used within criteria.
+var fieldNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-.]+$`)
+
+// SegmentCriteriaProblem represents single problem found within segment criteria.
+type SegmentCriteriaProblem struct {
+	Path    string // path to the invalid node or value, e.g. nodes[0].nodes[1].values.count
+	Message string
+}
+
+// SegmentCriteriaProblems is list of SegmentCriteriaProblem.
+type SegmentCriteriaProblems []SegmentCriteriaProblem
+
+// add appends new problem found at provided path.
+func (scp *SegmentCriteriaProblems) add(path, format string, args ...interface{}) {
+	*scp = append(*scp, SegmentCriteriaProblem{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks provided criteria against the segment blueprint and returns all the problems found.
+func (sbdb *SegmentBlueprintDB) Validate(criteria SegmentCriteria) (SegmentCriteriaProblems, error) {
+	blueprint, err := sbdb.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	criterions := make(map[string]*SegmentBlueprintTableCriterion)
+	for _, table := range blueprint {
+		for _, c := range table.Criteria {
+			criterions[c.Key] = c
+		}
+	}
+
+	problems := SegmentCriteriaProblems{}
+	for i, n := range criteria.Nodes {
+		path := fmt.Sprintf("nodes[%d]", i)
+		if n.Type != "operator" {
+			problems.add(path+".type", "incorrect type of node [%s] - only `operator` is allowed on this level", n.Type)
+		}
+		if n.Operator != "AND" {
+			problems.add(path+".operator", "incorrect operator [%s] - only `AND` operator is allowed", n.Operator)
+		}
+		for j, nn := range n.Nodes {
+			validateCriteriaNode(&problems, fmt.Sprintf("%s.nodes[%d]", path, j), nn, criterions)
+		}
+	}
+	return problems, nil
+}
+
+// validateCriteriaNode checks single criteria node against the blueprint of its criterion.
+func validateCriteriaNode(problems *SegmentCriteriaProblems, path string, node SegmentCriteriaNode, criterions map[string]*SegmentBlueprintTableCriterion) {
+	if node.Type != "criteria" {
+		problems.add(path+".type", "incorrect type of node [%s] - only `criteria` is allowed on this level", node.Type)
+		return
+	}
+	criterion, ok := criterions[node.Key]
+	if !ok {
+		problems.add(path+".key", "unknown criterion [%s]", node.Key)
+		return
+	}
+
+	// check presence of required params
+	var params []string
+	for key := range criterion.Params {
+		params = append(params, key)
+	}
+	sort.Strings(params)
+	for _, key := range params {
+		if _, ok := node.Values[key]; !ok && criterion.Params[key].Required {
+			problems.add(fmt.Sprintf("%s.values.%s", path, key), "missing required value")
+		}
+	}
+
+	var keys []string
+	for key := range node.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		vPath := fmt.Sprintf("%s.values.%s", path, key)
+		param, ok := criterion.Params[key]
+		if !ok {
+			problems.add(vPath, "unknown value of [%s] criterion", node.Key)
+			continue
+		}
+		value := node.Values[key]
+
+		switch key {
+		case "count":
+			validateCriteriaCount(problems, vPath, value)
+		case "timespan":
+			validateCriteriaTimespan(problems, vPath, value)
+		case "within":
+			var within struct {
+				Value *int
+				Unit  string
+			}
+			if err := scanCriteriaValue(value, &within); err != nil || within.Value == nil {
+				problems.add(vPath, "interval with value and unit expected")
+				continue
+			}
+			validateCriteriaUnit(problems, vPath+".unit", within.Unit)
+		case "fields":
+			mf, ok := value.(map[string]interface{})
+			if !ok {
+				problems.add(vPath, "object of field names and values expected")
+				continue
+			}
+			var fields []string
+			for field := range mf {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				fPath := fmt.Sprintf("%s.%s", vPath, field)
+				validateCriteriaField(problems, fPath, field, criterion)
+				validateCriteriaFieldValue(problems, fPath, mf[field])
+			}
+		case "aggregate_field":
+			field, ok := value.(string)
+			if !ok {
+				problems.add(vPath, "invalid field name [%v]", value)
+				continue
+			}
+			validateCriteriaField(problems, vPath, field, criterion)
+		case "steps", "without":
+			var steps []SegmentRuleSequenceStep
+			if err := scanCriteriaValue(value, &steps); err != nil {
+				problems.add(vPath, "list of steps with category and action expected")
+				continue
+			}
+			if key == "steps" && len(steps) == 0 {
+				problems.add(vPath, "at least one step is required")
+			}
+			for k, step := range steps {
+				validateCriteriaStep(problems, fmt.Sprintf("%s[%d]", vPath, k), step, criterions)
+			}
+		default:
+			validateCriteriaParam(problems, vPath, param, value)
+		}
+	}
+}
+
+// validateCriteriaParam checks value against the type and available values of blueprint param.
+func validateCriteriaParam(problems *SegmentCriteriaProblems, path string, param SegmentBlueprintTableCriterionParam, value interface{}) {
+	switch param.Type {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems.add(path, "boolean value expected")
+		}
+		return
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems.add(path, "numeric value expected")
+		}
+		return
+	case "string_array":
+		values, ok := value.([]interface{})
+		if !ok {
+			problems.add(path, "list of strings expected")
+			return
+		}
+		for i, v := range values {
+			validateCriteriaString(problems, fmt.Sprintf("%s[%d]", path, i), param, v)
+		}
+		return
+	case "string":
+		validateCriteriaString(problems, path, param, value)
+	}
+}
+
+// validateCriteriaString checks string value against available values of blueprint param.
+func validateCriteriaString(problems *SegmentCriteriaProblems, path string, param SegmentBlueprintTableCriterionParam, value interface{}) {
+	s, ok := value.(string)
+	if !ok {
+		problems.add(path, "string value expected")
+		return
+	}
+	if len(param.Available) == 0 {
+		return
+	}
+	for _, a := range param.Available {
+		if s == a {
+			return
+		}
+	}
+	problems.add(path, "unknown value [%s], available values: %s", s, strings.Join(param.Available, ", "))
+}
+
+// validateCriteriaCount checks operators and values of count criteria value.
+func validateCriteriaCount(problems *SegmentCriteriaProblems, path string, value interface{}) {
+	mf, ok := value.(map[string]interface{})
+	if !ok {
+		problems.add(path, "object of operators and values expected")
+		return
+	}
+	if len(mf) == 0 || len(mf) > 2 {
+		problems.add(path, "one or two operators expected")
+	}
+	for op, v := range mf {
+		switch op {
+		case "eq", "gt", "gte", "lt", "lte":
+		default:
+			problems.add(fmt.Sprintf("%s.%s", path, op), "unknown operator [%s]", op)
+			continue
+		}
+		if _, ok := v.(float64); !ok {
+			problems.add(fmt.Sprintf("%s.%s", path, op), "numeric value expected")
+		}
+	}
+}
+
+// validateCriteriaTimespan checks type and bounds of timespan criteria value.
+func validateCriteriaTimespan(problems *SegmentCriteriaProblems, path string, value interface{}) {
+	var scvd SegmentCriteriaValuesDatetime
+	if err := scvd.Scan(value); err != nil {
+		problems.add(path, "unable to read timespan: %s", err)
+		return
+	}
+
+	switch scvd.Type {
+	case "absolute":
+		if scvd.Absolute == nil || len(*scvd.Absolute) == 0 {
+			problems.add(path+".absolute", "absolute timespan missing values")
+			return
+		}
+		for bound, val := range *scvd.Absolute {
+			if bound != "gte" && bound != "lte" {
+				problems.add(fmt.Sprintf("%s.absolute.%s", path, bound), "unknown bound [%s]", bound)
+				continue
+			}
+			if _, err := parseCriteriaTime(val); err != nil {
+				problems.add(fmt.Sprintf("%s.absolute.%s", path, bound), "%s", err)
+			}
+		}
+	case "interval":
+		if scvd.Interval == nil || len(*scvd.Interval) == 0 {
+			problems.add(path+".interval", "interval timespan missing values")
+			return
+		}
+		for bound, val := range *scvd.Interval {
+			if bound != "gte" && bound != "lte" {
+				problems.add(fmt.Sprintf("%s.interval.%s", path, bound), "unknown bound [%s]", bound)
+				continue
+			}
+			validateCriteriaUnit(problems, fmt.Sprintf("%s.interval.%s.unit", path, bound), val.Unit)
+		}
+	default:
+		problems.add(path+".type", "unknown timespan type [%s]", scvd.Type)
+	}
+}
+
+// validateCriteriaUnit checks unit of criteria interval.
+func validateCriteriaUnit(problems *SegmentCriteriaProblems, path, unit string) {
+	switch unit {
+	case "minute", "hour", "day", "month":
+	default:
+		problems.add(path, "unknown interval unit [%s]", unit)
+	}
+}
+
+// validateCriteriaStep checks category, action and fields of single sequence step.
+func validateCriteriaStep(problems *SegmentCriteriaProblems, path string, step SegmentRuleSequenceStep, criterions map[string]*SegmentBlueprintTableCriterion) {
+	criterion, ok := criterions[step.Category]
+	if !ok || step.Category == CategorySequence || step.Category == CategoryRFM {
+		problems.add(path+".category", "unknown category [%s]", step.Category)
+		return
+	}
+	if action, ok := criterion.Params["action"]; ok {
+		validateCriteriaString(problems, path+".action", action, step.Action)
+	}
+	var fields []string
+	for field := range step.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		validateCriteriaField(problems, fmt.Sprintf("%s.fields.%s", path, field), field, criterion)
+	}
+}
+
+// validateCriteriaFieldValue checks value of the field, which is either matched exactly or by the filter
+// object {"operator": "prefix", "value": "..."}. Value is required by all operators except exists and missing.
+func validateCriteriaFieldValue(problems *SegmentCriteriaProblems, path string, value interface{}) {
+	switch v := value.(type) {
+	case string, float64, bool:
+		return
+	case map[string]interface{}:
+		for key := range v {
+			if key != "operator" && key != "value" {
+				problems.add(fmt.Sprintf("%s.%s", path, key), "unknown attribute of field filter")
+			}
+		}
+		operator, _ := v["operator"].(string)
+		if !validFilterOperator(operator) {
+			problems.add(path+".operator", "unknown operator [%v]", v["operator"])
+			return
+		}
+		if operator == FilterExists || operator == FilterMissing {
+			return
+		}
+		switch fv := v["value"].(type) {
+		case string:
+			if fv == "" {
+				problems.add(path+".value", "value required by operator [%s]", operator)
+			}
+		case float64, bool:
+		case nil:
+			problems.add(path+".value", "value required by operator [%s]", operator)
+		default:
+			problems.add(path+".value", "single value expected")
+		}
+	default:
+		problems.add(path, "value or object with operator and value expected")
+	}
+}
+
+// validateCriteriaField checks name of the field and its presence among the blueprint fields of the criterion.
+// Fields of criteria without known fields (e.g. sequence) are checked only by their name.
+func validateCriteriaField(problems *SegmentCriteriaProblems, path, field string, criterion *SegmentBlueprintTableCriterion) {
+	if !fieldNamePattern.MatchString(field) {
+		problems.add(path, "invalid field name [%s]", field)
+		return
+	}
+	if len(criterion.Fields) == 0 {
+		return
+	}
+	for _, f := range criterion.Fields {
+		if f == field {
+			return
+		}
+	}
+	problems.add(path, "unknown field [%s] of [%s] criterion", field, criterion.Key)
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateCriteriaNode(t *testing.T) {
+	sbdb := &SegmentBlueprintDB{}
+	params := sbdb.buildParams(CategoryPageview)
+	params["action"] = SegmentBlueprintTableCriterionParam{Type: "string", Required: true, Available: []string{"load"}}
+	criterions := map[string]*SegmentBlueprintTableCriterion{
+		CategoryPageview: {Key: CategoryPageview, Params: params, Fields: []string{"article_id", "author_id", "timespent"}},
+		CategorySequence: {Key: CategorySequence, Params: sbdb.sequenceParams()},
+	}
+
+	var validationTests = []struct {
+		Name  string
+		Node  string
+		Paths []string
+	}{
+		{
+			"valid",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load", "count": {"gte": 1}, "timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}}}`,
+			nil,
+		},
+		{
+			"unknown criterion",
+			`{"type": "criteria", "key": "unknown", "values": {}}`,
+			[]string{"n.key"},
+		},
+		{
+			"missing required",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load"}}`,
+			[]string{"n.values.count", "n.values.timespan"},
+		},
+		{
+			"invalid values",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "click", "count": {"gte": 1, "ne": 2}, "timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "week"}}}, "foo": true, "is_article": "yes"}}`,
+			[]string{"n.values.action", "n.values.count.ne", "n.values.foo", "n.values.is_article", "n.values.timespan.interval.gte.unit"},
+		},
+		{
+			"invalid timespan",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load", "count": {"gte": 1}, "timespan": {"type": "absolute", "absolute": {"gte": "yesterday"}}}}`,
+			[]string{"n.values.timespan.absolute.gte"},
+		},
+		{
+			"invalid sequence step",
+			`{"type": "criteria", "key": "sequence", "values": {"timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}, "steps": [{"category": "pageview", "action": "load"}, {"category": "pageview", "action": "click"}]}}`,
+			[]string{"n.values.steps[1].action"},
+		},
+		{
+			"unknown fields",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load", "count": {"gte": 1}, "timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}, "fields": {"article_id": "1", "artcle_id": "2"}, "aggregate": "distinct", "aggregate_field": "section"}}`,
+			[]string{"n.values.aggregate_field", "n.values.fields.artcle_id"},
+		},
+		{
+			"field filters",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load", "count": {"gte": 1}, "timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}, "fields": {"article_id": {"operator": "prefix", "value": "1"}, "author_id": {"operator": "exists"}, "timespent": {"operator": "gte"}}}}`,
+			[]string{"n.values.fields.timespent.value"},
+		},
+		{
+			"invalid field filters",
+			`{"type": "criteria", "key": "pageview", "values": {"action": "load", "count": {"gte": 1}, "timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}, "fields": {"article_id": {"operator": "like", "value": "1"}, "author_id": ["1", "2"], "timespent": {"operator": "in", "value": [1], "values": [2]}}}}`,
+			[]string{"n.values.fields.article_id.operator", "n.values.fields.author_id", "n.values.fields.timespent.values", "n.values.fields.timespent.value"},
+		},
+		{
+			"unknown step field",
+			`{"type": "criteria", "key": "sequence", "values": {"timespan": {"type": "interval", "interval": {"gte": {"value": 2, "unit": "day"}}}, "steps": [{"category": "pageview", "action": "load", "fields": {"author_id": "1", "section": "sport"}}]}}`,
+			[]string{"n.values.steps[0].fields.section"},
+		},
+	}
+
+	for _, vt := range validationTests {
+		var node SegmentCriteriaNode
+		if err := json.Unmarshal([]byte(vt.Node), &node); err != nil {
+			t.Fatalf("%s: unable to unmarshal node: %s", vt.Name, err)
+		}
+
+		problems := SegmentCriteriaProblems{}
+		validateCriteriaNode(&problems, "n", node, criterions)
+
+		if len(problems) != len(vt.Paths) {
+			t.Errorf("%s: returned %d problems (%v), expected %d", vt.Name, len(problems), problems, len(vt.Paths))
+			continue
+		}
+		for i, p := range problems {
+			if p.Path != vt.Paths[i] {
+				t.Errorf("%s: returned problem at %s, expected %s", vt.Name, p.Path, vt.Paths[i])
+			}
+		}
+	}
+}